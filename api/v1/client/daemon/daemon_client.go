@@ -205,6 +205,38 @@ func (a *Client) PatchConfig(params *PatchConfigParams) (*PatchConfigOK, error)
 
 }
 
+/*
+PostCNIEvent reports a c n i plugin event
+
+Relays an event reported by the CNI plugin, such as the failure to set
+up the networking of a pod, to the orchestration system.
+
+*/
+func (a *Client) PostCNIEvent(params *PostCNIEventParams) (*PostCNIEventOK, error) {
+	// TODO: Validate the params before sending
+	if params == nil {
+		params = NewPostCNIEventParams()
+	}
+
+	result, err := a.transport.Submit(&runtime.ClientOperation{
+		ID:                 "PostCNIEvent",
+		Method:             "POST",
+		PathPattern:        "/cni-event",
+		ProducesMediaTypes: []string{"application/json"},
+		ConsumesMediaTypes: []string{"application/json"},
+		Schemes:            []string{"http"},
+		Params:             params,
+		Reader:             &PostCNIEventReader{formats: a.formats},
+		Context:            params.Context,
+		Client:             params.HTTPClient,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*PostCNIEventOK), nil
+
+}
+
 // SetTransport changes the transport on the client
 func (a *Client) SetTransport(transport runtime.ClientTransport) {
 	a.transport = transport
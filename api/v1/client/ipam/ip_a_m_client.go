@@ -24,6 +24,34 @@ type Client struct {
 	formats   strfmt.Registry
 }
 
+/*
+DeleteIPAM releases all IP addresses allocated for an owner
+*/
+func (a *Client) DeleteIPAM(params *DeleteIPAMParams) (*DeleteIPAMOK, error) {
+	// TODO: Validate the params before sending
+	if params == nil {
+		params = NewDeleteIPAMParams()
+	}
+
+	result, err := a.transport.Submit(&runtime.ClientOperation{
+		ID:                 "DeleteIPAM",
+		Method:             "DELETE",
+		PathPattern:        "/ipam",
+		ProducesMediaTypes: []string{"application/json"},
+		ConsumesMediaTypes: []string{"application/json"},
+		Schemes:            []string{"http"},
+		Params:             params,
+		Reader:             &DeleteIPAMReader{formats: a.formats},
+		Context:            params.Context,
+		Client:             params.HTTPClient,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*DeleteIPAMOK), nil
+
+}
+
 /*
 DeleteIPAMIP releases an allocated IP address
 */
@@ -61,6 +61,11 @@ for the post IP a m IP operation typically these are written to a http.Request
 */
 type PostIPAMIPParams struct {
 
+	/*ContainerID
+	  ID of the container the addresses are allocated for
+
+	*/
+	ContainerID *string
 	/*IP
 	  IP address
 
@@ -107,6 +112,17 @@ func (o *PostIPAMIPParams) SetHTTPClient(client *http.Client) {
 	o.HTTPClient = client
 }
 
+// WithContainerID adds the containerID to the post IP a m IP params
+func (o *PostIPAMIPParams) WithContainerID(containerID *string) *PostIPAMIPParams {
+	o.SetContainerID(containerID)
+	return o
+}
+
+// SetContainerID adds the containerId to the post IP a m IP params
+func (o *PostIPAMIPParams) SetContainerID(containerID *string) {
+	o.ContainerID = containerID
+}
+
 // WithIP adds the ip to the post IP a m IP params
 func (o *PostIPAMIPParams) WithIP(ip string) *PostIPAMIPParams {
 	o.SetIP(ip)
@@ -137,6 +153,22 @@ func (o *PostIPAMIPParams) WriteToRequest(r runtime.ClientRequest, reg strfmt.Re
 	}
 	var res []error
 
+	if o.ContainerID != nil {
+
+		// query param container-id
+		var qrContainerID string
+		if o.ContainerID != nil {
+			qrContainerID = *o.ContainerID
+		}
+		qContainerID := qrContainerID
+		if qContainerID != "" {
+			if err := r.SetQueryParam("container-id", qContainerID); err != nil {
+				return err
+			}
+		}
+
+	}
+
 	// path param ip
 	if err := r.SetPathParam("ip", o.IP); err != nil {
 		return err
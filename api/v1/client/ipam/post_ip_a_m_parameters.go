@@ -61,10 +61,20 @@ for the post IP a m operation typically these are written to a http.Request
 */
 type PostIPAMParams struct {
 
+	/*ContainerID
+	  ID of the container the addresses are allocated for
+
+	*/
+	ContainerID *string
 	/*Family*/
 	Family *string
 	/*Owner*/
 	Owner *string
+	/*Subnet
+	  Subnet within the allocation range to allocate from
+
+	*/
+	Subnet *string
 
 	timeout    time.Duration
 	Context    context.Context
@@ -104,6 +114,17 @@ func (o *PostIPAMParams) SetHTTPClient(client *http.Client) {
 	o.HTTPClient = client
 }
 
+// WithContainerID adds the containerID to the post IP a m params
+func (o *PostIPAMParams) WithContainerID(containerID *string) *PostIPAMParams {
+	o.SetContainerID(containerID)
+	return o
+}
+
+// SetContainerID adds the containerId to the post IP a m params
+func (o *PostIPAMParams) SetContainerID(containerID *string) {
+	o.ContainerID = containerID
+}
+
 // WithFamily adds the family to the post IP a m params
 func (o *PostIPAMParams) WithFamily(family *string) *PostIPAMParams {
 	o.SetFamily(family)
@@ -126,6 +147,17 @@ func (o *PostIPAMParams) SetOwner(owner *string) {
 	o.Owner = owner
 }
 
+// WithSubnet adds the subnet to the post IP a m params
+func (o *PostIPAMParams) WithSubnet(subnet *string) *PostIPAMParams {
+	o.SetSubnet(subnet)
+	return o
+}
+
+// SetSubnet adds the subnet to the post IP a m params
+func (o *PostIPAMParams) SetSubnet(subnet *string) {
+	o.Subnet = subnet
+}
+
 // WriteToRequest writes these params to a swagger request
 func (o *PostIPAMParams) WriteToRequest(r runtime.ClientRequest, reg strfmt.Registry) error {
 
@@ -134,6 +166,22 @@ func (o *PostIPAMParams) WriteToRequest(r runtime.ClientRequest, reg strfmt.Regi
 	}
 	var res []error
 
+	if o.ContainerID != nil {
+
+		// query param container-id
+		var qrContainerID string
+		if o.ContainerID != nil {
+			qrContainerID = *o.ContainerID
+		}
+		qContainerID := qrContainerID
+		if qContainerID != "" {
+			if err := r.SetQueryParam("container-id", qContainerID); err != nil {
+				return err
+			}
+		}
+
+	}
+
 	if o.Family != nil {
 
 		// query param family
@@ -166,6 +214,22 @@ func (o *PostIPAMParams) WriteToRequest(r runtime.ClientRequest, reg strfmt.Regi
 
 	}
 
+	if o.Subnet != nil {
+
+		// query param subnet
+		var qrSubnet string
+		if o.Subnet != nil {
+			qrSubnet = *o.Subnet
+		}
+		qSubnet := qrSubnet
+		if qSubnet != "" {
+			if err := r.SetQueryParam("subnet", qSubnet); err != nil {
+				return err
+			}
+		}
+
+	}
+
 	if len(res) > 0 {
 		return errors.CompositeValidationError(res...)
 	}
@@ -39,6 +39,13 @@ func (o *PostIPAMReader) ReadResponse(response runtime.ClientResponse, consumer
 		}
 		return nil, result
 
+	case 507:
+		result := NewPostIPAMExhausted()
+		if err := result.readResponse(response, consumer, o.formats); err != nil {
+			return nil, err
+		}
+		return nil, result
+
 	default:
 		return nil, runtime.NewAPIError("unknown error", response, response.Code())
 	}
@@ -99,3 +106,30 @@ func (o *PostIPAMFailure) readResponse(response runtime.ClientResponse, consumer
 
 	return nil
 }
+
+// NewPostIPAMExhausted creates a PostIPAMExhausted with default headers values
+func NewPostIPAMExhausted() *PostIPAMExhausted {
+	return &PostIPAMExhausted{}
+}
+
+/*PostIPAMExhausted handles this case with default header values.
+
+No IP address available in the allocation range or subnet
+*/
+type PostIPAMExhausted struct {
+	Payload models.Error
+}
+
+func (o *PostIPAMExhausted) Error() string {
+	return fmt.Sprintf("[POST /ipam][%d] postIpAMExhausted  %+v", 507, o.Payload)
+}
+
+func (o *PostIPAMExhausted) readResponse(response runtime.ClientResponse, consumer runtime.Consumer, formats strfmt.Registry) error {
+
+	// response payload
+	if err := consumer.Consume(response.Body(), &o.Payload); err != nil && err != io.EOF {
+		return err
+	}
+
+	return nil
+}
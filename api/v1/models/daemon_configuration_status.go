@@ -28,6 +28,12 @@ type DaemonConfigurationStatus struct {
 	// MTU on workload facing devices
 	DeviceMTU int64 `json:"deviceMTU,omitempty"`
 
+	// Number of endpoint builds waiting for a build permit
+	EndpointBuildsQueued int64 `json:"endpoint-builds-queued,omitempty"`
+
+	// True while endpoints restored from a previous run are being regenerated
+	EndpointRestoreInProgress bool `json:"endpoint-restore-in-progress,omitempty"`
+
 	// Immutable configuration (read-only)
 	Immutable ConfigurationMap `json:"immutable,omitempty"`
 
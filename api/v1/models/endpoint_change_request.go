@@ -6,10 +6,14 @@ package models
 // Editing this file might prove futile when you re-run the swagger generate command
 
 import (
+	"encoding/json"
+	"strconv"
+
 	strfmt "github.com/go-openapi/strfmt"
 
 	"github.com/go-openapi/errors"
 	"github.com/go-openapi/swag"
+	"github.com/go-openapi/validate"
 )
 
 // EndpointChangeRequest Structure which contains the mutable elements of an Endpoint.
@@ -35,12 +39,18 @@ type EndpointChangeRequest struct {
 	// Docker network ID
 	DockerNetworkID string `json:"docker-network-id,omitempty"`
 
+	// Whether the addresses of the endpoint are managed outside of Cilium and must not be released
+	ExternalIPAM bool `json:"external-ipam,omitempty"`
+
 	// MAC address
 	HostMac string `json:"host-mac,omitempty"`
 
 	// Local endpoint ID
 	ID int64 `json:"id,omitempty"`
 
+	// Duration of the IP allocation in nanoseconds as observed by the caller
+	IPAMAllocationDuration int64 `json:"ipam-allocation-duration,omitempty"`
+
 	// Index of network device
 	InterfaceIndex int64 `json:"interface-index,omitempty"`
 
@@ -65,6 +75,16 @@ type EndpointChangeRequest struct {
 	// Whether policy enforcement is enabled or not
 	PolicyEnabled bool `json:"policy-enabled,omitempty"`
 
+	// Direction in which policy enforcement of the endpoint is disabled
+	// Enum: [ingress egress both]
+	PolicyExempt string `json:"policy-exempt,omitempty"`
+
+	// Properties recorded by the creator of the endpoint, e.g. for auditing
+	Properties map[string]string `json:"properties,omitempty"`
+
+	// Secondary addresses of the endpoint, released together with the endpoint
+	SecondaryAddressing []*AddressPair `json:"secondary-addressing"`
+
 	// Current state of endpoint
 	// Required: true
 	State EndpointState `json:"state"`
@@ -86,6 +106,14 @@ func (m *EndpointChangeRequest) Validate(formats strfmt.Registry) error {
 		res = append(res, err)
 	}
 
+	if err := m.validatePolicyExempt(formats); err != nil {
+		res = append(res, err)
+	}
+
+	if err := m.validateSecondaryAddressing(formats); err != nil {
+		res = append(res, err)
+	}
+
 	if err := m.validateState(formats); err != nil {
 		res = append(res, err)
 	}
@@ -130,6 +158,77 @@ func (m *EndpointChangeRequest) validateLabels(formats strfmt.Registry) error {
 	return nil
 }
 
+var endpointChangeRequestTypePolicyExemptPropEnum []interface{}
+
+func init() {
+	var res []string
+	if err := json.Unmarshal([]byte(`["ingress","egress","both"]`), &res); err != nil {
+		panic(err)
+	}
+	for _, v := range res {
+		endpointChangeRequestTypePolicyExemptPropEnum = append(endpointChangeRequestTypePolicyExemptPropEnum, v)
+	}
+}
+
+const (
+
+	// EndpointChangeRequestPolicyExemptIngress captures enum value "ingress"
+	EndpointChangeRequestPolicyExemptIngress string = "ingress"
+
+	// EndpointChangeRequestPolicyExemptEgress captures enum value "egress"
+	EndpointChangeRequestPolicyExemptEgress string = "egress"
+
+	// EndpointChangeRequestPolicyExemptBoth captures enum value "both"
+	EndpointChangeRequestPolicyExemptBoth string = "both"
+)
+
+// prop value enum
+func (m *EndpointChangeRequest) validatePolicyExemptEnum(path, location string, value string) error {
+	if err := validate.Enum(path, location, value, endpointChangeRequestTypePolicyExemptPropEnum); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *EndpointChangeRequest) validatePolicyExempt(formats strfmt.Registry) error {
+
+	if swag.IsZero(m.PolicyExempt) { // not required
+		return nil
+	}
+
+	// value enum
+	if err := m.validatePolicyExemptEnum("policy-exempt", "body", m.PolicyExempt); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *EndpointChangeRequest) validateSecondaryAddressing(formats strfmt.Registry) error {
+
+	if swag.IsZero(m.SecondaryAddressing) { // not required
+		return nil
+	}
+
+	for i := 0; i < len(m.SecondaryAddressing); i++ {
+		if swag.IsZero(m.SecondaryAddressing[i]) { // not required
+			continue
+		}
+
+		if m.SecondaryAddressing[i] != nil {
+			if err := m.SecondaryAddressing[i].Validate(formats); err != nil {
+				if ve, ok := err.(*errors.Validation); ok {
+					return ve.ValidateName("secondary-addressing" + "." + strconv.Itoa(i))
+				}
+				return err
+			}
+		}
+
+	}
+
+	return nil
+}
+
 func (m *EndpointChangeRequest) validateState(formats strfmt.Registry) error {
 
 	if err := m.State.Validate(formats); err != nil {
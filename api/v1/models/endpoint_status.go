@@ -40,6 +40,9 @@ type EndpointStatus struct {
 	// The policy applied to this endpoint from the policy repository
 	Policy *EndpointPolicyStatus `json:"policy,omitempty"`
 
+	// Properties recorded by the creator of the endpoint, e.g. for auditing
+	Properties map[string]string `json:"properties,omitempty"`
+
 	// The configuration in effect on this endpoint
 	Realized *EndpointConfigurationSpec `json:"realized,omitempty"`
 
@@ -21,9 +21,19 @@ type IPAMResponse struct {
 	// Required: true
 	Address *AddressPair `json:"address"`
 
+	// Expiration of the lease of the addresses, unset if the addresses do not expire
+	// Format: date-time
+	Expiration strfmt.DateTime `json:"expiration,omitempty"`
+
 	// host addressing
 	// Required: true
 	HostAddressing *NodeAddressing `json:"host-addressing"`
+
+	// Name of the IP pool the IPv4 address was allocated from, unset unless a pool was selected
+	IPV4Pool string `json:"ipv4-pool,omitempty"`
+
+	// Name of the IP pool the IPv6 address was allocated from, unset unless a pool was selected
+	IPV6Pool string `json:"ipv6-pool,omitempty"`
 }
 
 // Validate validates this IP a m response
@@ -34,6 +44,10 @@ func (m *IPAMResponse) Validate(formats strfmt.Registry) error {
 		res = append(res, err)
 	}
 
+	if err := m.validateExpiration(formats); err != nil {
+		res = append(res, err)
+	}
+
 	if err := m.validateHostAddressing(formats); err != nil {
 		res = append(res, err)
 	}
@@ -62,6 +76,19 @@ func (m *IPAMResponse) validateAddress(formats strfmt.Registry) error {
 	return nil
 }
 
+func (m *IPAMResponse) validateExpiration(formats strfmt.Registry) error {
+
+	if swag.IsZero(m.Expiration) { // not required
+		return nil
+	}
+
+	if err := validate.FormatOf("expiration", "body", "date-time", m.Expiration.String(), formats); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (m *IPAMResponse) validateHostAddressing(formats strfmt.Registry) error {
 
 	if err := validate.Required("host-addressing", "body", m.HostAddressing); err != nil {
@@ -32,6 +32,43 @@ func init() {
   },
   "basePath": "/v1",
   "paths": {
+    "/cni-event": {
+      "post": {
+        "description": "Relays an event reported by the CNI plugin, such as the failure to set\nup the networking of a pod, to the orchestration system.\n",
+        "tags": [
+          "daemon"
+        ],
+        "summary": "Report a CNI plugin event",
+        "parameters": [
+          {
+            "name": "event",
+            "in": "body",
+            "required": true,
+            "schema": {
+              "$ref": "#/definitions/CNIEvent"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Success"
+          },
+          "400": {
+            "description": "Invalid event",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "500": {
+            "description": "Event could not be relayed",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            },
+            "x-go-name": "Failure"
+          }
+        }
+      }
+    },
     "/config": {
       "get": {
         "description": "Returns the configuration of the Cilium daemon.\n",
@@ -679,6 +716,43 @@ func init() {
       }
     },
     "/ipam": {
+      "delete": {
+        "tags": [
+          "ipam"
+        ],
+        "summary": "Release all IP addresses allocated for an owner",
+        "parameters": [
+          {
+            "type": "string",
+            "description": "Owner of the addresses to release",
+            "name": "owner",
+            "in": "query",
+            "required": true
+          },
+          {
+            "$ref": "#/parameters/ipam-container-id"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Success"
+          },
+          "400": {
+            "description": "Invalid owner",
+            "x-go-name": "Invalid"
+          },
+          "404": {
+            "description": "No IP address allocated for owner"
+          },
+          "500": {
+            "description": "Address release failure",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            },
+            "x-go-name": "Failure"
+          }
+        }
+      },
       "post": {
         "tags": [
           "ipam"
@@ -690,6 +764,12 @@ func init() {
           },
           {
             "$ref": "#/parameters/ipam-owner"
+          },
+          {
+            "$ref": "#/parameters/ipam-container-id"
+          },
+          {
+            "$ref": "#/parameters/ipam-subnet"
           }
         ],
         "responses": {
@@ -705,6 +785,13 @@ func init() {
               "$ref": "#/definitions/Error"
             },
             "x-go-name": "Failure"
+          },
+          "507": {
+            "description": "No IP address available in the allocation range or subnet",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            },
+            "x-go-name": "Exhausted"
           }
         }
       }
@@ -721,6 +808,9 @@ func init() {
           },
           {
             "$ref": "#/parameters/ipam-owner"
+          },
+          {
+            "$ref": "#/parameters/ipam-container-id"
           }
         ],
         "responses": {
@@ -1285,6 +1375,32 @@ func init() {
         }
       }
     },
+    "CNIEvent": {
+      "description": "Event reported by the CNI plugin about a pod",
+      "type": "object",
+      "properties": {
+        "container-id": {
+          "description": "ID of the container",
+          "type": "string"
+        },
+        "error": {
+          "description": "Error message describing the failure",
+          "type": "string"
+        },
+        "k8s-namespace": {
+          "description": "Kubernetes namespace of the pod",
+          "type": "string"
+        },
+        "k8s-pod-name": {
+          "description": "Kubernetes name of the pod",
+          "type": "string"
+        },
+        "phase": {
+          "description": "Phase of the CNI operation the event relates to",
+          "type": "string"
+        }
+      }
+    },
     "ClusterStatus": {
       "description": "Status of cluster",
       "properties": {
@@ -1468,6 +1584,14 @@ func init() {
           "description": "MTU on workload facing devices",
           "type": "integer"
         },
+        "endpoint-builds-queued": {
+          "description": "Number of endpoint builds waiting for a build permit",
+          "type": "integer"
+        },
+        "endpoint-restore-in-progress": {
+          "description": "True while endpoints restored from a previous run are being regenerated",
+          "type": "boolean"
+        },
         "immutable": {
           "description": "Immutable configuration (read-only)",
           "$ref": "#/definitions/ConfigurationMap"
@@ -1602,6 +1726,10 @@ func init() {
           "description": "Docker network ID",
           "type": "string"
         },
+        "external-ipam": {
+          "description": "Whether the addresses of the endpoint are managed outside of Cilium and must not be released",
+          "type": "boolean"
+        },
         "host-mac": {
           "description": "MAC address",
           "type": "string"
@@ -1618,6 +1746,10 @@ func init() {
           "description": "Name of network device",
           "type": "string"
         },
+        "ipam-allocation-duration": {
+          "description": "Duration of the IP allocation in nanoseconds as observed by the caller",
+          "type": "integer"
+        },
         "k8s-namespace": {
           "description": "Kubernetes namespace name",
           "type": "string"
@@ -1642,6 +1774,29 @@ func init() {
           "description": "Whether policy enforcement is enabled or not",
           "type": "boolean"
         },
+        "policy-exempt": {
+          "description": "Direction in which policy enforcement of the endpoint is disabled",
+          "type": "string",
+          "enum": [
+            "ingress",
+            "egress",
+            "both"
+          ]
+        },
+        "properties": {
+          "description": "Properties recorded by the creator of the endpoint, e.g. for auditing",
+          "type": "object",
+          "additionalProperties": {
+            "type": "string"
+          }
+        },
+        "secondary-addressing": {
+          "description": "Secondary addresses of the endpoint, released together with the endpoint",
+          "type": "array",
+          "items": {
+            "$ref": "#/definitions/AddressPair"
+          }
+        },
         "state": {
           "description": "Current state of endpoint",
           "$ref": "#/definitions/EndpointState"
@@ -1904,6 +2059,13 @@ func init() {
           "description": "The policy applied to this endpoint from the policy repository",
           "$ref": "#/definitions/EndpointPolicyStatus"
         },
+        "properties": {
+          "description": "Properties recorded by the creator of the endpoint, e.g. for auditing",
+          "type": "object",
+          "additionalProperties": {
+            "type": "string"
+          }
+        },
         "realized": {
           "description": "The configuration in effect on this endpoint",
           "$ref": "#/definitions/EndpointConfigurationSpec"
@@ -1984,8 +2146,21 @@ func init() {
         "address": {
           "$ref": "#/definitions/AddressPair"
         },
+        "expiration": {
+          "description": "Expiration of the lease of the addresses, unset if the addresses do not expire",
+          "type": "string",
+          "format": "date-time"
+        },
         "host-addressing": {
           "$ref": "#/definitions/NodeAddressing"
+        },
+        "ipv4-pool": {
+          "description": "Name of the IP pool the IPv4 address was allocated from, unset unless a pool was selected",
+          "type": "string"
+        },
+        "ipv6-pool": {
+          "description": "Name of the IP pool the IPv6 address was allocated from, unset unless a pool was selected",
+          "type": "string"
         }
       }
     },
@@ -2656,6 +2831,12 @@ func init() {
       "in": "path",
       "required": true
     },
+    "ipam-container-id": {
+      "type": "string",
+      "description": "ID of the container the addresses are allocated for",
+      "name": "container-id",
+      "in": "query"
+    },
     "ipam-family": {
       "enum": [
         "ipv4",
@@ -2677,6 +2858,12 @@ func init() {
       "name": "owner",
       "in": "query"
     },
+    "ipam-subnet": {
+      "type": "string",
+      "description": "Subnet within the allocation range to allocate from",
+      "name": "subnet",
+      "in": "query"
+    },
     "labels": {
       "description": "List of labels\n",
       "name": "labels",
@@ -2775,6 +2962,43 @@ func init() {
   },
   "basePath": "/v1",
   "paths": {
+    "/cni-event": {
+      "post": {
+        "description": "Relays an event reported by the CNI plugin, such as the failure to set\nup the networking of a pod, to the orchestration system.\n",
+        "tags": [
+          "daemon"
+        ],
+        "summary": "Report a CNI plugin event",
+        "parameters": [
+          {
+            "name": "event",
+            "in": "body",
+            "required": true,
+            "schema": {
+              "$ref": "#/definitions/CNIEvent"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Success"
+          },
+          "400": {
+            "description": "Invalid event",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            }
+          },
+          "500": {
+            "description": "Event could not be relayed",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            },
+            "x-go-name": "Failure"
+          }
+        }
+      }
+    },
     "/config": {
       "get": {
         "description": "Returns the configuration of the Cilium daemon.\n",
@@ -3505,6 +3729,46 @@ func init() {
       }
     },
     "/ipam": {
+      "delete": {
+        "tags": [
+          "ipam"
+        ],
+        "summary": "Release all IP addresses allocated for an owner",
+        "parameters": [
+          {
+            "type": "string",
+            "description": "Owner of the addresses to release",
+            "name": "owner",
+            "in": "query",
+            "required": true
+          },
+          {
+            "type": "string",
+            "description": "ID of the container the addresses are allocated for",
+            "name": "container-id",
+            "in": "query"
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Success"
+          },
+          "400": {
+            "description": "Invalid owner",
+            "x-go-name": "Invalid"
+          },
+          "404": {
+            "description": "No IP address allocated for owner"
+          },
+          "500": {
+            "description": "Address release failure",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            },
+            "x-go-name": "Failure"
+          }
+        }
+      },
       "post": {
         "tags": [
           "ipam"
@@ -3524,6 +3788,18 @@ func init() {
             "type": "string",
             "name": "owner",
             "in": "query"
+          },
+          {
+            "type": "string",
+            "description": "ID of the container the addresses are allocated for",
+            "name": "container-id",
+            "in": "query"
+          },
+          {
+            "type": "string",
+            "description": "Subnet within the allocation range to allocate from",
+            "name": "subnet",
+            "in": "query"
           }
         ],
         "responses": {
@@ -3539,6 +3815,13 @@ func init() {
               "$ref": "#/definitions/Error"
             },
             "x-go-name": "Failure"
+          },
+          "507": {
+            "description": "No IP address available in the allocation range or subnet",
+            "schema": {
+              "$ref": "#/definitions/Error"
+            },
+            "x-go-name": "Exhausted"
           }
         }
       }
@@ -3561,6 +3844,12 @@ func init() {
             "type": "string",
             "name": "owner",
             "in": "query"
+          },
+          {
+            "type": "string",
+            "description": "ID of the container the addresses are allocated for",
+            "name": "container-id",
+            "in": "query"
           }
         ],
         "responses": {
@@ -4168,6 +4457,32 @@ func init() {
         }
       }
     },
+    "CNIEvent": {
+      "description": "Event reported by the CNI plugin about a pod",
+      "type": "object",
+      "properties": {
+        "container-id": {
+          "description": "ID of the container",
+          "type": "string"
+        },
+        "error": {
+          "description": "Error message describing the failure",
+          "type": "string"
+        },
+        "k8s-namespace": {
+          "description": "Kubernetes namespace of the pod",
+          "type": "string"
+        },
+        "k8s-pod-name": {
+          "description": "Kubernetes name of the pod",
+          "type": "string"
+        },
+        "phase": {
+          "description": "Phase of the CNI operation the event relates to",
+          "type": "string"
+        }
+      }
+    },
     "ClusterStatus": {
       "description": "Status of cluster",
       "properties": {
@@ -4351,6 +4666,14 @@ func init() {
           "description": "MTU on workload facing devices",
           "type": "integer"
         },
+        "endpoint-builds-queued": {
+          "description": "Number of endpoint builds waiting for a build permit",
+          "type": "integer"
+        },
+        "endpoint-restore-in-progress": {
+          "description": "True while endpoints restored from a previous run are being regenerated",
+          "type": "boolean"
+        },
         "immutable": {
           "description": "Immutable configuration (read-only)",
           "$ref": "#/definitions/ConfigurationMap"
@@ -4485,6 +4808,10 @@ func init() {
           "description": "Docker network ID",
           "type": "string"
         },
+        "external-ipam": {
+          "description": "Whether the addresses of the endpoint are managed outside of Cilium and must not be released",
+          "type": "boolean"
+        },
         "host-mac": {
           "description": "MAC address",
           "type": "string"
@@ -4501,6 +4828,10 @@ func init() {
           "description": "Name of network device",
           "type": "string"
         },
+        "ipam-allocation-duration": {
+          "description": "Duration of the IP allocation in nanoseconds as observed by the caller",
+          "type": "integer"
+        },
         "k8s-namespace": {
           "description": "Kubernetes namespace name",
           "type": "string"
@@ -4525,6 +4856,29 @@ func init() {
           "description": "Whether policy enforcement is enabled or not",
           "type": "boolean"
         },
+        "policy-exempt": {
+          "description": "Direction in which policy enforcement of the endpoint is disabled",
+          "type": "string",
+          "enum": [
+            "ingress",
+            "egress",
+            "both"
+          ]
+        },
+        "properties": {
+          "description": "Properties recorded by the creator of the endpoint, e.g. for auditing",
+          "type": "object",
+          "additionalProperties": {
+            "type": "string"
+          }
+        },
+        "secondary-addressing": {
+          "description": "Secondary addresses of the endpoint, released together with the endpoint",
+          "type": "array",
+          "items": {
+            "$ref": "#/definitions/AddressPair"
+          }
+        },
         "state": {
           "description": "Current state of endpoint",
           "$ref": "#/definitions/EndpointState"
@@ -4787,6 +5141,13 @@ func init() {
           "description": "The policy applied to this endpoint from the policy repository",
           "$ref": "#/definitions/EndpointPolicyStatus"
         },
+        "properties": {
+          "description": "Properties recorded by the creator of the endpoint, e.g. for auditing",
+          "type": "object",
+          "additionalProperties": {
+            "type": "string"
+          }
+        },
         "realized": {
           "description": "The configuration in effect on this endpoint",
           "$ref": "#/definitions/EndpointConfigurationSpec"
@@ -4867,8 +5228,21 @@ func init() {
         "address": {
           "$ref": "#/definitions/AddressPair"
         },
+        "expiration": {
+          "description": "Expiration of the lease of the addresses, unset if the addresses do not expire",
+          "type": "string",
+          "format": "date-time"
+        },
         "host-addressing": {
           "$ref": "#/definitions/NodeAddressing"
+        },
+        "ipv4-pool": {
+          "description": "Name of the IP pool the IPv4 address was allocated from, unset unless a pool was selected",
+          "type": "string"
+        },
+        "ipv6-pool": {
+          "description": "Name of the IP pool the IPv6 address was allocated from, unset unless a pool was selected",
+          "type": "string"
         }
       }
     },
@@ -5539,6 +5913,12 @@ func init() {
       "in": "path",
       "required": true
     },
+    "ipam-container-id": {
+      "type": "string",
+      "description": "ID of the container the addresses are allocated for",
+      "name": "container-id",
+      "in": "query"
+    },
     "ipam-family": {
       "enum": [
         "ipv4",
@@ -5560,6 +5940,12 @@ func init() {
       "name": "owner",
       "in": "query"
     },
+    "ipam-subnet": {
+      "type": "string",
+      "description": "Subnet within the allocation range to allocate from",
+      "name": "subnet",
+      "in": "query"
+    },
     "labels": {
       "description": "List of labels\n",
       "name": "labels",
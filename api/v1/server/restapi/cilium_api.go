@@ -51,6 +51,9 @@ func NewCiliumAPI(spec *loads.Document) *CiliumAPI {
 		PolicyDeleteFqdnCacheHandler: policy.DeleteFqdnCacheHandlerFunc(func(params policy.DeleteFqdnCacheParams) middleware.Responder {
 			return middleware.NotImplemented("operation PolicyDeleteFqdnCache has not yet been implemented")
 		}),
+		IPAMDeleteIPAMHandler: ipam.DeleteIPAMHandlerFunc(func(params ipam.DeleteIPAMParams) middleware.Responder {
+			return middleware.NotImplemented("operation IPAMDeleteIPAM has not yet been implemented")
+		}),
 		IPAMDeleteIPAMIPHandler: ipam.DeleteIPAMIPHandlerFunc(func(params ipam.DeleteIPAMIPParams) middleware.Responder {
 			return middleware.NotImplemented("operation IPAMDeleteIPAMIP has not yet been implemented")
 		}),
@@ -141,6 +144,9 @@ func NewCiliumAPI(spec *loads.Document) *CiliumAPI {
 		PrefilterPatchPrefilterHandler: prefilter.PatchPrefilterHandlerFunc(func(params prefilter.PatchPrefilterParams) middleware.Responder {
 			return middleware.NotImplemented("operation PrefilterPatchPrefilter has not yet been implemented")
 		}),
+		DaemonPostCNIEventHandler: daemon.PostCNIEventHandlerFunc(func(params daemon.PostCNIEventParams) middleware.Responder {
+			return middleware.NotImplemented("operation DaemonPostCNIEvent has not yet been implemented")
+		}),
 		IPAMPostIPAMHandler: ipam.PostIPAMHandlerFunc(func(params ipam.PostIPAMParams) middleware.Responder {
 			return middleware.NotImplemented("operation IPAMPostIPAM has not yet been implemented")
 		}),
@@ -191,6 +197,8 @@ type CiliumAPI struct {
 	EndpointDeleteEndpointIDHandler endpoint.DeleteEndpointIDHandler
 	// PolicyDeleteFqdnCacheHandler sets the operation handler for the delete fqdn cache operation
 	PolicyDeleteFqdnCacheHandler policy.DeleteFqdnCacheHandler
+	// IPAMDeleteIPAMHandler sets the operation handler for the delete IP a m operation
+	IPAMDeleteIPAMHandler ipam.DeleteIPAMHandler
 	// IPAMDeleteIPAMIPHandler sets the operation handler for the delete IP a m IP operation
 	IPAMDeleteIPAMIPHandler ipam.DeleteIPAMIPHandler
 	// PolicyDeletePolicyHandler sets the operation handler for the delete policy operation
@@ -251,6 +259,8 @@ type CiliumAPI struct {
 	EndpointPatchEndpointIDLabelsHandler endpoint.PatchEndpointIDLabelsHandler
 	// PrefilterPatchPrefilterHandler sets the operation handler for the patch prefilter operation
 	PrefilterPatchPrefilterHandler prefilter.PatchPrefilterHandler
+	// DaemonPostCNIEventHandler sets the operation handler for the post c n i event operation
+	DaemonPostCNIEventHandler daemon.PostCNIEventHandler
 	// IPAMPostIPAMHandler sets the operation handler for the post IP a m operation
 	IPAMPostIPAMHandler ipam.PostIPAMHandler
 	// IPAMPostIPAMIPHandler sets the operation handler for the post IP a m IP operation
@@ -332,6 +342,10 @@ func (o *CiliumAPI) Validate() error {
 		unregistered = append(unregistered, "policy.DeleteFqdnCacheHandler")
 	}
 
+	if o.IPAMDeleteIPAMHandler == nil {
+		unregistered = append(unregistered, "ipam.DeleteIPAMHandler")
+	}
+
 	if o.IPAMDeleteIPAMIPHandler == nil {
 		unregistered = append(unregistered, "ipam.DeleteIPAMIPHandler")
 	}
@@ -452,6 +466,10 @@ func (o *CiliumAPI) Validate() error {
 		unregistered = append(unregistered, "prefilter.PatchPrefilterHandler")
 	}
 
+	if o.DaemonPostCNIEventHandler == nil {
+		unregistered = append(unregistered, "daemon.PostCNIEventHandler")
+	}
+
 	if o.IPAMPostIPAMHandler == nil {
 		unregistered = append(unregistered, "ipam.PostIPAMHandler")
 	}
@@ -580,6 +598,11 @@ func (o *CiliumAPI) initHandlerCache() {
 	}
 	o.handlers["DELETE"]["/fqdn/cache"] = policy.NewDeleteFqdnCache(o.context, o.PolicyDeleteFqdnCacheHandler)
 
+	if o.handlers["DELETE"] == nil {
+		o.handlers["DELETE"] = make(map[string]http.Handler)
+	}
+	o.handlers["DELETE"]["/ipam"] = ipam.NewDeleteIPAM(o.context, o.IPAMDeleteIPAMHandler)
+
 	if o.handlers["DELETE"] == nil {
 		o.handlers["DELETE"] = make(map[string]http.Handler)
 	}
@@ -730,6 +753,11 @@ func (o *CiliumAPI) initHandlerCache() {
 	}
 	o.handlers["PATCH"]["/prefilter"] = prefilter.NewPatchPrefilter(o.context, o.PrefilterPatchPrefilterHandler)
 
+	if o.handlers["POST"] == nil {
+		o.handlers["POST"] = make(map[string]http.Handler)
+	}
+	o.handlers["POST"]["/cni-event"] = daemon.NewPostCNIEvent(o.context, o.DaemonPostCNIEventHandler)
+
 	if o.handlers["POST"] == nil {
 		o.handlers["POST"] = make(map[string]http.Handler)
 	}
@@ -31,6 +31,10 @@ type PostIPAMIPParams struct {
 	// HTTP Request Object
 	HTTPRequest *http.Request `json:"-"`
 
+	/*ID of the container the addresses are allocated for
+	  In: query
+	*/
+	ContainerID *string
 	/*IP address
 	  Required: true
 	  In: path
@@ -53,6 +57,11 @@ func (o *PostIPAMIPParams) BindRequest(r *http.Request, route *middleware.Matche
 
 	qs := runtime.Values(r.URL.Query())
 
+	qContainerID, qhkContainerID, _ := qs.GetOK("container-id")
+	if err := o.bindContainerID(qContainerID, qhkContainerID, route.Formats); err != nil {
+		res = append(res, err)
+	}
+
 	rIP, rhkIP, _ := route.Params.GetOK("ip")
 	if err := o.bindIP(rIP, rhkIP, route.Formats); err != nil {
 		res = append(res, err)
@@ -69,6 +78,24 @@ func (o *PostIPAMIPParams) BindRequest(r *http.Request, route *middleware.Matche
 	return nil
 }
 
+// bindContainerID binds and validates parameter ContainerID from query.
+func (o *PostIPAMIPParams) bindContainerID(rawData []string, hasKey bool, formats strfmt.Registry) error {
+	var raw string
+	if len(rawData) > 0 {
+		raw = rawData[len(rawData)-1]
+	}
+
+	// Required: false
+	// AllowEmptyValue: false
+	if raw == "" { // empty values pass all other validations
+		return nil
+	}
+
+	o.ContainerID = &raw
+
+	return nil
+}
+
 // bindIP binds and validates parameter IP from path.
 func (o *PostIPAMIPParams) bindIP(rawData []string, hasKey bool, formats strfmt.Registry) error {
 	var raw string
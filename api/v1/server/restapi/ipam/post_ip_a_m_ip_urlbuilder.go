@@ -14,7 +14,8 @@ import (
 
 // PostIPAMIPURL generates an URL for the post IP a m IP operation
 type PostIPAMIPURL struct {
-	IP string
+	ContainerID *string
+	IP          string
 
 	Owner *string
 
@@ -59,6 +60,14 @@ func (o *PostIPAMIPURL) Build() (*url.URL, error) {
 
 	qs := make(url.Values)
 
+	var containerID string
+	if o.ContainerID != nil {
+		containerID = *o.ContainerID
+	}
+	if containerID != "" {
+		qs.Set("container-id", containerID)
+	}
+
 	var owner string
 	if o.Owner != nil {
 		owner = *o.Owner
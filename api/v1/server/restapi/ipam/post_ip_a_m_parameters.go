@@ -32,6 +32,10 @@ type PostIPAMParams struct {
 	// HTTP Request Object
 	HTTPRequest *http.Request `json:"-"`
 
+	/*ID of the container the addresses are allocated for
+	  In: query
+	*/
+	ContainerID *string
 	/*
 	  In: query
 	*/
@@ -40,6 +44,10 @@ type PostIPAMParams struct {
 	  In: query
 	*/
 	Owner *string
+	/*Subnet within the allocation range to allocate from
+	  In: query
+	*/
+	Subnet *string
 }
 
 // BindRequest both binds and validates a request, it assumes that complex things implement a Validatable(strfmt.Registry) error interface
@@ -53,6 +61,11 @@ func (o *PostIPAMParams) BindRequest(r *http.Request, route *middleware.MatchedR
 
 	qs := runtime.Values(r.URL.Query())
 
+	qContainerID, qhkContainerID, _ := qs.GetOK("container-id")
+	if err := o.bindContainerID(qContainerID, qhkContainerID, route.Formats); err != nil {
+		res = append(res, err)
+	}
+
 	qFamily, qhkFamily, _ := qs.GetOK("family")
 	if err := o.bindFamily(qFamily, qhkFamily, route.Formats); err != nil {
 		res = append(res, err)
@@ -63,12 +76,35 @@ func (o *PostIPAMParams) BindRequest(r *http.Request, route *middleware.MatchedR
 		res = append(res, err)
 	}
 
+	qSubnet, qhkSubnet, _ := qs.GetOK("subnet")
+	if err := o.bindSubnet(qSubnet, qhkSubnet, route.Formats); err != nil {
+		res = append(res, err)
+	}
+
 	if len(res) > 0 {
 		return errors.CompositeValidationError(res...)
 	}
 	return nil
 }
 
+// bindContainerID binds and validates parameter ContainerID from query.
+func (o *PostIPAMParams) bindContainerID(rawData []string, hasKey bool, formats strfmt.Registry) error {
+	var raw string
+	if len(rawData) > 0 {
+		raw = rawData[len(rawData)-1]
+	}
+
+	// Required: false
+	// AllowEmptyValue: false
+	if raw == "" { // empty values pass all other validations
+		return nil
+	}
+
+	o.ContainerID = &raw
+
+	return nil
+}
+
 // bindFamily binds and validates parameter Family from query.
 func (o *PostIPAMParams) bindFamily(rawData []string, hasKey bool, formats strfmt.Registry) error {
 	var raw string
@@ -118,3 +154,21 @@ func (o *PostIPAMParams) bindOwner(rawData []string, hasKey bool, formats strfmt
 
 	return nil
 }
+
+// bindSubnet binds and validates parameter Subnet from query.
+func (o *PostIPAMParams) bindSubnet(rawData []string, hasKey bool, formats strfmt.Registry) error {
+	var raw string
+	if len(rawData) > 0 {
+		raw = rawData[len(rawData)-1]
+	}
+
+	// Required: false
+	// AllowEmptyValue: false
+	if raw == "" { // empty values pass all other validations
+		return nil
+	}
+
+	o.Subnet = &raw
+
+	return nil
+}
@@ -98,3 +98,45 @@ func (o *PostIPAMFailure) WriteResponse(rw http.ResponseWriter, producer runtime
 		panic(err) // let the recovery middleware deal with this
 	}
 }
+
+// PostIPAMExhaustedCode is the HTTP code returned for type PostIPAMExhausted
+const PostIPAMExhaustedCode int = 507
+
+/*PostIPAMExhausted No IP address available in the allocation range or subnet
+
+swagger:response postIpAMExhausted
+*/
+type PostIPAMExhausted struct {
+
+	/*
+	  In: Body
+	*/
+	Payload models.Error `json:"body,omitempty"`
+}
+
+// NewPostIPAMExhausted creates PostIPAMExhausted with default headers values
+func NewPostIPAMExhausted() *PostIPAMExhausted {
+
+	return &PostIPAMExhausted{}
+}
+
+// WithPayload adds the payload to the post Ip a m exhausted response
+func (o *PostIPAMExhausted) WithPayload(payload models.Error) *PostIPAMExhausted {
+	o.Payload = payload
+	return o
+}
+
+// SetPayload sets the payload to the post Ip a m exhausted response
+func (o *PostIPAMExhausted) SetPayload(payload models.Error) {
+	o.Payload = payload
+}
+
+// WriteResponse to the client
+func (o *PostIPAMExhausted) WriteResponse(rw http.ResponseWriter, producer runtime.Producer) {
+
+	rw.WriteHeader(507)
+	payload := o.Payload
+	if err := producer.Produce(rw, payload); err != nil {
+		panic(err) // let the recovery middleware deal with this
+	}
+}
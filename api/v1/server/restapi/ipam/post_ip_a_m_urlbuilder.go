@@ -13,8 +13,9 @@ import (
 
 // PostIPAMURL generates an URL for the post IP a m operation
 type PostIPAMURL struct {
-	Family *string
-	Owner  *string
+	ContainerID *string
+	Family      *string
+	Owner       *string
 
 	_basePath string
 	// avoid unkeyed usage
@@ -50,6 +51,14 @@ func (o *PostIPAMURL) Build() (*url.URL, error) {
 
 	qs := make(url.Values)
 
+	var containerID string
+	if o.ContainerID != nil {
+		containerID = *o.ContainerID
+	}
+	if containerID != "" {
+		qs.Set("container-id", containerID)
+	}
+
 	var family string
 	if o.Family != nil {
 		family = *o.Family
@@ -71,7 +71,7 @@ func configureHealthRouting(netns, dev string, addressing *models.NodeAddressing
 	routes := []route.Route{}
 
 	if option.Config.EnableIPv4 {
-		v4Routes, err := connector.IPv4Routes(addressing, mtuConfig.GetRouteMTU())
+		v4Routes, err := connector.IPv4Routes(addressing, mtuConfig.GetRouteMTU(), mtuConfig.GetDeviceMTU())
 		if err == nil {
 			routes = append(routes, v4Routes...)
 		} else {
@@ -80,7 +80,7 @@ func configureHealthRouting(netns, dev string, addressing *models.NodeAddressing
 	}
 
 	if option.Config.EnableIPv6 {
-		v6Routes, err := connector.IPv6Routes(addressing, mtuConfig.GetRouteMTU())
+		v6Routes, err := connector.IPv6Routes(addressing, mtuConfig.GetRouteMTU(), mtuConfig.GetDeviceMTU())
 		if err != nil {
 			return fmt.Errorf("Failed to get IPv6 routes")
 		}
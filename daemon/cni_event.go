@@ -0,0 +1,99 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	restapi "github.com/cilium/cilium/api/v1/server/restapi/daemon"
+	"github.com/cilium/cilium/pkg/api"
+	"github.com/cilium/cilium/pkg/k8s"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/node"
+
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// cniEventReason is the reason of the events relayed for the CNI plugin
+	cniEventReason = "CiliumCNIFailure"
+
+	// cniEventComponent is the source component of the relayed events
+	cniEventComponent = "cilium-agent"
+)
+
+type postCNIEvent struct {
+	daemon *Daemon
+}
+
+func NewPostCNIEventHandler(d *Daemon) restapi.PostCNIEventHandler {
+	return &postCNIEvent{daemon: d}
+}
+
+func (h *postCNIEvent) Handle(params restapi.PostCNIEventParams) middleware.Responder {
+	ev := params.Event
+	scopedLog := log.WithFields(logrus.Fields{
+		logfields.K8sNamespace: ev.K8sNamespace,
+		logfields.K8sPodName:   ev.K8sPodName,
+		logfields.ContainerID:  ev.ContainerID,
+		"phase":                ev.Phase,
+		logrus.ErrorKey:        ev.Error,
+	})
+	scopedLog.Debug("POST /cni-event request")
+
+	if ev.K8sNamespace == "" || ev.K8sPodName == "" {
+		return api.Error(restapi.PostCNIEventBadRequestCode,
+			fmt.Errorf("pod namespace and name are required"))
+	}
+
+	if !k8s.IsEnabled() {
+		scopedLog.Warning("CNI plugin failed to set up pod networking")
+		return restapi.NewPostCNIEventOK()
+	}
+
+	now := metav1.NewTime(time.Now())
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: ev.K8sPodName + ".",
+			Namespace:    ev.K8sNamespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:      "Pod",
+			Namespace: ev.K8sNamespace,
+			Name:      ev.K8sPodName,
+		},
+		Reason:  cniEventReason,
+		Message: fmt.Sprintf("Failed to set up networking of container %s during %s: %s", ev.ContainerID, ev.Phase, ev.Error),
+		Source: v1.EventSource{
+			Component: cniEventComponent,
+			Host:      node.GetName(),
+		},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           v1.EventTypeWarning,
+	}
+
+	if _, err := k8s.Client().CoreV1().Events(ev.K8sNamespace).Create(event); err != nil {
+		scopedLog.WithError(err).Warning("Unable to relay CNI event to Kubernetes")
+		return api.Error(restapi.PostCNIEventFailureCode, err)
+	}
+
+	return restapi.NewPostCNIEventOK()
+}
@@ -186,6 +186,24 @@ type Daemon struct {
 
 	// ipam is the IP address manager of the agent
 	ipam *ipam.IPAM
+
+	// endpointRestoreComplete is closed once all restored endpoints have
+	// been regenerated. It is nil if no endpoints are being restored.
+	endpointRestoreComplete chan struct{}
+}
+
+// endpointRestoreInProgress returns true while endpoints restored from a
+// previous run are being regenerated
+func (d *Daemon) endpointRestoreInProgress() bool {
+	if d.endpointRestoreComplete == nil {
+		return false
+	}
+	select {
+	case <-d.endpointRestoreComplete:
+		return false
+	default:
+		return true
+	}
 }
 
 // Datapath returns a reference to the datapath implementation.
@@ -243,6 +261,14 @@ func (d *Daemon) RemoveNetworkPolicy(e *endpoint.Endpoint) {
 	d.l7Proxy.RemoveNetworkPolicy(e)
 }
 
+// endpointBuildsQueued returns the number of endpoint builds waiting for a
+// build permit
+func (d *Daemon) endpointBuildsQueued() int {
+	d.uniqueIDMU.Lock()
+	defer d.uniqueIDMU.Unlock()
+	return len(d.uniqueID)
+}
+
 // QueueEndpointBuild waits for a "build permit" for the endpoint
 // identified by 'epID'. This function blocks until the endpoint can
 // start building.  The returned function must then be called to
@@ -1508,6 +1534,8 @@ func (h *getConfig) Handle(params GetConfigParams) middleware.Responder {
 			MasterDeviceIndex: int64(option.Config.Ipvlan.MasterDeviceIndex),
 			OperationMode:     option.Config.Ipvlan.OperationMode,
 		},
+		EndpointRestoreInProgress: d.endpointRestoreInProgress(),
+		EndpointBuildsQueued:      int64(d.endpointBuildsQueued()),
 	}
 
 	cfg := &models.DaemonConfiguration{
@@ -1259,6 +1259,7 @@ func runDaemon() {
 		// received the full list of policies present at the time the daemon
 		// is bootstrapped.
 		restoreComplete := d.regenerateRestoredEndpoints(restoredEndpoints)
+		d.endpointRestoreComplete = restoreComplete
 		go func() {
 			<-restoreComplete
 			endParallelMapMode()
@@ -1403,6 +1404,9 @@ func (d *Daemon) instantiateAPI() *restapi.CiliumAPI {
 	api.DaemonGetConfigHandler = NewGetConfigHandler(d)
 	api.DaemonPatchConfigHandler = NewPatchConfigHandler(d)
 
+	// /cni-event/
+	api.DaemonPostCNIEventHandler = NewPostCNIEventHandler(d)
+
 	// /endpoint/
 	api.EndpointGetEndpointHandler = NewGetEndpointHandler(d)
 
@@ -1458,6 +1462,9 @@ func (d *Daemon) instantiateAPI() *restapi.CiliumAPI {
 	api.IPAMPostIPAMIPHandler = NewPostIPAMIPHandler(d)
 	api.IPAMDeleteIPAMIPHandler = NewDeleteIPAMIPHandler(d)
 
+	// /ipam/
+	api.IPAMDeleteIPAMHandler = NewDeleteIPAMHandler(d)
+
 	// /debuginfo
 	api.DaemonGetDebuginfoHandler = NewGetDebugInfoHandler(d)
 
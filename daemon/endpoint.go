@@ -32,6 +32,7 @@ import (
 	"github.com/cilium/cilium/pkg/labels"
 	"github.com/cilium/cilium/pkg/logging/logfields"
 	"github.com/cilium/cilium/pkg/maps/lxcmap"
+	"github.com/cilium/cilium/pkg/metrics"
 	"github.com/cilium/cilium/pkg/option"
 	"github.com/cilium/cilium/pkg/workloads"
 
@@ -164,6 +165,10 @@ func (d *Daemon) createEndpoint(ctx context.Context, epTemplate *models.Endpoint
 		return invalidDataError(ep, fmt.Errorf("unable to parse endpoint parameters: %s", err))
 	}
 
+	if epTemplate.IPAMAllocationDuration > 0 {
+		metrics.IpamCNIAllocationDuration.Observe(time.Duration(epTemplate.IPAMAllocationDuration).Seconds())
+	}
+
 	oldEp := endpointmanager.LookupCiliumID(ep.ID)
 	if oldEp != nil {
 		return invalidDataError(ep, fmt.Errorf("endpoint ID %d already exists", ep.ID))
@@ -465,6 +470,23 @@ func (h *patchEndpointID) Handle(params PatchEndpointIDParams) middleware.Respon
 		}
 	}
 
+	// Secondary addresses are only added, e.g. when an interface is
+	// attached to the endpoint. They are released when the endpoint is
+	// deleted.
+	for _, ip := range newEp.SecondaryIPs {
+		known := false
+		for _, existing := range ep.SecondaryIPs {
+			if existing.Equal(ip) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			ep.SecondaryIPs = append(ep.SecondaryIPs, ip)
+			changed = true
+		}
+	}
+
 	// TODO: Do something with the labels?
 	// addLabels := labels.NewLabelsFromModel(params.Endpoint.Labels)
 
@@ -580,7 +602,7 @@ func (d *Daemon) deleteEndpointQuiet(ep *endpoint.Endpoint, conf endpoint.Delete
 		}
 	}
 
-	if !conf.NoIPRelease {
+	if !conf.NoIPRelease && !ep.ExternalIPAM {
 		if option.Config.EnableIPv4 {
 			if err := d.ipam.ReleaseIP(ep.IPv4.IP()); err != nil {
 				errs = append(errs, fmt.Errorf("unable to release ipv4 address: %s", err))
@@ -591,6 +613,11 @@ func (d *Daemon) deleteEndpointQuiet(ep *endpoint.Endpoint, conf endpoint.Delete
 				errs = append(errs, fmt.Errorf("unable to release ipv6 address: %s", err))
 			}
 		}
+		for _, ip := range ep.SecondaryIPs {
+			if err := d.ipam.ReleaseIP(ip); err != nil {
+				errs = append(errs, fmt.Errorf("unable to release secondary address %s: %s", ip, err))
+			}
+		}
 	}
 
 	completionCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
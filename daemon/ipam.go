@@ -15,11 +15,14 @@
 package main
 
 import (
+	"fmt"
+	"net"
 	"strings"
 
 	"github.com/cilium/cilium/api/v1/models"
 	ipamapi "github.com/cilium/cilium/api/v1/server/restapi/ipam"
 	"github.com/cilium/cilium/pkg/api"
+	"github.com/cilium/cilium/pkg/ipam"
 	"github.com/cilium/cilium/pkg/node"
 	"github.com/cilium/cilium/pkg/option"
 
@@ -45,9 +48,40 @@ func (h *postIPAM) Handle(params ipamapi.PostIPAMParams) middleware.Responder {
 
 	family := strings.ToLower(swag.StringValue(params.Family))
 	owner := swag.StringValue(params.Owner)
-	ipv4, ipv6, err := h.daemon.ipam.AllocateNext(family, owner)
-	if err != nil {
-		return api.Error(ipamapi.PostIPAMFailureCode, err)
+
+	var ipv4, ipv6 net.IP
+	if subnet := swag.StringValue(params.Subnet); subnet != "" {
+		_, ipNet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return api.Error(ipamapi.PostIPAMFailureCode, fmt.Errorf("invalid subnet %q: %s", subnet, err))
+		}
+		isIPv6 := ipNet.IP.To4() == nil
+		if (family == "ipv4" && isIPv6) || (family == "ipv6" && !isIPv6) {
+			return api.Error(ipamapi.PostIPAMFailureCode, fmt.Errorf("subnet %s does not match family %s", subnet, family))
+		}
+		ip, err := h.daemon.ipam.AllocateNextInSubnet(ipNet, owner)
+		if err != nil {
+			return allocationFailure(err)
+		}
+		if isIPv6 {
+			ipv6 = ip
+		} else {
+			ipv4 = ip
+		}
+	} else {
+		var err error
+		ipv4, ipv6, err = h.daemon.ipam.AllocateNext(family, owner)
+		if err != nil {
+			return allocationFailure(err)
+		}
+	}
+
+	if containerID := swag.StringValue(params.ContainerID); containerID != "" {
+		for _, ip := range []net.IP{ipv4, ipv6} {
+			if ip != nil {
+				h.daemon.ipam.SetContainer(ip, containerID)
+			}
+		}
 	}
 
 	if ipv4 != nil {
@@ -61,6 +95,15 @@ func (h *postIPAM) Handle(params ipamapi.PostIPAMParams) middleware.Responder {
 	return ipamapi.NewPostIPAMCreated().WithPayload(resp)
 }
 
+// allocationFailure returns the response to a failed allocation. Exhaustion
+// is reported with its own code as retrying is unlikely to succeed.
+func allocationFailure(err error) middleware.Responder {
+	if ipam.IsExhausted(err) {
+		return api.Error(ipamapi.PostIPAMExhaustedCode, err)
+	}
+	return api.Error(ipamapi.PostIPAMFailureCode, err)
+}
+
 type postIPAMIP struct {
 	daemon *Daemon
 }
@@ -78,6 +121,9 @@ func (h *postIPAMIP) Handle(params ipamapi.PostIPAMIPParams) middleware.Responde
 	if err := h.daemon.ipam.AllocateIPString(params.IP, owner); err != nil {
 		return api.Error(ipamapi.PostIPAMIPFailureCode, err)
 	}
+	if containerID := swag.StringValue(params.ContainerID); containerID != "" {
+		h.daemon.ipam.SetContainer(net.ParseIP(params.IP), containerID)
+	}
 
 	return ipamapi.NewPostIPAMIPOK()
 }
@@ -99,6 +145,28 @@ func (h *deleteIPAMIP) Handle(params ipamapi.DeleteIPAMIPParams) middleware.Resp
 	return ipamapi.NewDeleteIPAMIPOK()
 }
 
+type deleteIPAM struct {
+	daemon *Daemon
+}
+
+// NewDeleteIPAMHandler handle incoming requests to delete the addresses of
+// an owner.
+func NewDeleteIPAMHandler(d *Daemon) ipamapi.DeleteIPAMHandler {
+	return &deleteIPAM{daemon: d}
+}
+
+func (h *deleteIPAM) Handle(params ipamapi.DeleteIPAMParams) middleware.Responder {
+	released, err := h.daemon.ipam.ReleaseOwner(params.Owner, swag.StringValue(params.ContainerID))
+	if err != nil {
+		return api.Error(ipamapi.DeleteIPAMFailureCode, err)
+	}
+	if len(released) == 0 {
+		return ipamapi.NewDeleteIPAMNotFound()
+	}
+
+	return ipamapi.NewDeleteIPAMOK()
+}
+
 // DumpIPAM dumps in the form of a map, the list of
 // reserved IPv4 and IPv6 addresses.
 func (d *Daemon) DumpIPAM() *models.IPAMStatus {
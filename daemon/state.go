@@ -189,6 +189,9 @@ func (d *Daemon) restoreOldEndpoints(dir string, clean bool) (*endpointRestoreSt
 		if existingEndpoints != nil {
 			delete(existingEndpoints, ep.IPv4.String())
 			delete(existingEndpoints, ep.IPv6.String())
+			for _, ip := range ep.SecondaryIPs {
+				delete(existingEndpoints, ip.String())
+			}
 		}
 	}
 
@@ -416,6 +419,10 @@ func (d *Daemon) regenerateRestoredEndpoints(state *endpointRestoreState) (resto
 func (d *Daemon) allocateIPsLocked(ep *endpoint.Endpoint) error {
 	var err error
 
+	if ep.ExternalIPAM {
+		return nil
+	}
+
 	if option.Config.EnableIPv6 && ep.IPv6 != nil {
 		err = d.ipam.AllocateIP(ep.IPv6.IP(), ep.HumanStringLocked()+" [restored]")
 		if err != nil {
@@ -433,6 +440,21 @@ func (d *Daemon) allocateIPsLocked(ep *endpoint.Endpoint) error {
 		if err = d.ipam.AllocateIP(ep.IPv4.IP(), ep.HumanStringLocked()+" [restored]"); err != nil {
 			return fmt.Errorf("unable to reallocate IPv4 address: %s", err)
 		}
+
+		defer func() {
+			if err != nil {
+				d.ipam.ReleaseIP(ep.IPv4.IP())
+			}
+		}()
+	}
+
+	for i, ip := range ep.SecondaryIPs {
+		if err = d.ipam.AllocateIP(ip, ep.HumanStringLocked()+" [restored]"); err != nil {
+			for _, allocated := range ep.SecondaryIPs[:i] {
+				d.ipam.ReleaseIP(allocated)
+			}
+			return fmt.Errorf("unable to reallocate secondary address %s: %s", ip, err)
+		}
 	}
 
 	return nil
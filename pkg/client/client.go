@@ -38,6 +38,15 @@ import (
 
 type Client struct {
 	clientapi.Cilium
+	transport *http.Transport
+}
+
+// Close closes all idle connections of the client. Clients should be closed
+// once they are no longer needed to release their sockets.
+func (c *Client) Close() {
+	if c.transport != nil {
+		c.transport.CloseIdleConnections()
+	}
 }
 
 // DefaultSockPath returns deafult UNIX domain socket path or
@@ -99,6 +108,7 @@ func NewDefaultClientWithTimeout(timeout time.Duration) (*Client, error) {
 		for {
 			select {
 			case <-timeoutAfter:
+				c.Close()
 				return nil, fmt.Errorf("failed to create cilium agent client after %f seconds timeout: %s", timeout.Seconds(), err)
 			default:
 			}
@@ -140,7 +150,7 @@ func NewClient(host string) (*Client, error) {
 	httpClient := &http.Client{Transport: transport}
 	clientTrans := runtime_client.NewWithClient(tmp[1], clientapi.DefaultBasePath,
 		clientapi.DefaultSchemes, httpClient)
-	return &Client{*clientapi.New(clientTrans, strfmt.Default)}, nil
+	return &Client{Cilium: *clientapi.New(clientTrans, strfmt.Default), transport: transport}, nil
 }
 
 // ClientError is the error returned by all client functions which use Hint()
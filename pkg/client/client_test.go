@@ -22,6 +22,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cilium/cilium/api/v1/client/ipam"
+	"github.com/cilium/cilium/api/v1/models"
+
 	"golang.org/x/net/context"
 	. "gopkg.in/check.v1"
 )
@@ -60,3 +63,26 @@ func (cs *ClientTestSuite) TestHint(c *C) {
 
 	c.Assert(Hint(err), ErrorMatches, "Cilium API client timeout exceeded")
 }
+
+func (cs *ClientTestSuite) TestHintIPAMAllocate(c *C) {
+	c.Assert(hintIPAMAllocate(nil), IsNil)
+
+	err := hintIPAMAllocate(&ipam.PostIPAMExhausted{Payload: models.Error("no free IP in subnet 10.0.0.0/30")})
+	c.Assert(err, FitsTypeOf, IPAMExhaustedError{})
+	c.Assert(err, ErrorMatches, "no free IP in subnet 10.0.0.0/30")
+	c.Assert(err.(IPAMExhaustedError).Recoverable(), Equals, false)
+
+	// Other failures are not classified by their message
+	err = hintIPAMAllocate(&ipam.PostIPAMFailure{Payload: models.Error("range is full")})
+	c.Assert(err, FitsTypeOf, ClientError{})
+}
+
+func (cs *ClientTestSuite) TestClose(c *C) {
+	cl, err := NewClient("unix:///var/run/cilium/does-not-exist.sock")
+	c.Assert(err, IsNil)
+	cl.Close()
+	// Closing twice must be safe
+	cl.Close()
+
+	(&Client{}).Close()
+}
@@ -47,3 +47,11 @@ func (c *Client) ConfigPatch(cfg models.DaemonConfigurationSpec) error {
 	_, err = c.Daemon.PatchConfig(params)
 	return Hint(err)
 }
+
+// CNIEventPost reports an event of the CNI plugin to the daemon, which relays
+// it to the orchestration system.
+func (c *Client) CNIEventPost(ev *models.CNIEvent) error {
+	params := daemon.NewPostCNIEventParams().WithEvent(ev).WithTimeout(api.ClientTimeout)
+	_, err := c.Daemon.PostCNIEvent(params)
+	return Hint(err)
+}
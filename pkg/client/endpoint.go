@@ -15,6 +15,8 @@
 package client
 
 import (
+	"time"
+
 	"github.com/cilium/cilium/api/v1/client/endpoint"
 	"github.com/cilium/cilium/api/v1/models"
 	"github.com/cilium/cilium/pkg/api"
@@ -46,8 +48,14 @@ func (c *Client) EndpointGet(id string) (*models.Endpoint, error) {
 
 // EndpointCreate creates a new endpoint
 func (c *Client) EndpointCreate(ep *models.EndpointChangeRequest) error {
+	return c.EndpointCreateWithTimeout(ep, api.ClientTimeout)
+}
+
+// EndpointCreateWithTimeout creates a new endpoint and waits up to timeout
+// for the agent to respond
+func (c *Client) EndpointCreateWithTimeout(ep *models.EndpointChangeRequest, timeout time.Duration) error {
 	id := pkgEndpointID.NewCiliumID(ep.ID)
-	params := endpoint.NewPutEndpointIDParams().WithID(id).WithEndpoint(ep).WithTimeout(api.ClientTimeout)
+	params := endpoint.NewPutEndpointIDParams().WithID(id).WithEndpoint(ep).WithTimeout(timeout)
 	_, err := c.Endpoint.PutEndpointID(params)
 	return Hint(err)
 }
@@ -15,6 +15,8 @@
 package client
 
 import (
+	"fmt"
+
 	"github.com/cilium/cilium/api/v1/client/ipam"
 	"github.com/cilium/cilium/api/v1/models"
 	"github.com/cilium/cilium/pkg/api"
@@ -25,8 +27,33 @@ const (
 	AddressFamilyIPv4 = "ipv4"
 )
 
+// IPAMExhaustedError is returned when no IP could be allocated because the
+// pool is exhausted. Retrying is unlikely to succeed until IPs are released.
+type IPAMExhaustedError struct {
+	ClientError
+}
+
+// hintIPAMAllocate is like Hint but returns an IPAMExhaustedError if the
+// agent reports that the allocation failed due to pool exhaustion
+func hintIPAMAllocate(err error) error {
+	if e, ok := err.(*ipam.PostIPAMExhausted); ok {
+		return IPAMExhaustedError{newUnrecoverableError("%s", e.Payload)}
+	}
+	return Hint(err)
+}
+
 // IPAMAllocate allocates an IP address out of address family specific pool.
 func (c *Client) IPAMAllocate(family, owner string) (*models.IPAMResponse, error) {
+	return c.IPAMAllocateContainer(family, owner, "", "")
+}
+
+// IPAMAllocateContainer allocates an IP address for a container out of the
+// address family specific pool. The agent records the container ID, if not
+// empty, so that IPAMReleaseOwner can release the addresses of a single
+// container of the owner. If subnet is not empty, the IP is allocated within
+// the given subnet of the pool and the allocation fails if no IP is
+// available in the subnet.
+func (c *Client) IPAMAllocateContainer(family, owner, containerID, subnet string) (*models.IPAMResponse, error) {
 	params := ipam.NewPostIPAMParams().WithTimeout(api.ClientTimeout)
 
 	if family != "" {
@@ -37,16 +64,28 @@ func (c *Client) IPAMAllocate(family, owner string) (*models.IPAMResponse, error
 		params.SetOwner(&owner)
 	}
 
+	if containerID != "" {
+		params.SetContainerID(&containerID)
+	}
+
+	if subnet != "" {
+		params.SetSubnet(&subnet)
+	}
+
 	resp, err := c.IPAM.PostIPAM(params)
 	if err != nil {
-		return nil, Hint(err)
+		return nil, hintIPAMAllocate(err)
 	}
 	return resp.Payload, nil
 }
 
-// IPAMAllocateIP tries to allocate a particular IP address.
-func (c *Client) IPAMAllocateIP(ip, owner string) error {
+// IPAMAllocateIP tries to allocate a particular IP address. The container
+// ID is recorded as for IPAMAllocateContainer if not empty.
+func (c *Client) IPAMAllocateIP(ip, owner, containerID string) error {
 	params := ipam.NewPostIPAMIPParams().WithIP(ip).WithOwner(&owner).WithTimeout(api.ClientTimeout)
+	if containerID != "" {
+		params.SetContainerID(&containerID)
+	}
 	_, err := c.IPAM.PostIPAMIP(params)
 	return Hint(err)
 }
@@ -57,3 +96,32 @@ func (c *Client) IPAMReleaseIP(ip string) error {
 	_, err := c.IPAM.DeleteIPAMIP(params)
 	return Hint(err)
 }
+
+// IPAMAllocations returns the owners of all allocated IP addresses, indexed
+// by IP address.
+func (c *Client) IPAMAllocations() (models.AllocationMap, error) {
+	resp, err := c.Daemon.GetHealthz(nil)
+	if err != nil {
+		return nil, Hint(err)
+	}
+	if resp.Payload == nil || resp.Payload.IPAM == nil {
+		return nil, fmt.Errorf("IPAM status is not available")
+	}
+	return resp.Payload.IPAM.Allocations, nil
+}
+
+// IPAMReleaseOwner releases all IP addresses allocated for the owner back to
+// the pool. If containerID is not empty, only the addresses allocated for the
+// container are released. It is not an error if no address is allocated for
+// the owner.
+func (c *Client) IPAMReleaseOwner(owner, containerID string) error {
+	params := ipam.NewDeleteIPAMParams().WithOwner(owner).WithTimeout(api.ClientTimeout)
+	if containerID != "" {
+		params.SetContainerID(&containerID)
+	}
+	_, err := c.IPAM.DeleteIPAM(params)
+	if _, ok := err.(*ipam.DeleteIPAMNotFound); ok {
+		return nil
+	}
+	return Hint(err)
+}
@@ -34,6 +34,9 @@ type Route struct {
 	Scope   netlink.Scope
 	Table   int
 	Type    int
+	// Priority is the metric of the route, lower values are preferred.
+	// Zero leaves the metric to the kernel default.
+	Priority int
 }
 
 // LogFields returns the route attributes as logrus.Fields map
@@ -50,7 +53,8 @@ func (r *Route) getLogger() *logrus.Entry {
 	return log.WithFields(r.LogFields())
 }
 
-// ByMask is used to sort an array of routes by mask, narrow first.
+// ByMask is used to sort an array of routes by mask, narrow first. Routes
+// with the same mask are sorted by priority, preferred first.
 type ByMask []Route
 
 func (a ByMask) Len() int {
@@ -60,6 +64,9 @@ func (a ByMask) Len() int {
 func (a ByMask) Less(i, j int) bool {
 	lenA, _ := a[i].Prefix.Mask.Size()
 	lenB, _ := a[j].Prefix.Mask.Size()
+	if lenA == lenB {
+		return a[i].Priority < a[j].Priority
+	}
 	return lenA > lenB
 }
 
@@ -80,6 +87,9 @@ func (r *Route) ToIPCommand(dev string) []string {
 	if r.MTU != 0 {
 		res = append(res, "mtu", fmt.Sprintf("%d", r.MTU))
 	}
+	if r.Priority != 0 {
+		res = append(res, "metric", fmt.Sprintf("%d", r.Priority))
+	}
 	res = append(res, "dev", dev)
 	return res
 }
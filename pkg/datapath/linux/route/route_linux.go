@@ -51,6 +51,7 @@ func (r *Route) getNetlinkRoute() netlink.Route {
 		Protocol: r.Proto,
 		Table:    r.Table,
 		Type:     r.Type,
+		Priority: r.Priority,
 	}
 
 	if r.Nexthop != nil {
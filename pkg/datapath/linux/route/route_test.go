@@ -19,6 +19,7 @@ package route
 import (
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 	"testing"
 
@@ -75,3 +76,38 @@ func (p *RouteSuite) TestToIPCommand(c *C) {
 		c.Assert(result, checker.DeepEquals, expRes)
 	}
 }
+
+func (p *RouteSuite) TestToIPCommandPriority(c *C) {
+	r := &Route{
+		Prefix: net.IPNet{
+			IP:   net.ParseIP("10.0.0.0"),
+			Mask: net.CIDRMask(8, 32),
+		},
+		Nexthop:  parseIP("192.168.0.1"),
+		Priority: 100,
+	}
+	c.Assert(strings.Join(r.ToIPCommand("eth0"), " "), checker.DeepEquals,
+		"ip route add 10.0.0.0/8 via 192.168.0.1 metric 100 dev eth0")
+}
+
+func (p *RouteSuite) TestByMaskPriority(c *C) {
+	prefix := func(cidr string) net.IPNet {
+		_, n, err := net.ParseCIDR(cidr)
+		c.Assert(err, IsNil)
+		return *n
+	}
+	routes := []Route{
+		{Prefix: prefix("0.0.0.0/0"), Priority: 200},
+		{Prefix: prefix("0.0.0.0/0"), Priority: 100},
+		{Prefix: prefix("10.0.0.0/8"), Priority: 300},
+		{Prefix: prefix("10.0.0.0/8")},
+	}
+	sort.Sort(ByMask(routes))
+
+	// Specificity takes precedence over priority
+	c.Assert(routes[0].Prefix.String(), Equals, "10.0.0.0/8")
+	c.Assert(routes[0].Priority, Equals, 0)
+	c.Assert(routes[1].Priority, Equals, 300)
+	c.Assert(routes[2].Priority, Equals, 100)
+	c.Assert(routes[3].Priority, Equals, 200)
+}
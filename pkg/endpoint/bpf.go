@@ -878,6 +878,10 @@ func (e *Endpoint) GetBPFKeys() []*lxcmap.EndpointKey {
 		keys = append(keys, lxcmap.NewEndpointKey(e.IPv4.IP()))
 	}
 
+	for _, ip := range e.SecondaryIPs {
+		keys = append(keys, lxcmap.NewEndpointKey(ip))
+	}
+
 	return keys
 }
 
@@ -37,14 +37,44 @@ const (
 	hostInterfacePrefix = "lxc"
 	// temporaryInterfacePrefix is the temporary interface prefix while setting up libNetwork interface.
 	temporaryInterfacePrefix = "tmp"
+	// MinIfNameHashLen is the minimum number of characters of the endpoint
+	// hash kept in host interface names.
+	MinIfNameHashLen = 7
 )
 
 // Endpoint2IfName returns the host interface name for the given endpointID.
 func Endpoint2IfName(endpointID string) string {
+	return Endpoint2IfNameWithPrefix(hostInterfacePrefix, endpointID)
+}
+
+// Endpoint2IfNameWithPrefix returns the host interface name for the given
+// endpointID using the given interface name prefix. The default prefix is
+// used if prefix is empty.
+func Endpoint2IfNameWithPrefix(prefix, endpointID string) string {
+	if prefix == "" {
+		prefix = hostInterfacePrefix
+	}
 	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(endpointID)))
 	// returned string length should be < unix.IFNAMSIZ
-	truncateLength := uint(unix.IFNAMSIZ - len(temporaryInterfacePrefix) - 1)
-	return hostInterfacePrefix + truncateString(sum, truncateLength)
+	truncateLength := uint(unix.IFNAMSIZ - len(prefix) - 1)
+	return prefix + truncateString(sum, truncateLength)
+}
+
+// ValidateIfNamePrefix returns an error if the given prefix cannot be used
+// as host interface name prefix. The prefix must leave room for at least
+// MinIfNameHashLen characters of the endpoint hash.
+func ValidateIfNamePrefix(prefix string) error {
+	if len(prefix) > unix.IFNAMSIZ-1-MinIfNameHashLen {
+		return fmt.Errorf("interface prefix %q is too long, max %d characters",
+			prefix, unix.IFNAMSIZ-1-MinIfNameHashLen)
+	}
+	for _, c := range prefix {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') &&
+			!(c >= '0' && c <= '9') && c != '-' && c != '_' {
+			return fmt.Errorf("interface prefix %q contains invalid character %q", prefix, c)
+		}
+	}
+	return nil
 }
 
 // Endpoint2TempIfName returns the temporary interface name for the given
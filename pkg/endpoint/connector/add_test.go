@@ -0,0 +1,56 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package connector
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ConnectorSuite struct{}
+
+var _ = Suite(&ConnectorSuite{})
+
+func (s *ConnectorSuite) TestEndpoint2IfNameWithPrefix(c *C) {
+	c.Assert(Endpoint2IfNameWithPrefix("", "1234"), Equals, Endpoint2IfName("1234"))
+
+	name := Endpoint2IfNameWithPrefix("cil", "1234")
+	c.Assert(strings.HasPrefix(name, "cil"), Equals, true)
+	c.Assert(name[3:], Equals, Endpoint2IfName("1234")[3:])
+
+	// The hash is shortened to fit longer prefixes into IFNAMSIZ
+	name = Endpoint2IfNameWithPrefix("cilium-", "1234")
+	c.Assert(strings.HasPrefix(name, "cilium-"), Equals, true)
+	c.Assert(len(name), Equals, unix.IFNAMSIZ-1)
+}
+
+func (s *ConnectorSuite) TestValidateIfNamePrefix(c *C) {
+	for _, prefix := range []string{"", "lxc", "cil_", "pod-1", "abcdefgh"} {
+		c.Assert(ValidateIfNamePrefix(prefix), IsNil)
+	}
+	c.Assert(ValidateIfNamePrefix("abcdefghi"), ErrorMatches, `interface prefix "abcdefghi" is too long, max 8 characters`)
+	c.Assert(ValidateIfNamePrefix("lxc.1"), ErrorMatches, `interface prefix "lxc.1" contains invalid character '.'`)
+	c.Assert(ValidateIfNamePrefix("lxc/"), ErrorMatches, `interface prefix "lxc/" contains invalid character '/'`)
+}
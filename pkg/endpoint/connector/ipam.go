@@ -36,7 +36,9 @@ func IPv4Gateway(addr *models.NodeAddressing) string {
 }
 
 // IPv6Routes returns IPv6 routes to be installed in endpoint's networking namespace.
-func IPv6Routes(addr *models.NodeAddressing, linkMTU int) ([]route.Route, error) {
+// The link-scope route to the gateway uses the device MTU, the default route
+// which may cross a tunnel uses the route MTU.
+func IPv6Routes(addr *models.NodeAddressing, routeMTU, deviceMTU int) ([]route.Route, error) {
 	ip := net.ParseIP(addr.IPV6.IP)
 	if ip == nil {
 		return []route.Route{}, fmt.Errorf("Invalid IP address: %s", addr.IPV6.IP)
@@ -47,17 +49,20 @@ func IPv6Routes(addr *models.NodeAddressing, linkMTU int) ([]route.Route, error)
 				IP:   ip,
 				Mask: defaults.ContainerIPv6Mask,
 			},
+			MTU: deviceMTU,
 		},
 		{
 			Prefix:  defaults.IPv6DefaultRoute,
 			Nexthop: &ip,
-			MTU:     linkMTU,
+			MTU:     routeMTU,
 		},
 	}, nil
 }
 
 // IPv4Routes returns IPv4 routes to be installed in endpoint's networking namespace.
-func IPv4Routes(addr *models.NodeAddressing, linkMTU int) ([]route.Route, error) {
+// The link-scope route to the gateway uses the device MTU, the default route
+// which may cross a tunnel uses the route MTU.
+func IPv4Routes(addr *models.NodeAddressing, routeMTU, deviceMTU int) ([]route.Route, error) {
 	ip := net.ParseIP(addr.IPV4.IP)
 	if ip == nil {
 		return []route.Route{}, fmt.Errorf("Invalid IP address: %s", addr.IPV4.IP)
@@ -68,11 +73,12 @@ func IPv4Routes(addr *models.NodeAddressing, linkMTU int) ([]route.Route, error)
 				IP:   ip,
 				Mask: defaults.ContainerIPv4Mask,
 			},
+			MTU: deviceMTU,
 		},
 		{
 			Prefix:  defaults.IPv4DefaultRoute,
 			Nexthop: &ip,
-			MTU:     linkMTU,
+			MTU:     routeMTU,
 		},
 	}, nil
 }
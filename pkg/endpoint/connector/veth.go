@@ -16,6 +16,7 @@ package connector
 
 import (
 	"fmt"
+	"net"
 	"path/filepath"
 
 	"github.com/cilium/cilium/api/v1/models"
@@ -28,15 +29,67 @@ import (
 )
 
 // SetupVethRemoteNs renames the netdevice in the target namespace to the
-// provided dstIfName.
-func SetupVethRemoteNs(netNs ns.NetNS, srcIfName, dstIfName string) (int, int, error) {
-	return 0, 0, netNs.Do(func(_ ns.NetNS) error {
-		err := link.Rename(srcIfName, dstIfName)
+// provided dstIfName. It returns the interface index and the MAC address of
+// the renamed netdevice which can be used to verify that a later lookup by
+// name still refers to the same device.
+func SetupVethRemoteNs(netNs ns.NetNS, srcIfName, dstIfName string) (int, net.HardwareAddr, error) {
+	var (
+		ifIndex int
+		mac     net.HardwareAddr
+	)
+	err := netNs.Do(func(_ ns.NetNS) error {
+		l, err := netlink.LinkByName(srcIfName)
+		if err != nil {
+			return fmt.Errorf("failed to lookup veth %q: %s", srcIfName, err)
+		}
+		ifIndex = l.Attrs().Index
+		mac = l.Attrs().HardwareAddr
+
+		err = link.Rename(srcIfName, dstIfName)
 		if err != nil {
 			return fmt.Errorf("failed to rename veth from %q to %q: %s", srcIfName, dstIfName, err)
 		}
 		return nil
 	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return ifIndex, mac, nil
+}
+
+// VethOptions contains optional parameters for the setup of a veth pair.
+type VethOptions struct {
+	// HostIfPrefix is the name prefix of the host side interface. The
+	// default prefix is used if empty.
+	HostIfPrefix string
+
+	// HostIfName is the name of the host side interface. It takes
+	// precedence over HostIfPrefix, the name is derived from the ID if
+	// empty.
+	HostIfName string
+
+	// Queues is the number of RX and TX queues of both ends of the veth
+	// pair. The kernel default of a single queue is used if 0.
+	Queues int
+
+	// DeferHostLinkUp leaves the host side interface down. The caller is
+	// responsible for bringing it up, e.g. once the endpoint is ready.
+	DeferHostLinkUp bool
+
+	// HostPromisc puts the host side interface into promiscuous mode.
+	HostPromisc bool
+}
+
+// MaxVethQueues is the maximum number of RX/TX queues of a veth pair.
+const MaxVethQueues = 64
+
+// ValidateVethQueues returns an error if the given number of queues is not
+// supported for a veth pair.
+func ValidateVethQueues(queues int) error {
+	if queues < 0 || queues > MaxVethQueues {
+		return fmt.Errorf("invalid number of veth queues %d, must be between 0 and %d", queues, MaxVethQueues)
+	}
+	return nil
 }
 
 // SetupVeth sets up the net interface, the temporary interface and fills up some endpoint
@@ -44,15 +97,50 @@ func SetupVethRemoteNs(netNs ns.NetNS, srcIfName, dstIfName string) (int, int, e
 // veth, a pointer for the temporary link, the name of the temporary link and error if
 // something fails.
 func SetupVeth(id string, mtu int, ep *models.EndpointChangeRequest) (*netlink.Veth, *netlink.Link, string, error) {
+	return SetupVethWithOptions(id, mtu, VethOptions{}, ep)
+}
+
+// SetupVethWithOptions is identical to SetupVeth but allows to customize
+// the setup of the veth pair with the given options.
+func SetupVethWithOptions(id string, mtu int, opts VethOptions, ep *models.EndpointChangeRequest) (*netlink.Veth, *netlink.Link, string, error) {
 	if id == "" {
 		return nil, nil, "", fmt.Errorf("invalid: empty ID")
 	}
 
-	lxcIfName := Endpoint2IfName(id)
+	if err := ValidateVethQueues(opts.Queues); err != nil {
+		return nil, nil, "", err
+	}
+
+	lxcIfName := opts.HostIfName
+	if lxcIfName == "" {
+		lxcIfName = Endpoint2IfNameWithPrefix(opts.HostIfPrefix, id)
+	}
 	tmpIfName := Endpoint2TempIfName(id)
 
-	veth, link, err := SetupVethWithNames(lxcIfName, tmpIfName, mtu, ep)
-	return veth, link, tmpIfName, err
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{
+			Name:        lxcIfName,
+			NumTxQueues: opts.Queues,
+			NumRxQueues: opts.Queues,
+		},
+		PeerName: tmpIfName,
+	}
+
+	link, err := setupVeth(veth, mtu, !opts.DeferHostLinkUp, ep)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	if opts.HostPromisc {
+		if err := netlink.SetPromiscOn(veth); err != nil {
+			if err2 := netlink.LinkDel(veth); err2 != nil {
+				log.WithError(err2).WithField(logfields.Veth, veth.Name).Warn("failed to clean up veth")
+			}
+			return nil, nil, "", fmt.Errorf("unable to enable promiscuous mode on %s: %s", lxcIfName, err)
+		}
+	}
+
+	return veth, link, tmpIfName, nil
 }
 
 // SetupVethWithNames sets up the net interface, the temporary interface and fills up some endpoint
@@ -65,8 +153,18 @@ func SetupVethWithNames(lxcIfName, tmpIfName string, mtu int, ep *models.Endpoin
 		PeerName:  tmpIfName,
 	}
 
-	if err := netlink.LinkAdd(veth); err != nil {
-		return nil, nil, fmt.Errorf("unable to create veth pair: %s", err)
+	link, err := setupVeth(veth, mtu, true, ep)
+	return veth, link, err
+}
+
+// setupVeth creates the given veth pair and fills up the endpoint fields as
+// described in SetupVethWithNames. The host side interface is only brought
+// up if up is true.
+func setupVeth(veth *netlink.Veth, mtu int, up bool, ep *models.EndpointChangeRequest) (*netlink.Link, error) {
+	lxcIfName, tmpIfName := veth.Name, veth.PeerName
+
+	if err := linkAddVeth(veth); err != nil {
+		return nil, fmt.Errorf("unable to create veth pair: %s", err)
 	}
 	var err error
 	defer func() {
@@ -85,29 +183,31 @@ func SetupVethWithNames(lxcIfName, tmpIfName string, mtu int, ep *models.Endpoin
 	rpFilterPath := filepath.Join("/proc", "sys", "net", "ipv4", "conf", lxcIfName, "rp_filter")
 	err = WriteSysConfig(rpFilterPath, "0\n")
 	if err != nil {
-		return nil, nil, fmt.Errorf("unable to disable %s: %s", rpFilterPath, err)
+		return nil, fmt.Errorf("unable to disable %s: %s", rpFilterPath, err)
 	}
 
 	peer, err := netlink.LinkByName(tmpIfName)
 	if err != nil {
-		return nil, nil, fmt.Errorf("unable to lookup veth peer just created: %s", err)
+		return nil, fmt.Errorf("unable to lookup veth peer just created: %s", err)
 	}
 
 	if err = netlink.LinkSetMTU(peer, mtu); err != nil {
-		return nil, nil, fmt.Errorf("unable to set MTU to %q: %s", tmpIfName, err)
+		return nil, fmt.Errorf("unable to set MTU to %q: %s", tmpIfName, err)
 	}
 
 	hostVeth, err := netlink.LinkByName(lxcIfName)
 	if err != nil {
-		return nil, nil, fmt.Errorf("unable to lookup veth just created: %s", err)
+		return nil, fmt.Errorf("unable to lookup veth just created: %s", err)
 	}
 
 	if err = netlink.LinkSetMTU(hostVeth, mtu); err != nil {
-		return nil, nil, fmt.Errorf("unable to set MTU to %q: %s", lxcIfName, err)
+		return nil, fmt.Errorf("unable to set MTU to %q: %s", lxcIfName, err)
 	}
 
-	if err = netlink.LinkSetUp(veth); err != nil {
-		return nil, nil, fmt.Errorf("unable to bring up veth pair: %s", err)
+	if up {
+		if err = netlink.LinkSetUp(veth); err != nil {
+			return nil, fmt.Errorf("unable to bring up veth pair: %s", err)
+		}
 	}
 
 	ep.Mac = peer.Attrs().HardwareAddr.String()
@@ -115,5 +215,5 @@ func SetupVethWithNames(lxcIfName, tmpIfName string, mtu int, ep *models.Endpoin
 	ep.InterfaceIndex = int64(hostVeth.Attrs().Index)
 	ep.InterfaceName = lxcIfName
 
-	return veth, &peer, nil
+	return &peer, nil
 }
@@ -0,0 +1,114 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux,privileged_tests
+
+package connector
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cilium/cilium/api/v1/models"
+
+	"github.com/vishvananda/netlink"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type ConnectorPrivilegedTestSuite struct{}
+
+var _ = Suite(&ConnectorPrivilegedTestSuite{})
+
+// countQueues returns the number of RX and TX queues of the given interface
+func countQueues(c *C, ifName string) (rx, tx int) {
+	files, err := ioutil.ReadDir(filepath.Join("/sys/class/net", ifName, "queues"))
+	c.Assert(err, IsNil)
+	for _, f := range files {
+		switch {
+		case strings.HasPrefix(f.Name(), "rx-"):
+			rx++
+		case strings.HasPrefix(f.Name(), "tx-"):
+			tx++
+		}
+	}
+	return
+}
+
+func (s *ConnectorPrivilegedTestSuite) TestSetupVethQueues(c *C) {
+	ep := &models.EndpointChangeRequest{}
+	veth, peer, tmpIfName, err := SetupVethWithOptions("veth-queues-test", 1500, VethOptions{Queues: 4}, ep)
+	c.Assert(err, IsNil)
+	defer netlink.LinkDel(veth)
+
+	c.Assert((*peer).Attrs().Name, Equals, tmpIfName)
+
+	rx, tx := countQueues(c, veth.Name)
+	c.Assert(rx, Equals, 4)
+	c.Assert(tx, Equals, 4)
+
+	rx, tx = countQueues(c, tmpIfName)
+	c.Assert(rx, Equals, 4)
+	c.Assert(tx, Equals, 4)
+}
+
+func (s *ConnectorPrivilegedTestSuite) TestSetupVethInvalidQueues(c *C) {
+	ep := &models.EndpointChangeRequest{}
+	_, _, _, err := SetupVethWithOptions("veth-queues-test", 1500, VethOptions{Queues: MaxVethQueues + 1}, ep)
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *ConnectorPrivilegedTestSuite) TestSetupVethDeferHostLinkUp(c *C) {
+	ep := &models.EndpointChangeRequest{}
+	veth, _, _, err := SetupVethWithOptions("veth-defer-test", 1500, VethOptions{DeferHostLinkUp: true}, ep)
+	c.Assert(err, IsNil)
+	defer netlink.LinkDel(veth)
+
+	link, err := netlink.LinkByName(veth.Name)
+	c.Assert(err, IsNil)
+	c.Assert(link.Attrs().Flags&net.FlagUp, Equals, net.Flags(0))
+}
+
+func (s *ConnectorPrivilegedTestSuite) TestSetupVethHostLinkUp(c *C) {
+	ep := &models.EndpointChangeRequest{}
+	veth, _, _, err := SetupVethWithOptions("veth-up-test", 1500, VethOptions{}, ep)
+	c.Assert(err, IsNil)
+	defer netlink.LinkDel(veth)
+
+	link, err := netlink.LinkByName(veth.Name)
+	c.Assert(err, IsNil)
+	c.Assert(link.Attrs().Flags&net.FlagUp, Equals, net.FlagUp)
+}
+
+func (s *ConnectorPrivilegedTestSuite) TestSetupVethHostPromisc(c *C) {
+	ep := &models.EndpointChangeRequest{}
+	veth, _, tmpIfName, err := SetupVethWithOptions("veth-promisc-test", 1500, VethOptions{HostPromisc: true}, ep)
+	c.Assert(err, IsNil)
+	defer netlink.LinkDel(veth)
+
+	link, err := netlink.LinkByName(veth.Name)
+	c.Assert(err, IsNil)
+	c.Assert(link.Attrs().Promisc, Equals, 1)
+
+	peer, err := netlink.LinkByName(tmpIfName)
+	c.Assert(err, IsNil)
+	c.Assert(peer.Attrs().Promisc, Equals, 0)
+}
@@ -0,0 +1,77 @@
+// Copyright 2016-2018 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+
+package connector
+
+import (
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// linkAddVeth creates the veth pair. The vendored netlink library only
+// applies the number of RX and TX queues to the primary end of the pair, a
+// pair with multiple queues is therefore created with a request of its own
+// which applies them to the peer as well.
+func linkAddVeth(veth *netlink.Veth) error {
+	if veth.NumTxQueues <= 0 && veth.NumRxQueues <= 0 {
+		return netlink.LinkAdd(veth)
+	}
+
+	req := nl.NewNetlinkRequest(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL|unix.NLM_F_ACK)
+	req.AddData(nl.NewIfInfomsg(unix.AF_UNSPEC))
+	req.AddData(nl.NewRtAttr(unix.IFLA_IFNAME, nl.ZeroTerminated(veth.Name)))
+	for _, attr := range linkQueueAttrs(&veth.LinkAttrs) {
+		req.AddData(attr)
+	}
+
+	linkInfo := nl.NewRtAttr(unix.IFLA_LINKINFO, nil)
+	linkInfo.AddRtAttr(nl.IFLA_INFO_KIND, nl.NonZeroTerminated(veth.Type()))
+	data := linkInfo.AddRtAttr(nl.IFLA_INFO_DATA, nil)
+	peer := data.AddRtAttr(nl.VETH_INFO_PEER, nil)
+	nl.NewIfInfomsgChild(peer, unix.AF_UNSPEC)
+	peer.AddRtAttr(unix.IFLA_IFNAME, nl.ZeroTerminated(veth.PeerName))
+	for _, attr := range linkQueueAttrs(&veth.LinkAttrs) {
+		peer.AddChild(attr)
+	}
+	req.AddData(linkInfo)
+
+	if _, err := req.Execute(unix.NETLINK_ROUTE, 0); err != nil {
+		return err
+	}
+
+	link, err := netlink.LinkByName(veth.Name)
+	if err != nil {
+		return err
+	}
+	veth.Index = link.Attrs().Index
+	return nil
+}
+
+// linkQueueAttrs returns the attributes setting the transmit queue length
+// and the number of queues of an interface, as set by netlink.LinkAdd
+func linkQueueAttrs(attrs *netlink.LinkAttrs) []*nl.RtAttr {
+	var rtAttrs []*nl.RtAttr
+	if attrs.TxQLen >= 0 {
+		rtAttrs = append(rtAttrs, nl.NewRtAttr(unix.IFLA_TXQLEN, nl.Uint32Attr(uint32(attrs.TxQLen))))
+	}
+	if attrs.NumTxQueues > 0 {
+		rtAttrs = append(rtAttrs, nl.NewRtAttr(unix.IFLA_NUM_TX_QUEUES, nl.Uint32Attr(uint32(attrs.NumTxQueues))))
+	}
+	if attrs.NumRxQueues > 0 {
+		rtAttrs = append(rtAttrs, nl.NewRtAttr(unix.IFLA_NUM_RX_QUEUES, nl.Uint32Attr(uint32(attrs.NumRxQueues))))
+	}
+	return rtAttrs
+}
@@ -222,6 +222,25 @@ type Endpoint struct {
 	// K8sNamespace is the Kubernetes namespace of the endpoint
 	K8sNamespace string
 
+	// Properties are arbitrary properties recorded by the creator of the
+	// endpoint, e.g. the CNI request which created it
+	Properties map[string]string
+
+	// PolicyExempt is the direction in which policy enforcement of the
+	// endpoint is disabled, one of the EndpointChangeRequestPolicyExempt
+	// values of the API or empty if policy is enforced normally
+	PolicyExempt string
+
+	// ExternalIPAM is true if the addresses of the endpoint are managed
+	// outside of Cilium. They are neither reserved on restore nor released
+	// when the endpoint is deleted.
+	ExternalIPAM bool
+
+	// SecondaryIPs are addresses of the endpoint in addition to IPv4 and
+	// IPv6. They are allocated by the creator of the endpoint and released
+	// together with the endpoint.
+	SecondaryIPs []net.IP
+
 	// policyRevision is the policy revision this endpoint is currently on
 	// to modify this field please use endpoint.setPolicyRevision instead
 	policyRevision uint64
@@ -448,6 +467,9 @@ func NewEndpointFromChangeModel(base *models.EndpointChangeRequest) (*Endpoint,
 		IfName:           base.InterfaceName,
 		K8sPodName:       base.K8sPodName,
 		K8sNamespace:     base.K8sNamespace,
+		Properties:       base.Properties,
+		PolicyExempt:     base.PolicyExempt,
+		ExternalIPAM:     base.ExternalIPAM,
 		DatapathMapID:    int(base.DatapathMapID),
 		IfIndex:          int(base.InterfaceIndex),
 		OpLabels:         pkgLabels.NewOpLabels(),
@@ -494,6 +516,19 @@ func NewEndpointFromChangeModel(base *models.EndpointChangeRequest) (*Endpoint,
 		}
 	}
 
+	for _, pair := range base.SecondaryAddressing {
+		for _, addr := range []string{pair.IPV6, pair.IPV4} {
+			if addr == "" {
+				continue
+			}
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid secondary address %q", addr)
+			}
+			ep.SecondaryIPs = append(ep.SecondaryIPs, ip)
+		}
+	}
+
 	ep.SetDefaultOpts(option.Config.Opts)
 
 	ep.UpdateLogger(nil)
@@ -542,6 +577,18 @@ func (e *Endpoint) GetModelRLocked() *models.Endpoint {
 		spec.Options = *e.Options.GetMutableModel()
 	}
 
+	addressing := []*models.AddressPair{{
+		IPV4: e.IPv4.String(),
+		IPV6: e.IPv6.String(),
+	}}
+	for _, ip := range e.SecondaryIPs {
+		if ip.To4() != nil {
+			addressing = append(addressing, &models.AddressPair{IPV4: ip.String()})
+		} else {
+			addressing = append(addressing, &models.AddressPair{IPV6: ip.String()})
+		}
+	}
+
 	mdl := &models.Endpoint{
 		ID:   int64(e.ID),
 		Spec: spec,
@@ -552,10 +599,7 @@ func (e *Endpoint) GetModelRLocked() *models.Endpoint {
 			Identity: e.SecurityIdentity.GetModel(),
 			Labels:   lblMdl,
 			Networking: &models.EndpointNetworking{
-				Addressing: []*models.AddressPair{{
-					IPV4: e.IPv4.String(),
-					IPV6: e.IPv6.String(),
-				}},
+				Addressing:     addressing,
 				InterfaceIndex: int64(e.IfIndex),
 				InterfaceName:  e.IfName,
 				Mac:            e.LXCMAC.String(),
@@ -571,6 +615,7 @@ func (e *Endpoint) GetModelRLocked() *models.Endpoint {
 			// FIXME GH-3280 When we begin returning endpoint revisions this should
 			// change to return the configured and in-datapath policies.
 			Policy:      e.GetPolicyModel(),
+			Properties:  e.Properties,
 			Log:         statusLog,
 			Controllers: controllerMdl,
 			State:       currentState, // TODO: Validate
@@ -2173,6 +2218,19 @@ func (e *Endpoint) IPs() []net.IP {
 	if e.IPv6.IsSet() {
 		ips = append(ips, e.IPv6.IP())
 	}
+	return append(ips, e.SecondaryIPs...)
+}
+
+// secondaryCiliumIPs returns the secondary IPs of the endpoint
+func (e *Endpoint) secondaryCiliumIPs() []addressing.CiliumIP {
+	ips := make([]addressing.CiliumIP, 0, len(e.SecondaryIPs))
+	for _, ip := range e.SecondaryIPs {
+		if ip.To4() != nil {
+			ips = append(ips, addressing.DeriveCiliumIPv4(ip))
+		} else {
+			ips = append(ips, addressing.DeriveCiliumIPv6(ip))
+		}
+	}
 	return ips
 }
 
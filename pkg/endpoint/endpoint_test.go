@@ -22,7 +22,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cilium/cilium/api/v1/models"
 	"github.com/cilium/cilium/common/addressing"
+	"github.com/cilium/cilium/pkg/identity"
 	"github.com/cilium/cilium/pkg/identity/cache"
 	"github.com/cilium/cilium/pkg/k8s/apis/cilium.io"
 	"github.com/cilium/cilium/pkg/kvstore"
@@ -30,6 +32,7 @@ import (
 	"github.com/cilium/cilium/pkg/lock"
 	"github.com/cilium/cilium/pkg/policy"
 	"github.com/cilium/cilium/pkg/policy/api"
+	"github.com/cilium/cilium/pkg/policy/trafficdirection"
 	. "gopkg.in/check.v1"
 )
 
@@ -403,6 +406,81 @@ func (s *EndpointSuite) TestProxyID(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *EndpointSuite) TestSecondaryIPs(c *C) {
+	e, err := NewEndpointFromChangeModel(&models.EndpointChangeRequest{
+		Addressing: &models.AddressPair{
+			IPV4: IPv4Addr.String(),
+			IPV6: IPv6Addr.String(),
+		},
+		SecondaryAddressing: []*models.AddressPair{
+			{IPV4: "10.11.12.14"},
+			{IPV6: "beef:beef:beef:beef:aaaa:aaaa:1111:1113"},
+		},
+	})
+	c.Assert(err, IsNil)
+
+	var ips []string
+	for _, ip := range e.IPs() {
+		ips = append(ips, ip.String())
+	}
+	c.Assert(ips, DeepEquals, []string{"10.11.12.13", "beef:beef:beef:beef:aaaa:aaaa:1111:1112", "10.11.12.14", "beef:beef:beef:beef:aaaa:aaaa:1111:1113"})
+
+	// Secondary IPs are entered into the endpoint map
+	var keys []string
+	for _, k := range e.GetBPFKeys() {
+		keys = append(keys, k.ToIP().String())
+	}
+	c.Assert(keys, DeepEquals, []string{"beef:beef:beef:beef:aaaa:aaaa:1111:1112", "10.11.12.13", "10.11.12.14", "beef:beef:beef:beef:aaaa:aaaa:1111:1113"})
+
+	// and synchronized into the ipcache by the family of the address
+	secondary := e.secondaryCiliumIPs()
+	c.Assert(secondary, HasLen, 2)
+	c.Assert(secondary[0].GetFamilyString(), Equals, "IPv4")
+	c.Assert(secondary[0].String(), Equals, "10.11.12.14")
+	c.Assert(secondary[1].GetFamilyString(), Equals, "IPv6")
+	c.Assert(secondary[1].String(), Equals, "beef:beef:beef:beef:aaaa:aaaa:1111:1113")
+}
+
+func (s *EndpointSuite) TestExemptPolicy(c *C) {
+	identityCache := cache.IdentityCache{
+		identity.NumericIdentity(1000): pkgLabels.LabelArray{},
+	}
+	selectorPolicy := &policy.SelectorPolicy{
+		IngressPolicyEnabled: true,
+		EgressPolicyEnabled:  true,
+	}
+
+	for _, t := range []struct {
+		exempt  string
+		ingress bool
+		egress  bool
+	}{
+		{"", true, true},
+		{"ingress", false, true},
+		{"egress", true, false},
+		{"both", false, false},
+		{"bogus", true, true},
+	} {
+		e := &Endpoint{
+			SecurityIdentity: identity.NewIdentity(1001, pkgLabels.Labels{}),
+			PolicyExempt:     t.exempt,
+		}
+		calculated := selectorPolicy.DistillPolicy(e, identityCache)
+		exempted := e.exemptPolicy(calculated, identityCache)
+		c.Assert(exempted.IngressPolicyEnabled, Equals, t.ingress, Commentf("exempt %q", t.exempt))
+		c.Assert(exempted.EgressPolicyEnabled, Equals, t.egress, Commentf("exempt %q", t.exempt))
+
+		// Exempted directions allow all identities
+		ingressKey := policy.Key{Identity: 1000, TrafficDirection: trafficdirection.Ingress.Uint8()}
+		_, ok := exempted.PolicyMapState[ingressKey]
+		c.Assert(ok, Equals, !t.ingress, Commentf("exempt %q", t.exempt))
+	}
+
+	// The shared SelectorPolicy is never modified
+	c.Assert(selectorPolicy.IngressPolicyEnabled, Equals, true)
+	c.Assert(selectorPolicy.EgressPolicyEnabled, Equals, true)
+}
+
 func TestEndpoint_GetK8sPodLabels(t *testing.T) {
 	type fields struct {
 		OpLabels pkgLabels.OpLabels
@@ -23,6 +23,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cilium/cilium/api/v1/models"
 	"github.com/cilium/cilium/common/addressing"
 	"github.com/cilium/cilium/pkg/completion"
 	"github.com/cilium/cilium/pkg/controller"
@@ -46,6 +47,41 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// policyExemption returns whether policy enforcement of the endpoint is
+// disabled at ingress and egress
+func (e *Endpoint) policyExemption() (ingress, egress bool) {
+	switch e.PolicyExempt {
+	case models.EndpointChangeRequestPolicyExemptIngress:
+		return true, false
+	case models.EndpointChangeRequestPolicyExemptEgress:
+		return false, true
+	case models.EndpointChangeRequestPolicyExemptBoth:
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// exemptPolicy returns the policy of the endpoint with policy enforcement
+// disabled in the directions the endpoint is exempt from. The SelectorPolicy
+// is shared by all endpoints of the identity and is therefore copied before
+// it is modified.
+func (e *Endpoint) exemptPolicy(calculated *policy.EndpointPolicy, identityCache cache.IdentityCache) *policy.EndpointPolicy {
+	ingress, egress := e.policyExemption()
+	if (!ingress || !calculated.IngressPolicyEnabled) && (!egress || !calculated.EgressPolicyEnabled) {
+		return calculated
+	}
+
+	selectorPolicy := *calculated.SelectorPolicy
+	if ingress {
+		selectorPolicy.IngressPolicyEnabled = false
+	}
+	if egress {
+		selectorPolicy.EgressPolicyEnabled = false
+	}
+	return selectorPolicy.DistillPolicy(e, identityCache)
+}
+
 // ProxyID returns a unique string to identify a proxy mapping.
 func (e *Endpoint) ProxyID(l4 *policy.L4Filter) string {
 	return policy.ProxyID(e.ID, l4.Ingress, string(l4.Protocol), uint16(l4.Port))
@@ -180,7 +216,7 @@ func (e *Endpoint) regeneratePolicy(owner Owner) (retErr error) {
 		e.getLogger().WithError(err).Warning("Failed to update policy")
 		return err
 	}
-	calculatedPolicy := e.selectorPolicy.Consume(e, *labelsMap)
+	calculatedPolicy := e.exemptPolicy(e.selectorPolicy.Consume(e, *labelsMap), *labelsMap)
 	stats.policyCalculation.End(true)
 
 	e.desiredPolicy = calculatedPolicy
@@ -527,8 +563,13 @@ func (e *Endpoint) runIPIdentitySync(endpointIP addressing.CiliumIP) {
 	}
 
 	addressFamily := endpointIP.GetFamilyString()
+	controllerName := fmt.Sprintf("sync-%s-identity-mapping (%d)", addressFamily, e.ID)
+	// Each secondary IP is synchronized by a controller of its own
+	if ip := endpointIP.String(); ip != e.IPv4.String() && ip != e.IPv6.String() {
+		controllerName = fmt.Sprintf("sync-%s-identity-mapping %s (%d)", addressFamily, ip, e.ID)
+	}
 
-	e.controllers.UpdateController(fmt.Sprintf("sync-%s-identity-mapping (%d)", addressFamily, e.ID),
+	e.controllers.UpdateController(controllerName,
 		controller.ControllerParams{
 			DoFunc: func(ctx context.Context) error {
 				if err := e.RLockAlive(); err != nil {
@@ -602,6 +643,9 @@ func (e *Endpoint) SetIdentity(identity *identityPkg.Identity) {
 	// of IP to identity mapping.
 	e.runIPIdentitySync(e.IPv4)
 	e.runIPIdentitySync(e.IPv6)
+	for _, ip := range e.secondaryCiliumIPs() {
+		e.runIPIdentitySync(ip)
+	}
 
 	if oldIdentity != identity.StringID() {
 		e.getLogger().WithFields(logrus.Fields{
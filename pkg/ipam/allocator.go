@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"math/big"
 	"net"
+	"strings"
 
 	"github.com/cilium/cilium/pkg/metrics"
 
@@ -45,6 +46,25 @@ var (
 	ErrIPv6Disabled = errors.New("IPv6 allocation disabled")
 )
 
+// errSubnetFull is returned by AllocateNextInSubnet if all IPs of the subnet
+// are in use
+type errSubnetFull struct {
+	subnet *net.IPNet
+}
+
+func (e errSubnetFull) Error() string {
+	return fmt.Sprintf("no free IP in subnet %s", e.subnet)
+}
+
+// IsExhausted returns true if an allocation failed because all IPs of the
+// allocation range or of the requested subnet are in use
+func IsExhausted(err error) bool {
+	if _, ok := err.(errSubnetFull); ok {
+		return true
+	}
+	return err == ipallocator.ErrFull
+}
+
 // AllocateIP allocates a IP address.
 func (ipam *IPAM) AllocateIP(ip net.IP, owner string) error {
 	ipam.allocatorMutex.Lock()
@@ -121,6 +141,95 @@ func (ipam *IPAM) AllocateNextFamily(family Family, owner string) (ip net.IP, er
 	return
 }
 
+// AllocateNextInSubnet allocates the next available IP within the given
+// subnet. The subnet must be part of the allocation range of its address
+// family. An error is returned if no IP is available in the subnet, no IP
+// outside of the subnet is ever allocated.
+func (ipam *IPAM) AllocateNextInSubnet(subnet *net.IPNet, owner string) (net.IP, error) {
+	ipam.allocatorMutex.Lock()
+	defer ipam.allocatorMutex.Unlock()
+
+	family, allocator := IPv4, ipam.IPv4Allocator
+	if subnet.IP.To4() == nil {
+		family, allocator = IPv6, ipam.IPv6Allocator
+	}
+	if allocator == nil {
+		return nil, fmt.Errorf("%s allocator not available", family)
+	}
+
+	allocRange := allocator.CIDR()
+	ones, bits := subnet.Mask.Size()
+	rangeOnes, rangeBits := allocRange.Mask.Size()
+	if bits != rangeBits || ones < rangeOnes || !allocRange.Contains(subnet.IP) {
+		return nil, fmt.Errorf("subnet %s is not within allocation range %s", subnet, allocRange.String())
+	}
+
+	// The allocator tracks the addresses of the allocation range except
+	// for its first and last address in a bitmap of
+	// ipallocator.RangeSize() - 2 offsets, the first tracked address is at
+	// offset 0. Restrict the search to the offsets of the subnet.
+	base := big.NewInt(0).Add(bigForIP(allocRange.IP), big.NewInt(1))
+	first := big.NewInt(0).Sub(bigForIP(subnet.IP.Mask(subnet.Mask)), base)
+	last := big.NewInt(0).Lsh(big.NewInt(1), uint(bits-ones))
+	last.Add(last, first).Sub(last, big.NewInt(1))
+	if first.Sign() < 0 {
+		first.SetInt64(0)
+	}
+	if end := big.NewInt(ipallocator.RangeSize(&allocRange) - 3); last.Cmp(end) > 0 {
+		last = end
+	}
+	if first.Cmp(last) > 0 {
+		return nil, fmt.Errorf("subnet %s has no allocatable IP in allocation range %s", subnet, allocRange.String())
+	}
+
+	snapshot := k8sAPI.RangeAllocation{}
+	if err := allocator.Snapshot(&snapshot); err != nil {
+		return nil, err
+	}
+	allocated := big.NewInt(0).SetBytes(snapshot.Data)
+	size := net.IPv6len
+	if family == IPv4 {
+		size = net.IPv4len
+	}
+	for offset := int(first.Int64()); offset <= int(last.Int64()); offset++ {
+		if allocated.Bit(offset) != 0 {
+			continue
+		}
+		ip := ipForBig(big.NewInt(0).Add(base, big.NewInt(int64(offset))), size)
+		// Allocations of AllocateNextFamily do not hold allocatorMutex
+		// and may have taken the address since the snapshot
+		if err := allocator.Allocate(ip); err != nil {
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"ip":     ip.String(),
+			"owner":  owner,
+			"subnet": subnet.String(),
+		}).Debugf("Allocated IP in subnet")
+		ipam.owner[ip.String()] = owner
+		metrics.IpamEvent.WithLabelValues(metricAllocate, string(family)).Inc()
+		return ip, nil
+	}
+
+	return nil, errSubnetFull{subnet: subnet}
+}
+
+// bigForIP returns the IP address as an integer
+func bigForIP(ip net.IP) *big.Int {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return big.NewInt(0).SetBytes(ip)
+}
+
+// ipForBig returns the IP address of length size of the integer
+func ipForBig(b *big.Int, size int) net.IP {
+	ip := make(net.IP, size)
+	bytes := b.Bytes()
+	copy(ip[size-len(bytes):], bytes)
+	return ip
+}
+
 // AllocateNext allocates the next available IPv4 and IPv6 address out of the
 // configured address pool. If family is set to "ipv4" or "ipv6", then
 // allocation is limited to the specified address family. If the pool has been
@@ -151,6 +260,11 @@ func (ipam *IPAM) AllocateNext(family, owner string) (ipv4 net.IP, ipv6 net.IP,
 func (ipam *IPAM) ReleaseIP(ip net.IP) error {
 	ipam.allocatorMutex.Lock()
 	defer ipam.allocatorMutex.Unlock()
+	return ipam.releaseIPLocked(ip)
+}
+
+// releaseIPLocked releases a IP address, allocatorMutex must be held
+func (ipam *IPAM) releaseIPLocked(ip net.IP) error {
 	family := familyIPv4
 	if ip.To4() != nil {
 		if ipam.IPv4Allocator == nil {
@@ -177,6 +291,7 @@ func (ipam *IPAM) ReleaseIP(ip net.IP) error {
 		"owner": owner,
 	}).Debugf("Released IP")
 	delete(ipam.owner, ip.String())
+	delete(ipam.container, ip.String())
 
 	metrics.IpamEvent.WithLabelValues(metricRelease, family).Inc()
 	return nil
@@ -192,6 +307,47 @@ func (ipam *IPAM) ReleaseIPString(ipAddr string) error {
 	return ipam.ReleaseIP(ip)
 }
 
+// SetContainer records the ID of the container an allocated IP address is
+// allocated for
+func (ipam *IPAM) SetContainer(ip net.IP, containerID string) {
+	ipam.allocatorMutex.Lock()
+	defer ipam.allocatorMutex.Unlock()
+	if _, ok := ipam.owner[ip.String()]; ok {
+		ipam.container[ip.String()] = containerID
+	}
+}
+
+// ReleaseOwner releases all IP addresses allocated for the owner and returns
+// the released addresses. If containerID is not empty, only the addresses
+// allocated for the container are released. A failure to release an address
+// does not prevent the remaining addresses from being released, the returned
+// error lists all failures.
+func (ipam *IPAM) ReleaseOwner(owner, containerID string) ([]net.IP, error) {
+	ipam.allocatorMutex.Lock()
+	defer ipam.allocatorMutex.Unlock()
+
+	var ips []net.IP
+	for ip, o := range ipam.owner {
+		if o == owner && (containerID == "" || ipam.container[ip] == containerID) {
+			ips = append(ips, net.ParseIP(ip))
+		}
+	}
+
+	var errs []string
+	released := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if err := ipam.releaseIPLocked(ip); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", ip, err))
+			continue
+		}
+		released = append(released, ip)
+	}
+	if len(errs) > 0 {
+		return released, fmt.Errorf("unable to release IPs: %s", strings.Join(errs, "; "))
+	}
+	return released, nil
+}
+
 // Dump dumps the list of allocated IP addresses
 func (ipam *IPAM) Dump() (map[string]string, map[string]string) {
 	ipam.allocatorMutex.RLock()
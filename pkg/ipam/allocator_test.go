@@ -23,6 +23,7 @@ import (
 	"github.com/cilium/cilium/pkg/datapath/fake"
 
 	. "gopkg.in/check.v1"
+	"k8s.io/kubernetes/pkg/registry/core/service/ipallocator"
 )
 
 func (s *IPAMSuite) TestAllocatedIPDump(c *C) {
@@ -51,3 +52,125 @@ func (s *IPAMSuite) TestAllocatedIPDump(c *C) {
 		c.Assert(net.ParseIP(ip), NotNil)
 	}
 }
+
+func (s *IPAMSuite) TestAllocateNextInSubnet(c *C) {
+	fakeAddressing := fake.NewNodeAddressing()
+	ipam := NewIPAM(fakeAddressing, Configuration{EnableIPv4: true, EnableIPv6: true})
+
+	_, subnet, err := net.ParseCIDR("1.1.1.16/30")
+	c.Assert(err, IsNil)
+
+	allocated := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		ip, err := ipam.AllocateNextInSubnet(subnet, "foo")
+		c.Assert(err, IsNil)
+		c.Assert(subnet.Contains(ip), Equals, true)
+		c.Assert(allocated[ip.String()], Equals, false)
+		allocated[ip.String()] = true
+	}
+
+	_, err = ipam.AllocateNextInSubnet(subnet, "foo")
+	c.Assert(err, ErrorMatches, "no free IP in subnet 1.1.1.16/30")
+	c.Assert(IsExhausted(err), Equals, true)
+	// Exhaustion of the allocation range
+	c.Assert(IsExhausted(ipallocator.ErrFull), Equals, true)
+
+	c.Assert(ipam.ReleaseIPString("1.1.1.18"), IsNil)
+	ip, err := ipam.AllocateNextInSubnet(subnet, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(ip.String(), Equals, "1.1.1.18")
+
+	_, subnet6, err := net.ParseCIDR("cafe::100/120")
+	c.Assert(err, IsNil)
+	ip, err = ipam.AllocateNextInSubnet(subnet6, "foo")
+	c.Assert(err, IsNil)
+	c.Assert(subnet6.Contains(ip), Equals, true)
+
+	// The first and last address of the allocation range are never
+	// allocated
+	_, network, err := net.ParseCIDR("1.1.1.0/32")
+	c.Assert(err, IsNil)
+	_, err = ipam.AllocateNextInSubnet(network, "foo")
+	c.Assert(err, ErrorMatches, "subnet 1.1.1.0/32 has no allocatable IP in allocation range 1.1.1.0/24")
+	_, edge, err := net.ParseCIDR("1.1.1.252/30")
+	c.Assert(err, IsNil)
+	for _, want := range []string{"1.1.1.252", "1.1.1.253", "1.1.1.254"} {
+		ip, err = ipam.AllocateNextInSubnet(edge, "foo")
+		c.Assert(err, IsNil)
+		c.Assert(ip.String(), Equals, want)
+	}
+	_, err = ipam.AllocateNextInSubnet(edge, "foo")
+	c.Assert(err, ErrorMatches, "no free IP in subnet 1.1.1.252/30")
+
+	_, outside, err := net.ParseCIDR("1.1.2.0/30")
+	c.Assert(err, IsNil)
+	_, err = ipam.AllocateNextInSubnet(outside, "foo")
+	c.Assert(err, ErrorMatches, "subnet 1.1.2.0/30 is not within allocation range 1.1.1.0/24")
+	c.Assert(IsExhausted(err), Equals, false)
+}
+
+func (s *IPAMSuite) TestReleaseOwner(c *C) {
+	fakeAddressing := fake.NewNodeAddressing()
+	ipam := NewIPAM(fakeAddressing, Configuration{EnableIPv4: true, EnableIPv6: true})
+
+	ipv4, ipv6, err := ipam.AllocateNext("", "default/foo")
+	c.Assert(err, IsNil)
+	other, _, err := ipam.AllocateNext("ipv4", "default/bar")
+	c.Assert(err, IsNil)
+
+	released, err := ipam.ReleaseOwner("default/foo", "")
+	c.Assert(err, IsNil)
+	c.Assert(released, HasLen, 2)
+	for _, ip := range []net.IP{ipv4, ipv6} {
+		c.Assert(ipam.AllocateIP(ip, "default/baz"), IsNil)
+	}
+
+	// Addresses of other owners are kept
+	c.Assert(ipam.AllocateIP(other, "default/baz"), Not(IsNil))
+
+	released, err = ipam.ReleaseOwner("default/foo", "")
+	c.Assert(err, IsNil)
+	c.Assert(released, HasLen, 0)
+}
+
+func (s *IPAMSuite) TestReleaseOwnerPartialFailure(c *C) {
+	fakeAddressing := fake.NewNodeAddressing()
+	ipam := NewIPAM(fakeAddressing, Configuration{EnableIPv4: true, EnableIPv6: false})
+
+	ipv4, _, err := ipam.AllocateNext("ipv4", "default/foo")
+	c.Assert(err, IsNil)
+	// An address which can't be released as its family is disabled
+	ipam.owner["f00d::1"] = "default/foo"
+
+	released, err := ipam.ReleaseOwner("default/foo", "")
+	c.Assert(err, ErrorMatches, "unable to release IPs: f00d::1: IPv6 allocation disabled")
+	c.Assert(released, HasLen, 1)
+	c.Assert(released[0].Equal(ipv4), Equals, true)
+	c.Assert(ipam.AllocateIP(ipv4, "default/baz"), IsNil)
+}
+
+func (s *IPAMSuite) TestReleaseOwnerContainer(c *C) {
+	fakeAddressing := fake.NewNodeAddressing()
+	ipam := NewIPAM(fakeAddressing, Configuration{EnableIPv4: true, EnableIPv6: true})
+
+	ipv4, ipv6, err := ipam.AllocateNext("", "default/foo")
+	c.Assert(err, IsNil)
+	ipam.SetContainer(ipv4, "abcd")
+	ipam.SetContainer(ipv6, "abcd")
+	other, _, err := ipam.AllocateNext("ipv4", "default/foo")
+	c.Assert(err, IsNil)
+	ipam.SetContainer(other, "efgh")
+
+	released, err := ipam.ReleaseOwner("default/foo", "abcd")
+	c.Assert(err, IsNil)
+	c.Assert(released, HasLen, 2)
+
+	// Addresses of other containers of the owner are kept
+	c.Assert(ipam.AllocateIP(other, "default/baz"), Not(IsNil))
+
+	// The container is forgotten once the address is released
+	c.Assert(ipam.AllocateIP(ipv4, "default/foo"), IsNil)
+	released, err = ipam.ReleaseOwner("default/foo", "abcd")
+	c.Assert(err, IsNil)
+	c.Assert(released, HasLen, 0)
+}
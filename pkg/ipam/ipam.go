@@ -54,6 +54,7 @@ func NewIPAM(nodeAddressing datapath.NodeAddressing, c Configuration) *IPAM {
 		nodeAddressing: nodeAddressing,
 		config:         c,
 		owner:          map[string]string{},
+		container:      map[string]string{},
 	}
 
 	if c.EnableIPv6 {
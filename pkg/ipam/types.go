@@ -32,6 +32,10 @@ type IPAM struct {
 	// owner maps an IP to the owner
 	owner map[string]string
 
+	// container maps an IP to the ID of the container it is allocated
+	// for, if known
+	container map[string]string
+
 	// mutex covers access to all members of this struct
 	allocatorMutex lock.RWMutex
 }
@@ -528,6 +528,14 @@ var (
 		Help:      "Number of IPAM events received labeled by action and datapath family type",
 	}, []string{LabelAction, LabelDatapathFamily})
 
+	// IpamCNIAllocationDuration is the duration of IP allocations as
+	// observed by the CNI plugin
+	IpamCNIAllocationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "ipam_cni_allocation_duration_seconds",
+		Help:      "Duration in seconds of IP allocations as observed by the CNI plugin",
+	})
+
 	// KVstore events
 
 	// KVStoreOperationsTotal is the  number of interactions with the Key-Value
@@ -627,6 +635,7 @@ func init() {
 	MustRegister(KubernetesEventReceived)
 
 	MustRegister(IpamEvent)
+	MustRegister(IpamCNIAllocationDuration)
 
 	MustRegister(KVStoreOperationsTotal)
 	MustRegister(KVStoreOperationsDuration)
@@ -20,3 +20,9 @@ import (
 )
 
 var log = logging.DefaultLogger.WithField(logfields.LogSubsys, "mtu")
+
+// AutoDetect returns the MTU of the interface used to reach external
+// destinations from the current network namespace
+func AutoDetect() (int, error) {
+	return autoDetect()
+}
@@ -0,0 +1,57 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/cilium/cilium/api/v1/models"
+	endpointid "github.com/cilium/cilium/pkg/endpoint/id"
+)
+
+// endpointAttachClient is the subset of the agent API used to attach an
+// interface to an existing endpoint
+type endpointAttachClient interface {
+	endpointGetter
+	EndpointPatch(id string, ep *models.EndpointChangeRequest) error
+}
+
+// existingEndpoint returns the endpoint of the container an interface is
+// attached to in attachToExisting mode
+func existingEndpoint(c endpointGetter, containerID string) (*models.Endpoint, error) {
+	ep, err := c.EndpointGet(endpointid.NewID(endpointid.ContainerIdPrefix, containerID))
+	if err == nil && ep == nil {
+		err = fmt.Errorf("not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("attachToExisting is set but no endpoint of container %s could be found: %s", containerID, err)
+	}
+	return ep, nil
+}
+
+// attachToEndpoint adds the addresses of the interface described by ep to
+// the existing endpoint of the container as secondary addresses
+func attachToEndpoint(c endpointAttachClient, ep *models.EndpointChangeRequest) error {
+	var pairs []*models.AddressPair
+	if ep.Addressing != nil && (ep.Addressing.IPV4 != "" || ep.Addressing.IPV6 != "") {
+		pairs = append(pairs, &models.AddressPair{IPV4: ep.Addressing.IPV4, IPV6: ep.Addressing.IPV6})
+	}
+	pairs = append(pairs, ep.SecondaryAddressing...)
+	patch := &models.EndpointChangeRequest{
+		State:               models.EndpointStateWaitingForIdentity,
+		SecondaryAddressing: pairs,
+	}
+	return c.EndpointPatch(endpointid.NewID(endpointid.ContainerIdPrefix, ep.ContainerID), patch)
+}
@@ -0,0 +1,190 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/defaults"
+	endpointid "github.com/cilium/cilium/pkg/endpoint/id"
+
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+// defaultResultCacheDir is the default directory of the result cache
+const defaultResultCacheDir = defaults.RuntimePath + "/cni-cache"
+
+// cachedAttachment are the details of a successful ADD cached for the DEL of
+// the same container interface. They allow DEL to tear down exactly what
+// ADD created instead of discovering it from the namespace and the agent.
+type cachedAttachment struct {
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifName"`
+	// EndpointID is the ID of the endpoint, 0 if unknown
+	EndpointID int64 `json:"endpointID,omitempty"`
+	// HostInterface is the host side veth, empty in other datapath modes
+	HostInterface string `json:"hostInterface,omitempty"`
+	// MAC is the MAC address of the container interface
+	MAC string `json:"mac,omitempty"`
+	// IPs are the primary and secondary addresses of the endpoint
+	IPs []string `json:"ips,omitempty"`
+}
+
+// newCachedAttachment returns the cached details of the endpoint created by
+// ADD
+func newCachedAttachment(containerID, ifName string, ep *models.EndpointChangeRequest, endpointID int64, hostIf string) *cachedAttachment {
+	a := &cachedAttachment{
+		ContainerID:   containerID,
+		IfName:        ifName,
+		EndpointID:    endpointID,
+		HostInterface: hostIf,
+		MAC:           ep.Mac,
+	}
+	pairs := append([]*models.AddressPair{ep.Addressing}, ep.SecondaryAddressing...)
+	for _, pair := range pairs {
+		if pair == nil {
+			continue
+		}
+		for _, ip := range []string{pair.IPV6, pair.IPV4} {
+			if ip != "" {
+				a.IPs = append(a.IPs, ip)
+			}
+		}
+	}
+	return a
+}
+
+// ips returns the parsed addresses of the endpoint
+func (a *cachedAttachment) ips() []net.IP {
+	ips := make([]net.IP, 0, len(a.IPs))
+	for _, s := range a.IPs {
+		if ip := net.ParseIP(s); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// endpointID returns the ID to delete the endpoint by. The cached endpoint
+// ID is preferred as the container ID may have been reused.
+func (a *cachedAttachment) endpointID() string {
+	if a.EndpointID != 0 {
+		return endpointid.NewCiliumID(a.EndpointID)
+	}
+	return endpointid.NewID(endpointid.ContainerIdPrefix, a.ContainerID)
+}
+
+// resultCachePath returns the path of the cache file of the container
+// interface
+func resultCachePath(dir, containerID, ifName string) (string, error) {
+	name, err := attachmentFileName(containerID, ifName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".json"), nil
+}
+
+// writeResultCache writes the cache file of the attachment into the
+// directory
+func writeResultCache(dir string, a *cachedAttachment) error {
+	path, err := resultCachePath(dir, a.ContainerID, a.IfName)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return writeResultFile(dir, path, data)
+}
+
+// readResultCache returns the cached attachment of the container interface
+// or nil if there is none
+func readResultCache(dir, containerID, ifName string) (*cachedAttachment, error) {
+	path, err := resultCachePath(dir, containerID, ifName)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	a := &cachedAttachment{}
+	if err := json.Unmarshal(data, a); err != nil {
+		return nil, fmt.Errorf("invalid result cache %s: %s", path, err)
+	}
+	if a.ContainerID != containerID || a.IfName != ifName {
+		return nil, fmt.Errorf("result cache %s belongs to container %s interface %s", path, a.ContainerID, a.IfName)
+	}
+	return a, nil
+}
+
+// removeResultCache removes the cache file of the container interface
+func removeResultCache(dir, containerID, ifName string) error {
+	path, err := resultCachePath(dir, containerID, ifName)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// resultCacheDir returns the directory of the result cache or an empty
+// string if the cache is disabled
+func (n *netConf) resultCacheDir() string {
+	switch {
+	case n.DisableResultCache:
+		return ""
+	case n.ResultCacheDir != "":
+		return n.ResultCacheDir
+	}
+	return defaultResultCacheDir
+}
+
+// removeCachedAttachment removes the result cache of the container interface
+// once the DEL succeeded
+func removeCachedAttachment(n *netConf, args *skel.CmdArgs) {
+	if dir := n.resultCacheDir(); dir != "" {
+		if err := removeResultCache(dir, args.ContainerID, args.IfName); err != nil {
+			log.WithError(err).Warning("Unable to remove result cache")
+		}
+	}
+}
+
+// hasCachedAttachment returns true if the result cache holds an attachment of
+// the container interface, i.e. the interface has been created by an ADD of
+// Cilium. It returns false if the cache is disabled.
+func hasCachedAttachment(n *netConf, containerID, ifName string) bool {
+	dir := n.resultCacheDir()
+	if dir == "" {
+		return false
+	}
+	a, err := readResultCache(dir, containerID, ifName)
+	if err != nil {
+		log.WithError(err).Warning("Unable to read result cache")
+		return false
+	}
+	return a != nil
+}
@@ -0,0 +1,258 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/client"
+	endpointid "github.com/cilium/cilium/pkg/endpoint/id"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	cniTypesVer "github.com/containernetworking/cni/pkg/types/current"
+	cniVersion "github.com/containernetworking/cni/pkg/version"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// getPrevResult returns the result of the previous plugin in the chain
+func getPrevResult(n *netConf) (*cniTypesVer.Result, error) {
+	// Results of version 1.0.0 and 1.1.0 are parsed as results of the
+	// implemented version, see result100
+	conf := n.NetConf
+	if isResult100Version(conf.CNIVersion) {
+		conf.CNIVersion = cniTypesVer.ImplementedSpecVersion
+	}
+	if err := cniVersion.ParsePrevResult(&conf); err != nil {
+		return nil, fmt.Errorf("unable to understand network config: %s", err)
+	}
+	n.PrevResult = conf.PrevResult
+	r, err := cniTypesVer.GetResult(n.PrevResult)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get previous network result: %s", err)
+	}
+	fillIPVersions(r)
+	return r, nil
+}
+
+// containerAddressing returns the first IPv4 and IPv6 address of the
+// container side interface in the result of a previous plugin
+func containerAddressing(r *cniTypesVer.Result) (ipv4, ipv6 string) {
+	sandboxIdx := -1
+	for i, iDev := range r.Interfaces {
+		if iDev.Sandbox != "" {
+			sandboxIdx = i
+		}
+	}
+	for _, ipCfg := range r.IPs {
+		if ipCfg.Interface != nil && *ipCfg.Interface == sandboxIdx {
+			switch {
+			case ipCfg.Version == "4" && ipv4 == "":
+				ipv4 = ipCfg.Address.IP.String()
+			case ipCfg.Version == "6" && ipv6 == "":
+				ipv6 = ipCfg.Address.IP.String()
+			}
+		}
+	}
+	return
+}
+
+// chained returns true if the endpoint of the network is set up on top of
+// the result of a previous plugin in the chain
+func (n *netConf) chained() bool {
+	return len(n.NetConf.RawPrevResult) != 0 && (n.Name == "cbr0" || n.IPAM.Type == ipamTypeNone)
+}
+
+const (
+	// chainedProperty is the endpoint property flagging endpoints created
+	// by an invocation of the plugin which was not the first plugin of a
+	// chain
+	chainedProperty = "cni-chained"
+
+	// chainNameProperty is the endpoint property recording the name of
+	// the network of the chain a chained endpoint was created for
+	chainNameProperty = "cni-chain-name"
+)
+
+// addChainProperties records in the endpoint properties that the plugin was
+// invoked as a plugin other than the first of the chain of the network, so
+// that the agent can tell that e.g. the addresses of the endpoint may be
+// owned by a previous plugin
+func addChainProperties(ep *models.EndpointChangeRequest, network string) {
+	if ep.Properties == nil {
+		ep.Properties = map[string]string{}
+	}
+	ep.Properties[chainedProperty] = "true"
+	ep.Properties[chainNameProperty] = network
+}
+
+// chainedEndpointIDs returns the IDs the endpoint of a chained setup may be
+// found by: the container ID followed by the addresses of the container
+// interface in the previous result.
+func chainedEndpointIDs(n *netConf, containerID string) ([]string, error) {
+	ids := []string{endpointid.NewID(endpointid.ContainerIdPrefix, containerID)}
+	r, err := getPrevResult(n)
+	if err != nil {
+		return ids, err
+	}
+	ipv4, ipv6 := containerAddressing(r)
+	if ipv4 != "" {
+		ids = append(ids, endpointid.NewID(endpointid.IPv4Prefix, ipv4))
+	}
+	if ipv6 != "" {
+		ids = append(ids, endpointid.NewID(endpointid.IPv6Prefix, ipv6))
+	}
+	return ids, nil
+}
+
+// deleteChainedEndpoint deletes the endpoint of a chained setup. The
+// endpoint is looked up by all of its possible IDs as the agent may not know
+// the container ID, e.g. for endpoints restored from an older version.
+func deleteChainedEndpoint(c *client.Client, ids []string) error {
+	for _, id := range ids {
+		if ep, err := c.EndpointGet(id); err != nil || ep == nil {
+			continue
+		}
+		return c.EndpointDelete(id)
+	}
+	return nil
+}
+
+// ipamTypeNone is the IPAM type selecting the passthrough mode in which the
+// addresses are taken from the result of a previous plugin in the chain
+const ipamTypeNone = "none"
+
+// setupPassthrough creates the endpoint for the interface and addresses set
+// up by a previous plugin in the chain. No addresses are allocated, the
+// endpoint is marked accordingly to prevent the agent from releasing them.
+// If requireBridge is true, the host side veth must be attached to a bridge
+// whose MAC address is used as the host MAC of the endpoint, e.g. cni0 of
+// flannel. Otherwise the MAC address of the host side veth is used if there
+// is no bridge. The previous result is returned.
+func setupPassthrough(logger *logrus.Entry, args *skel.CmdArgs, cniArgs cniArgsSpec, n *netConf, c *client.Client, requireBridge bool) (r *cniTypesVer.Result, err error) {
+	r, err = getPrevResult(n)
+	if err != nil {
+		return nil, err
+	}
+	// We only care about the veth interface that is on the host side
+	// and cni0. Interfaces should be similar as:
+	//       "interfaces":[
+	//         {
+	//            "name":"cni0",
+	//            "mac":"0a:58:0a:f4:00:01"
+	//         },
+	//         {
+	//            "name":"veth15707e9b",
+	//            "mac":"4e:6d:93:35:6b:45"
+	//         },
+	//         {
+	//            "name":"eth0",
+	//            "mac":"0a:58:0a:f4:00:06",
+	//            "sandbox":"/proc/15259/ns/net"
+	//         }
+	//       ]
+
+	defer func() {
+		if err != nil {
+			logger.WithError(err).
+				WithFields(logrus.Fields{"cni-pre-result": n.PrevResult.String()}).
+				Errorf("Unable to create endpoint")
+		}
+	}()
+	var (
+		bridgeMac, vethHostMac, vethHostName, vethLXCMac string
+		vethHostIdx                                      int
+	)
+	for _, iDev := range r.Interfaces {
+		// We only care about the veth interface mac address on the container side.
+		if iDev.Sandbox != "" {
+			vethLXCMac = iDev.Mac
+			continue
+		}
+
+		l, err := netlink.LinkByName(iDev.Name)
+		if err != nil {
+			continue
+		}
+		switch l.Type() {
+		case "veth":
+			vethHostName = iDev.Name
+			vethHostIdx = l.Attrs().Index
+			vethHostMac = l.Attrs().HardwareAddr.String()
+		case "bridge":
+			// likely to be cni0
+			bridgeMac = iDev.Mac
+		}
+	}
+	vethIPv4, vethIPv6 := containerAddressing(r)
+
+	hostMac := bridgeMac
+	if hostMac == "" && !requireBridge {
+		hostMac = vethHostMac
+	}
+
+	switch {
+	case hostMac == "" && requireBridge:
+		return nil, errors.New("unable to determine MAC address of bridge interface (cni0)")
+	case hostMac == "":
+		return nil, errors.New("unable to determine MAC address of the host side interface")
+	case vethHostName == "":
+		return nil, errors.New("unable to determine name of veth pair on the host side")
+	case vethLXCMac == "":
+		return nil, errors.New("unable to determine MAC address of veth pair on the container side")
+	case vethIPv4 == "" && (requireBridge || vethIPv6 == ""):
+		return nil, errors.New("unable to determine IP address of the container")
+	case vethHostIdx == 0:
+		return nil, errors.New("unable to determine index interface of veth pair on the host side")
+	}
+
+	addressing := &models.AddressPair{
+		IPV4: vethIPv4,
+	}
+	if !requireBridge {
+		addressing.IPV6 = vethIPv6
+	}
+
+	ep := &models.EndpointChangeRequest{
+		Addressing:        addressing,
+		ContainerID:       args.ContainerID,
+		ExternalIPAM:      true,
+		State:             models.EndpointStateWaitingForIdentity,
+		HostMac:           hostMac,
+		InterfaceIndex:    int64(vethHostIdx),
+		Mac:               vethLXCMac,
+		InterfaceName:     vethHostName,
+		K8sPodName:        string(cniArgs.K8S_POD_NAME),
+		K8sNamespace:      string(cniArgs.K8S_POD_NAMESPACE),
+		Properties:        attachmentProperties(n.Name, args.IfName),
+		SyncBuildEndpoint: true,
+	}
+	addChainProperties(ep, n.Name)
+
+	err = c.EndpointCreate(ep)
+	if err != nil {
+		logger.WithError(err).WithFields(logrus.Fields{
+			logfields.ContainerID: ep.ContainerID}).Warn("Unable to create endpoint")
+		err = fmt.Errorf("unable to create endpoint: %s", err)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		logfields.ContainerID: ep.ContainerID}).Debug("Endpoint successfully created")
+	return r, nil
+}
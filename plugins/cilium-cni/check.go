@@ -0,0 +1,73 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/client"
+	"github.com/cilium/cilium/pkg/defaults"
+
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+// endpointLister is the subset of the agent API used to look up endpoints
+type endpointLister interface {
+	EndpointList() ([]*models.Endpoint, error)
+}
+
+// cmdCheck verifies that the network configuration is valid and that the
+// endpoint of the attachment still exists. The vendored skel package only
+// invokes CHECK for network configurations of version 0.4.0 or later.
+func cmdCheck(args *skel.CmdArgs) error {
+	if err := validateIfName(args.IfName); err != nil {
+		return err
+	}
+	if _, _, err := loadNetConf(args.StdinData); err != nil {
+		return err
+	}
+
+	c, err := client.NewDefaultClientWithTimeout(defaults.ClientConnectTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to connect to Cilium daemon: %s", err)
+	}
+	defer c.Close()
+
+	return checkEndpoint(c, args.ContainerID, args.IfName)
+}
+
+// checkEndpoint returns an error unless an endpoint of the container exists.
+// If the endpoint records the interface it was attached to, the interface
+// must match as well.
+func checkEndpoint(c endpointLister, containerID, ifName string) error {
+	endpoints, err := c.EndpointList()
+	if err != nil {
+		return fmt.Errorf("unable to list endpoints: %s", err)
+	}
+
+	for _, ep := range endpoints {
+		if ep.Status == nil || ep.Status.ExternalIdentifiers == nil ||
+			ep.Status.ExternalIdentifiers.ContainerID != containerID {
+			continue
+		}
+		if recorded, ok := ep.Status.Properties[attachmentIfNameProperty]; ok && recorded != ifName {
+			continue
+		}
+		return nil
+	}
+
+	return fmt.Errorf("no endpoint found for container %s interface %s", containerID, ifName)
+}
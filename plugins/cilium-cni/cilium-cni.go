@@ -16,14 +16,16 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"net"
 	"os"
-	"path/filepath"
 	"runtime"
 	"sort"
+	"time"
 
+	"github.com/cilium/cilium/api/v1/client/daemon"
 	"github.com/cilium/cilium/api/v1/models"
 	"github.com/cilium/cilium/common/addressing"
 	"github.com/cilium/cilium/pkg/client"
@@ -31,7 +33,6 @@ import (
 	"github.com/cilium/cilium/pkg/defaults"
 	"github.com/cilium/cilium/pkg/endpoint/connector"
 	endpointid "github.com/cilium/cilium/pkg/endpoint/id"
-	"github.com/cilium/cilium/pkg/labels"
 	"github.com/cilium/cilium/pkg/logging"
 	"github.com/cilium/cilium/pkg/logging/logfields"
 	"github.com/cilium/cilium/pkg/netns"
@@ -46,17 +47,37 @@ import (
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
-
 	"golang.org/x/sys/unix"
 )
 
+const (
+	subsystem = "cilium-cni"
+
+	// logCommandSubsys is the log field holding the subsystem qualified
+	// with the CNI command being processed, e.g. "cilium-cni/ADD"
+	logCommandSubsys = "cniSubsys"
+)
+
 var (
-	log = logging.DefaultLogger.WithField(logfields.LogSubsys, "cilium-cni")
+	log = logging.DefaultLogger.WithField(logfields.LogSubsys, subsystem)
 )
 
+// commandLogger returns the logger to use while processing the given CNI
+// command. The subsystem field is retained for existing log filters.
+func commandLogger(command string) *logrus.Entry {
+	if command == "" {
+		return logging.DefaultLogger.WithField(logfields.LogSubsys, subsystem)
+	}
+	return logging.DefaultLogger.WithFields(logrus.Fields{
+		logfields.LogSubsys: subsystem,
+		logCommandSubsys:    subsystem + "/" + command,
+	})
+}
+
 func init() {
 	logging.SetLogLevel(logrus.DebugLevel)
 	runtime.LockOSThread()
+	rand.Seed(time.Now().UnixNano())
 }
 
 type CmdState struct {
@@ -67,12 +88,390 @@ type CmdState struct {
 	IP4routes []route.Route
 	Client    *client.Client
 	HostAddr  *models.NodeAddressing
+	// Secondary are the secondary addresses of the endpoint
+	Secondary []addressing.CiliumIP
+	// IfIndex and IfMAC identify the interface created in the container
+	// namespace. They are used to verify that the interface found by
+	// name is the one which has been created. Unset if unknown.
+	IfIndex int
+	IfMAC   net.HardwareAddr
+	// RequestedMAC is the MAC address explicitly requested for the
+	// interface. It must not be in use by another interface of the
+	// namespace. Unset if the MAC address is assigned by the kernel.
+	RequestedMAC net.HardwareAddr
+	// GatewayMAC is the MAC address of the gateway programmed as a
+	// permanent neighbor entry. No entry is programmed if unset.
+	GatewayMAC net.HardwareAddr
 }
 
 type netConf struct {
 	cniTypes.NetConf
-	MTU  int  `json:"mtu"`
-	Args Args `json:"args"`
+	// MTU is the device and route MTU of the endpoint. It takes
+	// precedence over MTUMode and the MTU of the agent.
+	MTU           int           `json:"mtu"`
+	Args          Args          `json:"args"`
+	RuntimeConfig RuntimeConfig `json:"runtimeConfig,omitempty"`
+	// HostInterfacePrefix is the name prefix of the host side veth
+	// interfaces. Defaults to the prefix used by the connector.
+	HostInterfacePrefix string `json:"hostInterfacePrefix,omitempty"`
+	// HostInterfaceName is the template of the name of the host side
+	// veth interface, e.g. to correlate pods and interfaces. It supports
+	// the placeholders of OwnerTemplate. Names exceeding the kernel limit
+	// are shortened and suffixed with a hash of the full name. DEL
+	// renders the same name from CNI_ARGS. Defaults to a name derived
+	// from the container ID with HostInterfacePrefix.
+	HostInterfaceName string `json:"hostInterfaceName,omitempty"`
+	IPAM              IPAM   `json:"ipam,omitempty"`
+	// VethQueues is the number of RX and TX queues of the veth pair. The
+	// kernel default of a single queue is used if unset.
+	VethQueues int `json:"vethQueues,omitempty"`
+	// TxQueueLen is the transmit queue length of the container side veth,
+	// e.g. for high packet rate workloads. The kernel default is left in
+	// place if unset. Only supported in veth datapath mode.
+	TxQueueLen *int `json:"txQueueLen,omitempty"`
+	// HostTxQueueLen applies TxQueueLen to the host side veth as well
+	HostTxQueueLen bool `json:"hostTxQueueLen,omitempty"`
+	// HostProxyARP and HostProxyNDP enable proxy_arp and proxy_ndp on
+	// the host side veth, as required by L3 modes in which the pod
+	// resolves its gateway via the host. The sysctls are scoped to the
+	// interface and disappear with it on DEL. Only supported in veth
+	// datapath mode.
+	HostProxyARP bool `json:"hostProxyARP,omitempty"`
+	HostProxyNDP bool `json:"hostProxyNDP,omitempty"`
+	// HostMaster is the name of a bridge on the host the host side veth
+	// is enslaved to, e.g. to integrate with existing host bridging.
+	// Deleting the veth on DEL detaches it from the bridge. Only
+	// supported in veth datapath mode.
+	HostMaster string `json:"hostMaster,omitempty"`
+	// VerboseResultDir enables writing the details of each successful
+	// ADD, i.e. the endpoint ID, IP pools, MTU, gateways and the
+	// durations of the phases, as JSON file named after the container
+	// ID and interface into the directory. The file is removed on DEL.
+	// Failing to write the file does not fail the ADD.
+	VerboseResultDir string `json:"verboseResultDir,omitempty"`
+	// ResultCopyDir enables writing a copy of the result printed to the
+	// runtime on a successful ADD into the directory, named after the
+	// container ID and interface, to debug runtimes which lose the
+	// result. The file is removed on DEL. Failing to write the file does
+	// not fail the ADD.
+	ResultCopyDir string `json:"resultCopyDir,omitempty"`
+	// DisableInterfaceAlias disables setting the alias of the host side
+	// veth to the namespace and name of the pod.
+	DisableInterfaceAlias bool `json:"disableInterfaceAlias,omitempty"`
+	// MTUMode selects how the MTU of the endpoint is determined. If set
+	// to "auto", the MTU is derived from the host interface used to reach
+	// external destinations instead of using the MTU of the agent.
+	MTUMode string `json:"mtuMode,omitempty"`
+	// MTUOverhead is the number of bytes subtracted from the detected MTU
+	// when MTUMode is "auto". Defaults to the overhead accounted for by
+	// the agent.
+	MTUOverhead *int `json:"mtuOverhead,omitempty"`
+	// IPv4MTU and IPv6MTU override the route MTU of the respective
+	// address family, e.g. if IPv6 traffic is carried by a tunnel with
+	// a different overhead. They must not exceed the device MTU.
+	IPv4MTU int `json:"ipv4MTU,omitempty"`
+	IPv6MTU int `json:"ipv6MTU,omitempty"`
+	// DeviceMTU and RouteMTU independently override the MTU of the
+	// link and the MTU of the routes of the endpoint as otherwise
+	// selected by MTU, MTUMode or the agent. IPv4MTU and IPv6MTU in
+	// turn take precedence over RouteMTU.
+	DeviceMTU int `json:"deviceMTU,omitempty"`
+	RouteMTU  int `json:"routeMTU,omitempty"`
+	// SkipIPv6Enable skips enabling IPv6 in the container namespace. This
+	// is useful on nodes where the sysctl is read-only or IPv6 is disabled
+	// in the kernel.
+	SkipIPv6Enable bool `json:"skipIPv6Enable,omitempty"`
+	// EndpointCreateTimeout is the maximum duration to wait for the agent
+	// to create and synchronously build the endpoint, e.g. "2m". Defaults
+	// to the API client timeout. It must be lower than the CNI timeout of
+	// the runtime as the endpoint is otherwise torn down by a DEL while
+	// the ADD is still in progress.
+	EndpointCreateTimeout string `json:"endpointCreateTimeout,omitempty"`
+
+	// NetNSRetries is the number of times operations on the container
+	// namespace are retried if they fail with a transient error.
+	// Defaults to 2.
+	NetNSRetries *int `json:"netnsRetries,omitempty"`
+	// DeferHostLink keeps the host side veth down until the agent has
+	// created the endpoint to avoid a window in which traffic of the
+	// endpoint is not subject to policy. By default, the host side veth
+	// is brought up as soon as it is created.
+	DeferHostLink bool `json:"deferHostLink,omitempty"`
+	// HostPromisc puts the host side veth into promiscuous mode, e.g. for
+	// traffic sniffing setups. The host side veth then accepts all frames
+	// regardless of their destination MAC, which allows local processes
+	// with access to the interface to observe all traffic of the
+	// endpoint. Off by default. The setting is removed together with the
+	// veth pair on DEL.
+	HostPromisc bool `json:"hostPromisc,omitempty"`
+	// StrictGatewayValidation fails the ADD if the gateway of an address
+	// family is not within the allocation range of the endpoint address
+	// (or IPv6 link-local). By default, only a warning is logged.
+	StrictGatewayValidation bool `json:"strictGatewayValidation,omitempty"`
+	// Routes are additional routes installed in the container namespace
+	Routes []Route `json:"routes,omitempty"`
+	// PreferredSource is the source address set on the default route of
+	// its address family. It must be one of the addresses allocated to
+	// the endpoint. By default, the kernel selects the source address.
+	PreferredSource string `json:"preferredSource,omitempty"`
+	// DisableIPv4 and DisableIPv6 disable the respective address family
+	// for the endpoint even if it is enabled in the agent. An address of
+	// a disabled family allocated by the agent is released immediately.
+	DisableIPv4 bool `json:"disableIPv4,omitempty"`
+	DisableIPv6 bool `json:"disableIPv6,omitempty"`
+	// NoGateway allows endpoints without gateway if the agent does not
+	// provide a gateway address, e.g. if the endpoints are directly
+	// routed. The default route is then installed with link scope and
+	// additional routes must have a gateway or link scope. By default, a
+	// missing gateway fails the ADD.
+	NoGateway bool `json:"noGateway,omitempty"`
+	// StaticNeigh programs a permanent neighbor entry for the gateway with
+	// the MAC address of the host side of the endpoint to avoid address
+	// resolution delays on the first packet. Off by default.
+	StaticNeigh bool `json:"staticNeigh,omitempty"`
+	// RecordRequest records the CNI request which created an endpoint in
+	// the properties of the endpoint.
+	RecordRequest bool `json:"recordRequest,omitempty"`
+	// EndpointCreateJitter is the maximum random delay before the
+	// endpoint is created, e.g. "500ms". It spreads the endpoint creation
+	// requests of many pods started at once. The delay is only applied
+	// while the agent is regenerating restored endpoints unless
+	// EndpointCreateJitterAlways is set. The delay is deducted from the
+	// endpoint creation timeout.
+	EndpointCreateJitter string `json:"endpointCreateJitter,omitempty"`
+	// EndpointCreateJitterAlways applies EndpointCreateJitter regardless
+	// of the state of the agent
+	EndpointCreateJitterAlways bool `json:"endpointCreateJitterAlways,omitempty"`
+	// LabelSources overrides the source of the labels injected into the
+	// endpoint per label group. The groups are "mesos" for the labels
+	// passed in args by Mesos which defaults to the "mesos" source, and
+	// "cni-args" for the labels passed in CNI_ARGS and "zone" for the
+	// availability zone label which both default to the "container"
+	// source, and "service-account" for the service account label which
+	// defaults to the "k8s" source.
+	LabelSources map[string]string `json:"labelSources,omitempty"`
+	// AvailabilityZone is the availability zone of the node, typically
+	// populated by the node bootstrap. If set, it is injected into all
+	// endpoints as label with key failure-domain.beta.kubernetes.io/zone
+	// and the source of the "zone" label group, "container" by default.
+	// The agent does not derive endpoint labels from node labels, the
+	// label is independent of the labels of the Kubernetes node. Labels
+	// with the same key passed by Mesos or in CNI_ARGS take precedence.
+	AvailabilityZone string `json:"availabilityZone,omitempty"`
+	// ServiceAccountLabel injects the service account of the pod passed
+	// in CNI_ARGS as K8S_POD_SERVICE_ACCOUNT into the endpoint as label
+	// with key io.cilium.k8s.policy.serviceaccount and the source of the
+	// "service-account" label group, "k8s" by default. Policies can then
+	// select the endpoint by service account before the agent retrieved
+	// the pod. The labels of the pod retrieved by the agent, which
+	// include the service account, take precedence.
+	ServiceAccountLabel bool `json:"serviceAccountLabel,omitempty"`
+	// ArgsLabelPrefix enables the injection of labels passed in CNI_ARGS.
+	// Every CNI_ARGS key starting with the prefix, e.g. "CILIUM_LABEL_",
+	// is stripped of the prefix and injected as label with the value of
+	// the argument. Labels passed by Mesos take precedence over labels
+	// with the same key passed in CNI_ARGS. Disabled by default.
+	ArgsLabelPrefix string `json:"argsLabelPrefix,omitempty"`
+	// MaxLabels is the maximum number of labels injected into the
+	// endpoint. Labels exceeding the limit are dropped to protect the
+	// agent from pathological label sets. Defaults to 256.
+	MaxLabels int `json:"maxLabels,omitempty"`
+	// VerifyDelete checks that the interface is actually gone from the
+	// container namespace after it has been removed on DEL and logs an
+	// error if it persists.
+	VerifyDelete bool `json:"verifyDelete,omitempty"`
+	// Offloads maps ethtool offload features, e.g. "tx-checksumming", to
+	// "on" or "off". The settings are applied to the veth pair in veth
+	// datapath mode.
+	Offloads map[string]string `json:"offloads,omitempty"`
+	// IPv6DAD selects the duplicate address detection of the IPv6
+	// addresses of the endpoint. By default, the kernel runs DAD and the
+	// address may still be tentative when routes are added. "disabled"
+	// skips DAD as the addresses are unique by IPAM, "wait" waits for DAD
+	// to complete before routes are added.
+	IPv6DAD string `json:"ipv6DAD,omitempty"`
+	// ReportMetrics reports metrics observed by the plugin, e.g. the
+	// duration of the IP allocation, to the agent which exposes them.
+	ReportMetrics bool `json:"reportMetrics,omitempty"`
+	// AddLockDir is the directory of the lock files which serialize
+	// concurrent ADDs of the same container interface. Defaults to
+	// /var/run/cilium/cni-locks.
+	AddLockDir string `json:"addLockDir,omitempty"`
+	// PendingReleaseFile is the file recording the IPs which could not
+	// be released when rolling back a failed ADD. They are released by a
+	// later ADD or DEL. Defaults to /var/run/cilium/cni-pending-release.
+	PendingReleaseFile string `json:"pendingReleaseFile,omitempty"`
+	// ResultCacheDir is the directory in which the endpoint, addresses
+	// and interfaces created by a successful ADD are cached for the DEL
+	// of the same container interface. DEL falls back to discovering
+	// them if the cache is missing. Defaults to /var/run/cilium/cni-cache.
+	ResultCacheDir string `json:"resultCacheDir,omitempty"`
+	// DisableResultCache disables the result cache
+	DisableResultCache bool `json:"disableResultCache,omitempty"`
+	// AddLockTimeout is the maximum duration to wait for a concurrent ADD
+	// of the same container interface to complete, e.g. "10s". "0s"
+	// fails immediately. Defaults to 30 seconds.
+	AddLockTimeout string `json:"addLockTimeout,omitempty"`
+	// MaxConcurrentAdds is the maximum number of ADDs on the node which
+	// allocate addresses and create endpoints at once. Further ADDs wait
+	// up to AddLockTimeout for one of them to complete. The slots are
+	// lock files in the slots subdirectory of AddLockDir. Unlimited if 0.
+	MaxConcurrentAdds int `json:"maxConcurrentAdds,omitempty"`
+	// EndpointBuildMode selects whether the ADD waits for the agent to
+	// build the endpoint. "sync" (default) always waits. "auto" does not
+	// wait while the agent reports a backlog of endpoint builds and
+	// instead briefly polls for the endpoint to become ready, accepting
+	// that the first packets may be dropped.
+	EndpointBuildMode string `json:"endpointBuildMode,omitempty"`
+	// OwnerTemplate is the template of the owner recorded for allocated
+	// IPs. The placeholders {namespace}, {name} and {uid} are replaced
+	// with the namespace, name and UID of the pod, {containerID} with the
+	// ID of the container and {network} with the name of the network.
+	// Defaults to "{namespace}/{name}".
+	OwnerTemplate string `json:"ownerTemplate,omitempty"`
+	// OwnerPrefix partitions the IPAM accounting of tenants or clusters
+	// sharing namespaces. It is prepended to the rendered owner,
+	// separated by a slash, on allocation and release alike.
+	OwnerPrefix string `json:"ownerPrefix,omitempty"`
+	// OffloadsDevice selects the side of the veth pair the offload
+	// settings are applied to, "host", "container" or "both" (default).
+	OffloadsDevice string `json:"offloadsDevice,omitempty"`
+	// DatapathMode overrides the datapath mode of the agent for this
+	// network, "veth" or "ipvlan". The mode of the agent is used if
+	// unset. Handing an SR-IOV virtual function to the container is not
+	// supported, the agent attaches the datapath of an endpoint to its
+	// host side device which a virtual function does not have.
+	DatapathMode string `json:"datapathMode,omitempty"`
+	// ReportFailures reports failed ADDs of Kubernetes pods to the agent,
+	// which relays them as events of the pod to Kubernetes.
+	ReportFailures bool `json:"reportFailures,omitempty"`
+	// DelRetryPolicy selects which errors of the agent API are returned
+	// on DEL so that the runtime retries the deletion, see the
+	// delRetryPolicy constants. Defaults to "default".
+	DelRetryPolicy string `json:"delRetryPolicy,omitempty"`
+	// PolicyExempt disables policy enforcement of the endpoint in the
+	// given direction, one of "ingress", "egress" or "both". Policy is
+	// enforced normally if unset.
+	PolicyExempt string `json:"policyExempt,omitempty"`
+	// AllowPolicyExemptArgs allows overriding policyExempt with the
+	// CILIUM_POLICY_EXEMPT CNI argument. The argument is refused unless
+	// set.
+	AllowPolicyExemptArgs bool `json:"allowPolicyExemptArgs,omitempty"`
+	// DownBeforeDelete flushes the addresses of the container interface
+	// and brings it down on DEL before it is deleted. This avoids stale
+	// neighbor and conntrack state on kernels which do not clean up
+	// after interfaces deleted while up.
+	DownBeforeDelete bool `json:"downBeforeDelete,omitempty"`
+	// AttachToExisting attaches the interface to the existing endpoint of
+	// the container instead of creating an endpoint, e.g. to hot-add an
+	// interface. The addresses of the interface are added to the endpoint
+	// as secondary addresses and released when the endpoint is deleted.
+	// The ADD fails if the container has no endpoint. DEL only removes
+	// the interface.
+	AttachToExisting bool `json:"attachToExisting,omitempty"`
+	// PreAddHook is the absolute path of an executable run on ADD after
+	// the addresses have been allocated and the interface configured,
+	// before the endpoint is created. The container ID, netns, interface
+	// name, pod identity and addresses are passed as environment
+	// variables. A failing hook aborts the ADD, which is rolled back.
+	// The hook runs with the privileges of the plugin, usually root in
+	// the host namespaces, and must not be writable by group or others.
+	PreAddHook string `json:"preAddHook,omitempty"`
+	// PreAddHookTimeout is the maximum duration of PreAddHook, e.g. "5s".
+	// The hook is killed when it expires. Defaults to 10 seconds.
+	PreAddHookTimeout string `json:"preAddHookTimeout,omitempty"`
+	// PostDelHook is the absolute path of an executable run after a
+	// successful DEL, i.e. after the endpoint has been deleted and the
+	// interface removed. It receives the same environment as PreAddHook
+	// without the addresses. Failures are logged and do not fail the
+	// DEL. The security considerations of PreAddHook apply.
+	PostDelHook string `json:"postDelHook,omitempty"`
+	// PostDelHookTimeout is the maximum duration of PostDelHook.
+	// Defaults to 10 seconds.
+	PostDelHookTimeout string `json:"postDelHookTimeout,omitempty"`
+	// FlushExisting removes addresses found on the container interface
+	// before the addresses of the endpoint are added, e.g. if a sandbox
+	// is reused. IPv6 link-local addresses are retained. Enabled by
+	// default.
+	FlushExisting *bool `json:"flushExisting,omitempty"`
+	// ClampMTU caps the route MTU of the endpoint at the MTU of the host
+	// interface of the default route, the path MTU of the host. Larger
+	// packets would be dropped silently on the host path. A route MTU set
+	// by mtu, routeMTU, ipv4MTU or ipv6MTU is never clamped. Disabled by
+	// default.
+	ClampMTU *bool `json:"clampMTU,omitempty"`
+
+	endpointCreateTimeout time.Duration
+	endpointCreateJitter  time.Duration
+	addLockTimeout        time.Duration
+	preAddHookTimeout     time.Duration
+	postDelHookTimeout    time.Duration
+}
+
+// Route is an additional route installed in the container namespace
+type Route struct {
+	// Dst is the destination CIDR of the route
+	Dst string `json:"dst"`
+	// GW is the nexthop of the route. Defaults to the gateway of the
+	// endpoint.
+	GW string `json:"gw,omitempty"`
+	// Table is the routing table to install the route into. Defaults to
+	// the main table.
+	Table int `json:"table,omitempty"`
+	// Scope is the scope of the route: "global" (or "universe") for
+	// routes via a gateway, "site" for routes within the site, "link" for
+	// destinations directly attached to the interface and "host" for
+	// destinations on the local host. Defaults to "global" if the route
+	// has a nexthop and to "link" otherwise.
+	Scope string `json:"scope,omitempty"`
+	// Priority is the metric of the route, lower values are preferred.
+	// Defaults to the metric chosen by the kernel.
+	Priority int `json:"priority,omitempty"`
+}
+
+// IPAM is the IPAM configuration of the network
+type IPAM struct {
+	cniTypes.IPAM
+	// AddressesPerFamily is the number of addresses to allocate for each
+	// enabled address family. The first address is the primary address
+	// of the endpoint, all others are added as secondary addresses.
+	// Defaults to a single address per family.
+	AddressesPerFamily int `json:"addressesPerFamily,omitempty"`
+	// SubnetHint is a CIDR within the allocation range the primary
+	// address of its address family is allocated from, e.g. a rack-local
+	// subnet. The ADD fails if no address is available in the subnet. It
+	// can be overridden with the CILIUM_SUBNET_HINT CNI argument.
+	SubnetHint string `json:"subnetHint,omitempty"`
+	// IPv6Scope requires the IPv6 address to be a unique local ("ula")
+	// or a global unicast ("gua") address. The agent allocates from a
+	// single range per address family, the scope is therefore selected
+	// with SubnetHint and the allocated address is verified. The
+	// ADD fails if the address has a different scope. Any address
+	// returned by the agent is accepted by default.
+	IPv6Scope string `json:"ipv6Scope,omitempty"`
+}
+
+// RuntimeConfig contains the information the runtime passes to the plugin
+// for the capabilities enabled in the network configuration
+type RuntimeConfig struct {
+	// IPs are the addresses requested via the ips capability, at most
+	// one per address family
+	IPs []string `json:"ips,omitempty"`
+	// Mac is the MAC address requested via the mac capability
+	Mac string `json:"mac,omitempty"`
+}
+
+const (
+	capabilityIPs = "ips"
+	capabilityMac = "mac"
+)
+
+// supportedCapabilities are the capabilities for which the plugin consumes
+// the corresponding runtimeConfig entries. hostPort mappings are not
+// supported, they are programmed by chaining the portmap plugin.
+var supportedCapabilities = map[string]bool{
+	capabilityIPs: true,
+	capabilityMac: true,
 }
 
 type cniArgsSpec struct {
@@ -81,6 +480,14 @@ type cniArgsSpec struct {
 	K8S_POD_NAME               cniTypes.UnmarshallableString
 	K8S_POD_NAMESPACE          cniTypes.UnmarshallableString
 	K8S_POD_INFRA_CONTAINER_ID cniTypes.UnmarshallableString
+	K8S_POD_UID                cniTypes.UnmarshallableString
+	// K8S_POD_SERVICE_ACCOUNT is the service account of the pod, only
+	// used if ServiceAccountLabel is enabled
+	K8S_POD_SERVICE_ACCOUNT cniTypes.UnmarshallableString
+	CILIUM_SUBNET_HINT      cniTypes.UnmarshallableString
+	// CILIUM_POLICY_EXEMPT overrides policyExempt of the network
+	// configuration if allowPolicyExemptArgs is set
+	CILIUM_POLICY_EXEMPT cniTypes.UnmarshallableString
 }
 
 // Args contains arbitrary information a scheduler
@@ -105,12 +512,116 @@ type NetworkInfo struct {
 	} `json:"labels,omitempty"`
 }
 
+// pluginVersions are the CNI versions of the network configuration the plugin
+// accepts. Results are always emitted in the version of the network
+// configuration, runtimes supporting multiple versions negotiate it before
+// invoking the plugin.
+var pluginVersions = cniVersion.PluginSupports("0.1.0", "0.2.0", "0.3.0", "0.3.1", "0.4.0", specVersion100, specVersion110)
+
+// extraCommands are the commands which are not dispatched by skel, either
+// because they are not part of the CNI specification or because the vendored
+// CNI library predates them
+var extraCommands = map[string]func() error{
+	cmdGCName: func() error {
+		return cmdGC(os.Stdin)
+	},
+	cmdStatusName: func() error {
+		return cmdStatus(os.Stdin)
+	},
+	cmdReconcileName: func() error {
+		args, err := reconcileArgs(os.Stdin)
+		if err != nil {
+			return err
+		}
+		return cmdReconcile(args)
+	},
+	cmdProbeName: func() error {
+		return cmdProbe(os.Stdout)
+	},
+}
+
 func main() {
-	skel.PluginMain(cmdAdd,
-		nil,
-		cmdDel,
-		cniVersion.PluginSupports("0.1.0", "0.2.0", "0.3.0", "0.3.1"),
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		if err := printVersion(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Only a single command is processed per invocation
+	log = commandLogger(os.Getenv("CNI_COMMAND"))
+
+	if cmd, ok := extraCommands[os.Getenv("CNI_COMMAND")]; ok {
+		if err := cmd(); err != nil {
+			e, ok := err.(*cniTypes.Error)
+			if !ok {
+				e = &cniTypes.Error{Code: errCodeGeneric, Msg: err.Error()}
+			}
+			if err := e.Print(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	exitCode := exitCodeGeneric
+	e := skel.PluginMainWithError(recordExitCode(cmdAdd, &exitCode),
+		cmdCheck,
+		recordExitCode(cmdDel, &exitCode),
+		pluginVersions,
 		"Cilium CNI plugin "+version.Version)
+	if e != nil {
+		if err := e.Print(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+		if exitCode == exitCodeGeneric {
+			// The error may stem from skel, e.g. an incompatible
+			// version, before a command was invoked
+			exitCode = exitCodeFor(e)
+		}
+		os.Exit(exitCode)
+	}
+}
+
+// versionInfo is the output of the version subcommand
+type versionInfo struct {
+	PluginVersion     string   `json:"pluginVersion"`
+	SupportedVersions []string `json:"supportedVersions"`
+	AgentVersion      string   `json:"agentVersion,omitempty"`
+	AgentError        string   `json:"agentError,omitempty"`
+}
+
+// printVersion writes the version of the plugin, the supported CNI spec
+// versions and, if the agent is reachable, the version of the agent as JSON
+// to w.
+func printVersion(w io.Writer) error {
+	info := versionInfo{
+		PluginVersion:     version.Version,
+		SupportedVersions: pluginVersions.SupportedVersions(),
+	}
+
+	c, err := client.NewDefaultClient()
+	if err == nil {
+		defer c.Close()
+		var resp *daemon.GetDebuginfoOK
+		params := daemon.NewGetDebuginfoParams().WithTimeout(defaults.ClientConnectTimeout)
+		resp, err = c.Daemon.GetDebuginfo(params)
+		if err == nil {
+			info.AgentVersion = resp.Payload.CiliumVersion
+		}
+	}
+	if err != nil {
+		info.AgentError = client.Hint(err).Error()
+	}
+
+	out, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
 }
 
 func ipv6IsEnabled(ipam *models.IPAMResponse) bool {
@@ -138,27 +649,90 @@ func ipv4IsEnabled(ipam *models.IPAMResponse) bool {
 }
 
 func loadNetConf(bytes []byte) (*netConf, string, error) {
+	bytes, err := applyInclude(bytes, netConfIncludeDir)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load netconf: %s", err)
+	}
+	bytes, err = applyOverlay(bytes, netConfOverlayPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load netconf: %s", err)
+	}
+
 	n := &netConf{}
 	if err := json.Unmarshal(bytes, n); err != nil {
 		return nil, "", fmt.Errorf("failed to load netconf: %s", err)
 	}
+	if err := n.parseOptions(); err != nil {
+		return nil, "", err
+	}
+	if err := n.validate(); err != nil {
+		return nil, "", err
+	}
 	return n, n.CNIVersion, nil
 }
 
-func releaseIP(client *client.Client, ip string) {
-	if ip != "" {
-		if err := client.IPAMReleaseIP(ip); err != nil {
-			log.WithError(err).WithField(logfields.IPAddr, ip).Warn("Unable to release IP")
+// loadDelNetConf loads the network configuration of a DEL. The configuration
+// may have changed since the ADD and DEL must still clean up, so unlike
+// loadNetConf, fields of the wrong type and invalid options are logged and
+// skipped while every other field is kept. An error is only returned if the
+// configuration can't be decoded at all.
+func loadDelNetConf(bytes []byte) (*netConf, error) {
+	bytes, err := applyInclude(bytes, netConfIncludeDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %s", err)
+	}
+	bytes, err = applyOverlay(bytes, netConfOverlayPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %s", err)
+	}
+
+	n := &netConf{}
+	if err := json.Unmarshal(bytes, n); err != nil {
+		// The decoder continues past fields of the wrong type
+		if _, ok := err.(*json.UnmarshalTypeError); !ok {
+			return nil, fmt.Errorf("failed to load netconf: %s", err)
+		}
+		log.WithError(err).Warning("Ignoring invalid field of network configuration")
+	}
+	if err := n.parseOptions(); err != nil {
+		log.WithError(err).Warning("Ignoring invalid option of network configuration")
+	}
+	return n, nil
+}
+
+// datapathMode returns the datapath mode of the endpoint. The mode of the
+// network takes precedence over the mode of the agent, veth is used if
+// neither provides one. An error naming the missing field is returned if the
+// agent does not provide the configuration required by the mode.
+func (n *netConf) datapathMode(conf *models.DaemonConfigurationStatus) (string, error) {
+	mode := string(conf.DatapathMode)
+	if n.DatapathMode != "" {
+		mode = n.DatapathMode
+	}
+	switch mode {
+	case "":
+		log.Debug("cilium-agent did not provide a datapath mode, using veth")
+		mode = option.DatapathModeVeth
+	case option.DatapathModeIpvlan:
+		switch {
+		case conf.IpvlanConfiguration == nil:
+			return "", fmt.Errorf("datapath mode %q requires an ipvlan master device configured in cilium-agent: ipvlan-configuration is missing", mode)
+		case conf.IpvlanConfiguration.MasterDeviceIndex == 0:
+			return "", fmt.Errorf("datapath mode %q requires an ipvlan master device configured in cilium-agent: masterDeviceIndex is not set", mode)
 		}
 	}
+	return mode, nil
 }
 
-func releaseIPs(client *client.Client, addr *models.AddressPair) {
-	releaseIP(client, addr.IPV6)
-	releaseIP(client, addr.IPV4)
+// netNSRetries returns the number of retries of namespace operations
+func (n *netConf) netNSRetries() int {
+	if n.NetNSRetries != nil {
+		return *n.NetNSRetries
+	}
+	return defaultNetNSRetries
 }
 
-func addIPConfigToLink(ip addressing.CiliumIP, routes []route.Route, link netlink.Link, ifName string) error {
+func addIPConfigToLink(ip addressing.CiliumIP, routes []route.Route, link netlink.Link, ifName string, dad string) error {
 	log.WithFields(logrus.Fields{
 		logfields.IPAddr:    ip,
 		"netLink":           logfields.Repr(link),
@@ -166,7 +740,11 @@ func addIPConfigToLink(ip addressing.CiliumIP, routes []route.Route, link netlin
 	}).Debug("Configuring link")
 
 	addr := &netlink.Addr{IPNet: ip.EndpointPrefix()}
-	if err := netlink.AddrAdd(link, addr); err != nil {
+	if dad == ipv6DADDisabled {
+		addr.Flags = unix.IFA_F_NODAD
+	}
+	// The address may already exist if the ADD is retried
+	if err := netlink.AddrAdd(link, addr); err != nil && !os.IsExist(err) {
 		return fmt.Errorf("failed to add addr to %q: %v", ifName, err)
 	}
 
@@ -176,8 +754,15 @@ func addIPConfigToLink(ip addressing.CiliumIP, routes []route.Route, link netlin
 		return fmt.Errorf("failed to set %q UP: %v", ifName, err)
 	}
 
+	if dad == ipv6DADWait {
+		if err := waitForDAD(link, addr.IP, ipv6DADTimeout); err != nil {
+			return fmt.Errorf("failed to add addr to %q: %v", ifName, err)
+		}
+	}
+
 	// Sort provided routes to make sure we apply any more specific
-	// routes first which may be used as nexthops in wider routes
+	// routes first which may be used as nexthops in wider routes. Routes
+	// to the same prefix are applied in order of preference.
 	sort.Sort(route.ByMask(routes))
 
 	for _, r := range routes {
@@ -187,6 +772,9 @@ func addIPConfigToLink(ip addressing.CiliumIP, routes []route.Route, link netlin
 			Scope:     netlink.SCOPE_UNIVERSE,
 			Dst:       &r.Prefix,
 			MTU:       r.MTU,
+			Table:     r.Table,
+			Src:       r.Local,
+			Priority:  r.Priority,
 		}
 
 		if r.Nexthop == nil {
@@ -195,6 +783,12 @@ func addIPConfigToLink(ip addressing.CiliumIP, routes []route.Route, link netlin
 			rt.Gw = *r.Nexthop
 		}
 
+		// Like in the route package, SCOPE_UNIVERSE means the scope is
+		// inferred from the nexthop
+		if r.Scope != netlink.SCOPE_UNIVERSE {
+			rt.Scope = r.Scope
+		}
+
 		if err := netlink.RouteAdd(rt); err != nil {
 			if !os.IsExist(err) {
 				return fmt.Errorf("failed to add route '%s via %v dev %v': %v",
@@ -206,26 +800,63 @@ func addIPConfigToLink(ip addressing.CiliumIP, routes []route.Route, link netlin
 	return nil
 }
 
-func configureIface(ipam *models.IPAMResponse, ifName string, state *CmdState) (string, error) {
+func configureIface(ipam *models.IPAMResponse, ifName string, state *CmdState, ipv6DAD string, flush bool) (string, error) {
 	l, err := netlink.LinkByName(ifName)
 	if err != nil {
 		return "", fmt.Errorf("failed to lookup %q: %v", ifName, err)
 	}
 
+	if err := verifyLink(l, state); err != nil {
+		return "", err
+	}
+
+	if len(state.RequestedMAC) > 0 {
+		if err := checkMACUnique(l, state.RequestedMAC); err != nil {
+			return "", err
+		}
+	}
+
+	if flush {
+		if err := flushAddrs(l, ifName); err != nil {
+			return "", err
+		}
+	}
+
 	if err := netlink.LinkSetUp(l); err != nil {
 		return "", fmt.Errorf("failed to set %q UP: %v", ifName, err)
 	}
 
 	if ipv4IsEnabled(ipam) {
-		if err := addIPConfigToLink(state.IP4, state.IP4routes, l, ifName); err != nil {
+		if err := addIPConfigToLink(state.IP4, state.IP4routes, l, ifName, ""); err != nil {
 			return "", fmt.Errorf("error configuring IPv4: %s", err.Error())
 		}
+		if len(state.GatewayMAC) != 0 {
+			if err := addGatewayNeigh(l, connector.IPv4Gateway(state.HostAddr), state.GatewayMAC); err != nil {
+				return "", fmt.Errorf("error configuring IPv4: %s", err.Error())
+			}
+		}
 	}
 
 	if ipv6IsEnabled(ipam) {
-		if err := addIPConfigToLink(state.IP6, state.IP6routes, l, ifName); err != nil {
+		if err := addIPConfigToLink(state.IP6, state.IP6routes, l, ifName, ipv6DAD); err != nil {
 			return "", fmt.Errorf("error configuring IPv6: %s", err.Error())
 		}
+		if len(state.GatewayMAC) != 0 {
+			if err := addGatewayNeigh(l, connector.IPv6Gateway(state.HostAddr), state.GatewayMAC); err != nil {
+				return "", fmt.Errorf("error configuring IPv6: %s", err.Error())
+			}
+		}
+	}
+
+	for _, ip := range state.Secondary {
+		addr := &netlink.Addr{IPNet: ip.EndpointPrefix()}
+		if ip.IsIPv6() && ipv6DAD == ipv6DADDisabled {
+			addr.Flags = unix.IFA_F_NODAD
+		}
+		// The address may already exist if the ADD is retried
+		if err := netlink.AddrAdd(l, addr); err != nil && !os.IsExist(err) {
+			return "", fmt.Errorf("failed to add secondary addr %s to %q: %v", ip, ifName, err)
+		}
 	}
 
 	if err := netlink.LinkSetUp(l); err != nil {
@@ -250,47 +881,88 @@ func newCNIRoute(r route.Route) *cniTypes.Route {
 	return rt
 }
 
-func prepareIP(ipAddr string, isIPv6 bool, state *CmdState, mtu int) (*cniTypesVer.IPConfig, []*cniTypes.Route, error) {
+func prepareIP(ipAddr string, isIPv6 bool, state *CmdState, routeMTU, deviceMTU, pathMTU int, strictGateway, noGateway bool, extraRouteConfig []Route) (*cniTypesVer.IPConfig, []*cniTypes.Route, error) {
 	var (
-		routes    []route.Route
-		err       error
-		gw        string
-		ipVersion string
-		ip        addressing.CiliumIP
+		routes     []route.Route
+		err        error
+		gw         string
+		ipVersion  string
+		ip         addressing.CiliumIP
+		allocRange string
 	)
 
 	if isIPv6 {
 		if state.IP6, err = addressing.NewCiliumIPv6(ipAddr); err != nil {
 			return nil, nil, err
 		}
-		if state.IP6routes, err = connector.IPv6Routes(state.HostAddr, mtu); err != nil {
-			return nil, nil, err
-		}
-		routes = state.IP6routes
 		ip = state.IP6
 		gw = connector.IPv6Gateway(state.HostAddr)
+		allocRange = state.HostAddr.IPV6.AllocRange
 		ipVersion = "6"
 	} else {
 		if state.IP4, err = addressing.NewCiliumIPv4(ipAddr); err != nil {
 			return nil, nil, err
 		}
-		if state.IP4routes, err = connector.IPv4Routes(state.HostAddr, mtu); err != nil {
-			return nil, nil, err
-		}
-		routes = state.IP4routes
 		ip = state.IP4
 		gw = connector.IPv4Gateway(state.HostAddr)
+		allocRange = state.HostAddr.IPV4.AllocRange
 		ipVersion = "4"
 	}
 
+	if pathMTU > 0 && routeMTU > pathMTU {
+		log.WithFields(logrus.Fields{
+			logfields.IPAddr: ipAddr,
+			"routeMTU":       routeMTU,
+			"pathMTU":        pathMTU,
+		}).Warn("Route MTU exceeds path MTU of host, clamping route MTU")
+		routeMTU = pathMTU
+	}
+
+	gwIP := net.ParseIP(gw)
+	switch {
+	case gwIP == nil && !noGateway:
+		return nil, nil, fmt.Errorf("Invalid gateway address: %s", gw)
+	case gwIP == nil:
+		routes = noGatewayRoutes(isIPv6, routeMTU)
+	case isIPv6:
+		routes, err = connector.IPv6Routes(state.HostAddr, routeMTU, deviceMTU)
+	default:
+		routes, err = connector.IPv4Routes(state.HostAddr, routeMTU, deviceMTU)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if isIPv6 {
+		state.IP6routes = routes
+	} else {
+		state.IP4routes = routes
+	}
+
 	rt := []*cniTypes.Route{}
 	for _, r := range routes {
 		rt = append(rt, newCNIRoute(r))
 	}
 
-	gwIP := net.ParseIP(gw)
-	if gwIP == nil {
-		return nil, nil, fmt.Errorf("Invalid gateway address: %s", gw)
+	if gwIP != nil {
+		if err := validateGateway(ip, gwIP, allocRange); err != nil {
+			if strictGateway {
+				return nil, nil, err
+			}
+			log.WithError(err).Warning("Gateway may not be reachable from endpoint")
+		}
+	}
+
+	extra, err := extraRoutes(extraRouteConfig, isIPv6, gwIP, routeMTU, deviceMTU)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, r := range extra {
+		rt = append(rt, newCNIRoute(r))
+	}
+	if isIPv6 {
+		state.IP6routes = append(state.IP6routes, extra...)
+	} else {
+		state.IP4routes = append(state.IP4routes, extra...)
 	}
 
 	return &cniTypesVer.IPConfig{
@@ -300,110 +972,27 @@ func prepareIP(ipAddr string, isIPv6 bool, state *CmdState, mtu int) (*cniTypesV
 	}, rt, nil
 }
 
-func setUPWithFlannel(logger *logrus.Entry, args *skel.CmdArgs, cniArgs cniArgsSpec, n *netConf, cniVer string, c *client.Client) (err error) {
-	err = cniVersion.ParsePrevResult(&n.NetConf)
-	if err != nil {
-		return fmt.Errorf("unable to understand network config: %s", err)
-	}
-	r, err := cniTypesVer.GetResult(n.PrevResult)
-	if err != nil {
-		return fmt.Errorf("unable to get previous network result: %s", err)
-	}
-	// We only care about the veth interface that is on the host side
-	// and cni0. Interfaces should be similar as:
-	//       "interfaces":[
-	//         {
-	//            "name":"cni0",
-	//            "mac":"0a:58:0a:f4:00:01"
-	//         },
-	//         {
-	//            "name":"veth15707e9b",
-	//            "mac":"4e:6d:93:35:6b:45"
-	//         },
-	//         {
-	//            "name":"eth0",
-	//            "mac":"0a:58:0a:f4:00:06",
-	//            "sandbox":"/proc/15259/ns/net"
-	//         }
-	//       ]
+// getNetNS opens the network namespace of the container for ADD. The handle
+// is closed by cmdAdd once the ADD completes. Benchmarks of the datapath
+// setup replace it to reuse a single handle across ADDs.
+var getNetNS = ns.GetNS
 
-	defer func() {
-		if err != nil {
-			logger.WithError(err).
-				WithFields(logrus.Fields{"cni-pre-result": n.PrevResult.String()}).
-				Errorf("Unable to create endpoint")
-		}
-	}()
-	var (
-		hostMac, vethHostName, vethLXCMac, vethIP string
-		vethHostIdx, vethSliceIdx                 int
-	)
-	for i, iDev := range r.Interfaces {
-		// We only care about the veth interface mac address on the container side.
-		if iDev.Sandbox != "" {
-			vethLXCMac = iDev.Mac
-			vethSliceIdx = i
-			continue
-		}
-
-		l, err := netlink.LinkByName(iDev.Name)
-		if err != nil {
-			continue
-		}
-		switch l.Type() {
-		case "veth":
-			vethHostName = iDev.Name
-			vethHostIdx = l.Attrs().Index
-		case "bridge":
-			// likely to be cni0
-			hostMac = iDev.Mac
+// configureNetNS configures the loopback and the interface of the endpoint
+// in the network namespace of the container. Returns the MAC address of the
+// interface.
+func configureNetNS(logger *logrus.Entry, n *netConf, netNs ns.NetNS, ipam *models.IPAMResponse, ifName string, state *CmdState) (string, error) {
+	var macAddrStr string
+	err := doInNetNS(n.netNSRetries(), netNs, func() (err error) {
+		if !n.SkipIPv6Enable {
+			enableIPv6(logger)
 		}
-	}
-	for _, ipCfg := range r.IPs {
-		if ipCfg.Interface != nil && *ipCfg.Interface == vethSliceIdx {
-			vethIP = ipCfg.Address.IP.String()
-			break
+		if err := setupLoopback(logger); err != nil {
+			return err
 		}
-	}
-	switch {
-	case hostMac == "":
-		return errors.New("unable to determine MAC address of bridge interface (cni0)")
-	case vethHostName == "":
-		return errors.New("unable to determine name of veth pair on the host side")
-	case vethLXCMac == "":
-		return errors.New("unable to determine MAC address of veth pair on the container side")
-	case vethIP == "":
-		return errors.New("unable to determine IP address of the container")
-	case vethHostIdx == 0:
-		return errors.New("unable to determine index interface of veth pair on the host side")
-	}
-
-	ep := &models.EndpointChangeRequest{
-		Addressing: &models.AddressPair{
-			IPV4: vethIP,
-		},
-		ContainerID:       args.ContainerID,
-		State:             models.EndpointStateWaitingForIdentity,
-		HostMac:           hostMac,
-		InterfaceIndex:    int64(vethHostIdx),
-		Mac:               vethLXCMac,
-		InterfaceName:     vethHostName,
-		K8sPodName:        string(cniArgs.K8S_POD_NAME),
-		K8sNamespace:      string(cniArgs.K8S_POD_NAMESPACE),
-		SyncBuildEndpoint: true,
-	}
-
-	err = c.EndpointCreate(ep)
-	if err != nil {
-		logger.WithError(err).WithFields(logrus.Fields{
-			logfields.ContainerID: ep.ContainerID}).Warn("Unable to create endpoint")
-		err = fmt.Errorf("unable to create endpoint: %s", err)
-		return
-	}
-
-	logger.WithFields(logrus.Fields{
-		logfields.ContainerID: ep.ContainerID}).Debug("Endpoint successfully created")
-	return nil
+		macAddrStr, err = configureIface(ipam, ifName, state, n.IPv6DAD, n.flushExisting())
+		return err
+	})
+	return macAddrStr, err
 }
 
 func cmdAdd(args *skel.CmdArgs) (err error) {
@@ -415,61 +1004,122 @@ func cmdAdd(args *skel.CmdArgs) (err error) {
 		cniVer   string
 		c        *client.Client
 		netNs    ns.NetNS
+		// phase is the phase of the ADD reported on failure
+		phase = addPhaseSetup
 	)
 
 	logger := log.WithField("eventUUID", uuid.NewUUID())
 	logger.WithField("args", args).Debug("Processing CNI ADD request")
 
+	timer := newPhaseTimer(logger)
+	defer timer.summary()
+
+	if err = validateIfName(args.IfName); err != nil {
+		return
+	}
+
+	timer.begin(timingNetConfLoad)
 	n, cniVer, err = loadNetConf(args.StdinData)
 	if err != nil {
+		err = withExitCode(exitCodeValidation, err)
 		return
 	}
 
+	timer.begin(timingArgsParse)
+	argsLabels, loadArgs := splitArgsLabels(args.Args, n.ArgsLabelPrefix)
 	cniArgs := cniArgsSpec{}
-	if err = cniTypes.LoadArgs(args.Args, &cniArgs); err != nil {
-		err = fmt.Errorf("unable to extract CNI arguments: %s", err)
+	if err = cniTypes.LoadArgs(loadArgs, &cniArgs); err != nil {
+		err = withExitCode(exitCodeValidation, fmt.Errorf("unable to extract CNI arguments: %s", err))
 		return
 	}
 
+	// Serialize concurrent ADDs of the same container interface which
+	// would otherwise race on IP allocation and interface names
+	timer.begin(timingLockAcquire)
+	addLock, err := acquireAddLock(n.addLockDir(), args.ContainerID, args.IfName, n.addLockTimeout)
+	if err != nil {
+		return
+	}
+	defer addLock.Close()
+
+	timer.begin(timingClientConnect)
 	c, err = client.NewDefaultClientWithTimeout(defaults.ClientConnectTimeout)
 	if err != nil {
-		err = fmt.Errorf("unable to connect to Cilium daemon: %s", err)
+		err = withExitCode(exitCodeAgentUnreachable, fmt.Errorf("unable to connect to Cilium daemon: %s", err))
 		return
 	}
+	defer c.Close()
+
+	// Registered before all cleanups so that the final error is reported
+	if n.ReportFailures {
+		defer func() {
+			if err != nil {
+				reportAddFailure(c, logger, args, cniArgs, phase, err)
+			}
+		}()
+	}
+
+	replayPendingReleases(c, n.pendingReleaseFile(), n.addLockDir())
 
 	if len(n.NetConf.RawPrevResult) != 0 {
-		switch n.Name {
-		case "cbr0":
-			err = setUPWithFlannel(logger, args, cniArgs, n, cniVer, c)
+		switch {
+		case n.Name == "cbr0":
+			_, err = setupPassthrough(logger, args, cniArgs, n, c, true)
+			if err != nil {
+				return
+			}
+			return cniTypes.PrintResult(&ciliumResult{}, cniVer)
+		case n.IPAM.Type == ipamTypeNone:
+			var prevResult *cniTypesVer.Result
+			prevResult, err = setupPassthrough(logger, args, cniArgs, n, c, false)
 			if err != nil {
 				return
 			}
-			return cniTypes.PrintResult(&cniTypesVer.Result{}, cniVer)
-		default:
+			return cniTypes.PrintResult(&ciliumResult{Result: *prevResult}, cniVer)
 		}
+	} else if n.IPAM.Type == ipamTypeNone {
+		err = fmt.Errorf("ipam type %q requires the result of a previous plugin in the chain", ipamTypeNone)
+		return
 	}
 
-	netNs, err = ns.GetNS(args.Netns)
+	timer.begin(timingNetNSPrepare)
+	netNs, err = openNetNS(n.netNSRetries(), args.Netns, getNetNS)
 	if err != nil {
-		err = fmt.Errorf("failed to open netns %q: %s", args.Netns, err)
+		err = withExitCode(exitCodeNetNS, err)
+		return
 	}
 	defer netNs.Close()
 
-	if err = netns.RemoveIfFromNetNSIfExists(netNs, args.IfName); err != nil {
-		err = fmt.Errorf("failed removing interface %q from namespace %q: %s",
-			args.IfName, args.Netns, err)
+	if err = removeIfFromNetNS(n.netNSRetries(), netNs, args.IfName); err != nil {
+		err = withExitCode(exitCodeNetNS, fmt.Errorf("failed removing interface %q from namespace %q: %s",
+			args.IfName, args.Netns, err))
 		return
 	}
 
 	addLabels := models.Labels{}
 
+	mesosSource := n.labelSource(labelGroupMesos)
 	for _, label := range n.Args.Mesos.NetworkInfo.Labels.Labels {
-		addLabels = append(addLabels, fmt.Sprintf("%s:%s=%s", labels.LabelSourceMesos, label.Key, label.Value))
+		addLabels = append(addLabels, fmt.Sprintf("%s:%s=%s", mesosSource, label.Key, label.Value))
+	}
+	addLabels = addArgsLabels(logger, addLabels, n.labelSource(labelGroupArgs), argsLabels)
+	if n.AvailabilityZone != "" {
+		zoneLabel := []argLabel{{key: zoneLabelKey, value: n.AvailabilityZone}}
+		addLabels = addArgsLabels(logger, addLabels, n.labelSource(labelGroupZone), zoneLabel)
 	}
+	var saLabels []argLabel
+	if saLabels, err = serviceAccountLabels(n, cniArgs); err != nil {
+		return
+	}
+	addLabels = addArgsLabels(logger, addLabels, n.labelSource(labelGroupServiceAccount), saLabels)
+	addLabels = limitLabels(logger, addLabels, n.maxLabels())
 
+	timer.begin(timingConfigGet)
 	configResult, err := c.ConfigGet()
 	if err != nil {
-		return fmt.Errorf("unable to retrieve configuration from cilium-agent: %s", err)
+		// Only errors of reaching the agent are reported as such
+		code := exitCodeFor(err)
+		return withExitCode(code, fmt.Errorf("unable to retrieve configuration from cilium-agent: %s", err))
 	}
 
 	if configResult == nil || configResult.Status == nil {
@@ -479,6 +1129,12 @@ func cmdAdd(args *skel.CmdArgs) (err error) {
 
 	conf := *configResult.Status
 
+	var mtuSource string
+	conf.DeviceMTU, conf.RouteMTU, mtuSource = endpointMTU(n, &conf, logger)
+
+	// The agent derives the state of a new endpoint from the resolution
+	// of its identity and does not honor the requested state, the initial
+	// state is therefore not configurable.
 	ep := &models.EndpointChangeRequest{
 		ContainerID:  args.ContainerID,
 		Labels:       addLabels,
@@ -488,31 +1144,146 @@ func cmdAdd(args *skel.CmdArgs) (err error) {
 		K8sNamespace: string(cniArgs.K8S_POD_NAMESPACE),
 	}
 
-	switch conf.DatapathMode {
+	ep.Properties = attachmentProperties(n.Name, args.IfName)
+	// A previous result is only passed to plugins which are not the first
+	// in a chain
+	if len(n.NetConf.RawPrevResult) != 0 {
+		addChainProperties(ep, n.Name)
+	}
+	if n.RecordRequest {
+		for k, v := range requestProperties(args, cniArgs) {
+			ep.Properties[k] = v
+		}
+	}
+
+	if ep.PolicyExempt, err = n.policyExempt(cniArgs); err != nil {
+		return
+	}
+
+	var (
+		ifIndex int
+		ifMAC   net.HardwareAddr
+		// hostLink is the host side interface which must be brought up
+		// once the endpoint has been created
+		hostLink netlink.Link
+	)
+
+	timer.begin(timingDatapathSetup)
+	datapathMode, err := n.datapathMode(&conf)
+	if err != nil {
+		return
+	}
+	if n.TxQueueLen != nil && datapathMode != option.DatapathModeVeth {
+		err = fmt.Errorf("txQueueLen is only supported in %s datapath mode", option.DatapathModeVeth)
+		return
+	}
+	if (n.HostProxyARP || n.HostProxyNDP) && datapathMode != option.DatapathModeVeth {
+		err = fmt.Errorf("hostProxyARP and hostProxyNDP are only supported in %s datapath mode", option.DatapathModeVeth)
+		return
+	}
+	if n.HostMaster != "" && datapathMode != option.DatapathModeVeth {
+		err = fmt.Errorf("hostMaster is only supported in %s datapath mode", option.DatapathModeVeth)
+		return
+	}
+	mac := requestedMAC(n)
+	if mac != nil && datapathMode != option.DatapathModeVeth {
+		err = fmt.Errorf("the mac capability is only supported in %s datapath mode", option.DatapathModeVeth)
+		return
+	}
+
+	switch datapathMode {
 	case option.DatapathModeVeth:
 		var (
 			veth      *netlink.Veth
 			peer      *netlink.Link
 			tmpIfName string
 		)
-		veth, peer, tmpIfName, err = connector.SetupVeth(ep.ContainerID, int(conf.DeviceMTU), ep)
+		vethOpts := connector.VethOptions{
+			HostIfPrefix:    n.HostInterfacePrefix,
+			HostIfName:      hostIfName(n, args, cniArgs),
+			Queues:          n.VethQueues,
+			DeferHostLinkUp: n.DeferHostLink,
+			HostPromisc:     n.HostPromisc,
+		}
+		veth, peer, tmpIfName, err = connector.SetupVethWithOptions(ep.ContainerID, int(conf.DeviceMTU), vethOpts, ep)
 		if err != nil {
 			return err
 		}
 		defer func() {
 			if err != nil {
-				if err = netlink.LinkDel(veth); err != nil {
-					logger.WithError(err).WithField(logfields.Veth, veth.Name).Warn("failed to clean up and delete veth")
+				// The veth pair is gone already if the container
+				// side has been removed from the namespace
+				if _, err2 := netlink.LinkByName(veth.Name); err2 != nil {
+					return
+				}
+				if err2 := netlink.LinkDel(veth); err2 != nil {
+					logger.WithError(err2).WithField(logfields.Veth, veth.Name).Warn("failed to clean up and delete veth")
 				}
 			}
 		}()
 
+		if n.DeferHostLink {
+			hostLink = veth
+		}
+
+		if n.HostProxyARP || n.HostProxyNDP {
+			if err = setProxyNeigh(logger, veth.Name, n.HostProxyARP, n.HostProxyNDP); err != nil {
+				return
+			}
+		}
+
+		if n.HostMaster != "" {
+			if err = setHostMaster(logger, veth, n.HostMaster); err != nil {
+				return
+			}
+		}
+
+		if !n.DisableInterfaceAlias {
+			if err2 := setInterfaceAlias(veth, cniArgs); err2 != nil {
+				logger.WithError(err2).WithField(logfields.Veth, veth.Name).Warn("Unable to set interface alias")
+			}
+		}
+
+		if len(n.Offloads) != 0 {
+			if n.OffloadsDevice != offloadsDeviceContainer {
+				if err = setOffloads(logger, veth.Name, n.Offloads); err != nil {
+					return
+				}
+			}
+			if n.OffloadsDevice != offloadsDeviceHost {
+				if err = setOffloads(logger, tmpIfName, n.Offloads); err != nil {
+					return
+				}
+			}
+		}
+
+		if n.TxQueueLen != nil {
+			// The queue length is retained when the peer is moved
+			// into the namespace
+			if err = setTxQueueLen(*peer, *n.TxQueueLen); err != nil {
+				return
+			}
+			if n.HostTxQueueLen {
+				if err = setTxQueueLen(veth, *n.TxQueueLen); err != nil {
+					return
+				}
+			}
+		}
+
+		if mac != nil {
+			if err = netlink.LinkSetHardwareAddr(*peer, mac); err != nil {
+				err = fmt.Errorf("unable to set MAC address %s of %s: %s", mac, tmpIfName, err)
+				return
+			}
+			ep.Mac = mac.String()
+		}
+
 		if err = netlink.LinkSetNsFd(*peer, int(netNs.Fd())); err != nil {
 			err = fmt.Errorf("unable to move veth pair '%v' to netns: %s", peer, err)
 			return
 		}
 
-		_, _, err = connector.SetupVethRemoteNs(netNs, tmpIfName, args.IfName)
+		ifIndex, ifMAC, err = setupVethRemoteNs(n.netNSRetries(), netNs, tmpIfName, args.IfName)
 		if err != nil {
 			return
 		}
@@ -529,27 +1300,122 @@ func cmdAdd(args *skel.CmdArgs) (err error) {
 			return
 		}
 		defer unix.Close(mapFD)
+
+		defer func() {
+			if err != nil {
+				removeIpvlanSlave(logger, netNs, args.IfName)
+			}
+		}()
+	}
+
+	ipamConf := n.IPAM
+	if hint := string(cniArgs.CILIUM_SUBNET_HINT); hint != "" {
+		ipamConf.SubnetHint = hint
 	}
 
-	podName := string(cniArgs.K8S_POD_NAMESPACE) + "/" + string(cniArgs.K8S_POD_NAME)
-	ipam, err = c.IPAMAllocate("", podName)
+	if n.MaxConcurrentAdds > 0 {
+		// Bound the number of ADDs hitting the IPAM and endpoint API
+		// of the agent at once
+		timer.begin(timingSlotAcquire)
+		var addSlot *os.File
+		addSlot, err = acquireAddSlot(n.addLockDir(), n.MaxConcurrentAdds, n.addLockTimeout)
+		if err != nil {
+			return
+		}
+		defer addSlot.Close()
+	}
+
+	if n.AttachToExisting {
+		if _, err = existingEndpoint(c, args.ContainerID); err != nil {
+			return
+		}
+	}
+
+	phase = addPhaseIPAM
+	timer.begin(timingIPAMAllocate)
+	owner := ipOwner(n, args, cniArgs)
+	allocStart := time.Now()
+	if ips := requestedIPs(n, &cniArgs); len(ips) > 0 {
+		ipam, err = allocateRequestedIPs(c, ips, owner, args.ContainerID, conf.Addressing)
+	} else {
+		ipam, err = allocateIPs(c, &ipamConf, owner, args.ContainerID)
+	}
 	if err != nil {
+		// The agent may have allocated addresses which are unknown
+		// to the plugin
+		releaseByIdentity(c, n, args, cniArgs)
 		return
 	}
+	recordAllocationDuration(n, ep, allocStart)
 
 	if ipam.Address == nil {
 		err = fmt.Errorf("Invalid IPAM response, missing addressing")
 		return
 	}
 
+	if expiration := time.Time(ipam.Expiration); !expiration.IsZero() {
+		logger.WithField("expiration", expiration).Info("Allocated addresses expire")
+	} else {
+		logger.Debug("Allocated addresses do not expire")
+	}
+
+	if n.DisableIPv4 || n.DisableIPv6 {
+		if err = disableFamilies(c, n, ipam); err != nil {
+			return
+		}
+	}
+
+	if ipam.IPV4Pool != "" || ipam.IPV6Pool != "" {
+		logger.WithFields(logrus.Fields{
+			"ipv4Pool": ipam.IPV4Pool,
+			"ipv6Pool": ipam.IPV6Pool,
+		}).Info("Allocated addresses from IP pools")
+	}
+
+	if err = checkIPv6Scope(c, &ipamConf, ipam); err != nil {
+		return
+	}
+
 	// release addresses on failure
+	rollback := &ipRollback{
+		client:      c,
+		file:        n.pendingReleaseFile(),
+		owner:       owner,
+		containerID: args.ContainerID,
+		ifName:      args.IfName,
+	}
+	defer func() {
+		if err != nil {
+			rollback.release(ipam.Address.IPV4)
+			rollback.release(ipam.Address.IPV6)
+		}
+	}()
+
+	// The rollback releases the address of the mismatched family
+	if err = checkAddressFamilies(ipam); err != nil {
+		return
+	}
+
+	ep.SecondaryAddressing, err = allocateSecondaryIPs(c, &n.IPAM, owner, args.ContainerID, ipam.Address)
+	if err != nil {
+		return
+	}
+
 	defer func() {
 		if err != nil {
-			releaseIP(c, ipam.Address.IPV4)
-			releaseIP(c, ipam.Address.IPV6)
+			for _, pair := range ep.SecondaryAddressing {
+				rollback.release(pair.IPV6)
+				rollback.release(pair.IPV4)
+			}
 		}
 	}()
 
+	// Packets of the endpoint are not marked with a configurable fwmark.
+	// The datapath owns the mark of a packet for its magic markers and the
+	// encryption key, and the tc ingress hook of the host side interface
+	// is taken by the BPF program of the endpoint. An ip rule can only
+	// match a mark, not set it.
+
 	if err = connector.SufficientAddressing(ipam.HostAddressing); err != nil {
 		return
 	}
@@ -558,9 +1424,20 @@ func cmdAdd(args *skel.CmdArgs) (err error) {
 		Endpoint: ep,
 		Client:   c,
 		HostAddr: ipam.HostAddressing,
+		IfIndex:  ifIndex,
+		IfMAC:    ifMAC,
+
+		RequestedMAC: mac,
 	}
 
-	res := &cniTypesVer.Result{}
+	if n.StaticNeigh {
+		if state.GatewayMAC, err = gatewayMAC(logger, ep); err != nil {
+			return
+		}
+	}
+
+	res := &ciliumResult{}
+	pathMTU := n.pathMTU(logger)
 
 	if !ipv6IsEnabled(ipam) && !ipv4IsEnabled(ipam) {
 		err = fmt.Errorf("IPAM did not provide IPv4 or IPv6 address")
@@ -570,7 +1447,11 @@ func cmdAdd(args *skel.CmdArgs) (err error) {
 	if ipv6IsEnabled(ipam) {
 		ep.Addressing.IPV6 = ipam.Address.IPV6
 
-		ipConfig, routes, err = prepareIP(ep.Addressing.IPV6, true, &state, int(conf.RouteMTU))
+		var routeMTU int
+		if routeMTU, err = familyRouteMTU(n, true, int(conf.RouteMTU), int(conf.DeviceMTU)); err != nil {
+			return
+		}
+		ipConfig, routes, err = prepareIP(ep.Addressing.IPV6, true, &state, routeMTU, int(conf.DeviceMTU), pathMTU, n.StrictGatewayValidation, n.NoGateway, n.Routes)
 		if err != nil {
 			return
 		}
@@ -581,7 +1462,11 @@ func cmdAdd(args *skel.CmdArgs) (err error) {
 	if ipv4IsEnabled(ipam) {
 		ep.Addressing.IPV4 = ipam.Address.IPV4
 
-		ipConfig, routes, err = prepareIP(ep.Addressing.IPV4, false, &state, int(conf.RouteMTU))
+		var routeMTU int
+		if routeMTU, err = familyRouteMTU(n, false, int(conf.RouteMTU), int(conf.DeviceMTU)); err != nil {
+			return
+		}
+		ipConfig, routes, err = prepareIP(ep.Addressing.IPV4, false, &state, routeMTU, int(conf.DeviceMTU), pathMTU, n.StrictGatewayValidation, n.NoGateway, n.Routes)
 		if err != nil {
 			return
 		}
@@ -589,32 +1474,166 @@ func cmdAdd(args *skel.CmdArgs) (err error) {
 		res.Routes = append(res.Routes, routes...)
 	}
 
-	var macAddrStr string
-	if err = netNs.Do(func(_ ns.NetNS) error {
-		allInterfacesPath := filepath.Join("/proc", "sys", "net", "ipv6", "conf", "all", "disable_ipv6")
-		err = connector.WriteSysConfig(allInterfacesPath, "0\n")
-		if err != nil {
-			logger.WithError(err).Warn("unable to enable ipv6 on all interfaces")
+	for _, pair := range ep.SecondaryAddressing {
+		if ipv6IsEnabled(ipam) && pair.IPV6 != "" {
+			ipConfig, err = secondaryIPConfig(pair.IPV6, true, &state, n.NoGateway)
+			if err != nil {
+				return
+			}
+			res.IPs = append(res.IPs, ipConfig)
 		}
-		macAddrStr, err = configureIface(ipam, args.IfName, &state)
-		return err
-	}); err != nil {
+		if ipv4IsEnabled(ipam) && pair.IPV4 != "" {
+			ipConfig, err = secondaryIPConfig(pair.IPV4, false, &state, n.NoGateway)
+			if err != nil {
+				return
+			}
+			res.IPs = append(res.IPs, ipConfig)
+		}
+	}
+
+	if n.PreferredSource != "" {
+		if err = setPreferredSource(&state, net.ParseIP(n.PreferredSource)); err != nil {
+			return
+		}
+	}
+
+	if err = validateResult(logger, res, cniVer); err != nil {
+		return
+	}
+
+	res.addRouteDetails(state.IP6routes)
+	res.addRouteDetails(state.IP4routes)
+	// A previous result is only passed to plugins which are not the first
+	// in a chain
+	if len(n.NetConf.RawPrevResult) != 0 {
+		res.details().Chain = &chainDetails{Name: n.Name}
+	}
+	res.addLeaseDetails(ipam)
+	res.addPoolDetails(ipam)
+	if mtuSource != mtuSourceAgent {
+		res.details().MTU = &mtuDetails{
+			Device: conf.DeviceMTU,
+			Route:  conf.RouteMTU,
+			Source: mtuSource,
+		}
+	}
+
+	phase = addPhaseConfigure
+	timer.begin(timingIfaceConfigure)
+	var macAddrStr string
+	if macAddrStr, err = configureNetNS(logger, n, netNs, ipam, args.IfName, &state); err != nil {
 		return
 	}
 
 	res.Interfaces = append(res.Interfaces, &cniTypesVer.Interface{
 		Name:    args.IfName,
 		Mac:     macAddrStr,
-		Sandbox: "/proc/" + args.Netns + "/ns/net",
+		Sandbox: sandboxPath(args.Netns),
 	})
 
-	// Specify that endpoint must be regenerated synchronously. See GH-4409.
-	ep.SyncBuildEndpoint = true
-	if err = c.EndpointCreate(ep); err != nil {
-		logger.WithError(err).WithFields(logrus.Fields{
-			logfields.ContainerID: ep.ContainerID}).Warn("Unable to create endpoint")
-		err = fmt.Errorf("Unable to create endpoint: %s", err)
-		return
+	if n.PreAddHook != "" {
+		timer.begin(timingPreAddHook)
+		env := hookEnv(hookPreAdd, args, cniArgs, ep.Addressing)
+		if err = runHook(n.PreAddHook, env, n.preAddHookTimeout); err != nil {
+			err = fmt.Errorf("pre-ADD hook failed: %s", err)
+			return
+		}
+	}
+
+	phase = addPhaseEndpoint
+	timer.begin(timingEndpointCreate)
+	createTimeout := n.createTimeout()
+	if jitter := n.createJitter(&conf); jitter > 0 {
+		logger.WithField("jitter", jitter).Debug("Delaying endpoint creation")
+		time.Sleep(jitter)
+		createTimeout -= jitter
+	}
+
+	if n.AttachToExisting {
+		if err = attachToEndpoint(c, ep); err != nil {
+			err = fmt.Errorf("Unable to attach interface to endpoint: %s", err)
+			return
+		}
+	} else {
+		// Specify that endpoint must be regenerated synchronously. See GH-4409.
+		// The agent may be too backlogged to do so in a timely manner, see
+		// endpointBuildMode.
+		ep.SyncBuildEndpoint = n.syncBuild(&conf)
+		if err = c.EndpointCreateWithTimeout(ep, createTimeout); err != nil {
+			logger.WithError(err).WithFields(logrus.Fields{
+				logfields.ContainerID: ep.ContainerID}).Warn("Unable to create endpoint")
+			// On timeouts and other recoverable errors the request may still
+			// be processed by the agent, make sure the endpoint does not
+			// outlive the failed ADD. The addresses and the veth pair are
+			// released by the deferred cleanups.
+			if clientErr, ok := err.(client.ClientError); ok && clientErr.Recoverable() {
+				id := endpointid.NewID(endpointid.ContainerIdPrefix, ep.ContainerID)
+				if err2 := c.EndpointDelete(id); err2 != nil {
+					logger.WithError(err2).Debug("Unable to delete endpoint after failed creation")
+				}
+			}
+			err = fmt.Errorf("Unable to create endpoint: %s", err)
+			return
+		}
+
+		if !ep.SyncBuildEndpoint {
+			id := endpointid.NewID(endpointid.ContainerIdPrefix, ep.ContainerID)
+			if !waitForEndpointReady(c, id, endpointReadyTimeout) {
+				logger.WithField(logfields.ContainerID, ep.ContainerID).
+					Info("Endpoint is not ready yet, agent is building it asynchronously")
+			}
+		}
+	}
+
+	if hostLink != nil {
+		if err = netlink.LinkSetUp(hostLink); err != nil {
+			err = fmt.Errorf("unable to bring up host side veth %q: %s", hostLink.Attrs().Name, err)
+			if !n.AttachToExisting {
+				id := endpointid.NewID(endpointid.ContainerIdPrefix, ep.ContainerID)
+				if err2 := c.EndpointDelete(id); err2 != nil {
+					logger.WithError(err2).Warn("Unable to delete endpoint after failed ADD")
+				}
+			}
+			return
+		}
+	}
+
+	var endpointID int64
+	if id, err2 := createdEndpointID(c, ep.ContainerID); err2 != nil {
+		logger.WithError(err2).Warn("Unable to retrieve ID of created endpoint")
+	} else {
+		endpointID = id
+		res.details().EndpointID = id
+		logger.WithFields(logrus.Fields{
+			logfields.ContainerID: ep.ContainerID,
+			logfields.EndpointID:  id,
+		}).Info("Endpoint created")
+	}
+
+	if dir := n.resultCacheDir(); dir != "" {
+		var hostIf string
+		if datapathMode == option.DatapathModeVeth {
+			hostIf = ep.InterfaceName
+		}
+		a := newCachedAttachment(args.ContainerID, args.IfName, ep, endpointID, hostIf)
+		if err2 := writeResultCache(dir, a); err2 != nil {
+			logger.WithError(err2).Warn("Unable to write result cache, DEL will discover the attachment")
+		}
+	}
+
+	if n.VerboseResultDir != "" {
+		timer.end()
+		mtu := mtuDetails{Device: conf.DeviceMTU, Route: conf.RouteMTU, Source: mtuSource}
+		v := newVerboseResult(args.ContainerID, args.IfName, res, mtu, timer.durationsByPhase())
+		if err2 := writeVerboseResult(n.VerboseResultDir, v); err2 != nil {
+			logger.WithError(err2).Warn("Unable to write verbose result")
+		}
+	}
+
+	if n.ResultCopyDir != "" {
+		if err2 := writeResultCopy(n.ResultCopyDir, args.ContainerID, args.IfName, res, cniVer); err2 != nil {
+			logger.WithError(err2).Warn("Unable to write copy of result")
+		}
 	}
 
 	logger.WithFields(logrus.Fields{
@@ -622,20 +1641,120 @@ func cmdAdd(args *skel.CmdArgs) (err error) {
 	return cniTypes.PrintResult(res, cniVer)
 }
 
+// removeIpvlanSlave removes the ipvlan slave of a failed ADD from the
+// container namespace, if it exists
+func removeIpvlanSlave(logger *logrus.Entry, netNs ns.NetNS, ifName string) {
+	if err := netns.RemoveIfFromNetNSIfExists(netNs, ifName); err != nil {
+		logger.WithError(err).WithField(logfields.Interface, ifName).Warn("failed to clean up ipvlan slave")
+	}
+}
+
+// setupVethRemoteNs renames the container side of the veth pair, which has
+// been moved into the namespace already, to ifName. On failure, the container
+// side is removed from the namespace under its temporary and its final name
+// as the rename may have succeeded.
+func setupVethRemoteNs(retries int, netNs ns.NetNS, tmpIfName, ifName string) (int, net.HardwareAddr, error) {
+	ifIndex, ifMAC, err := connector.SetupVethRemoteNs(netNs, tmpIfName, ifName)
+	if err != nil {
+		for _, name := range []string{tmpIfName, ifName} {
+			if err2 := removeIfFromNetNS(retries, netNs, name); err2 != nil {
+				log.WithError(err2).WithField(logfields.Interface, name).Warn("Unable to remove container side veth after failed setup")
+			}
+		}
+		return 0, nil, err
+	}
+	return ifIndex, ifMAC, nil
+}
+
 func cmdDel(args *skel.CmdArgs) error {
 	// Note about when to return errors: kubelet will retry the deletion
 	// for a long time. Therefore, only return an error for errors which
 	// are guaranteed to be recoverable.
 	log.WithField("args", args).Debug("Processing CNI DEL request")
 
+	if err := validateIfName(args.IfName); err != nil {
+		return err
+	}
+
+	n, err := loadDelNetConf(args.StdinData)
+	if err != nil {
+		// The configuration is only used to derive the names of leftover
+		// interfaces, continue with the defaults.
+		log.WithError(err).Warning("Unable to parse network configuration, using defaults")
+		n = &netConf{}
+	}
+
+	cniArgs := cniArgsSpec{}
+	_, loadArgs := splitArgsLabels(args.Args, n.ArgsLabelPrefix)
+	argsErr := cniTypes.LoadArgs(loadArgs, &cniArgs)
+
+	defer func() {
+		if err := removeAddLock(n.addLockDir(), args.ContainerID, args.IfName); err != nil {
+			log.WithError(err).Debug("Unable to remove ADD lock file")
+		}
+	}()
+
+	if n.VerboseResultDir != "" {
+		path := verboseResultPath(n.VerboseResultDir, args.ContainerID, args.IfName)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).Debug("Unable to remove verbose result")
+		}
+	}
+	if n.ResultCopyDir != "" {
+		path := resultCopyPath(n.ResultCopyDir, args.ContainerID, args.IfName)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).Debug("Unable to remove copy of result")
+		}
+	}
+
+	var cached *cachedAttachment
+	if dir := n.resultCacheDir(); dir != "" {
+		if cached, err = readResultCache(dir, args.ContainerID, args.IfName); err != nil {
+			log.WithError(err).Warning("Unable to read result cache, discovering attachment")
+		}
+	}
+
 	c, err := client.NewDefaultClientWithTimeout(defaults.ClientConnectTimeout)
 	if err != nil {
+		if n.DelRetryPolicy == delRetryPolicyNever {
+			log.WithError(err).Warning("Unable to connect to Cilium daemon, endpoint will not be deleted")
+			return nil
+		}
 		// this error can be recovered from
-		return fmt.Errorf("unable to connect to Cilium daemon: %s", err)
+		return withExitCode(exitCodeAgentUnreachable, fmt.Errorf("unable to connect to Cilium daemon: %s", err))
+	}
+	defer c.Close()
+
+	replayPendingReleases(c, n.pendingReleaseFile(), n.addLockDir())
+
+	if n.chained() {
+		// The interface and its addresses are owned by the previous
+		// plugin in the chain, only the endpoint is deleted.
+		ids, err := chainedEndpointIDs(n, args.ContainerID)
+		if err != nil {
+			log.WithError(err).Warning("Unable to parse previous result, deleting endpoint by container ID")
+		}
+		if err := deleteChainedEndpoint(c, ids); err != nil {
+			log.WithError(err).Warning("Errors encountered while deleting endpoint")
+			if n.delRecoverable(err) {
+				return err
+			}
+		}
+		runPostDelHook(n, args, cniArgs)
+		return nil
 	}
 
 	id := endpointid.NewID(endpointid.ContainerIdPrefix, args.ContainerID)
-	if err := c.EndpointDelete(id); err != nil {
+	if cached != nil {
+		id = cached.endpointID()
+	}
+	if n.AttachToExisting {
+		// The endpoint belongs to the primary interface of the
+		// container, the addresses of this interface are released
+		// along with it
+		log.WithField(logfields.ContainerID, args.ContainerID).
+			Debug("Interface is attached to an existing endpoint, not deleting endpoint")
+	} else if err := c.EndpointDelete(id); err != nil {
 		// EndpointDelete returns an error in the following scenarios:
 		// DeleteEndpointIDInvalid: Invalid delete parameters, no need to retry
 		// DeleteEndpointIDNotFound: No need to retry
@@ -644,28 +1763,89 @@ func cmdDel(args *skel.CmdArgs) error {
 		//                         need to retry
 		// ClientError: Various reasons, type will be ClientError and
 		//              Recoverable() will return true if error can be
-		//              retried
+		//              retried. The classification can be overridden
+		//              with DelRetryPolicy.
 		log.WithError(err).Warning("Errors encountered while deleting endpoint")
-		if clientError, ok := err.(client.ClientError); ok {
-			if clientError.Recoverable() {
-				return err
+		if n.delRecoverable(err) {
+			return err
+		}
+		// The container ID may be unknown or stale, e.g. on DEL
+		// after a crash of the runtime. Fall back to the cached
+		// addressing of the interface or its addressing in the
+		// namespace.
+		var found bool
+		if cached != nil {
+			found, err = deleteEndpointByAddressing(c, cached.MAC, cached.ips())
+		} else {
+			found, err = deleteEndpointByNetNS(c, n, args)
+		}
+		if err != nil {
+			log.WithError(err).Debug("Unable to delete endpoint by addressing of container namespace")
+		} else if !found {
+			// The endpoint may not exist because the ADD failed
+			// after allocating addresses, release them by the
+			// identity of the container.
+			if argsErr == nil {
+				releaseByIdentity(c, n, args, cniArgs)
 			}
 		}
 	}
 
-	netNs, err := ns.GetNS(args.Netns)
+	hostVeth := hostIfName(n, args, cniArgs)
+	if cached != nil && cached.HostInterface != "" {
+		hostVeth = cached.HostInterface
+	}
+	netNs, err := openNetNS(n.netNSRetries(), args.Netns, ns.GetNS)
 	if err != nil {
-		log.WithError(err).Warningf("Unable to enter namespace %q, will not delete interface", args.Netns)
+		if _, ok := err.(*netNSMissingError); ok {
+			log.WithError(err).Info("Namespace is already gone, will not delete interface")
+		} else {
+			log.WithError(err).Warning("Unable to enter namespace, will not delete interface")
+		}
+		// The peer in the namespace can't be removed, make sure the host
+		// side of the veth pair is not left behind.
+		removeHostVeth(hostVeth)
+		removeCachedAttachment(n, args)
+		runPostDelHook(n, args, cniArgs)
 		// We are not returning an error as this is very unlikely to be recoverable
 		return nil
 	}
 	defer netNs.Close()
 
-	err = netns.RemoveIfFromNetNSIfExists(netNs, args.IfName)
-	if err != nil {
-		log.WithError(err).Warningf("Unable to delete interface %s in namespace %q, will not delete interface", args.IfName, args.Netns)
-		// We are not returning an error as this is very unlikely to be recoverable
+	ifaces := containerInterfaces(n, args.IfName, func(ifName string) bool {
+		return hasCachedAttachment(n, args.ContainerID, ifName)
+	})
+	var removed []string
+	teardownInterfaces(ifaces, func(ifName string) error {
+		remove := removeIfFromNetNS
+		if ifName == args.IfName && n.DownBeforeDelete {
+			remove = downBeforeRemove(remove)
+		}
+		if err := remove(n.netNSRetries(), netNs, ifName); err != nil {
+			return err
+		}
+		removed = append(removed, ifName)
+		if dir := n.resultCacheDir(); dir != "" && ifName != args.IfName {
+			if err := removeResultCache(dir, args.ContainerID, ifName); err != nil {
+				log.WithError(err).Warning("Unable to remove result cache")
+			}
+		}
+		return nil
+	}, ownedIPRelease(c, ipOwner(n, args, cniArgs)))
+
+	if n.VerifyDelete {
+		for _, ifName := range removed {
+			if err = verifyIfRemoved(netNs, ifName); err != nil {
+				log.WithError(err).WithFields(logrus.Fields{
+					logfields.ContainerID: args.ContainerID,
+					"interface":           ifName,
+					"netns":               args.Netns,
+				}).Error("Interface was not removed from container namespace")
+			}
+		}
 	}
 
+	removeCachedAttachment(n, args)
+	runPostDelHook(n, args, cniArgs)
 	return nil
 }
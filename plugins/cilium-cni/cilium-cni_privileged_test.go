@@ -0,0 +1,538 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build linux,privileged_tests
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/common/addressing"
+	"github.com/cilium/cilium/pkg/datapath/linux/route"
+	"github.com/cilium/cilium/pkg/endpoint/connector"
+
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type CNIPrivilegedTestSuite struct{}
+
+var _ = Suite(&CNIPrivilegedTestSuite{})
+
+func (s *CNIPrivilegedTestSuite) TestRemoveIpvlanSlave(c *C) {
+	netNs, err := ns.NewNS()
+	c.Assert(err, IsNil)
+	defer netNs.Close()
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "cni-slave-host"},
+		PeerName:  "cni-slave-peer",
+	}
+	c.Assert(netlink.LinkAdd(veth), IsNil)
+	defer netlink.LinkDel(veth)
+	peer, err := netlink.LinkByName(veth.PeerName)
+	c.Assert(err, IsNil)
+	c.Assert(netlink.LinkSetNsFd(peer, int(netNs.Fd())), IsNil)
+
+	removeIpvlanSlave(log, netNs, veth.PeerName)
+	err = netNs.Do(func(ns.NetNS) error {
+		_, err := netlink.LinkByName(veth.PeerName)
+		return err
+	})
+	c.Assert(err, FitsTypeOf, netlink.LinkNotFoundError{})
+
+	// The interface may not have been created yet
+	removeIpvlanSlave(log, netNs, veth.PeerName)
+}
+
+func (s *CNIPrivilegedTestSuite) TestSetInterfaceAlias(c *C) {
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "cni-alias-host"},
+		PeerName:  "cni-alias-peer",
+	}
+	c.Assert(netlink.LinkAdd(veth), IsNil)
+	defer netlink.LinkDel(veth)
+
+	alias := func() string {
+		l, err := netlink.LinkByName(veth.Name)
+		c.Assert(err, IsNil)
+		return l.Attrs().Alias
+	}
+
+	// Without a pod name the alias is left alone
+	c.Assert(setInterfaceAlias(veth, cniArgsSpec{}), IsNil)
+	c.Assert(alias(), Equals, "")
+
+	args := cniArgsSpec{
+		K8S_POD_NAMESPACE: cniTypes.UnmarshallableString("default"),
+		K8S_POD_NAME:      cniTypes.UnmarshallableString("foo"),
+	}
+	c.Assert(setInterfaceAlias(veth, args), IsNil)
+	c.Assert(alias(), Equals, "default/foo")
+
+	// Aliases exceeding the limit of the kernel are truncated
+	args.K8S_POD_NAME = cniTypes.UnmarshallableString(strings.Repeat("a", 300))
+	c.Assert(setInterfaceAlias(veth, args), IsNil)
+	c.Assert(alias(), Equals, ("default/" + strings.Repeat("a", 300))[:maxIfAliasLen])
+}
+
+func (s *CNIPrivilegedTestSuite) TestSetupLoopback(c *C) {
+	netNs, err := ns.NewNS()
+	c.Assert(err, IsNil)
+	defer netNs.Close()
+
+	err = netNs.Do(func(ns.NetNS) error {
+		// Loopback is down in a new namespace
+		l, err := netlink.LinkByName("lo")
+		c.Assert(err, IsNil)
+		c.Assert(l.Attrs().Flags&net.FlagUp, Equals, net.Flags(0))
+
+		c.Assert(setupLoopback(log), IsNil)
+		l, err = netlink.LinkByName("lo")
+		c.Assert(err, IsNil)
+		c.Assert(l.Attrs().Flags&net.FlagUp, Equals, net.FlagUp)
+
+		// Nothing to do if loopback is up already
+		return setupLoopback(log)
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *CNIPrivilegedTestSuite) TestAddIPConfigToLinkExistingAddr(c *C) {
+	link := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "cni-addr-test"},
+		PeerName:  "cni-addr-peer",
+	}
+	c.Assert(netlink.LinkAdd(link), IsNil)
+	defer netlink.LinkDel(link)
+
+	ip, err := addressing.NewCiliumIPv4("192.0.2.10")
+	c.Assert(err, IsNil)
+
+	// The address already exists on the link, e.g. on a retried ADD
+	c.Assert(netlink.AddrAdd(link, &netlink.Addr{IPNet: ip.EndpointPrefix()}), IsNil)
+	c.Assert(addIPConfigToLink(ip, nil, link, link.Name, ""), IsNil)
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	c.Assert(err, IsNil)
+	c.Assert(addrs, HasLen, 1)
+	c.Assert(addrs[0].IP.String(), Equals, "192.0.2.10")
+}
+
+func (s *CNIPrivilegedTestSuite) TestReconcileIdempotent(c *C) {
+	link := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "cni-rec-test"},
+		PeerName:  "cni-rec-peer",
+	}
+	c.Assert(netlink.LinkAdd(link), IsNil)
+	defer netlink.LinkDel(link)
+
+	ip, err := addressing.NewCiliumIPv4("192.0.2.10")
+	c.Assert(err, IsNil)
+	_, gw, err := net.ParseCIDR("192.0.2.1/32")
+	c.Assert(err, IsNil)
+	state := &CmdState{
+		IP4:       ip,
+		IP4routes: []route.Route{{Prefix: *gw}},
+	}
+	ipam := &models.IPAMResponse{Address: &models.AddressPair{IPV4: "192.0.2.10"}}
+
+	before, err := linkConfig(link.Name)
+	c.Assert(err, IsNil)
+	_, err = configureIface(ipam, link.Name, state, "", false)
+	c.Assert(err, IsNil)
+	after, err := linkConfig(link.Name)
+	c.Assert(err, IsNil)
+	changes := map[string]bool{}
+	for _, change := range configChanges(before, after) {
+		changes[change] = true
+	}
+	c.Assert(changes["addr 192.0.2.10/32"], Equals, true)
+
+	// Reconciling again must not change anything
+	_, err = configureIface(ipam, link.Name, state, "", false)
+	c.Assert(err, IsNil)
+	again, err := linkConfig(link.Name)
+	c.Assert(err, IsNil)
+	c.Assert(configChanges(after, again), HasLen, 0)
+}
+
+func (s *CNIPrivilegedTestSuite) TestConfigureIfaceFlushExisting(c *C) {
+	link := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "cni-flush-test"},
+		PeerName:  "cni-flush-peer",
+	}
+	c.Assert(netlink.LinkAdd(link), IsNil)
+	defer netlink.LinkDel(link)
+
+	// Addresses left behind in a reused sandbox
+	for _, a := range []string{"198.51.100.7/24", "2001:db8::7/64"} {
+		addr, err := netlink.ParseAddr(a)
+		c.Assert(err, IsNil)
+		c.Assert(netlink.AddrAdd(link, addr), IsNil)
+	}
+
+	ip, err := addressing.NewCiliumIPv4("192.0.2.10")
+	c.Assert(err, IsNil)
+	state := &CmdState{IP4: ip}
+	ipam := &models.IPAMResponse{Address: &models.AddressPair{IPV4: "192.0.2.10"}}
+	_, err = configureIface(ipam, link.Name, state, "", true)
+	c.Assert(err, IsNil)
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	c.Assert(err, IsNil)
+	var global []string
+	for _, a := range addrs {
+		if a.IP.To4() == nil && a.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		global = append(global, a.IPNet.String())
+	}
+	c.Assert(global, DeepEquals, []string{"192.0.2.10/32"})
+}
+
+func (s *CNIPrivilegedTestSuite) TestConfigureIfaceMACCollision(c *C) {
+	mac, err := net.ParseMAC("02:00:00:00:ca:fe")
+	c.Assert(err, IsNil)
+	link := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "cni-mac-test", HardwareAddr: mac},
+		PeerName:  "cni-mac-peer",
+	}
+	c.Assert(netlink.LinkAdd(link), IsNil)
+	defer netlink.LinkDel(link)
+
+	ip, err := addressing.NewCiliumIPv4("192.0.2.10")
+	c.Assert(err, IsNil)
+	ipam := &models.IPAMResponse{Address: &models.AddressPair{IPV4: "192.0.2.10"}}
+
+	// The requested MAC is only used by the interface itself
+	state := &CmdState{IP4: ip, RequestedMAC: mac}
+	_, err = configureIface(ipam, link.Name, state, "", false)
+	c.Assert(err, IsNil)
+
+	// Another interface of the namespace uses the requested MAC
+	peer, err := netlink.LinkByName(link.PeerName)
+	c.Assert(err, IsNil)
+	c.Assert(netlink.LinkSetHardwareAddr(peer, mac), IsNil)
+	_, err = configureIface(ipam, link.Name, state, "", false)
+	c.Assert(err, ErrorMatches, `requested MAC 02:00:00:00:ca:fe of "cni-mac-test" is already used by interface "cni-mac-peer"`)
+}
+
+func (s *CNIPrivilegedTestSuite) TestAddGatewayNeigh(c *C) {
+	link := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "cni-neigh-test"},
+		PeerName:  "cni-neigh-peer",
+	}
+	c.Assert(netlink.LinkAdd(link), IsNil)
+	defer netlink.LinkDel(link)
+
+	mac, err := net.ParseMAC("0a:0b:0c:0d:0e:0f")
+	c.Assert(err, IsNil)
+
+	// Programming the entry twice must succeed, e.g. on a retried ADD
+	c.Assert(addGatewayNeigh(link, "192.0.2.1", mac), IsNil)
+	c.Assert(addGatewayNeigh(link, "192.0.2.1", mac), IsNil)
+
+	neighs, err := netlink.NeighList(link.Attrs().Index, netlink.FAMILY_V4)
+	c.Assert(err, IsNil)
+	c.Assert(neighs, HasLen, 1)
+	c.Assert(neighs[0].IP.String(), Equals, "192.0.2.1")
+	c.Assert(neighs[0].HardwareAddr.String(), Equals, "0a:0b:0c:0d:0e:0f")
+	c.Assert(neighs[0].State, Equals, netlink.NUD_PERMANENT)
+}
+
+func (s *CNIPrivilegedTestSuite) TestAddIPConfigToLinkPriority(c *C) {
+	link := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "cni-prio-test"},
+		PeerName:  "cni-prio-peer",
+	}
+	c.Assert(netlink.LinkAdd(link), IsNil)
+	defer netlink.LinkDel(link)
+
+	ip, err := addressing.NewCiliumIPv4("192.0.2.10")
+	c.Assert(err, IsNil)
+
+	_, dst, err := net.ParseCIDR("198.51.100.0/24")
+	c.Assert(err, IsNil)
+	routes := []route.Route{
+		{Prefix: *dst, Priority: 200},
+		{Prefix: *dst, Priority: 100},
+	}
+	c.Assert(addIPConfigToLink(ip, routes, link, link.Name, ""), IsNil)
+
+	installed, err := netlink.RouteListFiltered(netlink.FAMILY_V4,
+		&netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst},
+		netlink.RT_FILTER_OIF|netlink.RT_FILTER_DST)
+	c.Assert(err, IsNil)
+	c.Assert(installed, HasLen, 2)
+	priorities := map[int]bool{}
+	for _, r := range installed {
+		priorities[r.Priority] = true
+	}
+	c.Assert(priorities, DeepEquals, map[int]bool{100: true, 200: true})
+}
+
+// reusedNetNS is a network namespace handle which survives Close so that it
+// can be reused across ADDs
+type reusedNetNS struct {
+	ns.NetNS
+}
+
+func (r reusedNetNS) Close() error {
+	return nil
+}
+
+// BenchmarkDatapathSetup measures the datapath setup phase of ADD, i.e.
+// moving the container side veth into the namespace and configuring it. The
+// namespace is opened once, as opening and closing it on every ADD would
+// dominate the measurement. Run with -check.b.
+func (s *CNIPrivilegedTestSuite) BenchmarkDatapathSetup(c *C) {
+	netNs, err := ns.NewNS()
+	c.Assert(err, IsNil)
+	defer netNs.Close()
+
+	oldGetNetNS := getNetNS
+	getNetNS = func(string) (ns.NetNS, error) {
+		return reusedNetNS{netNs}, nil
+	}
+	defer func() { getNetNS = oldGetNetNS }()
+
+	ip, err := addressing.NewCiliumIPv4("192.0.2.10")
+	c.Assert(err, IsNil)
+	_, gw, err := net.ParseCIDR("192.0.2.1/32")
+	c.Assert(err, IsNil)
+	ipam := &models.IPAMResponse{Address: &models.AddressPair{IPV4: "192.0.2.10"}}
+	n := &netConf{SkipIPv6Enable: true}
+
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		containerNs, err := getNetNS(netNs.Path())
+		c.Assert(err, IsNil)
+
+		veth := &netlink.Veth{
+			LinkAttrs: netlink.LinkAttrs{Name: "cni-bench-host"},
+			PeerName:  "cni-bench-tmp",
+		}
+		c.Assert(netlink.LinkAdd(veth), IsNil)
+		peer, err := netlink.LinkByName(veth.PeerName)
+		c.Assert(err, IsNil)
+		c.Assert(netlink.LinkSetNsFd(peer, int(containerNs.Fd())), IsNil)
+
+		ifIndex, ifMAC, err := connector.SetupVethRemoteNs(containerNs, veth.PeerName, "eth0")
+		c.Assert(err, IsNil)
+		state := &CmdState{
+			IP4:       ip,
+			IP4routes: []route.Route{{Prefix: *gw}},
+			IfIndex:   ifIndex,
+			IfMAC:     ifMAC,
+		}
+		_, err = configureNetNS(log, n, containerNs, ipam, "eth0", state)
+		c.Assert(err, IsNil)
+
+		c.Assert(netlink.LinkDel(veth), IsNil)
+		c.Assert(containerNs.Close(), IsNil)
+	}
+}
+
+func (s *CNIPrivilegedTestSuite) TestSetupVethRemoteNsFailure(c *C) {
+	netNs, err := ns.NewNS()
+	c.Assert(err, IsNil)
+	defer netNs.Close()
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "cni-setup-host"},
+		PeerName:  "cni-setup-tmp",
+	}
+	c.Assert(netlink.LinkAdd(veth), IsNil)
+	defer netlink.LinkDel(veth)
+	peer, err := netlink.LinkByName(veth.PeerName)
+	c.Assert(err, IsNil)
+	c.Assert(netlink.LinkSetNsFd(peer, int(netNs.Fd())), IsNil)
+
+	// The move succeeds but the rename fails as the name is too long
+	_, _, err = setupVethRemoteNs(0, netNs, veth.PeerName, "cni-name-too-long-for-kernel")
+	c.Assert(err, Not(IsNil))
+
+	err = netNs.Do(func(ns.NetNS) error {
+		links, err := netlink.LinkList()
+		c.Assert(err, IsNil)
+		for _, l := range links {
+			c.Assert(l.Type(), Not(Equals), "veth")
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+
+	// Removing the container side removes the host side as well
+	_, err = netlink.LinkByName(veth.Name)
+	c.Assert(err, FitsTypeOf, netlink.LinkNotFoundError{})
+}
+
+func (s *CNIPrivilegedTestSuite) TestSetProxyNeigh(c *C) {
+	link := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "cni-proxy-test"},
+		PeerName:  "cni-proxy-peer",
+	}
+	c.Assert(netlink.LinkAdd(link), IsNil)
+	defer netlink.LinkDel(link)
+
+	read := func(family, name string) string {
+		data, err := ioutil.ReadFile(filepath.Join("/proc/sys/net", family, "conf", link.Name, name))
+		c.Assert(err, IsNil)
+		return strings.TrimSpace(string(data))
+	}
+
+	c.Assert(setProxyNeigh(log, link.Name, true, false), IsNil)
+	c.Assert(read("ipv4", "proxy_arp"), Equals, "1")
+	c.Assert(read("ipv6", "proxy_ndp"), Equals, "0")
+
+	c.Assert(setProxyNeigh(log, link.Name, false, true), IsNil)
+	c.Assert(read("ipv6", "proxy_ndp"), Equals, "1")
+
+	c.Assert(setProxyNeigh(log, "cni-proxy-none", true, false), ErrorMatches, `unable to enable proxy_arp of "cni-proxy-none": .*`)
+}
+
+func (s *CNIPrivilegedTestSuite) TestSetHostMaster(c *C) {
+	bridge := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "cni-master-br"}}
+	c.Assert(netlink.LinkAdd(bridge), IsNil)
+	defer netlink.LinkDel(bridge)
+
+	link := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "cni-master-test"},
+		PeerName:  "cni-master-peer",
+	}
+	c.Assert(netlink.LinkAdd(link), IsNil)
+	defer netlink.LinkDel(link)
+
+	c.Assert(setHostMaster(log, link, bridge.Name), IsNil)
+	l, err := netlink.LinkByName(link.Name)
+	c.Assert(err, IsNil)
+	br, err := netlink.LinkByName(bridge.Name)
+	c.Assert(err, IsNil)
+	c.Assert(l.Attrs().MasterIndex, Equals, br.Attrs().Index)
+
+	c.Assert(setHostMaster(log, link, "cni-master-none"), ErrorMatches, `unable to find hostMaster bridge "cni-master-none": .*`)
+	c.Assert(setHostMaster(log, link, link.PeerName), ErrorMatches, `hostMaster "cni-master-peer" is a veth device, not a bridge`)
+}
+
+func (s *CNIPrivilegedTestSuite) TestSetTxQueueLen(c *C) {
+	link := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "cni-qlen-test"},
+		PeerName:  "cni-qlen-peer",
+	}
+	c.Assert(netlink.LinkAdd(link), IsNil)
+	defer netlink.LinkDel(link)
+
+	peer, err := netlink.LinkByName(link.PeerName)
+	c.Assert(err, IsNil)
+	c.Assert(setTxQueueLen(peer, 5000), IsNil)
+
+	peer, err = netlink.LinkByName(link.PeerName)
+	c.Assert(err, IsNil)
+	c.Assert(peer.Attrs().TxQLen, Equals, 5000)
+}
+
+func (s *CNIPrivilegedTestSuite) TestDownBeforeRemove(c *C) {
+	netNs, err := ns.NewNS()
+	c.Assert(err, IsNil)
+	defer netNs.Close()
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "cni-down-host"},
+		PeerName:  "cni-down-tmp",
+	}
+	c.Assert(netlink.LinkAdd(veth), IsNil)
+	defer netlink.LinkDel(veth)
+	peer, err := netlink.LinkByName(veth.PeerName)
+	c.Assert(err, IsNil)
+	c.Assert(netlink.LinkSetNsFd(peer, int(netNs.Fd())), IsNil)
+
+	err = netNs.Do(func(ns.NetNS) error {
+		l, err := netlink.LinkByName(veth.PeerName)
+		c.Assert(err, IsNil)
+		ip, err := netlink.ParseAddr("192.0.2.20/32")
+		c.Assert(err, IsNil)
+		c.Assert(netlink.AddrAdd(l, ip), IsNil)
+		return netlink.LinkSetUp(l)
+	})
+	c.Assert(err, IsNil)
+
+	// The interface must be down and without addresses by the time it
+	// is removed
+	var removed bool
+	remove := downBeforeRemove(func(retries int, netNs ns.NetNS, ifName string) error {
+		err := netNs.Do(func(ns.NetNS) error {
+			l, err := netlink.LinkByName(ifName)
+			c.Assert(err, IsNil)
+			c.Assert(l.Attrs().Flags&net.FlagUp, Equals, net.Flags(0))
+			addrs, err := netlink.AddrList(l, netlink.FAMILY_V4)
+			c.Assert(err, IsNil)
+			c.Assert(addrs, HasLen, 0)
+			return nil
+		})
+		c.Assert(err, IsNil)
+		removed = true
+		return removeIfFromNetNS(retries, netNs, ifName)
+	})
+	c.Assert(remove(0, netNs, veth.PeerName), IsNil)
+	c.Assert(removed, Equals, true)
+	c.Assert(verifyIfRemoved(netNs, veth.PeerName), IsNil)
+
+	// Failing to bring down a missing interface is not fatal
+	c.Assert(downBeforeRemove(removeIfFromNetNS)(0, netNs, veth.PeerName), IsNil)
+}
+
+func (s *CNIPrivilegedTestSuite) TestIfaceAddressing(c *C) {
+	netNs, err := ns.NewNS()
+	c.Assert(err, IsNil)
+	defer netNs.Close()
+
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "cni-addr-host"},
+		PeerName:  "cni-addr-tmp",
+	}
+	c.Assert(netlink.LinkAdd(veth), IsNil)
+	defer netlink.LinkDel(veth)
+	peer, err := netlink.LinkByName(veth.PeerName)
+	c.Assert(err, IsNil)
+	c.Assert(netlink.LinkSetNsFd(peer, int(netNs.Fd())), IsNil)
+
+	err = netNs.Do(func(ns.NetNS) error {
+		l, err := netlink.LinkByName(veth.PeerName)
+		c.Assert(err, IsNil)
+		ip, err := netlink.ParseAddr("192.0.2.10/32")
+		c.Assert(err, IsNil)
+		return netlink.AddrAdd(l, ip)
+	})
+	c.Assert(err, IsNil)
+
+	mac, ips, err := ifaceAddressing(0, netNs, veth.PeerName)
+	c.Assert(err, IsNil)
+	c.Assert(mac, Equals, peer.Attrs().HardwareAddr.String())
+	c.Assert(ips, HasLen, 1)
+	c.Assert(ips[0].String(), Equals, "192.0.2.10")
+}
@@ -0,0 +1,2735 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !privileged_tests
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/common/addressing"
+	"github.com/cilium/cilium/pkg/api"
+	"github.com/cilium/cilium/pkg/client"
+	"github.com/cilium/cilium/pkg/datapath/linux/route"
+	"github.com/cilium/cilium/pkg/defaults"
+	"github.com/cilium/cilium/pkg/endpoint/connector"
+	"github.com/cilium/cilium/pkg/labels"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/option"
+	"github.com/cilium/cilium/pkg/version"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	cniTypes020 "github.com/containernetworking/cni/pkg/types/020"
+	cniTypesVer "github.com/containernetworking/cni/pkg/types/current"
+	cniVersion "github.com/containernetworking/cni/pkg/version"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/go-openapi/strfmt"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type CNISuite struct{}
+
+var _ = Suite(&CNISuite{})
+
+func (s *CNISuite) TestPrintVersion(c *C) {
+	oldSock := os.Getenv(defaults.SockPathEnv)
+	defer os.Setenv(defaults.SockPathEnv, oldSock)
+	os.Setenv(defaults.SockPathEnv, filepath.Join(c.MkDir(), "cilium.sock"))
+
+	var buf bytes.Buffer
+	c.Assert(printVersion(&buf), IsNil)
+
+	var info versionInfo
+	c.Assert(json.Unmarshal(buf.Bytes(), &info), IsNil)
+	c.Assert(info.PluginVersion, Equals, version.Version)
+	c.Assert(info.SupportedVersions, DeepEquals, pluginVersions.SupportedVersions())
+
+	// The agent is not reachable
+	c.Assert(info.AgentVersion, Equals, "")
+	c.Assert(info.AgentError, Not(Equals), "")
+}
+
+func (s *CNISuite) TestRouteDetails(c *C) {
+	_, prefix, err := net.ParseCIDR("10.0.0.0/24")
+	c.Assert(err, IsNil)
+
+	res := &ciliumResult{}
+	res.addRouteDetails([]route.Route{
+		{Prefix: *prefix},
+		{Prefix: *prefix, Table: unix.RT_TABLE_MAIN},
+	})
+	c.Assert(res.Cilium, IsNil)
+
+	res.addRouteDetails([]route.Route{{Prefix: *prefix, Table: 100}})
+	c.Assert(res.Cilium.Routes, DeepEquals, []*routeDetails{
+		{Dst: cniTypes.IPNet(*prefix), Table: 100},
+	})
+
+	// The details are retained by versions represented by the current
+	// result type
+	res.CNIVersion = "0.3.1"
+	r, err := res.GetAsVersion("0.3.1")
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(r.(*ciliumResult).PrintTo(&buf), IsNil)
+	var raw map[string]interface{}
+	c.Assert(json.Unmarshal(buf.Bytes(), &raw), IsNil)
+	c.Assert(raw["cilium"], DeepEquals, map[string]interface{}{
+		"routes": []interface{}{
+			map[string]interface{}{"dst": "10.0.0.0/24", "table": float64(100)},
+		},
+	})
+}
+
+func (s *CNISuite) TestEnableIPv6WarnOnce(c *C) {
+	oldWriteSysConfig := writeSysConfig
+	defer func() { writeSysConfig = oldWriteSysConfig }()
+	writeSysConfig = func(string, string) error {
+		return errors.New("read-only file system")
+	}
+	enableIPv6WarnOnce = sync.Once{}
+
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.Out = &buf
+	for i := 0; i < 3; i++ {
+		enableIPv6(logrus.NewEntry(log))
+	}
+	c.Assert(strings.Count(buf.String(), "unable to enable ipv6"), Equals, 1)
+
+	n, _, err := loadNetConf([]byte(`{"name": "cilium", "skipIPv6Enable": true}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.SkipIPv6Enable, Equals, true)
+}
+
+func (s *CNISuite) TestRequestProperties(c *C) {
+	args := &skel.CmdArgs{
+		ContainerID: "c1",
+		Netns:       "/var/run/netns/c1",
+		IfName:      "eth0",
+		Args:        "K8S_POD_NAME=foo;TOKEN=secret",
+	}
+	c.Assert(requestProperties(args, cniArgsSpec{}), DeepEquals, map[string]string{
+		"cni-container-id": "c1",
+		"cni-netns":        "/var/run/netns/c1",
+	})
+
+	cniArgs := cniArgsSpec{K8S_POD_NAMESPACE: "default", K8S_POD_NAME: "foo"}
+	props := requestProperties(args, cniArgs)
+	c.Assert(props["cni-pod-namespace"], Equals, "default")
+	c.Assert(props["cni-pod-name"], Equals, "foo")
+
+	// CNI_ARGS is never recorded as a whole
+	for _, v := range props {
+		c.Assert(strings.Contains(v, "secret"), Equals, false)
+	}
+}
+
+func (s *CNISuite) TestVerifyLink(c *C) {
+	mac, err := net.ParseMAC("0a:00:00:00:00:01")
+	c.Assert(err, IsNil)
+	otherMAC, err := net.ParseMAC("0a:00:00:00:00:02")
+	c.Assert(err, IsNil)
+	l := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 5, HardwareAddr: mac}}
+
+	// Nothing to verify if the interface wasn't recorded
+	c.Assert(verifyLink(l, &CmdState{}), IsNil)
+	c.Assert(verifyLink(l, &CmdState{IfIndex: 5}), IsNil)
+	c.Assert(verifyLink(l, &CmdState{IfIndex: 5, IfMAC: mac}), IsNil)
+
+	c.Assert(verifyLink(l, &CmdState{IfIndex: 6, IfMAC: mac}), ErrorMatches,
+		`interface "eth0" has index 5, expected 6`)
+	c.Assert(verifyLink(l, &CmdState{IfIndex: 5, IfMAC: otherMAC}), ErrorMatches,
+		`interface "eth0" has MAC 0a:00:00:00:00:01, expected 0a:00:00:00:00:02`)
+}
+
+func (s *CNISuite) TestAllocateSecondaryIPs(c *C) {
+	fake := &fakeIPAMClient{}
+	primary := &models.AddressPair{IPV4: "10.0.0.1"}
+	secondary, err := allocateSecondaryIPs(fake, &IPAM{}, "default/foo", "abcd", primary)
+	c.Assert(err, IsNil)
+	c.Assert(secondary, HasLen, 0)
+
+	// Only the families of the primary addresses are allocated
+	secondary, err = allocateSecondaryIPs(fake, &IPAM{AddressesPerFamily: 3}, "default/foo", "abcd", primary)
+	c.Assert(err, IsNil)
+	c.Assert(secondary, DeepEquals, []*models.AddressPair{{IPV4: "10.0.0.1"}, {IPV4: "10.0.0.1"}})
+
+	// All secondary addresses are released if an allocation fails
+	fake = &fakeIPAMClient{errs: map[string]error{client.AddressFamilyIPv4: errors.New("connection refused")}}
+	primary = &models.AddressPair{IPV4: "10.0.0.1", IPV6: "f00d::1"}
+	_, err = allocateSecondaryIPs(fake, &IPAM{AddressesPerFamily: 2}, "default/foo", "abcd", primary)
+	c.Assert(err, ErrorMatches, "unable to allocate secondary IPv4 address: connection refused")
+	c.Assert(fake.released, DeepEquals, []string{"f00d::1"})
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "ipam": {"addressesPerFamily": -1}}`))
+	c.Assert(err, ErrorMatches, "invalid addressesPerFamily -1")
+}
+
+func (s *CNISuite) TestChainDetails(c *C) {
+	res := &ciliumResult{}
+	res.details().Chain = &chainDetails{Name: "cbr0"}
+
+	var buf bytes.Buffer
+	c.Assert(res.PrintTo(&buf), IsNil)
+	var raw map[string]interface{}
+	c.Assert(json.Unmarshal(buf.Bytes(), &raw), IsNil)
+	c.Assert(raw["cilium"], DeepEquals, map[string]interface{}{
+		"chain": map[string]interface{}{"name": "cbr0"},
+	})
+}
+
+func (s *CNISuite) TestExtraRoutes(c *C) {
+	routes := []Route{
+		{Dst: "192.168.0.0/24"},
+		{Dst: "192.168.1.0/24", GW: "10.1.0.2", Table: 100},
+		{Dst: "fd00::/64"},
+	}
+
+	rts, err := extraRoutes(routes, false, net.ParseIP("10.1.0.1"), 1450, 1450)
+	c.Assert(err, IsNil)
+	c.Assert(rts, HasLen, 2)
+	c.Assert(rts[0].Prefix.String(), Equals, "192.168.0.0/24")
+	c.Assert(rts[0].Nexthop.String(), Equals, "10.1.0.1")
+	c.Assert(rts[0].MTU, Equals, 1450)
+	c.Assert(rts[1].Prefix.String(), Equals, "192.168.1.0/24")
+	c.Assert(rts[1].Nexthop.String(), Equals, "10.1.0.2")
+	c.Assert(rts[1].Table, Equals, 100)
+
+	rts, err = extraRoutes(routes, true, net.ParseIP("fe80::1"), 1450, 1450)
+	c.Assert(err, IsNil)
+	c.Assert(rts, HasLen, 1)
+	c.Assert(rts[0].Prefix.String(), Equals, "fd00::/64")
+	c.Assert(rts[0].Nexthop.String(), Equals, "fe80::1")
+
+	gw := net.ParseIP("10.1.0.1")
+	_, err = parseRoute(Route{Dst: "192.168.0.0"}, gw, 0, 0)
+	c.Assert(err, ErrorMatches, `invalid destination "192.168.0.0": .*`)
+	_, err = parseRoute(Route{Dst: "192.168.0.0/24", GW: "foo"}, gw, 0, 0)
+	c.Assert(err, ErrorMatches, `invalid gateway "foo" of route to 192.168.0.0/24`)
+	_, err = parseRoute(Route{Dst: "192.168.0.0/24", GW: "fd00::1"}, gw, 0, 0)
+	c.Assert(err, ErrorMatches, "gateway fd00::1 and destination 192.168.0.0/24 of route differ in address family")
+	_, err = parseRoute(Route{Dst: "192.168.0.0/24", Table: -1}, gw, 0, 0)
+	c.Assert(err, ErrorMatches, "invalid table -1 of route to 192.168.0.0/24")
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "routes": [{"dst": "192.168.0.0/24", "gw": "foo"}]}`))
+	c.Assert(err, ErrorMatches, `invalid route: invalid gateway "foo" of route to 192.168.0.0/24`)
+}
+
+// fakeIPAMClient returns the configured error for all allocations of the
+// given address family and records released IPs, released owners with the
+// container ID and requested subnets. Allocated addresses expire at the
+// configured expiration of the address family. Releases of an IP fail as many
+// times as configured in releaseFailures.
+type fakeIPAMClient struct {
+	errs            map[string]error
+	releaseFailures map[string]int
+	allocated       []string
+	released        []string
+	releasedOwners  []string
+	subnets         map[string]string
+	expirations     map[string]strfmt.DateTime
+}
+
+func (f *fakeIPAMClient) IPAMAllocateContainer(family, owner, containerID, subnet string) (*models.IPAMResponse, error) {
+	if err := f.errs[family]; err != nil {
+		return nil, err
+	}
+	if subnet != "" {
+		if f.subnets == nil {
+			f.subnets = map[string]string{}
+		}
+		f.subnets[family] = subnet
+	}
+	resp := &models.IPAMResponse{Address: &models.AddressPair{}, Expiration: f.expirations[family]}
+	if family != client.AddressFamilyIPv4 {
+		resp.Address.IPV6 = "f00d::1"
+		resp.IPV6Pool = "default"
+	}
+	if family != client.AddressFamilyIPv6 {
+		resp.Address.IPV4 = "10.0.0.1"
+		resp.IPV4Pool = "default"
+	}
+	return resp, nil
+}
+
+func (f *fakeIPAMClient) IPAMAllocateIP(ip, owner, containerID string) error {
+	if err := f.errs[ip]; err != nil {
+		return err
+	}
+	f.allocated = append(f.allocated, ip)
+	return nil
+}
+
+func (f *fakeIPAMClient) IPAMReleaseIP(ip string) error {
+	if f.releaseFailures[ip] > 0 {
+		f.releaseFailures[ip]--
+		return errors.New("agent unavailable")
+	}
+	f.released = append(f.released, ip)
+	return nil
+}
+
+func (f *fakeIPAMClient) IPAMReleaseOwner(owner, containerID string) error {
+	f.releasedOwners = append(f.releasedOwners, owner+":"+containerID)
+	return nil
+}
+
+func (s *CNISuite) TestRollbackRelease(c *C) {
+	oldInterval := rollbackReleaseInterval
+	defer func() { rollbackReleaseInterval = oldInterval }()
+	rollbackReleaseInterval = time.Millisecond
+
+	dir, err := ioutil.TempDir("", "cilium-cni-release")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "run", "pending-release")
+
+	f := &fakeIPAMClient{releaseFailures: map[string]int{
+		"10.0.0.1": rollbackReleaseRetries,
+		"f00d::1":  rollbackReleaseRetries + 1,
+	}}
+	r := &ipRollback{client: f, file: file, owner: "default/foo", containerID: "abcd", ifName: "eth0"}
+
+	// A transient failure is retried
+	r.release("10.0.0.1")
+	c.Assert(f.released, DeepEquals, []string{"10.0.0.1"})
+	_, err = os.Stat(file)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// A persistent failure is recorded as pending release
+	r.release("f00d::1")
+	r.release("")
+	c.Assert(f.released, DeepEquals, []string{"10.0.0.1"})
+	data, err := ioutil.ReadFile(file)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Count(string(data), "\n"), Equals, 1)
+	rec := pendingRelease{}
+	c.Assert(json.Unmarshal(data, &rec), IsNil)
+	c.Assert(rec.IP, Equals, "f00d::1")
+	c.Assert(rec.Owner, Equals, "default/foo")
+	c.Assert(rec.ContainerID, Equals, "abcd")
+	c.Assert(rec.IfName, Equals, "eth0")
+}
+
+// fakePendingReleaseClient serves the IPAM allocations and the endpoints
+// used to verify pending releases
+type fakePendingReleaseClient struct {
+	fakeIPAMClient
+	allocations models.AllocationMap
+	allocErr    error
+	endpoints   []*models.Endpoint
+}
+
+func (f *fakePendingReleaseClient) IPAMAllocations() (models.AllocationMap, error) {
+	return f.allocations, f.allocErr
+}
+
+func (f *fakePendingReleaseClient) EndpointList() ([]*models.Endpoint, error) {
+	return f.endpoints, nil
+}
+
+// pendingIPs returns the IPs recorded in the pending release file
+func pendingIPs(c *C, file string) []string {
+	recs, err := takePendingReleases(file)
+	c.Assert(err, IsNil)
+	var ips []string
+	for _, rec := range recs {
+		c.Assert(appendPendingRelease(file, rec), IsNil)
+		ips = append(ips, rec.IP)
+	}
+	return ips
+}
+
+func (s *CNISuite) TestReplayPendingReleases(c *C) {
+	dir, err := ioutil.TempDir("", "cilium-cni-release")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "pending-release")
+	lockDir := filepath.Join(dir, "locks")
+
+	// Nothing to replay
+	replayPendingReleases(&fakePendingReleaseClient{}, file, lockDir)
+
+	now := time.Now()
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6", "10.0.0.7"} {
+		rec := pendingRelease{IP: ip, Owner: "default/foo", ContainerID: fmt.Sprintf("c%d", i), IfName: "eth0", Time: now}
+		if ip == "10.0.0.6" {
+			rec.Time = now.Add(-pendingReleaseMaxAge - time.Minute)
+		}
+		c.Assert(appendPendingRelease(file, rec), IsNil)
+	}
+
+	f := &fakePendingReleaseClient{
+		fakeIPAMClient: fakeIPAMClient{releaseFailures: map[string]int{"10.0.0.5": 1}},
+		allocations: models.AllocationMap{
+			"10.0.0.1": "default/foo",
+			// Allocated again to another owner
+			"10.0.0.2": "default/bar",
+			"10.0.0.4": "default/foo",
+			"10.0.0.5": "default/foo",
+			"10.0.0.6": "default/foo",
+			"10.0.0.7": "default/foo",
+		},
+		endpoints: []*models.Endpoint{{
+			Status: &models.EndpointStatus{
+				Networking: &models.EndpointNetworking{
+					Addressing: []*models.AddressPair{{IPV4: "10.0.0.4"}},
+				},
+			},
+		}},
+	}
+
+	// The attachment of a pending release is locked by a concurrent ADD
+	lock, err := acquireAddLock(lockDir, "c6", "eth0", 0)
+	c.Assert(err, IsNil)
+	replayPendingReleases(f, file, lockDir)
+	lock.Close()
+
+	c.Assert(f.released, DeepEquals, []string{"10.0.0.1"})
+	// Failed releases and releases of locked attachments are retried
+	c.Assert(pendingIPs(c, file), DeepEquals, []string{"10.0.0.5", "10.0.0.7"})
+
+	// Without the allocations no release is attempted
+	f.allocErr = errors.New("agent unavailable")
+	replayPendingReleases(f, file, lockDir)
+	c.Assert(f.released, DeepEquals, []string{"10.0.0.1"})
+	c.Assert(pendingIPs(c, file), DeepEquals, []string{"10.0.0.5", "10.0.0.7"})
+
+	f.allocErr = nil
+	replayPendingReleases(f, file, lockDir)
+	c.Assert(f.released, DeepEquals, []string{"10.0.0.1", "10.0.0.5", "10.0.0.7"})
+	c.Assert(pendingIPs(c, file), HasLen, 0)
+}
+
+func (s *CNISuite) TestAllocateIPsExhausted(c *C) {
+	fake := &fakeIPAMClient{errs: map[string]error{"": client.IPAMExhaustedError{}}}
+	_, err := allocateIPs(fake, &IPAM{}, "default/foo", "")
+	c.Assert(err, FitsTypeOf, &cniTypes.Error{})
+	c.Assert(err.(*cniTypes.Error).Code, Equals, uint(errCodeIPAMExhausted))
+
+	fake = &fakeIPAMClient{errs: map[string]error{client.AddressFamilyIPv4: client.IPAMExhaustedError{}}}
+	_, err = allocateIPs(fake, &IPAM{SubnetHint: "10.0.0.0/24"}, "default/foo", "")
+	c.Assert(err, FitsTypeOf, &cniTypes.Error{})
+	c.Assert(err.(*cniTypes.Error).Code, Equals, uint(errCodeIPAMExhausted))
+	c.Assert(fake.released, DeepEquals, []string{"f00d::1"})
+}
+
+func (s *CNISuite) TestAllocateIPsFailure(c *C) {
+	fake := &fakeIPAMClient{errs: map[string]error{"": errors.New("connection refused")}}
+	_, err := allocateIPs(fake, &IPAM{}, "default/foo", "")
+	c.Assert(err, Not(FitsTypeOf), &cniTypes.Error{})
+	c.Assert(err, ErrorMatches, "connection refused")
+}
+
+func (s *CNISuite) TestAllocateIPsSubnetHint(c *C) {
+	fake := &fakeIPAMClient{}
+	ipam, err := allocateIPs(fake, &IPAM{SubnetHint: "10.0.0.0/24"}, "default/foo", "")
+	c.Assert(err, IsNil)
+	c.Assert(ipam.Address.IPV4, Equals, "10.0.0.1")
+	c.Assert(ipam.Address.IPV6, Equals, "f00d::1")
+	c.Assert(fake.subnets, DeepEquals, map[string]string{client.AddressFamilyIPv4: "10.0.0.0/24"})
+
+	noSpace := client.Hint(errors.New("no free IP in subnet 10.0.1.0/24"))
+	fake = &fakeIPAMClient{errs: map[string]error{client.AddressFamilyIPv4: noSpace}}
+	_, err = allocateIPs(fake, &IPAM{SubnetHint: "10.0.1.0/24"}, "default/foo", "")
+	c.Assert(err, ErrorMatches, "unable to allocate IPv4 address: no free IP in subnet 10.0.1.0/24")
+	c.Assert(fake.released, DeepEquals, []string{"f00d::1"})
+}
+
+func (s *CNISuite) TestLoadNetConfInclude(c *C) {
+	dir, err := ioutil.TempDir("", "cilium-cni-include")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	base := `{"cniVersion": "0.3.1", "mtu": 1400, "vethQueues": 2, "dns": {"nameservers": ["10.0.0.10"], "domain": "base.local"}}`
+	err = ioutil.WriteFile(filepath.Join(dir, "base.conf"), []byte(base), 0644)
+	c.Assert(err, IsNil)
+
+	oldDir := netConfIncludeDir
+	netConfIncludeDir = dir
+	defer func() { netConfIncludeDir = oldDir }()
+
+	n, cniVer, err := loadNetConf([]byte(`{"name": "cilium", "include": "base.conf", "mtu": 1450, "dns": {"domain": "cluster.local"}}`))
+	c.Assert(err, IsNil)
+	c.Assert(cniVer, Equals, "0.3.1")
+	c.Assert(n.Name, Equals, "cilium")
+	c.Assert(n.MTU, Equals, 1450)
+	c.Assert(n.VethQueues, Equals, 2)
+	c.Assert(n.DNS.Domain, Equals, "cluster.local")
+	c.Assert(n.DNS.Nameservers, DeepEquals, []string{"10.0.0.10"})
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "include": "` + filepath.Join(dir, "base.conf") + `"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.MTU, Equals, 1400)
+}
+
+func (s *CNISuite) TestLoadNetConfOverlay(c *C) {
+	dir, err := ioutil.TempDir("", "cilium-cni-overlay")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	base := `{"mtu": 1400, "vethQueues": 2, "ipam": {"subnetHint": "10.0.0.0/24"}}`
+	err = ioutil.WriteFile(filepath.Join(dir, "base.conf"), []byte(base), 0644)
+	c.Assert(err, IsNil)
+	overlay := `{"cniVersion": "0.3.1", "mtu": 1300, "vethQueues": 4, "maxLabels": 10, "ipam": {"subnetHint": "10.0.2.0/24", "ipv6Scope": "gua"}}`
+	overlayPath := filepath.Join(dir, "overrides.json")
+	err = ioutil.WriteFile(overlayPath, []byte(overlay), 0644)
+	c.Assert(err, IsNil)
+
+	oldDir, oldPath := netConfIncludeDir, netConfOverlayPath
+	netConfIncludeDir, netConfOverlayPath = dir, overlayPath
+	defer func() { netConfIncludeDir, netConfOverlayPath = oldDir, oldPath }()
+
+	// stdin takes precedence over the include, which takes precedence
+	// over the overlay
+	n, cniVer, err := loadNetConf([]byte(`{"name": "cilium", "include": "base.conf", "mtu": 1450}`))
+	c.Assert(err, IsNil)
+	c.Assert(cniVer, Equals, "0.3.1")
+	c.Assert(n.MTU, Equals, 1450)
+	c.Assert(n.VethQueues, Equals, 2)
+	c.Assert(n.MaxLabels, Equals, 10)
+	c.Assert(n.IPAM.SubnetHint, Equals, "10.0.0.0/24")
+	c.Assert(n.IPAM.IPv6Scope, Equals, ipv6ScopeGUA)
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.MTU, Equals, 1300)
+	c.Assert(n.IPAM.SubnetHint, Equals, "10.0.2.0/24")
+
+	err = ioutil.WriteFile(overlayPath, []byte(`{"include": "base.conf"}`), 0644)
+	c.Assert(err, IsNil)
+	_, _, err = loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, ErrorMatches, "failed to load netconf: include in overlay .* is not supported")
+
+	// A missing overlay is ignored
+	netConfOverlayPath = filepath.Join(dir, "missing.json")
+	n, _, err = loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.MTU, Equals, 0)
+}
+
+func (s *CNISuite) TestLoadNetConfIncludeUnsafe(c *C) {
+	parent, err := ioutil.TempDir("", "cilium-cni-include")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(parent)
+
+	dir := filepath.Join(parent, "safe")
+	c.Assert(os.Mkdir(dir, 0755), IsNil)
+	outside := filepath.Join(parent, "outside.conf")
+	c.Assert(ioutil.WriteFile(outside, []byte(`{"mtu": 1400}`), 0644), IsNil)
+	c.Assert(os.Symlink(outside, filepath.Join(dir, "link.conf")), IsNil)
+
+	oldDir := netConfIncludeDir
+	netConfIncludeDir = dir
+	defer func() { netConfIncludeDir = oldDir }()
+
+	for _, include := range []string{"../outside.conf", outside, "link.conf", "missing.conf"} {
+		_, _, err = loadNetConf([]byte(`{"name": "cilium", "include": "` + include + `"}`))
+		c.Assert(err, Not(IsNil), Commentf("include %q", include))
+	}
+}
+
+func (s *CNISuite) TestLoadDelNetConf(c *C) {
+	// Fields of the wrong type and invalid options are skipped, the other
+	// fields are kept
+	payload := `{
+		"name": "cilium",
+		"mtu": "1400",
+		"hostInterfacePrefix": "invalid-prefix-which-is-too-long",
+		"disableIPv4": true,
+		"disableIPv6": true,
+		"resultCacheDir": "/var/run/cni-cache",
+		"postDelHook": "/usr/bin/hook",
+		"postDelHookTimeout": "-1s",
+		"downBeforeDelete": true
+	}`
+	_, _, err := loadNetConf([]byte(payload))
+	c.Assert(err, Not(IsNil))
+	n, err := loadDelNetConf([]byte(payload))
+	c.Assert(err, IsNil)
+	c.Assert(n.Name, Equals, "cilium")
+	c.Assert(n.MTU, Equals, 0)
+	c.Assert(n.ResultCacheDir, Equals, "/var/run/cni-cache")
+	c.Assert(n.PostDelHook, Equals, "/usr/bin/hook")
+	c.Assert(n.postDelTimeout(), Equals, defaultHookTimeout)
+	c.Assert(n.DownBeforeDelete, Equals, true)
+
+	_, err = loadDelNetConf([]byte(`{"name": "cilium"`))
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *CNISuite) TestValidateGateway(c *C) {
+	ip4, err := addressing.NewCiliumIPv4("10.1.0.5")
+	c.Assert(err, IsNil)
+	c.Assert(validateGateway(ip4, net.ParseIP("10.1.0.1"), "10.1.0.0/16"), IsNil)
+	c.Assert(validateGateway(ip4, net.ParseIP("10.2.0.1"), "10.1.0.0/16"), ErrorMatches,
+		"gateway 10.2.0.1 and endpoint prefix 10.1.0.5/32 are not in the same subnet 10.1.0.0/16")
+	c.Assert(validateGateway(ip4, net.ParseIP("10.1.0.1"), ""), Not(IsNil))
+
+	ip6, err := addressing.NewCiliumIPv6("f00d::a0f:0:0:5")
+	c.Assert(err, IsNil)
+	c.Assert(validateGateway(ip6, net.ParseIP("f00d::a0f:0:0:1"), "f00d::a0f:0:0:0/96"), IsNil)
+	c.Assert(validateGateway(ip6, net.ParseIP("fe80::1"), "f00d::a0f:0:0:0/96"), IsNil)
+	c.Assert(validateGateway(ip6, net.ParseIP("beef::1"), "f00d::a0f:0:0:0/96"), Not(IsNil))
+}
+
+func (s *CNISuite) TestCreateJitter(c *C) {
+	n, _, err := loadNetConf([]byte(`{"name": "cilium", "endpointCreateTimeout": "10s", "endpointCreateJitter": "1s"}`))
+	c.Assert(err, IsNil)
+
+	idle := &models.DaemonConfigurationStatus{}
+	busy := &models.DaemonConfigurationStatus{EndpointRestoreInProgress: true}
+	c.Assert(n.createJitter(idle), Equals, time.Duration(0))
+	for i := 0; i < 100; i++ {
+		jitter := n.createJitter(busy)
+		c.Assert(jitter >= 0 && jitter < time.Second, Equals, true, Commentf("jitter %s", jitter))
+	}
+
+	n.EndpointCreateJitterAlways = true
+	c.Assert(n.createJitter(idle) < time.Second, Equals, true)
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "endpointCreateTimeout": "1s", "endpointCreateJitter": "1s"}`))
+	c.Assert(err, Not(IsNil))
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "endpointCreateJitter": "-1s"}`))
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *CNISuite) TestLabelSources(c *C) {
+	n, _, err := loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.labelSource(labelGroupMesos), Equals, labels.LabelSourceMesos)
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "labelSources": {"mesos": "container"}}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.labelSource(labelGroupMesos), Equals, labels.LabelSourceContainer)
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "labelSources": {"mesos": "reserved"}}`))
+	c.Assert(err, ErrorMatches, `invalid label source "reserved" for label group "mesos"`)
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "labelSources": {"foo": "k8s"}}`))
+	c.Assert(err, ErrorMatches, `unknown label group "foo"`)
+}
+
+func (s *CNISuite) TestValidateOffloads(c *C) {
+	c.Assert(validateOffloads(map[string]string{"tx-checksumming": "off", "gro": "on"}, ""), IsNil)
+	c.Assert(validateOffloads(map[string]string{"tso": "off"}, offloadsDeviceHost), IsNil)
+	c.Assert(validateOffloads(map[string]string{"foo": "off"}, ""), ErrorMatches, `unknown offload feature "foo"`)
+	c.Assert(validateOffloads(map[string]string{"tso": "disabled"}, ""), Not(IsNil))
+	c.Assert(validateOffloads(nil, "peer"), ErrorMatches, `invalid offloadsDevice "peer"`)
+}
+
+func (s *CNISuite) TestParseRouteScope(c *C) {
+	gw := net.ParseIP("10.1.0.1")
+	rt, err := parseRoute(Route{Dst: "192.168.0.0/24"}, gw, 0, 0)
+	c.Assert(err, IsNil)
+	c.Assert(rt.Scope, Equals, netlink.SCOPE_UNIVERSE)
+
+	rt, err = parseRoute(Route{Dst: "192.168.0.0/24", Scope: "link"}, gw, 0, 0)
+	c.Assert(err, IsNil)
+	c.Assert(rt.Scope, Equals, netlink.SCOPE_LINK)
+
+	_, err = parseRoute(Route{Dst: "192.168.0.0/24", Scope: "nowhere"}, gw, 0, 0)
+	c.Assert(err, ErrorMatches, `invalid scope "nowhere" of route to 192.168.0.0/24`)
+}
+
+func (s *CNISuite) TestParseRoutePriority(c *C) {
+	gw := net.ParseIP("10.1.0.1")
+	rt, err := parseRoute(Route{Dst: "192.168.0.0/24"}, gw, 0, 0)
+	c.Assert(err, IsNil)
+	c.Assert(rt.Priority, Equals, 0)
+
+	rt, err = parseRoute(Route{Dst: "192.168.0.0/24", Priority: 100}, gw, 0, 0)
+	c.Assert(err, IsNil)
+	c.Assert(rt.Priority, Equals, 100)
+
+	_, err = parseRoute(Route{Dst: "192.168.0.0/24", Priority: -1}, gw, 0, 0)
+	c.Assert(err, ErrorMatches, `invalid priority -1 of route to 192.168.0.0/24`)
+}
+
+func (s *CNISuite) TestSandboxPath(c *C) {
+	c.Assert(sandboxPath("/proc/1234/ns/net"), Equals, "/proc/1234/ns/net")
+	c.Assert(sandboxPath("1234"), Equals, "/proc/1234/ns/net")
+	c.Assert(sandboxPath("/var/run/netns/cni-0b2a3c4d-1234"), Equals, "/var/run/netns/cni-0b2a3c4d-1234")
+	c.Assert(sandboxPath("/var/run/netns//cni-0b2a3c4d-1234/"), Equals, "/var/run/netns/cni-0b2a3c4d-1234")
+}
+
+func (s *CNISuite) TestAddLock(c *C) {
+	dir, err := ioutil.TempDir("", "cilium-cni-lock")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	lock, err := acquireAddLock(dir, "abcd", "eth0", time.Second)
+	c.Assert(err, IsNil)
+
+	_, err = acquireAddLock(dir, "abcd", "eth0", 0)
+	c.Assert(err, ErrorMatches, "timeout waiting for concurrent ADD of container abcd interface eth0")
+	c.Assert(removeAddLock(dir, "abcd", "eth0"), Not(IsNil))
+
+	other, err := acquireAddLock(dir, "abcd", "eth1", 0)
+	c.Assert(err, IsNil)
+	other.Close()
+
+	lock.Close()
+	lock, err = acquireAddLock(dir, "abcd", "eth0", 0)
+	c.Assert(err, IsNil)
+	lock.Close()
+
+	c.Assert(removeAddLock(dir, "abcd", "eth0"), IsNil)
+	_, err = os.Stat(filepath.Join(dir, "abcd-eth0.lock"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+	c.Assert(removeAddLock(dir, "abcd", "eth0"), IsNil)
+
+	_, err = acquireAddLock(dir, "../abcd", "eth0", 0)
+	c.Assert(err, Not(IsNil))
+}
+
+func (s *CNISuite) TestAddLockReplaced(c *C) {
+	dir, err := ioutil.TempDir("", "cilium-cni-lock")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "abcd-eth0.lock")
+
+	lock, err := acquireAddLock(dir, "abcd", "eth0", 0)
+	c.Assert(err, IsNil)
+	defer lock.Close()
+	c.Assert(isLockFile(lock, path), Equals, true)
+
+	// A lock of a file removed by a concurrent DEL no longer excludes
+	// other ADDs, which lock a new file at the same path
+	c.Assert(os.Remove(path), IsNil)
+	c.Assert(isLockFile(lock, path), Equals, false)
+
+	other, err := acquireAddLock(dir, "abcd", "eth0", 0)
+	c.Assert(err, IsNil)
+	defer other.Close()
+	c.Assert(isLockFile(lock, path), Equals, false)
+	c.Assert(isLockFile(other, path), Equals, true)
+}
+
+func (s *CNISuite) TestPrepareIPRouteMTU(c *C) {
+	state := &CmdState{
+		HostAddr: &models.NodeAddressing{
+			IPV4: &models.NodeAddressingElement{IP: "10.1.0.1", AllocRange: "10.1.0.0/16"},
+		},
+	}
+	extra := []Route{
+		{Dst: "192.168.0.0/24"},
+		{Dst: "192.168.1.0/24", Scope: "link"},
+	}
+	_, _, err := prepareIP("10.1.0.5", false, state, 1450, 1500, 0, false, false, extra)
+	c.Assert(err, IsNil)
+
+	mtus := map[string]int{}
+	for _, r := range state.IP4routes {
+		mtus[r.Prefix.String()] = r.MTU
+	}
+	c.Assert(mtus, DeepEquals, map[string]int{
+		"10.1.0.1/32":    1500,
+		"0.0.0.0/0":      1450,
+		"192.168.0.0/24": 1450,
+		"192.168.1.0/24": 1500,
+	})
+
+	res := &ciliumResult{}
+	res.addRouteDetails(state.IP4routes)
+	c.Assert(res.Cilium.Routes, HasLen, 4)
+	for _, d := range res.Cilium.Routes {
+		c.Assert(d.MTU, Equals, mtus[(*net.IPNet)(&d.Dst).String()])
+	}
+}
+
+func (s *CNISuite) TestFamilyRouteMTU(c *C) {
+	n, _, err := loadNetConf([]byte(`{"name": "cilium", "ipv6MTU": 1400}`))
+	c.Assert(err, IsNil)
+	state := &CmdState{
+		HostAddr: &models.NodeAddressing{
+			IPV4: &models.NodeAddressingElement{IP: "10.1.0.1", AllocRange: "10.1.0.0/16"},
+			IPV6: &models.NodeAddressingElement{IP: "f00d::1", AllocRange: "f00d::/96"},
+		},
+	}
+
+	mtu4, err := familyRouteMTU(n, false, 1450, 1500)
+	c.Assert(err, IsNil)
+	c.Assert(mtu4, Equals, 1450)
+	_, _, err = prepareIP("10.1.0.5", false, state, mtu4, 1500, 0, false, false, nil)
+	c.Assert(err, IsNil)
+
+	mtu6, err := familyRouteMTU(n, true, 1450, 1500)
+	c.Assert(err, IsNil)
+	c.Assert(mtu6, Equals, 1400)
+	_, _, err = prepareIP("f00d::5", true, state, mtu6, 1500, 0, false, false, nil)
+	c.Assert(err, IsNil)
+
+	defaultRouteMTU := func(routes []route.Route) int {
+		for _, r := range routes {
+			if ones, _ := r.Prefix.Mask.Size(); ones == 0 {
+				return r.MTU
+			}
+		}
+		return -1
+	}
+	c.Assert(defaultRouteMTU(state.IP4routes), Equals, 1450)
+	c.Assert(defaultRouteMTU(state.IP6routes), Equals, 1400)
+
+	_, err = familyRouteMTU(&netConf{IPv4MTU: 9000}, false, 1450, 1500)
+	c.Assert(err, ErrorMatches, "ipv4MTU 9000 exceeds the device MTU 1500")
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "ipv4MTU": -1}`))
+	c.Assert(err, ErrorMatches, "invalid ipv4MTU -1")
+}
+
+func (s *CNISuite) TestCheckAddressFamilies(c *C) {
+	enabled := &models.NodeAddressingElement{IP: "10.1.0.1", Enabled: true}
+	disabled := &models.NodeAddressingElement{IP: "10.1.0.1"}
+	dual := &models.AddressPair{IPV4: "10.1.0.5", IPV6: "f00d::5"}
+
+	tests := []struct {
+		address *models.AddressPair
+		host    *models.NodeAddressing
+		err     string
+	}{
+		// Matching families
+		{dual, &models.NodeAddressing{IPV4: enabled, IPV6: enabled}, ""},
+		{&models.AddressPair{IPV4: "10.1.0.5"}, &models.NodeAddressing{IPV4: enabled}, ""},
+		{&models.AddressPair{IPV6: "f00d::5"}, &models.NodeAddressing{IPV4: disabled, IPV6: enabled}, ""},
+		{dual, nil, ""},
+
+		// IPv4 address without IPv4 host addressing
+		{dual, &models.NodeAddressing{IPV6: enabled},
+			"IPAM returned IPv4 address 10.1.0.5 but the agent provided no IPv4 host addressing"},
+		{dual, &models.NodeAddressing{IPV4: disabled, IPV6: enabled},
+			"IPAM returned IPv4 address 10.1.0.5 but IPv4 is disabled in the host addressing of the agent"},
+
+		// IPv6 address without IPv6 host addressing
+		{dual, &models.NodeAddressing{IPV4: enabled},
+			"IPAM returned IPv6 address f00d::5 but the agent provided no IPv6 host addressing"},
+		{dual, &models.NodeAddressing{IPV4: enabled, IPV6: disabled},
+			"IPAM returned IPv6 address f00d::5 but IPv6 is disabled in the host addressing of the agent"},
+	}
+	for _, t := range tests {
+		err := checkAddressFamilies(&models.IPAMResponse{Address: t.address, HostAddressing: t.host})
+		if t.err == "" {
+			c.Assert(err, IsNil)
+		} else {
+			c.Assert(err, ErrorMatches, t.err)
+		}
+	}
+}
+
+func (s *CNISuite) TestClampMTU(c *C) {
+	oldDetect := detectUplinkMTU
+	defer func() {
+		detectUplinkMTU = oldDetect
+		resetUplinkMTU()
+	}()
+	lookups := 0
+	detectUplinkMTU = func() (int, error) {
+		lookups++
+		return 1500, nil
+	}
+	resetUplinkMTU()
+	logger := log.WithField("test", "TestClampMTU")
+
+	newState := func() *CmdState {
+		return &CmdState{
+			HostAddr: &models.NodeAddressing{
+				IPV4: &models.NodeAddressingElement{IP: "10.1.0.1", AllocRange: "10.1.0.0/16"},
+			},
+		}
+	}
+	defaultRouteMTU := func(routes []route.Route) int {
+		for _, r := range routes {
+			if ones, _ := r.Prefix.Mask.Size(); ones == 0 {
+				return r.MTU
+			}
+		}
+		return -1
+	}
+
+	// Clamp skipped, disabled by default
+	n, _, err := loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.pathMTU(logger), Equals, 0)
+	c.Assert(lookups, Equals, 0)
+
+	// Clamp applied, the route MTU exceeds the path MTU
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "clampMTU": true}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.pathMTU(logger), Equals, 1500)
+	state := newState()
+	_, _, err = prepareIP("10.1.0.5", false, state, 9000, 9000, n.pathMTU(logger), false, false, nil)
+	c.Assert(err, IsNil)
+	c.Assert(defaultRouteMTU(state.IP4routes), Equals, 1500)
+
+	// Clamp skipped, the route MTU fits the path MTU
+	state = newState()
+	_, _, err = prepareIP("10.1.0.5", false, state, 1450, 1500, n.pathMTU(logger), false, false, nil)
+	c.Assert(err, IsNil)
+	c.Assert(defaultRouteMTU(state.IP4routes), Equals, 1450)
+
+	// The uplink is looked up once and shared with the MTU detection
+	c.Assert(lookups, Equals, 1)
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "clampMTU": true, "mtuMode": "auto"}`))
+	c.Assert(err, IsNil)
+	dev, rt, _ := endpointMTU(n, &models.DaemonConfigurationStatus{}, logger)
+	c.Assert([]int64{dev, rt}, DeepEquals, []int64{1500, 1500})
+	c.Assert(n.pathMTU(logger), Equals, 1500)
+	c.Assert(lookups, Equals, 1)
+
+	// Clamp skipped, disabled
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "clampMTU": false}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.pathMTU(logger), Equals, 0)
+	state = newState()
+	_, _, err = prepareIP("10.1.0.5", false, state, 9000, 9000, n.pathMTU(logger), false, false, nil)
+	c.Assert(err, IsNil)
+	c.Assert(defaultRouteMTU(state.IP4routes), Equals, 9000)
+
+	// Clamp skipped, the route MTU is set explicitly
+	for _, conf := range []string{
+		`{"name": "cilium", "clampMTU": true, "mtu": 9000}`,
+		`{"name": "cilium", "clampMTU": true, "routeMTU": 9000}`,
+		`{"name": "cilium", "clampMTU": true, "ipv4MTU": 9000}`,
+		`{"name": "cilium", "clampMTU": true, "ipv6MTU": 9000}`,
+	} {
+		n, _, err = loadNetConf([]byte(conf))
+		c.Assert(err, IsNil)
+		c.Assert(n.pathMTU(logger), Equals, 0, Commentf("%s", conf))
+	}
+
+	// Clamp skipped, the path MTU is unknown
+	detectUplinkMTU = func() (int, error) { return 0, errors.New("no default route") }
+	resetUplinkMTU()
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "clampMTU": true}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.pathMTU(logger), Equals, 0)
+}
+
+func (s *CNISuite) TestSyncBuild(c *C) {
+	idle := &models.DaemonConfigurationStatus{}
+	restoring := &models.DaemonConfigurationStatus{EndpointRestoreInProgress: true}
+	queued := &models.DaemonConfigurationStatus{EndpointBuildsQueued: 3}
+
+	n := &netConf{}
+	c.Assert(n.syncBuild(idle), Equals, true)
+	c.Assert(n.syncBuild(queued), Equals, true)
+
+	n.EndpointBuildMode = endpointBuildModeAuto
+	c.Assert(n.syncBuild(idle), Equals, true)
+	c.Assert(n.syncBuild(restoring), Equals, false)
+	c.Assert(n.syncBuild(queued), Equals, false)
+
+	_, _, err := loadNetConf([]byte(`{"name": "cilium", "endpointBuildMode": "async"}`))
+	c.Assert(err, ErrorMatches, `invalid endpointBuildMode "async"`)
+}
+
+func (s *CNISuite) TestChainProperties(c *C) {
+	ep := &models.EndpointChangeRequest{}
+	addChainProperties(ep, "cbr0")
+	c.Assert(ep.Properties, DeepEquals, map[string]string{chainedProperty: "true", chainNameProperty: "cbr0"})
+
+	ep = &models.EndpointChangeRequest{Properties: attachmentProperties("cbr0", "eth0")}
+	addChainProperties(ep, "cbr0")
+	c.Assert(ep.Properties, DeepEquals, map[string]string{
+		attachmentNetworkProperty: "cbr0",
+		attachmentIfNameProperty:  "eth0",
+		chainedProperty:           "true",
+		chainNameProperty:         "cbr0",
+	})
+}
+
+func (s *CNISuite) TestChainedDelete(c *C) {
+	payload := `{
+		"cniVersion": "0.3.1",
+		"name": "cbr0",
+		"type": "cilium-cni",
+		"prevResult": {
+			"cniVersion": "0.3.1",
+			"interfaces": [
+				{"name": "cni0", "mac": "0a:58:0a:f4:00:01"},
+				{"name": "veth15707e9b", "mac": "4e:6d:93:35:6b:45"},
+				{"name": "eth0", "mac": "0a:58:0a:f4:00:06", "sandbox": "/proc/15259/ns/net"}
+			],
+			"ips": [
+				{"version": "4", "interface": 2, "address": "10.244.0.6/24", "gateway": "10.244.0.1"},
+				{"version": "6", "interface": 2, "address": "f00d::6/64"}
+			]
+		}
+	}`
+	n, _, err := loadNetConf([]byte(payload))
+	c.Assert(err, IsNil)
+	c.Assert(n.chained(), Equals, true)
+
+	ids, err := chainedEndpointIDs(n, "abcd")
+	c.Assert(err, IsNil)
+	c.Assert(ids, DeepEquals, []string{"container-id:abcd", "ipv4:10.244.0.6", "ipv6:f00d::6"})
+
+	n, _, err = loadNetConf([]byte(`{"cniVersion": "0.3.1", "name": "cilium", "type": "cilium-cni"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.chained(), Equals, false)
+}
+
+func (s *CNISuite) TestOwnerTemplate(c *C) {
+	args := &skel.CmdArgs{ContainerID: "abcd"}
+	cniArgs := cniArgsSpec{
+		K8S_POD_NAMESPACE: "default",
+		K8S_POD_NAME:      "foo",
+		K8S_POD_UID:       "1234",
+	}
+
+	n, _, err := loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	c.Assert(ipOwner(n, args, cniArgs), Equals, "default/foo")
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "ownerTemplate": "{network}:{namespace}/{name}/{uid}@{containerID}"}`))
+	c.Assert(err, IsNil)
+	c.Assert(ipOwner(n, args, cniArgs), Equals, "cilium:default/foo/1234@abcd")
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "ownerTemplate": "{namespace}/{pod}"}`))
+	c.Assert(err, ErrorMatches, `unknown placeholder \{pod\} in ownerTemplate .*`)
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "ownerPrefix": "tenant-a", "ownerTemplate": "{namespace}/{name}@{containerID}"}`))
+	c.Assert(err, IsNil)
+	c.Assert(ipOwner(n, args, cniArgs), Equals, "tenant-a/default/foo@abcd")
+	// Release uses the same owner as the allocation
+	f := &fakeIPAMClient{}
+	releaseByIdentity(f, n, args, cniArgs)
+	c.Assert(f.releasedOwners, DeepEquals, []string{"tenant-a/default/foo@abcd:abcd"})
+
+	for _, prefix := range []string{"-tenant", "tenant/a", "a b", strings.Repeat("a", 64)} {
+		_, _, err = loadNetConf([]byte(`{"name": "cilium", "ownerPrefix": "` + prefix + `"}`))
+		c.Assert(err, ErrorMatches, "invalid ownerPrefix .*")
+	}
+}
+
+func (s *CNISuite) TestHostIfName(c *C) {
+	args := &skel.CmdArgs{ContainerID: "0123456789abcdef"}
+	cniArgs := cniArgsSpec{
+		K8S_POD_NAMESPACE: "default",
+		K8S_POD_NAME:      "foo",
+	}
+
+	n, _, err := loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	c.Assert(hostIfName(n, args, cniArgs), Equals, connector.Endpoint2IfName(args.ContainerID))
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "hostInterfaceName": "{namespace}/{name}"}`))
+	c.Assert(err, IsNil)
+	c.Assert(hostIfName(n, args, cniArgs), Equals, "default-foo")
+
+	// Long names are shortened to a hash suffix, deterministically
+	cniArgs.K8S_POD_NAME = "frontend-7d4b9c"
+	name := hostIfName(n, args, cniArgs)
+	c.Assert(name, HasLen, unix.IFNAMSIZ-1)
+	c.Assert(name[:8], Equals, "default-")
+	c.Assert(hostIfName(n, args, cniArgs), Equals, name)
+	cniArgs.K8S_POD_NAME = "frontend-7d4b9d"
+	c.Assert(hostIfName(n, args, cniArgs), Not(Equals), name)
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "hostInterfaceName": "{pod}"}`))
+	c.Assert(err, ErrorMatches, `unknown placeholder \{pod\} in hostInterfaceName .*`)
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "hostInterfaceName": "{name}", "hostInterfacePrefix": "cil"}`))
+	c.Assert(err, ErrorMatches, "hostInterfaceName and hostInterfacePrefix are mutually exclusive")
+}
+
+type fakeEventClient struct {
+	events []*models.CNIEvent
+	err    error
+}
+
+func (f *fakeEventClient) CNIEventPost(ev *models.CNIEvent) error {
+	f.events = append(f.events, ev)
+	return f.err
+}
+
+func (s *CNISuite) TestReportAddFailure(c *C) {
+	args := &skel.CmdArgs{ContainerID: "abcd"}
+	addErr := errors.New("no free IP")
+
+	// Events are only reported for known pods
+	f := &fakeEventClient{}
+	reportAddFailure(f, log, args, cniArgsSpec{}, addPhaseIPAM, addErr)
+	c.Assert(f.events, HasLen, 0)
+
+	cniArgs := cniArgsSpec{K8S_POD_NAMESPACE: "default", K8S_POD_NAME: "foo"}
+	reportAddFailure(f, log, args, cniArgs, addPhaseIPAM, addErr)
+	c.Assert(f.events, DeepEquals, []*models.CNIEvent{{
+		K8sNamespace: "default",
+		K8sPodName:   "foo",
+		ContainerID:  "abcd",
+		Phase:        addPhaseIPAM,
+		Error:        "no free IP",
+	}})
+
+	// Failures to report are not propagated
+	f = &fakeEventClient{err: errors.New("connection refused")}
+	reportAddFailure(f, log, args, cniArgs, addPhaseEndpoint, addErr)
+	c.Assert(f.events, HasLen, 1)
+}
+
+func (s *CNISuite) TestOwnedIPRelease(c *C) {
+	f := &fakePendingReleaseClient{allocations: models.AllocationMap{
+		"10.0.0.1": "default/foo",
+		"10.0.0.2": "default/bar",
+	}}
+	release := ownedIPRelease(f, "default/foo")
+	c.Assert(release("10.0.0.1"), IsNil)
+	// Addresses of another owner or of another IPAM are left alone
+	c.Assert(release("10.0.0.2"), IsNil)
+	c.Assert(release("10.245.0.7"), IsNil)
+	c.Assert(f.released, DeepEquals, []string{"10.0.0.1"})
+
+	// Nothing is released if the owner can't be verified
+	f = &fakePendingReleaseClient{allocErr: errors.New("agent unavailable")}
+	c.Assert(ownedIPRelease(f, "default/foo")("10.0.0.1"), ErrorMatches, "unable to verify owner: agent unavailable")
+	c.Assert(f.released, HasLen, 0)
+}
+
+func (s *CNISuite) TestTeardownInterfaces(c *C) {
+	payload := `{
+		"cniVersion": "0.3.1",
+		"name": "cilium",
+		"type": "cilium-cni",
+		"prevResult": {
+			"cniVersion": "0.3.1",
+			"interfaces": [
+				{"name": "lxc12345", "mac": "4e:6d:93:35:6b:45"},
+				{"name": "eth0", "mac": "0a:58:0a:f4:00:06", "sandbox": "/proc/15259/ns/net"},
+				{"name": "net1", "mac": "0a:58:0a:f4:00:07", "sandbox": "/proc/15259/ns/net"},
+				{"name": "net2", "mac": "0a:58:0a:f4:00:08", "sandbox": "/proc/15259/ns/net"}
+			],
+			"ips": [
+				{"version": "4", "interface": 1, "address": "10.244.0.6/24"},
+				{"version": "4", "interface": 2, "address": "10.245.0.7/24"},
+				{"version": "4", "interface": 3, "address": "10.246.0.8/24"}
+			]
+		}
+	}`
+	n, _, err := loadNetConf([]byte(payload))
+	c.Assert(err, IsNil)
+	// net2 has been created by another plugin of the chain
+	ifaces := containerInterfaces(n, "eth0", func(ifName string) bool {
+		return ifName == "net1"
+	})
+	c.Assert(ifaces, DeepEquals, []containerIface{
+		{name: "eth0"},
+		{name: "net1", ips: []string{"10.245.0.7"}},
+	})
+
+	n, _, err = loadNetConf([]byte(`{"cniVersion": "0.3.1", "name": "cilium", "type": "cilium-cni"}`))
+	c.Assert(err, IsNil)
+	c.Assert(containerInterfaces(n, "eth0", func(string) bool { return true }), DeepEquals, []containerIface{{name: "eth0"}})
+
+	var removed, released []string
+	release := func(ip string) error {
+		released = append(released, ip)
+		return nil
+	}
+
+	// Interfaces are removed in reverse order and cleanup continues after
+	// a failure
+	for _, e := range []error{unix.EPERM, unix.EBUSY} {
+		removed, released = nil, nil
+		teardownInterfaces(ifaces, func(ifName string) error {
+			removed = append(removed, ifName)
+			if ifName == "net1" {
+				return e
+			}
+			return nil
+		}, release)
+		c.Assert(removed, DeepEquals, []string{"net1", "eth0"})
+		c.Assert(released, DeepEquals, []string{"10.245.0.7"})
+	}
+}
+
+func (s *CNISuite) TestValidateIfName(c *C) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"eth0", true},
+		{"net1", true},
+		{"a", true},
+		{"abcdefghijklmno", true},
+		{"abcdefghijklmnop", false},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"eth/0", false},
+		{"eth:0", false},
+		{"eth 0", false},
+		{"eth0\t", false},
+	}
+	for _, tt := range tests {
+		err := validateIfName(tt.name)
+		if tt.valid {
+			c.Assert(err, IsNil, Commentf("name %q", tt.name))
+		} else {
+			c.Assert(err, FitsTypeOf, &cniTypes.Error{}, Commentf("name %q", tt.name))
+			c.Assert(err.(*cniTypes.Error).Code, Equals, uint(errCodeInvalidEnvironment))
+		}
+	}
+}
+
+func (s *CNISuite) TestValidateNetConf(c *C) {
+	tests := []struct {
+		conf string
+		err  string
+	}{
+		// Valid combinations
+		{`{}`, ""},
+		{`{"disableIPv4": true, "ipv6MTU": 1400, "hostProxyNDP": true}`, ""},
+		{`{"datapathMode": "veth", "txQueueLen": 5000, "hostTxQueueLen": true}`, ""},
+		{`{"mtuMode": "auto", "mtuOverhead": 50}`, ""},
+		{`{"preAddHook": "/bin/true", "preAddHookTimeout": "1s"}`, ""},
+
+		// Mutually exclusive options
+		{`{"noGateway": true, "staticNeigh": true}`, "staticNeigh requires a gateway and can't be combined with noGateway"},
+		{`{"disableIPv4": true, "disableIPv6": true}`, "disableIPv4 and disableIPv6 are mutually exclusive"},
+		{`{"hostInterfaceName": "lxc{containerID}", "hostInterfacePrefix": "cil"}`, "hostInterfaceName and hostInterfacePrefix are mutually exclusive"},
+		{`{"disableIPv4": true, "ipv4MTU": 1400}`, "ipv4MTU can't be combined with disableIPv4"},
+		{`{"disableIPv6": true, "ipv6MTU": 1400}`, "ipv6MTU can't be combined with disableIPv6"},
+		{`{"disableIPv4": true, "hostProxyARP": true}`, "hostProxyARP can't be combined with disableIPv4"},
+		{`{"disableIPv6": true, "hostProxyNDP": true}`, "hostProxyNDP can't be combined with disableIPv6"},
+		{`{"ipam": {"type": "none"}, "capabilities": {"ips": true}, "runtimeConfig": {"ips": ["10.0.0.5"]}}`, `ipam type "none" can't be combined with requested ips`},
+		{`{"ipam": {"type": "none"}, "attachToExisting": true}`, `ipam type "none" can't be combined with attachToExisting`},
+		{`{"ipam": {"type": "none", "addressesPerFamily": 2}}`, `ipam type "none" can't be combined with addressesPerFamily`},
+		{`{"datapathMode": "ipvlan", "txQueueLen": 5000}`, `txQueueLen requires datapathMode "veth" and can't be combined with datapathMode "ipvlan"`},
+		{`{"datapathMode": "ipvlan", "hostProxyARP": true}`, `hostProxyARP requires datapathMode "veth" and can't be combined with datapathMode "ipvlan"`},
+		{`{"datapathMode": "ipvlan", "hostProxyNDP": true}`, `hostProxyNDP requires datapathMode "veth" and can't be combined with datapathMode "ipvlan"`},
+		{`{"datapathMode": "ipvlan", "hostMaster": "br0"}`, `hostMaster requires datapathMode "veth" and can't be combined with datapathMode "ipvlan"`},
+		{`{"policyExempt": "egress", "attachToExisting": true}`, "policyExempt can't be combined with attachToExisting"},
+		{`{"allowPolicyExemptArgs": true, "attachToExisting": true}`, "allowPolicyExemptArgs can't be combined with attachToExisting"},
+		{`{"disableResultCache": true, "resultCacheDir": "/tmp"}`, "resultCacheDir can't be combined with disableResultCache"},
+
+		// Dependent options
+		{`{"hostTxQueueLen": true}`, "hostTxQueueLen requires txQueueLen"},
+		{`{"mtuOverhead": 50}`, `mtuOverhead requires mtuMode "auto"`},
+		{`{"endpointCreateJitterAlways": true}`, "endpointCreateJitterAlways requires endpointCreateJitter"},
+		{`{"preAddHookTimeout": "1s"}`, "preAddHookTimeout requires preAddHook"},
+		{`{"postDelHookTimeout": "1s"}`, "postDelHookTimeout requires postDelHook"},
+	}
+	for _, tt := range tests {
+		n := &netConf{}
+		c.Assert(json.Unmarshal([]byte(tt.conf), n), IsNil, Commentf("conf %s", tt.conf))
+		err := n.validate()
+		if tt.err == "" {
+			c.Assert(err, IsNil, Commentf("conf %s", tt.conf))
+		} else {
+			c.Assert(err, Not(IsNil), Commentf("conf %s", tt.conf))
+			c.Assert(err.Error(), Equals, tt.err, Commentf("conf %s", tt.conf))
+		}
+	}
+
+	// loadNetConf rejects conflicting options
+	_, _, err := loadNetConf([]byte(`{"name": "cilium", "hostTxQueueLen": true}`))
+	c.Assert(err, ErrorMatches, "hostTxQueueLen requires txQueueLen")
+}
+
+func (s *CNISuite) TestDatapathMode(c *C) {
+	veth := &models.DaemonConfigurationStatus{DatapathMode: option.DatapathModeVeth}
+	ipvlan := &models.DaemonConfigurationStatus{
+		DatapathMode:        option.DatapathModeIpvlan,
+		IpvlanConfiguration: &models.IpvlanConfiguration{MasterDeviceIndex: 2},
+	}
+
+	n, _, err := loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	mode, err := n.datapathMode(ipvlan)
+	c.Assert(err, IsNil)
+	c.Assert(mode, Equals, option.DatapathModeIpvlan)
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "datapathMode": "veth"}`))
+	c.Assert(err, IsNil)
+	mode, err = n.datapathMode(ipvlan)
+	c.Assert(err, IsNil)
+	c.Assert(mode, Equals, option.DatapathModeVeth)
+
+	// ipvlan requires the master device of the agent
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "datapathMode": "ipvlan"}`))
+	c.Assert(err, IsNil)
+	_, err = n.datapathMode(veth)
+	c.Assert(err, ErrorMatches, `datapath mode "ipvlan" requires an ipvlan master device .*`)
+	c.Assert(err, ErrorMatches, `.*: ipvlan-configuration is missing`)
+	_, err = n.datapathMode(&models.DaemonConfigurationStatus{IpvlanConfiguration: &models.IpvlanConfiguration{}})
+	c.Assert(err, ErrorMatches, `.*: masterDeviceIndex is not set`)
+
+	// veth is used if the agent does not provide a mode
+	n, _, err = loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	mode, err = n.datapathMode(&models.DaemonConfigurationStatus{})
+	c.Assert(err, IsNil)
+	c.Assert(mode, Equals, option.DatapathModeVeth)
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "datapathMode": "macvlan"}`))
+	c.Assert(err, ErrorMatches, `invalid datapathMode "macvlan"`)
+}
+
+func (s *CNISuite) TestPhaseTimer(c *C) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	// Timing is disabled unless debug logging is enabled
+	logger.SetLevel(logrus.InfoLevel)
+	t := newPhaseTimer(logrus.NewEntry(logger))
+	t.begin(timingNetConfLoad)
+	t.summary()
+	c.Assert(t.durations, HasLen, 0)
+
+	logger.SetLevel(logrus.DebugLevel)
+	t = newPhaseTimer(logrus.NewEntry(logger))
+	t.begin(timingNetConfLoad)
+	t.begin(timingArgsParse)
+	time.Sleep(time.Millisecond)
+	t.summary()
+	c.Assert(t.durations, HasLen, 2)
+	c.Assert(t.durations[0].phase, Equals, timingNetConfLoad)
+	c.Assert(t.durations[1].phase, Equals, timingArgsParse)
+	c.Assert(t.durations[1].duration >= time.Millisecond, Equals, true)
+
+	// Summaries end the current phase only once
+	t.summary()
+	c.Assert(t.durations, HasLen, 2)
+}
+
+func (s *CNISuite) TestReleaseByIdentity(c *C) {
+	args := &skel.CmdArgs{ContainerID: "abcd"}
+	cniArgs := cniArgsSpec{K8S_POD_NAMESPACE: "default", K8S_POD_NAME: "foo"}
+
+	// The release is limited to the container regardless of the owner
+	// template
+	n, _, err := loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	f := &fakeIPAMClient{}
+	releaseByIdentity(f, n, args, cniArgs)
+	c.Assert(f.releasedOwners, DeepEquals, []string{"default/foo:abcd"})
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "ownerTemplate": "{namespace}/{name}/{containerID}"}`))
+	c.Assert(err, IsNil)
+	releaseByIdentity(f, n, args, cniArgs)
+	c.Assert(f.releasedOwners, DeepEquals, []string{"default/foo:abcd", "default/foo/abcd:abcd"})
+
+	// Without a container ID the container cannot be identified
+	f = &fakeIPAMClient{}
+	releaseByIdentity(f, n, &skel.CmdArgs{}, cniArgs)
+	c.Assert(f.releasedOwners, HasLen, 0)
+}
+
+func (s *CNISuite) TestResultVersion(c *C) {
+	c.Assert(pluginVersions.SupportedVersions(), DeepEquals, []string{"0.1.0", "0.2.0", "0.3.0", "0.3.1", "0.4.0", "1.0.0", "1.1.0"})
+
+	_, ipNet, err := net.ParseCIDR("10.0.0.2/32")
+	c.Assert(err, IsNil)
+	ipNet.IP = net.ParseIP("10.0.0.2")
+	res := &ciliumResult{}
+	res.CNIVersion = "0.3.1"
+	res.IPs = append(res.IPs, &cniTypesVer.IPConfig{Version: "4", Address: *ipNet})
+	res.details().EndpointID = 42
+
+	r, err := res.GetAsVersion("0.3.1")
+	c.Assert(err, IsNil)
+	c.Assert(r.(*ciliumResult).CNIVersion, Equals, "0.3.1")
+
+	// 1.0.0 results omit the version of the addresses and retain the
+	// Cilium specific details
+	r, err = res.GetAsVersion("1.0.0")
+	c.Assert(err, IsNil)
+	c.Assert(r.Version(), Equals, "1.0.0")
+	var raw map[string]interface{}
+	c.Assert(json.Unmarshal(printResult(c, res, "1.0.0"), &raw), IsNil)
+	c.Assert(raw["cniVersion"], Equals, "1.0.0")
+	c.Assert(raw["ips"], DeepEquals, []interface{}{map[string]interface{}{"address": "10.0.0.2/32"}})
+	c.Assert(raw["cilium"], DeepEquals, map[string]interface{}{"endpointID": float64(42)})
+
+	// 1.1.0 results have the same format
+	r110, err := r.GetAsVersion("1.1.0")
+	c.Assert(err, IsNil)
+	c.Assert(r110.Version(), Equals, "1.1.0")
+	c.Assert(r.Version(), Equals, "1.0.0")
+	c.Assert(json.Unmarshal(printResult(c, res, "1.1.0"), &raw), IsNil)
+	c.Assert(raw["cniVersion"], Equals, "1.1.0")
+	c.Assert(raw["ips"], DeepEquals, []interface{}{map[string]interface{}{"address": "10.0.0.2/32"}})
+
+	// Converting back restores the version of the addresses
+	r, err = r.GetAsVersion("0.3.1")
+	c.Assert(err, IsNil)
+	back := r.(*ciliumResult)
+	c.Assert(back.CNIVersion, Equals, "0.3.1")
+	c.Assert(back.IPs, HasLen, 1)
+	c.Assert(back.IPs[0].Version, Equals, "4")
+	c.Assert(back.IPs[0].Address.String(), Equals, "10.0.0.2/32")
+	c.Assert(back.Cilium.EndpointID, Equals, int64(42))
+
+	r, err = res.GetAsVersion("0.2.0")
+	c.Assert(err, IsNil)
+	r020, ok := r.(*cniTypes020.Result)
+	c.Assert(ok, Equals, true)
+	c.Assert(r020.IP4.IP.String(), Equals, "10.0.0.2/32")
+	c.Assert(r020.CNIVersion, Equals, "0.2.0")
+
+	// The converted result is left unchanged
+	c.Assert(res.CNIVersion, Equals, "0.3.1")
+}
+
+// printResult returns the output of cniTypes.PrintResult
+func printResult(c *C, res cniTypes.Result, version string) []byte {
+	r, w, err := os.Pipe()
+	c.Assert(err, IsNil)
+	defer r.Close()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	err = cniTypes.PrintResult(res, version)
+	os.Stdout = stdout
+	w.Close()
+	c.Assert(err, IsNil)
+
+	out, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	return out
+}
+
+func (s *CNISuite) TestIPv6OnlyResult(c *C) {
+	_, ipNet, err := net.ParseCIDR("f00d::2/128")
+	c.Assert(err, IsNil)
+	res := &ciliumResult{}
+	res.IPs = append(res.IPs, &cniTypesVer.IPConfig{Version: "6", Address: *ipNet, Gateway: net.ParseIP("f00d::1")})
+	res.Routes = append(res.Routes, &cniTypes.Route{Dst: net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}, GW: net.ParseIP("f00d::1")})
+
+	for _, version := range pluginVersions.SupportedVersions() {
+		c.Assert(validateResult(log, res, version), IsNil)
+
+		out := printResult(c, res, version)
+		// 1.0.0 and 1.1.0 results are parsed as results of the
+		// implemented version, see result100
+		parseVersion := version
+		if isResult100Version(version) {
+			parseVersion = cniTypesVer.ImplementedSpecVersion
+		}
+		parsed, err := cniVersion.NewResult(parseVersion, out)
+		c.Assert(err, IsNil, Commentf("version %s: %s", version, out))
+
+		var raw map[string]interface{}
+		c.Assert(json.Unmarshal(out, &raw), IsNil)
+		c.Assert(raw["cniVersion"], Equals, version)
+
+		current, err := cniTypesVer.NewResultFromResult(parsed)
+		c.Assert(err, IsNil)
+		fillIPVersions(current)
+		c.Assert(current.IPs, HasLen, 1)
+		c.Assert(current.IPs[0].Version, Equals, "6")
+		c.Assert(current.IPs[0].Address.String(), Equals, "f00d::2/128")
+		c.Assert(current.Routes, HasLen, 1)
+	}
+
+	// The address family must match the version of the address
+	res.IPs[0].Version = "4"
+	c.Assert(validateResult(log, res, "0.3.1"), ErrorMatches, `address f00d::2 does not match IP version "4"`)
+	c.Assert(validateResult(log, &ciliumResult{}, "0.3.1"), ErrorMatches, "result does not contain any addresses")
+}
+
+func (s *CNISuite) TestEndpointMTU(c *C) {
+	oldDetect := detectUplinkMTU
+	defer func() {
+		detectUplinkMTU = oldDetect
+		resetUplinkMTU()
+	}()
+	detectUplinkMTU = func() (int, error) { return 9001, nil }
+	resetUplinkMTU()
+
+	conf := &models.DaemonConfigurationStatus{DeviceMTU: 1500, RouteMTU: 1450}
+
+	// Agent
+	n, _, err := loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	dev, rt, source := endpointMTU(n, conf, log)
+	c.Assert([]interface{}{dev, rt, source}, DeepEquals, []interface{}{int64(1500), int64(1450), mtuSourceAgent})
+
+	// Auto-detected, minus the overhead of the agent
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "mtuMode": "auto"}`))
+	c.Assert(err, IsNil)
+	dev, rt, source = endpointMTU(n, conf, log)
+	c.Assert([]interface{}{dev, rt, source}, DeepEquals, []interface{}{int64(8951), int64(8951), mtuSourceAuto})
+
+	// Failed detection falls back to the agent
+	detectUplinkMTU = func() (int, error) { return 0, errors.New("no default route") }
+	resetUplinkMTU()
+	dev, rt, source = endpointMTU(n, conf, log)
+	c.Assert([]interface{}{dev, rt, source}, DeepEquals, []interface{}{int64(1500), int64(1450), mtuSourceAgent})
+
+	// Network configuration takes precedence over detection
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "mtuMode": "auto", "mtu": 1400}`))
+	c.Assert(err, IsNil)
+	dev, rt, source = endpointMTU(n, conf, log)
+	c.Assert([]interface{}{dev, rt, source}, DeepEquals, []interface{}{int64(1400), int64(1400), mtuSourceNetConf})
+
+	// Without the MTU of the agent, detection does not subtract an overhead
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "mtuMode": "auto"}`))
+	c.Assert(err, IsNil)
+	detectUplinkMTU = func() (int, error) { return 9001, nil }
+	resetUplinkMTU()
+	dev, rt, source = endpointMTU(n, &models.DaemonConfigurationStatus{RouteMTU: 1450}, log)
+	c.Assert([]interface{}{dev, rt, source}, DeepEquals, []interface{}{int64(9001), int64(9001), mtuSourceAuto})
+
+	// Device and route MTU are overridden independently
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "deviceMTU": 9000}`))
+	c.Assert(err, IsNil)
+	dev, rt, source = endpointMTU(n, conf, log)
+	c.Assert([]interface{}{dev, rt, source}, DeepEquals, []interface{}{int64(9000), int64(1450), mtuSourceNetConf})
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "mtu": 1400, "routeMTU": 1300}`))
+	c.Assert(err, IsNil)
+	dev, rt, source = endpointMTU(n, conf, log)
+	c.Assert([]interface{}{dev, rt, source}, DeepEquals, []interface{}{int64(1400), int64(1300), mtuSourceNetConf})
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "mtu": -1}`))
+	c.Assert(err, ErrorMatches, "invalid mtu -1")
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "deviceMTU": -1}`))
+	c.Assert(err, ErrorMatches, "invalid deviceMTU -1")
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "routeMTU": -1}`))
+	c.Assert(err, ErrorMatches, "invalid routeMTU -1")
+}
+
+func (s *CNISuite) TestMTUMode(c *C) {
+	_, _, err := loadNetConf([]byte(`{"name": "cilium", "mtuMode": "static"}`))
+	c.Assert(err, ErrorMatches, `invalid mtuMode "static"`)
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "mtuMode": "auto", "mtuOverhead": -1}`))
+	c.Assert(err, ErrorMatches, "invalid mtuOverhead -1")
+}
+
+func (s *CNISuite) TestTxQueueLen(c *C) {
+	n, _, err := loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.TxQueueLen, IsNil)
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "txQueueLen": 0}`))
+	c.Assert(err, IsNil)
+	c.Assert(*n.TxQueueLen, Equals, 0)
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "txQueueLen": -1}`))
+	c.Assert(err, ErrorMatches, "invalid txQueueLen -1")
+}
+
+func (s *CNISuite) TestCapabilities(c *C) {
+	n, _, err := loadNetConf([]byte(`{"name": "cilium", "capabilities": {"ips": true, "mac": true},
+		"runtimeConfig": {"ips": ["10.0.0.5/24", "f00d::5"], "mac": "0a:58:0a:00:00:05"}}`))
+	c.Assert(err, IsNil)
+	ips := requestedIPs(n, &cniArgsSpec{})
+	c.Assert(ips, HasLen, 2)
+	c.Assert(ips[0].String(), Equals, "10.0.0.5")
+	c.Assert(ips[1].String(), Equals, "f00d::5")
+	c.Assert(requestedMAC(n).String(), Equals, "0a:58:0a:00:00:05")
+
+	// The runtimeConfig entries are ignored without the capability
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "runtimeConfig": {"ips": ["10.0.0.5"], "mac": "0a:58:0a:00:00:05"}}`))
+	c.Assert(err, IsNil)
+	c.Assert(requestedIPs(n, &cniArgsSpec{}), HasLen, 0)
+	c.Assert(requestedMAC(n), IsNil)
+	ips = requestedIPs(n, &cniArgsSpec{IP: net.ParseIP("10.0.0.6")})
+	c.Assert(ips, HasLen, 1)
+	c.Assert(ips[0].String(), Equals, "10.0.0.6")
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "capabilities": {"bandwidth": true}}`))
+	c.Assert(err, ErrorMatches, `unsupported capability "bandwidth"`)
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "capabilities": {"bandwidth": false}}`))
+	c.Assert(err, IsNil)
+
+	// hostPort mappings are left to the portmap plugin
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "capabilities": {"portMappings": true},
+		"runtimeConfig": {"portMappings": [{"hostPort": 8080, "containerPort": 80, "protocol": "tcp"}]}}`))
+	c.Assert(err, ErrorMatches, `unsupported capability "portMappings"`)
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "runtimeConfig": {"ips": ["10.0.0.5", "10.0.0.6"]}}`))
+	c.Assert(err, ErrorMatches, "invalid runtimeConfig ips: more than one IPv4 address requested")
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "runtimeConfig": {"ips": ["foo"]}}`))
+	c.Assert(err, ErrorMatches, `invalid runtimeConfig ips: invalid IP "foo"`)
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "runtimeConfig": {"mac": "foo"}}`))
+	c.Assert(err, ErrorMatches, `invalid runtimeConfig mac "foo": .*`)
+}
+
+func (s *CNISuite) TestAllocateRequestedIPs(c *C) {
+	hostAddressing := &models.NodeAddressing{}
+	fake := &fakeIPAMClient{}
+	ipam, err := allocateRequestedIPs(fake, []net.IP{net.ParseIP("10.0.0.5"), net.ParseIP("f00d::5")}, "default/foo", "", hostAddressing)
+	c.Assert(err, IsNil)
+	c.Assert(ipam.Address.IPV4, Equals, "10.0.0.5")
+	c.Assert(ipam.Address.IPV6, Equals, "f00d::5")
+	c.Assert(ipam.HostAddressing, Equals, hostAddressing)
+	c.Assert(fake.allocated, DeepEquals, []string{"10.0.0.5", "f00d::5"})
+
+	// The IPv4 address is released again if the IPv6 allocation fails
+	fake = &fakeIPAMClient{errs: map[string]error{"f00d::5": fmt.Errorf("in use")}}
+	_, err = allocateRequestedIPs(fake, []net.IP{net.ParseIP("10.0.0.5"), net.ParseIP("f00d::5")}, "default/foo", "", hostAddressing)
+	c.Assert(err, ErrorMatches, "unable to allocate requested IP f00d::5: in use")
+	c.Assert(fake.released, DeepEquals, []string{"10.0.0.5"})
+}
+
+func (s *CNISuite) TestCommandLogger(c *C) {
+	logger := commandLogger("ADD")
+	c.Assert(logger.Data[logfields.LogSubsys], Equals, "cilium-cni")
+	c.Assert(logger.Data[logCommandSubsys], Equals, "cilium-cni/ADD")
+
+	logger = commandLogger("")
+	c.Assert(logger.Data[logfields.LogSubsys], Equals, "cilium-cni")
+	_, ok := logger.Data[logCommandSubsys]
+	c.Assert(ok, Equals, false)
+}
+
+func (s *CNISuite) TestOpenNetNS(c *C) {
+	dir, err := ioutil.TempDir("", "cilium-cni-netns")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	// Missing namespace
+	missing := filepath.Join(dir, "missing")
+	_, err = openNetNS(0, missing, ns.GetNS)
+	c.Assert(err, FitsTypeOf, &netNSMissingError{})
+	c.Assert(err, ErrorMatches, `netns path ".*/missing" does not exist`)
+
+	// Existing path which is not a namespace
+	file := filepath.Join(dir, "file")
+	c.Assert(ioutil.WriteFile(file, nil, 0600), IsNil)
+	_, err = openNetNS(0, file, ns.GetNS)
+	c.Assert(err, Not(FitsTypeOf), &netNSMissingError{})
+	c.Assert(err, ErrorMatches, `netns ".*/file" exists but can't be entered: .*`)
+
+	// Transient failures to open the namespace are retried
+	opens := 0
+	open := func(path string) (ns.NetNS, error) {
+		opens++
+		if opens < 3 {
+			return nil, unix.EAGAIN
+		}
+		return &fakeNetNS{path: path}, nil
+	}
+	netNs, err := openNetNS(2, file, open)
+	c.Assert(err, IsNil)
+	c.Assert(netNs.Path(), Equals, file)
+	c.Assert(opens, Equals, 3)
+
+	// Retries are bounded
+	opens = 0
+	_, err = openNetNS(1, file, open)
+	c.Assert(err, ErrorMatches, `netns ".*/file" exists but can't be entered: .*`)
+	c.Assert(opens, Equals, 2)
+}
+
+// fakeNetNS is a namespace which fails to be entered with enterErr until
+// enterFailures is exhausted
+type fakeNetNS struct {
+	path          string
+	enterErr      error
+	enterFailures int
+	enters        int
+}
+
+func (f *fakeNetNS) Do(toRun func(ns.NetNS) error) error {
+	f.enters++
+	if f.enterFailures > 0 {
+		f.enterFailures--
+		return f.enterErr
+	}
+	return toRun(f)
+}
+
+func (f *fakeNetNS) Set() error   { return nil }
+func (f *fakeNetNS) Path() string { return f.path }
+func (f *fakeNetNS) Fd() uintptr  { return 0 }
+func (f *fakeNetNS) Close() error { return nil }
+
+func (s *CNISuite) TestNetNSErrors(c *C) {
+	c.Assert(isTransientNetNSError(unix.EAGAIN), Equals, true)
+	c.Assert(isTransientNetNSError(fmt.Errorf("failed to Statfs %q: %v", "/proc/1/ns/net", unix.EBUSY)), Equals, true)
+	c.Assert(isTransientNetNSError(unix.ENOENT), Equals, false)
+
+	c.Assert(isMissingNetNSError(fmt.Errorf("failed to open %q: %v", "/proc/1/ns/net", unix.ENOENT)), Equals, true)
+	c.Assert(isMissingNetNSError(errors.New("Link not found")), Equals, true)
+	c.Assert(isMissingNetNSError(unix.EAGAIN), Equals, false)
+}
+
+func (s *CNISuite) TestRetryNetNSOp(c *C) {
+	failing := func(errs ...error) (func() error, *int) {
+		calls := 0
+		return func() error {
+			calls++
+			if calls <= len(errs) {
+				return errs[calls-1]
+			}
+			return nil
+		}, &calls
+	}
+
+	// Transient errors are retried until the operation succeeds
+	op, calls := failing(unix.EAGAIN, unix.EINTR)
+	c.Assert(retryNetNSOp(2, op), IsNil)
+	c.Assert(*calls, Equals, 3)
+
+	// Transient errors exceeding the retries are returned
+	op, calls = failing(unix.EAGAIN, unix.EAGAIN, unix.EAGAIN)
+	c.Assert(retryNetNSOp(1, op), Equals, unix.EAGAIN)
+	c.Assert(*calls, Equals, 2)
+
+	// Other errors are not retried
+	op, calls = failing(unix.ENOENT)
+	c.Assert(retryNetNSOp(2, op), Equals, unix.ENOENT)
+	c.Assert(*calls, Equals, 1)
+}
+
+func (s *CNISuite) TestDoInNetNS(c *C) {
+	// Transient failures to enter the namespace are retried
+	netNs := &fakeNetNS{enterErr: unix.EAGAIN, enterFailures: 2}
+	runs := 0
+	c.Assert(doInNetNS(2, netNs, func() error {
+		runs++
+		return nil
+	}), IsNil)
+	c.Assert(netNs.enters, Equals, 3)
+	c.Assert(runs, Equals, 1)
+
+	// Failures of the operation itself are never retried
+	netNs = &fakeNetNS{}
+	err := doInNetNS(2, netNs, func() error { return unix.EAGAIN })
+	c.Assert(err, Equals, unix.EAGAIN)
+	c.Assert(netNs.enters, Equals, 1)
+
+	// A missing namespace is not retried
+	netNs = &fakeNetNS{enterErr: unix.ENOENT, enterFailures: 1}
+	err = doInNetNS(2, netNs, func() error { return nil })
+	c.Assert(isMissingNetNSError(err), Equals, true)
+	c.Assert(netNs.enters, Equals, 1)
+}
+
+func (s *CNISuite) TestVerifyIfRemoved(c *C) {
+	netNs := &fakeNetNS{}
+	c.Assert(verifyIfRemoved(netNs, "cilium-missing"), IsNil)
+
+	// The namespace is gone as well
+	netNs = &fakeNetNS{enterErr: unix.ENOENT, enterFailures: ifRemovalChecks}
+	c.Assert(verifyIfRemoved(netNs, "eth0"), IsNil)
+	c.Assert(netNs.enters, Equals, 1)
+
+	netNs = &fakeNetNS{}
+	c.Assert(verifyIfRemoved(netNs, "lo"), ErrorMatches,
+		fmt.Sprintf("interface lo still present after %d checks", ifRemovalChecks))
+	c.Assert(netNs.enters, Equals, ifRemovalChecks)
+}
+
+func (s *CNISuite) TestRecordAllocationDuration(c *C) {
+	start := time.Now().Add(-time.Second)
+
+	ep := &models.EndpointChangeRequest{}
+	recordAllocationDuration(&netConf{}, ep, start)
+	c.Assert(ep.IPAMAllocationDuration, Equals, int64(0))
+
+	recordAllocationDuration(&netConf{ReportMetrics: true}, ep, start)
+	c.Assert(time.Duration(ep.IPAMAllocationDuration) >= time.Second, Equals, true)
+}
+
+func (s *CNISuite) TestNetNSRetries(c *C) {
+	n, _, err := loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.netNSRetries(), Equals, defaultNetNSRetries)
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "netnsRetries": 0}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.netNSRetries(), Equals, 0)
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "netnsRetries": -1}`))
+	c.Assert(err, ErrorMatches, "invalid netnsRetries -1")
+}
+
+func (s *CNISuite) TestEndpointCreateTimeout(c *C) {
+	n, _, err := loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.createTimeout(), Equals, api.ClientTimeout)
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "endpointCreateTimeout": "2m"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.createTimeout(), Equals, 2*time.Minute)
+
+	for _, timeout := range []string{"2", "0s", "-1s"} {
+		_, _, err = loadNetConf([]byte(fmt.Sprintf(`{"name": "cilium", "endpointCreateTimeout": %q}`, timeout)))
+		c.Assert(err, ErrorMatches, fmt.Sprintf("invalid endpointCreateTimeout %q", timeout))
+	}
+}
+
+func (s *CNISuite) TestIPv6DAD(c *C) {
+	for _, dad := range []string{"", ipv6DADDisabled, ipv6DADWait} {
+		n, _, err := loadNetConf([]byte(fmt.Sprintf(`{"name": "cilium", "ipv6DAD": %q}`, dad)))
+		c.Assert(err, IsNil, Commentf("ipv6DAD %q", dad))
+		c.Assert(n.IPv6DAD, Equals, dad)
+	}
+
+	_, _, err := loadNetConf([]byte(`{"name": "cilium", "ipv6DAD": "off"}`))
+	c.Assert(err, ErrorMatches, `invalid ipv6DAD "off"`)
+}
+
+func (s *CNISuite) TestDelRetryPolicy(c *C) {
+	recoverable := client.Hint(fmt.Errorf("dial unix %s: connect: no such file or directory", defaults.SockPath))
+	unrecoverable := client.Hint(fmt.Errorf("[DELETE /endpoint/{id}][500] deleteEndpointIdErrors"))
+	timeout := client.Hint(fmt.Errorf("Delete http://localhost/v1/endpoint/foo: net/http: request canceled (Client.Timeout exceeded while awaiting headers)"))
+
+	n, _, err := loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.delRecoverable(recoverable), Equals, true)
+	c.Assert(n.delRecoverable(unrecoverable), Equals, false)
+	c.Assert(n.delRecoverable(timeout), Equals, false)
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "delRetryPolicy": "never"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.delRecoverable(recoverable), Equals, false)
+	c.Assert(n.delRecoverable(timeout), Equals, false)
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "delRetryPolicy": "timeouts"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.delRecoverable(recoverable), Equals, true)
+	c.Assert(n.delRecoverable(unrecoverable), Equals, false)
+	c.Assert(n.delRecoverable(timeout), Equals, true)
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "delRetryPolicy": "always"}`))
+	c.Assert(err, ErrorMatches, `invalid delRetryPolicy "always"`)
+}
+
+func (s *CNISuite) TestExitCodeFor(c *C) {
+	c.Assert(exitCodeFor(nil), Equals, 0)
+	c.Assert(exitCodeFor(errors.New("foo")), Equals, exitCodeGeneric)
+	c.Assert(exitCodeFor(withExitCode(exitCodeNetNS, errors.New("foo"))), Equals, exitCodeNetNS)
+	c.Assert(withExitCode(exitCodeNetNS, errors.New("foo")), ErrorMatches, "foo")
+	c.Assert(withExitCode(exitCodeNetNS, nil), IsNil)
+
+	unreachable := client.Hint(fmt.Errorf("dial unix %s: connect: no such file or directory", defaults.SockPath))
+	c.Assert(exitCodeFor(unreachable), Equals, exitCodeAgentUnreachable)
+	c.Assert(exitCodeFor(client.Hint(errors.New("[500] failed"))), Equals, exitCodeGeneric)
+
+	c.Assert(exitCodeFor(classifyIPAMError(client.IPAMExhaustedError{}, "")), Equals, exitCodeIPAMExhausted)
+	c.Assert(exitCodeFor(validateIfName("")), Equals, exitCodeValidation)
+	c.Assert(exitCodeFor(&cniTypes.Error{Code: errCodeGeneric}), Equals, exitCodeGeneric)
+
+	code := exitCodeGeneric
+	cmd := func(*skel.CmdArgs) error { return withExitCode(exitCodeValidation, errors.New("foo")) }
+	c.Assert(recordExitCode(cmd, &code)(&skel.CmdArgs{}), ErrorMatches, "foo")
+	c.Assert(code, Equals, exitCodeValidation)
+}
+
+func (s *CNISuite) TestPreAddHook(c *C) {
+	dir, err := ioutil.TempDir("", "cilium-cni-hook")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	writeHook := func(name, script string) string {
+		path := filepath.Join(dir, name)
+		c.Assert(ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0700), IsNil)
+		return path
+	}
+	check := writeHook("check", `[ "$CILIUM_CNI_HOOK" = pre-add ] && [ "$K8S_POD_NAME" = foo ] && [ "$CILIUM_IPV4" = 10.0.0.5 ]`)
+	fail := writeHook("fail", "echo denied; exit 1")
+	slow := writeHook("slow", "exec sleep 5")
+
+	args := &skel.CmdArgs{ContainerID: "abcd", Netns: "/var/run/netns/foo", IfName: "eth0"}
+	env := hookEnv(hookPreAdd, args, cniArgsSpec{K8S_POD_NAMESPACE: "default", K8S_POD_NAME: "foo"}, &models.AddressPair{IPV4: "10.0.0.5"})
+	c.Assert(runHook(check, env, time.Second), IsNil)
+	c.Assert(runHook(fail, env, time.Second), ErrorMatches, "hook .*/fail failed: exit status 1: denied\n")
+	c.Assert(runHook(slow, env, 100*time.Millisecond), ErrorMatches, "hook .*/slow failed: .*context deadline exceeded")
+
+	c.Assert(os.Chmod(check, 0777), IsNil)
+	c.Assert(runHook(check, env, time.Second), ErrorMatches, "hook .*/check is writable by group or others")
+	c.Assert(runHook("check", env, time.Second), ErrorMatches, "hook check is not an absolute path")
+
+	n, _, err := loadNetConf([]byte(`{"name": "cilium", "preAddHook": "/usr/bin/hook"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.preAddHookTimeout, Equals, defaultHookTimeout)
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "preAddHook": "hook"}`))
+	c.Assert(err, ErrorMatches, `invalid preAddHook "hook": must be an absolute path`)
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "preAddHookTimeout": "0s"}`))
+	c.Assert(err, ErrorMatches, `invalid preAddHookTimeout "0s"`)
+}
+
+func (s *CNISuite) TestPostDelHook(c *C) {
+	dir, err := ioutil.TempDir("", "cilium-cni-hook")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	marker := filepath.Join(dir, "marker")
+	hook := filepath.Join(dir, "hook")
+	script := "#!/bin/sh\necho \"$CILIUM_CNI_HOOK $K8S_POD_NAME $CNI_CONTAINERID $CILIUM_IPV4\" > " + marker + "\n"
+	c.Assert(ioutil.WriteFile(hook, []byte(script), 0700), IsNil)
+
+	n, _, err := loadNetConf([]byte(`{"name": "cilium", "postDelHook": "` + hook + `", "postDelHookTimeout": "2s"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.postDelHookTimeout, Equals, 2*time.Second)
+
+	args := &skel.CmdArgs{ContainerID: "abcd", IfName: "eth0"}
+	runPostDelHook(n, args, cniArgsSpec{K8S_POD_NAME: "foo"})
+	out, err := ioutil.ReadFile(marker)
+	c.Assert(err, IsNil)
+	// No addresses are passed to the post-DEL hook
+	c.Assert(string(out), Equals, "post-del foo abcd \n")
+
+	// Failures are not propagated
+	n.PostDelHook = filepath.Join(dir, "missing")
+	runPostDelHook(n, args, cniArgsSpec{})
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "postDelHook": "hook"}`))
+	c.Assert(err, ErrorMatches, `invalid postDelHook "hook": must be an absolute path`)
+}
+
+func (s *CNISuite) TestPolicyExempt(c *C) {
+	n, _, err := loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	direction, err := n.policyExempt(cniArgsSpec{})
+	c.Assert(err, IsNil)
+	c.Assert(direction, Equals, "")
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "policyExempt": "ingress"}`))
+	c.Assert(err, IsNil)
+	direction, err = n.policyExempt(cniArgsSpec{})
+	c.Assert(err, IsNil)
+	c.Assert(direction, Equals, models.EndpointChangeRequestPolicyExemptIngress)
+
+	// CNI_ARGS are refused without the opt-in of the network configuration
+	_, err = n.policyExempt(cniArgsSpec{CILIUM_POLICY_EXEMPT: "both"})
+	c.Assert(err, ErrorMatches, "CILIUM_POLICY_EXEMPT requires allowPolicyExemptArgs .*")
+
+	// CNI_ARGS take precedence once allowed
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "policyExempt": "ingress", "allowPolicyExemptArgs": true}`))
+	c.Assert(err, IsNil)
+	direction, err = n.policyExempt(cniArgsSpec{CILIUM_POLICY_EXEMPT: "both"})
+	c.Assert(err, IsNil)
+	c.Assert(direction, Equals, models.EndpointChangeRequestPolicyExemptBoth)
+
+	_, err = n.policyExempt(cniArgsSpec{CILIUM_POLICY_EXEMPT: "all"})
+	c.Assert(err, ErrorMatches, "invalid CILIUM_POLICY_EXEMPT .*")
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "policyExempt": "Ingress"}`))
+	c.Assert(err, ErrorMatches, "invalid policyExempt .*")
+}
+
+func (s *CNISuite) TestFlushExisting(c *C) {
+	n, _, err := loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.flushExisting(), Equals, true)
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "flushExisting": false}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.flushExisting(), Equals, false)
+}
+
+func (s *CNISuite) TestLimitLabels(c *C) {
+	n, _, err := loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.maxLabels(), Equals, defaultMaxLabels)
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "maxLabels": 3}`))
+	c.Assert(err, IsNil)
+
+	lbls := models.Labels{}
+	for i := 0; i < 1000; i++ {
+		lbls = append(lbls, fmt.Sprintf("mesos:key%d=value", i))
+	}
+	limited := limitLabels(log, lbls, n.maxLabels())
+	c.Assert(limited, DeepEquals, models.Labels{"mesos:key0=value", "mesos:key1=value", "mesos:key2=value"})
+	c.Assert(limitLabels(log, limited, n.maxLabels()), DeepEquals, limited)
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "maxLabels": -1}`))
+	c.Assert(err, ErrorMatches, "invalid maxLabels -1")
+}
+
+func (s *CNISuite) TestSetPreferredSource(c *C) {
+	state := &CmdState{
+		HostAddr: &models.NodeAddressing{
+			IPV4: &models.NodeAddressingElement{IP: "10.1.0.1", AllocRange: "10.1.0.0/16"},
+		},
+	}
+	_, _, err := prepareIP("10.1.0.5", false, state, 1450, 1500, 0, false, false, nil)
+	c.Assert(err, IsNil)
+	_, err = secondaryIPConfig("10.1.0.6", false, state, false)
+	c.Assert(err, IsNil)
+
+	c.Assert(setPreferredSource(state, net.ParseIP("10.1.0.7")), ErrorMatches, ".*not an address of the endpoint")
+	c.Assert(setPreferredSource(state, net.ParseIP("f00d::1")), ErrorMatches, ".*not an address of the endpoint")
+
+	c.Assert(setPreferredSource(state, net.ParseIP("10.1.0.6")), IsNil)
+	srcs := map[string]string{}
+	for _, r := range state.IP4routes {
+		srcs[r.Prefix.String()] = r.Local.String()
+	}
+	c.Assert(srcs, DeepEquals, map[string]string{
+		"10.1.0.1/32": "<nil>",
+		"0.0.0.0/0":   "10.1.0.6",
+	})
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "preferredSource": "foo"}`))
+	c.Assert(err, ErrorMatches, `invalid preferredSource "foo"`)
+}
+
+type fakeReconcileClient struct {
+	conf *models.DaemonConfiguration
+	ep   *models.Endpoint
+	ids  []string
+}
+
+func (f *fakeReconcileClient) ConfigGet() (*models.DaemonConfiguration, error) {
+	return f.conf, nil
+}
+
+func (f *fakeReconcileClient) EndpointGet(id string) (*models.Endpoint, error) {
+	f.ids = append(f.ids, id)
+	if f.ep == nil {
+		return nil, errors.New("endpoint not found")
+	}
+	return f.ep, nil
+}
+
+func (s *CNISuite) TestCreatedEndpointID(c *C) {
+	f := &fakeReconcileClient{}
+	_, err := createdEndpointID(f, "abcd")
+	c.Assert(err, ErrorMatches, "endpoint not found")
+	c.Assert(f.ids, DeepEquals, []string{"container-id:abcd"})
+
+	f.ep = &models.Endpoint{}
+	_, err = createdEndpointID(f, "abcd")
+	c.Assert(err, ErrorMatches, "endpoint of container has no ID")
+
+	f.ep.ID = 1234
+	id, err := createdEndpointID(f, "abcd")
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, int64(1234))
+}
+
+// fakeEndpointDeleteClient serves endpoints by ID and records deletions
+type fakeEndpointDeleteClient struct {
+	eps     map[string]*models.Endpoint
+	deleted []string
+}
+
+func (f *fakeEndpointDeleteClient) EndpointGet(id string) (*models.Endpoint, error) {
+	if ep, ok := f.eps[id]; ok {
+		return ep, nil
+	}
+	return nil, errors.New("endpoint not found")
+}
+
+func (f *fakeEndpointDeleteClient) EndpointDelete(id string) error {
+	f.deleted = append(f.deleted, id)
+	return nil
+}
+
+// fakeEndpointAttachClient records the endpoints patched by ID
+type fakeEndpointAttachClient struct {
+	fakeEndpointDeleteClient
+	patched map[string]*models.EndpointChangeRequest
+}
+
+func (f *fakeEndpointAttachClient) EndpointPatch(id string, ep *models.EndpointChangeRequest) error {
+	if _, err := f.EndpointGet(id); err != nil {
+		return err
+	}
+	f.patched[id] = ep
+	return nil
+}
+
+// fakeProbeClient records the endpoints created and deleted, creation of
+// the container IDs in failCreate fails
+type fakeProbeClient struct {
+	created    []string
+	deleted    []string
+	failCreate map[string]bool
+}
+
+func (f *fakeProbeClient) EndpointCreate(ep *models.EndpointChangeRequest) error {
+	if f.failCreate[ep.ContainerID] {
+		return errors.New("create failed")
+	}
+	f.created = append(f.created, ep.ContainerID)
+	return nil
+}
+
+func (f *fakeProbeClient) EndpointDelete(id string) error {
+	f.deleted = append(f.deleted, id)
+	return nil
+}
+
+func (s *CNISuite) TestProbeEndpointCreate(c *C) {
+	f := &fakeProbeClient{failCreate: map[string]bool{"probe-1": true}}
+	stats := probeEndpointCreate(f, "probe", 3)
+	c.Assert(stats.Iterations, Equals, 3)
+	c.Assert(stats.Failures, Equals, 1)
+	c.Assert(f.created, DeepEquals, []string{"probe-0", "probe-2"})
+	c.Assert(f.deleted, DeepEquals, []string{"container-id:probe-0", "container-id:probe-2"})
+	c.Assert(stats.Create.Count, Equals, 2)
+	c.Assert(stats.Delete.Count, Equals, 2)
+
+	l := newProbeLatency([]time.Duration{3, 1, 2, 4})
+	c.Assert(l, DeepEquals, probeLatency{Count: 4, Min: 1, Max: 4, Mean: 2, P50: 2, P99: 3})
+
+	// The probe never runs unless explicitly enabled
+	os.Unsetenv(probeEnableEnv)
+	c.Assert(cmdProbe(ioutil.Discard), ErrorMatches, "CILIUM_CNI_PROBE must be set to true to run the PROBE command")
+
+	os.Setenv(probeIterationsEnv, "0")
+	defer os.Unsetenv(probeIterationsEnv)
+	_, err := probeIterations()
+	c.Assert(err, ErrorMatches, `invalid CILIUM_CNI_PROBE_ITERATIONS "0": must be a positive integer`)
+}
+
+func (s *CNISuite) TestAttachToExisting(c *C) {
+	f := &fakeEndpointAttachClient{
+		fakeEndpointDeleteClient: fakeEndpointDeleteClient{eps: map[string]*models.Endpoint{
+			"container-id:abcd": {ID: 10},
+		}},
+		patched: map[string]*models.EndpointChangeRequest{},
+	}
+
+	ep, err := existingEndpoint(f, "abcd")
+	c.Assert(err, IsNil)
+	c.Assert(ep.ID, Equals, int64(10))
+	_, err = existingEndpoint(f, "efgh")
+	c.Assert(err, ErrorMatches, "attachToExisting is set but no endpoint of container efgh could be found: endpoint not found")
+
+	req := &models.EndpointChangeRequest{
+		ContainerID:         "abcd",
+		Addressing:          &models.AddressPair{IPV4: "10.0.0.5", IPV6: "f00d::5"},
+		SecondaryAddressing: []*models.AddressPair{{IPV4: "10.0.0.6"}},
+	}
+	c.Assert(attachToEndpoint(f, req), IsNil)
+	patch := f.patched["container-id:abcd"]
+	c.Assert(patch, Not(IsNil))
+	c.Assert(patch.State, Equals, models.EndpointStateWaitingForIdentity)
+	c.Assert(patch.Addressing, IsNil)
+	c.Assert(patch.SecondaryAddressing, DeepEquals, []*models.AddressPair{
+		{IPV4: "10.0.0.5", IPV6: "f00d::5"},
+		{IPV4: "10.0.0.6"},
+	})
+
+	req.ContainerID = "efgh"
+	c.Assert(attachToEndpoint(f, req), Not(IsNil))
+
+	// The addresses of the existing endpoint share the owner and must
+	// not be released
+	n, _, err := loadNetConf([]byte(`{"name": "cilium", "attachToExisting": true}`))
+	c.Assert(err, IsNil)
+	fake := &fakeIPAMClient{}
+	args := &skel.CmdArgs{ContainerID: "abcd"}
+	releaseByIdentity(fake, n, args, cniArgsSpec{K8S_POD_NAMESPACE: "default", K8S_POD_NAME: "foo"})
+	c.Assert(fake.releasedOwners, HasLen, 0)
+}
+
+func (s *CNISuite) TestDeleteEndpointByAddressing(c *C) {
+	ep := func(id int64, mac string) *models.Endpoint {
+		return &models.Endpoint{ID: id, Status: &models.EndpointStatus{Networking: &models.EndpointNetworking{Mac: mac}}}
+	}
+	f := &fakeEndpointDeleteClient{eps: map[string]*models.Endpoint{
+		"ipv4:10.1.0.5": ep(10, "0a:00:00:00:00:01"),
+		"ipv6:f00d::5":  ep(11, "0a:00:00:00:00:02"),
+	}}
+	ips := []net.IP{net.ParseIP("10.1.0.5"), net.ParseIP("f00d::5")}
+
+	// The address has been reassigned to an endpoint with another MAC
+	found, err := deleteEndpointByAddressing(f, "0a:00:00:00:00:03", ips)
+	c.Assert(err, IsNil)
+	c.Assert(found, Equals, false)
+	c.Assert(f.deleted, IsNil)
+
+	found, err = deleteEndpointByAddressing(f, "0a:00:00:00:00:02", ips)
+	c.Assert(err, IsNil)
+	c.Assert(found, Equals, true)
+	c.Assert(f.deleted, DeepEquals, []string{"cilium-local:11"})
+
+	found, err = deleteEndpointByAddressing(f, "0a:00:00:00:00:01", []net.IP{net.ParseIP("10.1.0.6")})
+	c.Assert(err, IsNil)
+	c.Assert(found, Equals, false)
+}
+
+func (s *CNISuite) TestReconcileState(c *C) {
+	f := &fakeReconcileClient{
+		conf: &models.DaemonConfiguration{
+			Status: &models.DaemonConfigurationStatus{
+				Addressing: &models.NodeAddressing{
+					IPV4: &models.NodeAddressingElement{IP: "10.1.0.1", AllocRange: "10.1.0.0/16", Enabled: true},
+					IPV6: &models.NodeAddressingElement{IP: "f00d::1", AllocRange: "f00d::/96", Enabled: false},
+				},
+				DeviceMTU: 1500,
+				RouteMTU:  1450,
+			},
+		},
+	}
+	dir, err := ioutil.TempDir("", "cilium-cni-reconcile")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	n := &netConf{ResultCacheDir: dir}
+
+	_, _, err = reconcileState(f, n, "abcd", "eth0", log)
+	c.Assert(err, ErrorMatches, "unable to retrieve endpoint of container: endpoint not found")
+	c.Assert(f.ids, DeepEquals, []string{"container-id:abcd"})
+
+	f.ep = &models.Endpoint{Status: &models.EndpointStatus{Networking: &models.EndpointNetworking{}}}
+	_, _, err = reconcileState(f, n, "abcd", "eth0", log)
+	c.Assert(err, ErrorMatches, "endpoint of container has no addressing")
+
+	f.ep.Status.Networking.Addressing = []*models.AddressPair{{IPV4: "10.1.0.5", IPV6: "f00d::5"}}
+	f.ep.Status.Networking.Mac = "01:02:03:04:05:06"
+	state, ipam, err := reconcileState(f, n, "abcd", "eth0", log)
+	c.Assert(err, IsNil)
+	c.Assert(ipv4IsEnabled(ipam), Equals, true)
+	c.Assert(ipv6IsEnabled(ipam), Equals, false)
+	c.Assert(state.IP4.String(), Equals, "10.1.0.5")
+	c.Assert(state.IP6.IsSet(), Equals, false)
+	c.Assert(state.IP4routes, HasLen, 2)
+	c.Assert(state.IfMAC.String(), Equals, "01:02:03:04:05:06")
+	c.Assert(state.Secondary, HasLen, 0)
+
+	// Secondary addresses of enabled address families are restored
+	f.ep.Status.Networking.Addressing = append(f.ep.Status.Networking.Addressing,
+		&models.AddressPair{IPV4: "10.1.0.6"}, &models.AddressPair{IPV6: "f00d::6"}, &models.AddressPair{IPV4: "10.1.0.7"})
+	state, _, err = reconcileState(f, n, "abcd", "eth0", log)
+	c.Assert(err, IsNil)
+	c.Assert(state.Secondary, HasLen, 2)
+	c.Assert(state.Secondary[0].String(), Equals, "10.1.0.6")
+	c.Assert(state.Secondary[1].String(), Equals, "10.1.0.7")
+
+	// Only the addresses cached for the interface are restored
+	err = writeResultCache(dir, &cachedAttachment{ContainerID: "abcd", IfName: "eth0", IPs: []string{"10.1.0.5", "10.1.0.7"}})
+	c.Assert(err, IsNil)
+	state, _, err = reconcileState(f, n, "abcd", "eth0", log)
+	c.Assert(err, IsNil)
+	c.Assert(state.Secondary, HasLen, 1)
+	c.Assert(state.Secondary[0].String(), Equals, "10.1.0.7")
+}
+
+func (s *CNISuite) TestConfigChanges(c *C) {
+	before := []string{"addr 10.1.0.5/32", "route 10.1.0.1/32"}
+	after := []string{"addr 10.1.0.5/32", "route 0.0.0.0/0", "route 10.1.0.1/32"}
+	c.Assert(configChanges(before, after), DeepEquals, []string{"route 0.0.0.0/0"})
+	c.Assert(configChanges(after, after), HasLen, 0)
+}
+
+func (s *CNISuite) TestGatewayMAC(c *C) {
+	ep := &models.EndpointChangeRequest{}
+	mac, err := gatewayMAC(log, ep)
+	c.Assert(err, IsNil)
+	c.Assert(mac, IsNil)
+
+	ep.HostMac = "foo"
+	_, err = gatewayMAC(log, ep)
+	c.Assert(err, ErrorMatches, `invalid gateway MAC address "foo".*`)
+
+	ep.HostMac = "0a:0b:0c:0d:0e:0f"
+	mac, err = gatewayMAC(log, ep)
+	c.Assert(err, IsNil)
+	c.Assert(mac.String(), Equals, "0a:0b:0c:0d:0e:0f")
+}
+
+func (s *CNISuite) TestLeaseExpiration(c *C) {
+	exp4 := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	exp6 := exp4.Add(-time.Hour)
+	f := &fakeIPAMClient{
+		expirations: map[string]strfmt.DateTime{
+			client.AddressFamilyIPv4: strfmt.DateTime(exp4),
+			client.AddressFamilyIPv6: strfmt.DateTime(exp6),
+		},
+	}
+
+	// The earliest expiration of both families applies
+	ipam, err := allocateIPs(f, &IPAM{SubnetHint: "10.0.0.0/24"}, "owner", "")
+	c.Assert(err, IsNil)
+	c.Assert(time.Time(ipam.Expiration).Equal(exp6), Equals, true)
+
+	res := &ciliumResult{}
+	res.addLeaseDetails(ipam)
+	c.Assert(res.Cilium.Lease, DeepEquals, &leaseDetails{Expiration: "2019-05-01T11:00:00Z"})
+
+	// Addresses without expiration do not expire
+	ipam, err = allocateIPs(&fakeIPAMClient{}, &IPAM{}, "owner", "")
+	c.Assert(err, IsNil)
+	res = &ciliumResult{}
+	res.addLeaseDetails(ipam)
+	c.Assert(res.Cilium, IsNil)
+}
+
+func (s *CNISuite) TestPoolDetails(c *C) {
+	ipam, err := allocateIPs(&fakeIPAMClient{}, &IPAM{}, "owner", "")
+	c.Assert(err, IsNil)
+
+	res := &ciliumResult{}
+	res.addPoolDetails(ipam)
+	c.Assert(res.Cilium.Pools, DeepEquals, &poolDetails{IPv4: "default", IPv6: "default"})
+
+	// The pool of a disabled family is not reported
+	c.Assert(disableFamilies(&fakeIPAMClient{}, &netConf{DisableIPv6: true}, ipam), IsNil)
+	res = &ciliumResult{}
+	res.addPoolDetails(ipam)
+	c.Assert(res.Cilium.Pools, DeepEquals, &poolDetails{IPv4: "default"})
+
+	// No pool is reported without a pool concept
+	res = &ciliumResult{}
+	res.addPoolDetails(&models.IPAMResponse{Address: &models.AddressPair{IPV4: "10.0.0.1"}})
+	c.Assert(res.Cilium, IsNil)
+}
+
+func (s *CNISuite) TestDisableFamilies(c *C) {
+	f := &fakeIPAMClient{}
+	ipam, err := allocateIPs(f, &IPAM{}, "owner", "")
+	c.Assert(err, IsNil)
+	c.Assert(disableFamilies(f, &netConf{DisableIPv6: true}, ipam), IsNil)
+	c.Assert(ipam.Address, DeepEquals, &models.AddressPair{IPV4: "10.0.0.1"})
+	c.Assert(ipv6IsEnabled(ipam), Equals, false)
+	c.Assert(ipv4IsEnabled(ipam), Equals, true)
+	c.Assert(f.released, DeepEquals, []string{"f00d::1"})
+
+	// No address remains
+	f = &fakeIPAMClient{}
+	ipam = &models.IPAMResponse{Address: &models.AddressPair{IPV4: "10.0.0.1"}}
+	c.Assert(disableFamilies(f, &netConf{DisableIPv4: true}, ipam), ErrorMatches, ".*enabled for the endpoint")
+	c.Assert(f.released, DeepEquals, []string{"10.0.0.1"})
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "disableIPv4": true, "disableIPv6": true}`))
+	c.Assert(err, ErrorMatches, "disableIPv4 and disableIPv6 are mutually exclusive")
+}
+
+func (s *CNISuite) TestArgsLabels(c *C) {
+	args := "IgnoreUnknown=1;CILIUM_LABEL_app=web;K8S_POD_NAME=foo;CILIUM_LABEL_=bar;CILIUM_LABEL_tier=front;CILIUM_LABEL_app=db"
+
+	lbls, rest := splitArgsLabels(args, "")
+	c.Assert(lbls, IsNil)
+	c.Assert(rest, Equals, args)
+
+	lbls, rest = splitArgsLabels(args, "CILIUM_LABEL_")
+	c.Assert(lbls, DeepEquals, []argLabel{{"app", "web"}, {"tier", "front"}, {"app", "db"}})
+	c.Assert(rest, Equals, "IgnoreUnknown=1;K8S_POD_NAME=foo;CILIUM_LABEL_=bar")
+
+	cniArgs := cniArgsSpec{}
+	c.Assert(cniTypes.LoadArgs(rest, &cniArgs), IsNil)
+	c.Assert(string(cniArgs.K8S_POD_NAME), Equals, "foo")
+
+	// Mesos labels take precedence, repeated keys are skipped
+	existing := models.Labels{"mesos:tier=back"}
+	c.Assert(addArgsLabels(log, existing, labels.LabelSourceContainer, lbls), DeepEquals,
+		models.Labels{"mesos:tier=back", "container:app=web"})
+
+	n, _, err := loadNetConf([]byte(`{"name": "cilium", "labelSources": {"cni-args": "k8s"}}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.labelSource(labelGroupArgs), Equals, labels.LabelSourceK8s)
+}
+
+func (s *CNISuite) TestServiceAccountLabels(c *C) {
+	cniArgs := cniArgsSpec{}
+	c.Assert(cniTypes.LoadArgs("K8S_POD_NAME=foo;K8S_POD_SERVICE_ACCOUNT=frontend", &cniArgs), IsNil)
+
+	// Disabled by default
+	n, _, err := loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	lbls, err := serviceAccountLabels(n, cniArgs)
+	c.Assert(err, IsNil)
+	c.Assert(lbls, IsNil)
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "serviceAccountLabel": true}`))
+	c.Assert(err, IsNil)
+	lbls, err = serviceAccountLabels(n, cniArgs)
+	c.Assert(err, IsNil)
+	c.Assert(addArgsLabels(log, nil, n.labelSource(labelGroupServiceAccount), lbls), DeepEquals,
+		models.Labels{"k8s:io.cilium.k8s.policy.serviceaccount=frontend"})
+
+	// Not passed by the runtime
+	lbls, err = serviceAccountLabels(n, cniArgsSpec{})
+	c.Assert(err, IsNil)
+	c.Assert(lbls, IsNil)
+
+	cniArgs.K8S_POD_SERVICE_ACCOUNT = "Front_End"
+	_, err = serviceAccountLabels(n, cniArgs)
+	c.Assert(err, ErrorMatches, `invalid K8S_POD_SERVICE_ACCOUNT "Front_End": .*`)
+}
+
+func (s *CNISuite) TestAvailabilityZone(c *C) {
+	n, _, err := loadNetConf([]byte(`{"name": "cilium", "availabilityZone": "us-west-1a"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.AvailabilityZone, Equals, "us-west-1a")
+	c.Assert(n.labelSource(labelGroupZone), Equals, labels.LabelSourceContainer)
+
+	// A label with the same key passed in CNI_ARGS takes precedence
+	existing := models.Labels{"container:" + zoneLabelKey + "=us-west-1b"}
+	zone := []argLabel{{key: zoneLabelKey, value: n.AvailabilityZone}}
+	c.Assert(addArgsLabels(log, existing, n.labelSource(labelGroupZone), zone), DeepEquals, existing)
+	c.Assert(addArgsLabels(log, nil, n.labelSource(labelGroupZone), zone), DeepEquals,
+		models.Labels{"container:" + zoneLabelKey + "=us-west-1a"})
+
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "availabilityZone": "a", "labelSources": {"zone": "k8s"}}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.labelSource(labelGroupZone), Equals, labels.LabelSourceK8s)
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "availabilityZone": "zone a!"}`))
+	c.Assert(err, ErrorMatches, `invalid availabilityZone "zone a!": .*`)
+}
+
+func (s *CNISuite) TestPrepareIPNoGateway(c *C) {
+	state := &CmdState{
+		HostAddr: &models.NodeAddressing{
+			IPV4: &models.NodeAddressingElement{AllocRange: "10.1.0.0/16"},
+		},
+	}
+
+	// A gateway is required by default
+	_, _, err := prepareIP("10.1.0.5", false, state, 1450, 1500, 0, false, false, nil)
+	c.Assert(err, ErrorMatches, "Invalid gateway address: ")
+
+	ipConfig, routes, err := prepareIP("10.1.0.5", false, state, 1450, 1500, 0, false, true, nil)
+	c.Assert(err, IsNil)
+	c.Assert(ipConfig.Gateway, IsNil)
+	c.Assert(routes, HasLen, 1)
+	c.Assert(routes[0].Dst.String(), Equals, "0.0.0.0/0")
+	c.Assert(routes[0].GW, IsNil)
+	c.Assert(state.IP4routes, HasLen, 1)
+	c.Assert(state.IP4routes[0].Scope, Equals, netlink.SCOPE_LINK)
+	c.Assert(state.IP4routes[0].Nexthop, IsNil)
+
+	// Additional routes need a gateway or link scope
+	extra := []Route{{Dst: "192.168.0.0/24", Scope: "link"}}
+	_, routes, err = prepareIP("10.1.0.5", false, state, 1450, 1500, 0, false, true, extra)
+	c.Assert(err, IsNil)
+	c.Assert(routes, HasLen, 2)
+	c.Assert(state.IP4routes[1].Nexthop, IsNil)
+
+	extra = []Route{{Dst: "192.168.0.0/24"}}
+	_, _, err = prepareIP("10.1.0.5", false, state, 1450, 1500, 0, false, true, extra)
+	c.Assert(err, ErrorMatches, "route to 192.168.0.0/24 requires a gateway or link scope")
+
+	_, err = secondaryIPConfig("10.1.0.6", false, state, true)
+	c.Assert(err, IsNil)
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "noGateway": true, "staticNeigh": true}`))
+	c.Assert(err, ErrorMatches, "staticNeigh requires a gateway.*")
+}
+
+func (s *CNISuite) TestAddSlot(c *C) {
+	dir, err := ioutil.TempDir("", "cilium-cni-lock")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	slot1, err := acquireAddSlot(dir, 2, 0)
+	c.Assert(err, IsNil)
+	slot2, err := acquireAddSlot(dir, 2, 0)
+	c.Assert(err, IsNil)
+	c.Assert(slot1.Name(), Not(Equals), slot2.Name())
+
+	_, err = acquireAddSlot(dir, 2, 0)
+	c.Assert(err, ErrorMatches, "timeout waiting for one of 2 concurrent ADDs to complete")
+
+	// A waiting ADD gets the slot once it is released
+	go func() {
+		time.Sleep(2 * addLockRetryInterval)
+		slot1.Close()
+	}()
+	slot3, err := acquireAddSlot(dir, 2, 10*time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(slot3.Name(), Equals, slot1.Name())
+	slot2.Close()
+	slot3.Close()
+
+	_, _, err = loadNetConf([]byte(`{"name": "cilium", "maxConcurrentAdds": -1}`))
+	c.Assert(err, ErrorMatches, "invalid maxConcurrentAdds -1")
+}
+
+func (s *CNISuite) TestCheckIPv6Scope(c *C) {
+	f := &fakeIPAMClient{}
+	ipam := &models.IPAMResponse{Address: &models.AddressPair{IPV4: "10.0.0.1", IPV6: "fd00::1"}}
+	c.Assert(checkIPv6Scope(f, &IPAM{}, ipam), IsNil)
+	c.Assert(checkIPv6Scope(f, &IPAM{IPv6Scope: ipv6ScopeULA}, ipam), IsNil)
+	c.Assert(f.released, HasLen, 0)
+
+	// All addresses are released if the scope does not match
+	c.Assert(checkIPv6Scope(f, &IPAM{IPv6Scope: ipv6ScopeGUA}, ipam), ErrorMatches, "allocated IPv6 address fd00::1 is not a GUA address")
+	c.Assert(f.released, DeepEquals, []string{"10.0.0.1", "fd00::1"})
+
+	f = &fakeIPAMClient{}
+	ipam.Address.IPV6 = "2001:db8::1"
+	c.Assert(checkIPv6Scope(f, &IPAM{IPv6Scope: ipv6ScopeGUA}, ipam), IsNil)
+	c.Assert(checkIPv6Scope(f, &IPAM{IPv6Scope: ipv6ScopeULA}, ipam), ErrorMatches, ".* is not a ULA address")
+
+	// IPv4-only allocations are not affected
+	ipam.Address.IPV6 = ""
+	c.Assert(checkIPv6Scope(f, &IPAM{IPv6Scope: ipv6ScopeULA}, ipam), IsNil)
+
+	_, _, err := loadNetConf([]byte(`{"name": "cilium", "ipam": {"ipv6Scope": "link"}}`))
+	c.Assert(err, ErrorMatches, `invalid ipv6Scope "link"`)
+}
+
+func (s *CNISuite) TestVerboseResult(c *C) {
+	dir, err := ioutil.TempDir("", "cilium-cni-verbose")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	gw := net.ParseIP("10.1.0.1")
+	res := &ciliumResult{}
+	res.IPs = []*cniTypesVer.IPConfig{{Version: "4", Gateway: gw}}
+	res.details().EndpointID = 1234
+	res.details().Pools = &poolDetails{IPv4: "default"}
+	mtu := mtuDetails{Device: 1500, Route: 1450, Source: mtuSourceAgent}
+	v := newVerboseResult("abcd", "eth0", res, mtu, map[string]string{timingIPAMAllocate: "1ms"})
+	c.Assert(v.EndpointID, Equals, int64(1234))
+	c.Assert(v.IPv4Gateway, Equals, "10.1.0.1")
+	c.Assert(v.IPv6Gateway, Equals, "")
+
+	c.Assert(writeVerboseResult(dir, v), IsNil)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "abcd-eth0.json"))
+	c.Assert(err, IsNil)
+	written := &verboseResult{}
+	c.Assert(json.Unmarshal(data, written), IsNil)
+	c.Assert(written, DeepEquals, v)
+
+	// No temporary files are left behind
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 1)
+}
+
+func (s *CNISuite) TestResultCopy(c *C) {
+	dir, err := ioutil.TempDir("", "cilium-cni-result")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	_, ipNet, err := net.ParseCIDR("10.1.0.2/32")
+	c.Assert(err, IsNil)
+	res := &ciliumResult{}
+	res.IPs = []*cniTypesVer.IPConfig{{Version: "4", Address: *ipNet, Gateway: net.ParseIP("10.1.0.1")}}
+	res.details().EndpointID = 1234
+
+	// The copy matches the result printed to the runtime
+	for _, version := range []string{"0.2.0", "0.4.0"} {
+		c.Assert(writeResultCopy(dir, "abcd", "eth0", res, version), IsNil)
+		data, err := ioutil.ReadFile(filepath.Join(dir, "abcd-eth0.result.json"))
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, string(printResult(c, res, version)))
+	}
+
+	c.Assert(writeResultCopy(dir, "abcd", "eth0", res, "9.9.9"), Not(IsNil))
+}
+
+func (s *CNISuite) TestResultCache(c *C) {
+	dir, err := ioutil.TempDir("", "cilium-cni-cache")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	// Without a cache, DEL discovers the attachment
+	a, err := readResultCache(dir, "abcd", "eth0")
+	c.Assert(err, IsNil)
+	c.Assert(a, IsNil)
+
+	ep := &models.EndpointChangeRequest{
+		Mac:                 "02:00:00:00:00:01",
+		Addressing:          &models.AddressPair{IPV4: "10.0.0.5", IPV6: "f00d::5"},
+		SecondaryAddressing: []*models.AddressPair{{IPV4: "10.0.0.6"}},
+	}
+	cached := newCachedAttachment("abcd", "eth0", ep, 1234, "lxcabcd")
+	c.Assert(cached.IPs, DeepEquals, []string{"f00d::5", "10.0.0.5", "10.0.0.6"})
+	c.Assert(cached.endpointID(), Equals, "cilium-local:1234")
+	c.Assert(writeResultCache(dir, cached), IsNil)
+
+	a, err = readResultCache(dir, "abcd", "eth0")
+	c.Assert(err, IsNil)
+	c.Assert(a, DeepEquals, cached)
+	c.Assert(a.ips(), HasLen, 3)
+	c.Assert(a.MAC, Equals, "02:00:00:00:00:01")
+	c.Assert(a.HostInterface, Equals, "lxcabcd")
+
+	// Without the endpoint ID, the endpoint is deleted by container ID
+	a.EndpointID = 0
+	c.Assert(a.endpointID(), Equals, "container-id:abcd")
+
+	// A cache of another attachment is never used
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "efgh-eth0.json"), []byte(`{"containerID": "abcd", "ifName": "eth0"}`), 0600), IsNil)
+	_, err = readResultCache(dir, "efgh", "eth0")
+	c.Assert(err, ErrorMatches, ".* belongs to container abcd interface eth0")
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "efgh-eth0.json"), []byte(`{`), 0600), IsNil)
+	_, err = readResultCache(dir, "efgh", "eth0")
+	c.Assert(err, ErrorMatches, "invalid result cache .*")
+
+	// Removed on successful DEL
+	c.Assert(removeResultCache(dir, "abcd", "eth0"), IsNil)
+	c.Assert(removeResultCache(dir, "abcd", "eth0"), IsNil)
+	a, err = readResultCache(dir, "abcd", "eth0")
+	c.Assert(err, IsNil)
+	c.Assert(a, IsNil)
+
+	n, _, err := loadNetConf([]byte(`{"name": "cilium"}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.resultCacheDir(), Equals, defaultResultCacheDir)
+	n, _, err = loadNetConf([]byte(`{"name": "cilium", "disableResultCache": true}`))
+	c.Assert(err, IsNil)
+	c.Assert(n.resultCacheDir(), Equals, "")
+}
+
+func (s *CNISuite) TestHasCachedAttachment(c *C) {
+	dir := c.MkDir()
+	n := &netConf{ResultCacheDir: dir}
+	c.Assert(writeResultCache(dir, &cachedAttachment{ContainerID: "abc", IfName: "net1"}), IsNil)
+	c.Assert(hasCachedAttachment(n, "abc", "net1"), Equals, true)
+	c.Assert(hasCachedAttachment(n, "abc", "net2"), Equals, false)
+
+	n.DisableResultCache = true
+	c.Assert(hasCachedAttachment(n, "abc", "net1"), Equals, false)
+}
+
+// gcEndpoint returns an endpoint of the container attached to the network
+// on the given interface
+func gcEndpoint(id int64, containerID, network, ifName string) *models.Endpoint {
+	ep := &models.Endpoint{
+		ID: id,
+		Status: &models.EndpointStatus{
+			ExternalIdentifiers: &models.EndpointIdentifiers{ContainerID: containerID},
+		},
+	}
+	if network != "" {
+		ep.Status.Properties = attachmentProperties(network, ifName)
+	}
+	return ep
+}
+
+// fakeGCClient serves a list of endpoints and records deletions, deletion
+// of the endpoint IDs in failDelete fails
+type fakeGCClient struct {
+	eps        []*models.Endpoint
+	deleted    []string
+	failDelete map[string]bool
+}
+
+func (f *fakeGCClient) EndpointList() ([]*models.Endpoint, error) {
+	return f.eps, nil
+}
+
+func (f *fakeGCClient) EndpointDelete(id string) error {
+	if f.failDelete[id] {
+		return errors.New("delete failed")
+	}
+	f.deleted = append(f.deleted, id)
+	return nil
+}
+
+func (s *CNISuite) TestGCEmptyValidAttachments(c *C) {
+	// An empty set is ignored before connecting to the agent
+	c.Assert(cmdGC(strings.NewReader(`{"cniVersion": "1.1.0", "name": "cilium"}`)), IsNil)
+	c.Assert(cmdGC(strings.NewReader(`{"cniVersion": "1.1.0", "name": "cilium", "cni.dev/valid-attachments": []}`)), IsNil)
+}
+
+func (s *CNISuite) TestGCEndpoints(c *C) {
+	docker := gcEndpoint(6, "docker", "cilium", "eth0")
+	docker.Status.ExternalIdentifiers.DockerEndpointID = "abcd"
+	f := &fakeGCClient{eps: []*models.Endpoint{
+		gcEndpoint(1, "valid", "cilium", "eth0"),
+		gcEndpoint(2, "stale", "cilium", "eth0"),
+		// Second interface of a valid container
+		gcEndpoint(3, "valid", "cilium", "net1"),
+		// Attachments of other networks and endpoints without a
+		// recorded attachment are never deleted
+		gcEndpoint(4, "stale", "other", "eth0"),
+		gcEndpoint(5, "stale", "", ""),
+		docker,
+		{ID: 7},
+	}}
+	valid := map[gcAttachment]struct{}{
+		{ContainerID: "valid", IfName: "eth0"}: {},
+	}
+	c.Assert(gcEndpoints(f, "cilium", valid), IsNil)
+	c.Assert(f.deleted, DeepEquals, []string{"2", "3"})
+}
+
+func (s *CNISuite) TestGCEndpointsPartialFailure(c *C) {
+	f := &fakeGCClient{
+		eps: []*models.Endpoint{
+			gcEndpoint(1, "a", "cilium", "eth0"),
+			gcEndpoint(2, "b", "cilium", "eth0"),
+			gcEndpoint(3, "c", "cilium", "eth0"),
+		},
+		failDelete: map[string]bool{"2": true},
+	}
+	err := gcEndpoints(f, "cilium", map[gcAttachment]struct{}{})
+	c.Assert(err, ErrorMatches, "unable to remove stale endpoints: endpoint 2: delete failed")
+	// A failure does not prevent the remaining endpoints from being deleted
+	c.Assert(f.deleted, DeepEquals, []string{"1", "3"})
+}
+
+func (s *CNISuite) TestPrevResult100(c *C) {
+	// Previous results of version 1.0.0 omit the version of the addresses
+	n, _, err := loadNetConf([]byte(`{"cniVersion": "1.0.0", "name": "cilium", "prevResult": {"cniVersion": "1.0.0", "ips": [{"address": "10.0.0.2/32"}, {"address": "f00d::2/128"}]}}`))
+	c.Assert(err, IsNil)
+	prev, err := getPrevResult(n)
+	c.Assert(err, IsNil)
+	c.Assert(prev.IPs, HasLen, 2)
+	c.Assert(prev.IPs[0].Version, Equals, "4")
+	c.Assert(prev.IPs[1].Version, Equals, "6")
+}
+
+func (s *CNISuite) TestPrevResult110(c *C) {
+	n, _, err := loadNetConf([]byte(`{"cniVersion": "1.1.0", "name": "cilium", "prevResult": {"cniVersion": "1.1.0", "ips": [{"address": "10.0.0.2/32"}]}}`))
+	c.Assert(err, IsNil)
+	prev, err := getPrevResult(n)
+	c.Assert(err, IsNil)
+	c.Assert(prev.IPs, HasLen, 1)
+	c.Assert(prev.IPs[0].Version, Equals, "4")
+}
+
+type fakeStatusClient struct {
+	err error
+}
+
+func (f *fakeStatusClient) ConfigGet() (*models.DaemonConfiguration, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &models.DaemonConfiguration{}, nil
+}
+
+func (s *CNISuite) TestStatus(c *C) {
+	c.Assert(agentStatus(&fakeStatusClient{}), IsNil)
+
+	err := agentStatus(&fakeStatusClient{err: errors.New("connection refused")})
+	c.Assert(err, ErrorMatches, "unable to connect to Cilium daemon; connection refused")
+	c.Assert(err.(*cniTypes.Error).Code, Equals, uint(errCodePluginNotAvailable))
+	c.Assert(err.(*cniTypes.Error).Details, Equals, "connection refused")
+
+	// An invalid network configuration is reported before connecting to
+	// the agent
+	err = cmdStatus(strings.NewReader(`{"cniVersion": "1.1.0", "name": "cilium", "mtu": -1}`))
+	c.Assert(err, ErrorMatches, "invalid mtu -1")
+}
+
+func (s *CNISuite) TestCheckEndpoint(c *C) {
+	f := &fakeGCClient{eps: []*models.Endpoint{
+		gcEndpoint(1, "a", "cilium", "eth0"),
+		gcEndpoint(2, "b", "", ""),
+	}}
+	c.Assert(checkEndpoint(f, "a", "eth0"), IsNil)
+	c.Assert(checkEndpoint(f, "a", "net1"), ErrorMatches, "no endpoint found for container a interface net1")
+	// Endpoints without a recorded interface match by container only
+	c.Assert(checkEndpoint(f, "b", "eth0"), IsNil)
+	c.Assert(checkEndpoint(f, "c", "eth0"), ErrorMatches, "no endpoint found for container c interface eth0")
+}
@@ -0,0 +1,261 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/api"
+	"github.com/cilium/cilium/pkg/client"
+	endpointid "github.com/cilium/cilium/pkg/endpoint/id"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// endpointBuildModeSync always builds the endpoint synchronously
+	endpointBuildModeSync = "sync"
+
+	// endpointBuildModeAuto builds the endpoint asynchronously while the
+	// agent is backlogged
+	endpointBuildModeAuto = "auto"
+
+	// endpointReadyTimeout is the maximum time to poll for an
+	// asynchronously built endpoint to become ready
+	endpointReadyTimeout = 2 * time.Second
+
+	// endpointReadyInterval is the interval in which the state of an
+	// asynchronously built endpoint is polled
+	endpointReadyInterval = 100 * time.Millisecond
+)
+
+// syncBuild returns true if the endpoint must be built synchronously. In
+// "auto" mode, the endpoint is built asynchronously while the agent is
+// restoring endpoints or has endpoint builds queued.
+func (n *netConf) syncBuild(conf *models.DaemonConfigurationStatus) bool {
+	if n.EndpointBuildMode != endpointBuildModeAuto {
+		return true
+	}
+	return !conf.EndpointRestoreInProgress && conf.EndpointBuildsQueued == 0
+}
+
+// waitForEndpointReady polls the agent until the endpoint is ready or the
+// timeout expires. It returns false if the endpoint did not become ready.
+func waitForEndpointReady(c *client.Client, id string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		ep, err := c.EndpointGet(id)
+		if err == nil && ep.Status != nil && ep.Status.State == models.EndpointStateReady {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(endpointReadyInterval)
+	}
+}
+
+// endpointGetter is the subset of the agent API used to look up an endpoint
+type endpointGetter interface {
+	EndpointGet(id string) (*models.Endpoint, error)
+}
+
+// createdEndpointID returns the ID assigned by the agent to the endpoint of
+// the container
+func createdEndpointID(c endpointGetter, containerID string) (int64, error) {
+	ep, err := c.EndpointGet(endpointid.NewID(endpointid.ContainerIdPrefix, containerID))
+	if err != nil {
+		return 0, err
+	}
+	if ep.ID == 0 {
+		return 0, fmt.Errorf("endpoint of container has no ID")
+	}
+	return ep.ID, nil
+}
+
+// createTimeout returns the timeout of the endpoint creation request
+func (n *netConf) createTimeout() time.Duration {
+	if n.endpointCreateTimeout != 0 {
+		return n.endpointCreateTimeout
+	}
+	return api.ClientTimeout
+}
+
+// createJitter returns a random delay to wait before creating the endpoint.
+// It is zero unless a maximum jitter is configured and either the agent
+// reports to be busy restoring endpoints or the jitter is always applied.
+func (n *netConf) createJitter(conf *models.DaemonConfigurationStatus) time.Duration {
+	if n.endpointCreateJitter <= 0 {
+		return 0
+	}
+	if !n.EndpointCreateJitterAlways && !conf.EndpointRestoreInProgress {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(n.endpointCreateJitter)))
+}
+
+// requestProperties returns the endpoint properties recording the CNI
+// request. Only well-known fields are recorded, CNI_ARGS may contain
+// arbitrary values including credentials and is never copied as a whole.
+// The interface is always recorded, see attachmentProperties.
+func requestProperties(args *skel.CmdArgs, cniArgs cniArgsSpec) map[string]string {
+	props := map[string]string{
+		"cni-container-id": args.ContainerID,
+		"cni-netns":        args.Netns,
+	}
+	if cniArgs.K8S_POD_NAMESPACE != "" {
+		props["cni-pod-namespace"] = string(cniArgs.K8S_POD_NAMESPACE)
+	}
+	if cniArgs.K8S_POD_NAME != "" {
+		props["cni-pod-name"] = string(cniArgs.K8S_POD_NAME)
+	}
+	return props
+}
+
+const (
+	// delRetryPolicyDefault returns errors which the agent client
+	// classifies as recoverable, e.g. the agent not running, and
+	// ignores all others. Endpoints may leak if the agent keeps failing
+	// with an unrecoverable error.
+	delRetryPolicyDefault = "default"
+
+	// delRetryPolicyNever never returns errors of the agent API. The
+	// pod is always torn down without delay, at the risk of leaking
+	// the endpoint and its addresses if the agent is unavailable.
+	delRetryPolicyNever = "never"
+
+	// delRetryPolicyTimeouts returns timeouts of API requests in
+	// addition to the errors of the default policy. A busy agent delays
+	// the termination of the pod instead of leaking the endpoint.
+	delRetryPolicyTimeouts = "timeouts"
+)
+
+// delRecoverable returns true if the error of the agent API is returned on
+// DEL to have the runtime retry the deletion
+func (n *netConf) delRecoverable(err error) bool {
+	switch n.DelRetryPolicy {
+	case delRetryPolicyNever:
+		return false
+	case delRetryPolicyTimeouts:
+		if isTimeout(err) {
+			return true
+		}
+	}
+	clientErr, ok := err.(client.ClientError)
+	return ok && clientErr.Recoverable()
+}
+
+// isTimeout returns true if the error is the result of a timed out request
+// to the agent. The client does not preserve the type of the error, the
+// message is inspected as a fallback.
+func isTimeout(err error) bool {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "timeout") || strings.Contains(msg, "deadline exceeded")
+}
+
+// endpointDeleteClient is the subset of the agent API used to delete an
+// endpoint by its addressing
+type endpointDeleteClient interface {
+	endpointGetter
+	EndpointDelete(id string) error
+}
+
+// ifaceAddressing returns the MAC address and the global unicast addresses
+// of the interface in the namespace
+func ifaceAddressing(retries int, netNs ns.NetNS, ifName string) (string, []net.IP, error) {
+	var (
+		mac string
+		ips []net.IP
+	)
+	err := doInNetNS(retries, netNs, func() error {
+		l, err := netlink.LinkByName(ifName)
+		if err != nil {
+			return err
+		}
+		addrs, err := netlink.AddrList(l, netlink.FAMILY_ALL)
+		if err != nil {
+			return err
+		}
+		mac = l.Attrs().HardwareAddr.String()
+		for _, a := range addrs {
+			if a.IP.IsGlobalUnicast() {
+				ips = append(ips, a.IP)
+			}
+		}
+		return nil
+	})
+	return mac, ips, err
+}
+
+// deleteEndpointByAddressing deletes the endpoint with one of the given
+// addresses. The MAC address of the endpoint must match as the address may
+// have been reassigned to another endpoint. Returns whether an endpoint was
+// deleted.
+func deleteEndpointByAddressing(c endpointDeleteClient, mac string, ips []net.IP) (bool, error) {
+	for _, ip := range ips {
+		ep, err := c.EndpointGet(endpointid.NewIPPrefixID(ip))
+		if err != nil || ep.Status == nil || ep.Status.Networking == nil {
+			continue
+		}
+		if ep.Status.Networking.Mac != mac {
+			log.WithFields(logrus.Fields{
+				logfields.IPAddr:     ip,
+				logfields.EndpointID: ep.ID,
+			}).Debug("Endpoint with address of container namespace has a different MAC, not deleting")
+			continue
+		}
+		if err := c.EndpointDelete(endpointid.NewCiliumID(ep.ID)); err != nil {
+			return false, err
+		}
+		log.WithFields(logrus.Fields{
+			logfields.IPAddr:     ip,
+			logfields.EndpointID: ep.ID,
+		}).Info("Deleted endpoint by addressing of container namespace")
+		return true, nil
+	}
+	return false, nil
+}
+
+// deleteEndpointByNetNS deletes the endpoint of the interface of the request
+// in the namespace by the addressing of the interface. Returns whether an
+// endpoint was deleted.
+func deleteEndpointByNetNS(c endpointDeleteClient, n *netConf, args *skel.CmdArgs) (bool, error) {
+	if args.Netns == "" {
+		return false, nil
+	}
+	netNs, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return false, err
+	}
+	defer netNs.Close()
+
+	mac, ips, err := ifaceAddressing(n.netNSRetries(), netNs, args.IfName)
+	if err != nil {
+		return false, err
+	}
+	return deleteEndpointByAddressing(c, mac, ips)
+}
@@ -0,0 +1,53 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/cilium/cilium/api/v1/models"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/sirupsen/logrus"
+)
+
+// Phases of an ADD reported on failure
+const (
+	addPhaseSetup     = "interface-setup"
+	addPhaseIPAM      = "ip-allocation"
+	addPhaseConfigure = "interface-configuration"
+	addPhaseEndpoint  = "endpoint-creation"
+)
+
+// cniEventClient is the subset of the agent client used to report events
+type cniEventClient interface {
+	CNIEventPost(ev *models.CNIEvent) error
+}
+
+// reportAddFailure reports the failed ADD of a Kubernetes pod to the agent.
+// Errors are only logged so that they never mask the error of the ADD.
+func reportAddFailure(c cniEventClient, logger *logrus.Entry, args *skel.CmdArgs, cniArgs cniArgsSpec, phase string, addErr error) {
+	if cniArgs.K8S_POD_NAMESPACE == "" || cniArgs.K8S_POD_NAME == "" {
+		return
+	}
+	ev := &models.CNIEvent{
+		K8sNamespace: string(cniArgs.K8S_POD_NAMESPACE),
+		K8sPodName:   string(cniArgs.K8S_POD_NAME),
+		ContainerID:  args.ContainerID,
+		Phase:        phase,
+		Error:        addErr.Error(),
+	}
+	if err := c.CNIEventPost(ev); err != nil {
+		logger.WithError(err).Warn("Unable to report failed ADD to cilium-agent")
+	}
+}
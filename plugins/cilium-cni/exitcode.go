@@ -0,0 +1,115 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/cilium/cilium/pkg/client"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+)
+
+// Exit codes of the plugin. The CNI specification only requires a non-zero
+// exit code on failure, runtimes rely on the error printed to stdout. The
+// distinct codes allow scripts invoking the plugin directly, e.g. via
+// cnitool, to tell failure classes apart.
+//
+//	0  success
+//	1  any failure not covered below
+//	2  the agent is unreachable
+//	3  the IP pool is exhausted
+//	4  the container network namespace can't be opened or prepared
+//	5  the network configuration, CNI_ARGS or environment is invalid
+const (
+	exitCodeGeneric          = 1
+	exitCodeAgentUnreachable = 2
+	exitCodeIPAMExhausted    = 3
+	exitCodeNetNS            = 4
+	exitCodeValidation       = 5
+)
+
+const (
+	// errCodeGeneric is the CNI error code used by skel for all errors
+	// which are not typed. Codes below 100 are reserved by the CNI
+	// specification.
+	errCodeGeneric = 100
+
+	// errCodeIPAMExhausted is the CNI error code returned when no IP
+	// could be allocated because the pool is exhausted
+	errCodeIPAMExhausted = 101
+
+	// errCodeInvalidEnvironment is the CNI error code for invalid
+	// environment variables as defined by the CNI specification
+	errCodeInvalidEnvironment = 4
+
+	// errCodePluginNotAvailable is the CNI error code of STATUS if the
+	// plugin can't service ADD requests as defined by the CNI
+	// specification
+	errCodePluginNotAvailable = 50
+)
+
+// exitCodeError is an error which determines the exit code of the plugin.
+// Its message is the message of the wrapped error so that the error printed
+// to stdout is unaffected.
+type exitCodeError struct {
+	err  error
+	code int
+}
+
+func (e *exitCodeError) Error() string {
+	return e.err.Error()
+}
+
+// withExitCode returns err annotated with the exit code of the plugin
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitCodeError{err: err, code: code}
+}
+
+// exitCodeFor returns the exit code of the plugin for the error returned by a
+// command
+func exitCodeFor(err error) int {
+	switch e := err.(type) {
+	case nil:
+		return 0
+	case *exitCodeError:
+		return e.code
+	case client.ClientError:
+		// The client marks errors of connecting to the agent as
+		// recoverable
+		if e.Recoverable() {
+			return exitCodeAgentUnreachable
+		}
+	case *cniTypes.Error:
+		switch e.Code {
+		case errCodeIPAMExhausted:
+			return exitCodeIPAMExhausted
+		case cniTypes.ErrIncompatibleCNIVersion, cniTypes.ErrUnsupportedField, errCodeInvalidEnvironment:
+			return exitCodeValidation
+		}
+	}
+	return exitCodeGeneric
+}
+
+// recordExitCode returns cmd with the exit code for its error stored in code
+func recordExitCode(cmd func(*skel.CmdArgs) error, code *int) func(*skel.CmdArgs) error {
+	return func(args *skel.CmdArgs) error {
+		err := cmd(args)
+		*code = exitCodeFor(err)
+		return err
+	}
+}
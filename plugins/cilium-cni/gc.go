@@ -0,0 +1,165 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/client"
+	"github.com/cilium/cilium/pkg/defaults"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/sirupsen/logrus"
+)
+
+// cmdGCName is the value of CNI_COMMAND for the GC command introduced with
+// version 1.1.0 of the CNI specification. The vendored skel package predates
+// it, the command is therefore dispatched before handing over to skel.
+const cmdGCName = "GC"
+
+const (
+	// attachmentNetworkProperty is the endpoint property recording the name
+	// of the network the endpoint was attached to
+	attachmentNetworkProperty = "cni-network"
+
+	// attachmentIfNameProperty is the endpoint property recording the name
+	// of the interface inside the container
+	attachmentIfNameProperty = "cni-ifname"
+)
+
+// gcNetConf is the network configuration passed to the GC command
+type gcNetConf struct {
+	cniTypes.NetConf
+	ValidAttachments []gcAttachment `json:"cni.dev/valid-attachments,omitempty"`
+}
+
+// gcAttachment is an attachment which is still in use by the runtime
+type gcAttachment struct {
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifname"`
+}
+
+// attachmentProperties returns the endpoint properties identifying the
+// attachment an endpoint was created for. GC only considers endpoints
+// carrying these properties.
+func attachmentProperties(network, ifName string) map[string]string {
+	return map[string]string{
+		attachmentNetworkProperty: network,
+		attachmentIfNameProperty:  ifName,
+	}
+}
+
+// gcClient is the subset of the agent API used to garbage collect endpoints
+type gcClient interface {
+	EndpointList() ([]*models.Endpoint, error)
+	EndpointDelete(id string) error
+}
+
+// cmdGC removes all endpoints attached to the network by the plugin which are
+// not part of the valid attachments passed by the runtime
+func cmdGC(stdin io.Reader) error {
+	data, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return fmt.Errorf("unable to read network configuration: %s", err)
+	}
+
+	n := &gcNetConf{}
+	if err := json.Unmarshal(data, n); err != nil {
+		return fmt.Errorf("failed to load netconf: %s", err)
+	}
+
+	// An empty set of valid attachments would cause all endpoints to be
+	// removed. This is far more likely to be a bug in the runtime than an
+	// actual request, ignore it.
+	if len(n.ValidAttachments) == 0 {
+		log.Warning("Received GC request without valid attachments, ignoring")
+		return nil
+	}
+
+	c, err := client.NewDefaultClientWithTimeout(defaults.ClientConnectTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to connect to Cilium daemon: %s", err)
+	}
+	defer c.Close()
+
+	valid := make(map[gcAttachment]struct{}, len(n.ValidAttachments))
+	for _, a := range n.ValidAttachments {
+		valid[a] = struct{}{}
+	}
+
+	return gcEndpoints(c, n.Name, valid)
+}
+
+// gcEndpoints deletes all endpoints attached to network whose attachment is
+// not present in valid. Deleting an endpoint releases its IPs as well.
+// Endpoints of other networks and endpoints not recording their attachment,
+// e.g. created by the Docker plugin or an older version of the plugin, are
+// never deleted. A failure to delete an endpoint does not prevent the
+// remaining endpoints from being deleted.
+func gcEndpoints(c gcClient, network string, valid map[gcAttachment]struct{}) error {
+	endpoints, err := c.EndpointList()
+	if err != nil {
+		return fmt.Errorf("unable to list endpoints: %s", err)
+	}
+
+	var errs []string
+	for _, ep := range endpoints {
+		if ep.Status == nil || ep.Status.ExternalIdentifiers == nil {
+			continue
+		}
+
+		ids := ep.Status.ExternalIdentifiers
+		if ids.ContainerID == "" || ids.DockerEndpointID != "" {
+			continue
+		}
+
+		props := ep.Status.Properties
+		if props[attachmentNetworkProperty] != network || props[attachmentIfNameProperty] == "" {
+			continue
+		}
+
+		attachment := gcAttachment{
+			ContainerID: ids.ContainerID,
+			IfName:      props[attachmentIfNameProperty],
+		}
+		if _, ok := valid[attachment]; ok {
+			continue
+		}
+
+		scopedLog := log.WithFields(logrus.Fields{
+			logfields.EndpointID:  ep.ID,
+			logfields.ContainerID: attachment.ContainerID,
+			logfields.Interface:   attachment.IfName,
+		})
+		scopedLog.Info("Removing stale endpoint")
+		if err := c.EndpointDelete(strconv.FormatInt(ep.ID, 10)); err != nil {
+			scopedLog.WithError(err).Warning("Unable to remove stale endpoint")
+			errs = append(errs, fmt.Sprintf("endpoint %d: %s", ep.ID, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to remove stale endpoints: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/command/exec"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+const (
+	// hookPreAdd is the hook run before the endpoint is created on ADD
+	hookPreAdd = "pre-add"
+
+	// hookPostDel is the hook run after a successful DEL
+	hookPostDel = "post-del"
+
+	// defaultHookTimeout is the default maximum duration of a hook
+	defaultHookTimeout = 10 * time.Second
+)
+
+// hookEnv returns the environment of a hook. The environment of the plugin is
+// not passed on, it may contain arbitrary CNI_ARGS.
+func hookEnv(hook string, args *skel.CmdArgs, cniArgs cniArgsSpec, addr *models.AddressPair) []string {
+	env := []string{
+		"PATH=" + os.Getenv("PATH"),
+		"CILIUM_CNI_HOOK=" + hook,
+		"CNI_CONTAINERID=" + args.ContainerID,
+		"CNI_NETNS=" + args.Netns,
+		"CNI_IFNAME=" + args.IfName,
+		"K8S_POD_NAMESPACE=" + string(cniArgs.K8S_POD_NAMESPACE),
+		"K8S_POD_NAME=" + string(cniArgs.K8S_POD_NAME),
+		"K8S_POD_UID=" + string(cniArgs.K8S_POD_UID),
+	}
+	if addr != nil {
+		env = append(env, "CILIUM_IPV4="+addr.IPV4, "CILIUM_IPV6="+addr.IPV6)
+	}
+	return env
+}
+
+// checkHook verifies that the hook is a regular file which can only be
+// modified by its owner. The hook runs with the privileges of the plugin.
+func checkHook(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("hook %s is not an absolute path", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("hook %s is not a regular file", path)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("hook %s is writable by group or others", path)
+	}
+	return nil
+}
+
+// runPostDelHook runs the post-DEL hook if configured. Failures are only
+// logged, a DEL must not be retried because of an external system.
+func runPostDelHook(n *netConf, args *skel.CmdArgs, cniArgs cniArgsSpec) {
+	if n.PostDelHook == "" {
+		return
+	}
+	env := hookEnv(hookPostDel, args, cniArgs, nil)
+	if err := runHook(n.PostDelHook, env, n.postDelTimeout()); err != nil {
+		log.WithError(err).WithField(logfields.ContainerID, args.ContainerID).Warning("Post-DEL hook failed")
+	}
+}
+
+// postDelTimeout returns the timeout of the post-DEL hook. The parsed timeout
+// is unset if the options of a DEL could not be parsed.
+func (n *netConf) postDelTimeout() time.Duration {
+	if n.postDelHookTimeout != 0 {
+		return n.postDelHookTimeout
+	}
+	return defaultHookTimeout
+}
+
+// runHook runs the hook executable with the given environment and kills it
+// after the timeout
+var runHook = func(path string, env []string, timeout time.Duration) error {
+	if err := checkHook(path); err != nil {
+		return err
+	}
+	cmd := exec.WithTimeout(timeout, path)
+	cmd.Env = env
+	out, err := cmd.CombinedOutput(log, false)
+	switch {
+	case err != nil && len(out) != 0:
+		return fmt.Errorf("hook %s failed: %s: %s", path, err, out)
+	case err != nil:
+		return fmt.Errorf("hook %s failed: %s", path, err)
+	}
+	return nil
+}
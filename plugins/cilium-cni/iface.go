@@ -0,0 +1,448 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/endpoint/connector"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// invalidIfNameChars replaces the characters which are not permitted in
+// interface names
+var invalidIfNameChars = strings.NewReplacer("/", "-", ":", "-", " ", "-", "\t", "-", "\n", "-")
+
+// hostIfName returns the name of the host side veth interface of the
+// container. A rendered name exceeding the kernel limit keeps its beginning
+// and is suffixed with a hash of the full name.
+func hostIfName(n *netConf, args *skel.CmdArgs, cniArgs cniArgsSpec) string {
+	if n.HostInterfaceName == "" {
+		return connector.Endpoint2IfNameWithPrefix(n.HostInterfacePrefix, args.ContainerID)
+	}
+
+	name := invalidIfNameChars.Replace(renderTemplate(n.HostInterfaceName, n, args, cniArgs))
+	if len(name) > unix.IFNAMSIZ-1 {
+		sum := fmt.Sprintf("%x", sha256.Sum256([]byte(name)))
+		name = name[:unix.IFNAMSIZ-1-connector.MinIfNameHashLen] + sum[:connector.MinIfNameHashLen]
+	}
+	return name
+}
+
+// validateIfName returns a CNI error if the name is not a valid Linux
+// interface name, see dev_valid_name() in the kernel
+func validateIfName(name string) error {
+	var reason string
+	switch {
+	case name == "":
+		reason = "must not be empty"
+	case len(name) >= unix.IFNAMSIZ:
+		reason = fmt.Sprintf("must be shorter than %d characters", unix.IFNAMSIZ)
+	case name == "." || name == "..":
+		reason = "must not be \".\" or \"..\""
+	case strings.ContainsAny(name, "/:"):
+		reason = "must not contain '/' or ':'"
+	case strings.IndexFunc(name, unicode.IsSpace) >= 0:
+		reason = "must not contain whitespace"
+	default:
+		return nil
+	}
+	return &cniTypes.Error{
+		Code:    errCodeInvalidEnvironment,
+		Msg:     "invalid interface name",
+		Details: fmt.Sprintf("CNI_IFNAME %q %s", name, reason),
+	}
+}
+
+// requestedMAC returns the MAC address requested via the mac capability or
+// nil if none was requested
+func requestedMAC(n *netConf) net.HardwareAddr {
+	if !n.Capabilities[capabilityMac] || n.RuntimeConfig.Mac == "" {
+		return nil
+	}
+	// Validated in parseOptions
+	mac, _ := net.ParseMAC(n.RuntimeConfig.Mac)
+	return mac
+}
+
+// maxIfAliasLen is the maximum length of an interface alias as accepted by
+// the kernel (IFALIASZ without the terminating NUL)
+const maxIfAliasLen = 255
+
+// setInterfaceAlias sets the alias of the given link to the namespace and
+// name of the pod, truncated to the maximum alias length.
+func setInterfaceAlias(link netlink.Link, cniArgs cniArgsSpec) error {
+	if cniArgs.K8S_POD_NAME == "" {
+		return nil
+	}
+	alias := string(cniArgs.K8S_POD_NAMESPACE) + "/" + string(cniArgs.K8S_POD_NAME)
+	if len(alias) > maxIfAliasLen {
+		alias = alias[:maxIfAliasLen]
+	}
+	return netlink.LinkSetAlias(link, alias)
+}
+
+// setTxQueueLen sets the transmit queue length of the link
+func setTxQueueLen(link netlink.Link, qlen int) error {
+	if err := netlink.LinkSetTxQLen(link, qlen); err != nil {
+		return fmt.Errorf("unable to set txqueuelen of %q to %d: %s", link.Attrs().Name, qlen, err)
+	}
+	return nil
+}
+
+// procSysNetPath is the path of the networking sysctls
+var procSysNetPath = filepath.Join("/proc", "sys", "net")
+
+// setProxyNeigh enables proxy_arp and/or proxy_ndp on the interface of the
+// current network namespace
+func setProxyNeigh(logger *logrus.Entry, ifName string, arp, ndp bool) error {
+	settings := []struct {
+		enabled bool
+		path    string
+	}{
+		{arp, filepath.Join(procSysNetPath, "ipv4", "conf", ifName, "proxy_arp")},
+		{ndp, filepath.Join(procSysNetPath, "ipv6", "conf", ifName, "proxy_ndp")},
+	}
+	for _, s := range settings {
+		if !s.enabled {
+			continue
+		}
+		if err := connector.WriteSysConfig(s.path, "1\n"); err != nil {
+			return fmt.Errorf("unable to enable %s of %q: %s", filepath.Base(s.path), ifName, err)
+		}
+		logger.WithFields(logrus.Fields{
+			logfields.Interface: ifName,
+			"sysctl":            s.path,
+		}).Debug("Enabled neighbor proxy on host side veth")
+	}
+	return nil
+}
+
+// setHostMaster enslaves the link to the bridge named master
+func setHostMaster(logger *logrus.Entry, link netlink.Link, master string) error {
+	l, err := netlink.LinkByName(master)
+	if err != nil {
+		return fmt.Errorf("unable to find hostMaster bridge %q: %s", master, err)
+	}
+	bridge, ok := l.(*netlink.Bridge)
+	if !ok {
+		return fmt.Errorf("hostMaster %q is a %s device, not a bridge", master, l.Type())
+	}
+	if err := netlink.LinkSetMaster(link, bridge); err != nil {
+		return fmt.Errorf("unable to enslave %q to bridge %q: %s", link.Attrs().Name, master, err)
+	}
+	logger.WithFields(logrus.Fields{
+		logfields.Interface: link.Attrs().Name,
+		"master":            master,
+	}).Debug("Enslaved host side veth to bridge")
+	return nil
+}
+
+// addGatewayNeigh programs a permanent neighbor entry for the gateway on the
+// link. An existing entry is replaced.
+func addGatewayNeigh(link netlink.Link, gw string, mac net.HardwareAddr) error {
+	gwIP := net.ParseIP(gw)
+	if gwIP == nil {
+		return fmt.Errorf("invalid gateway address: %s", gw)
+	}
+	family := netlink.FAMILY_V6
+	if gwIP.To4() != nil {
+		family = netlink.FAMILY_V4
+	}
+
+	neigh := &netlink.Neigh{
+		LinkIndex:    link.Attrs().Index,
+		Family:       family,
+		State:        netlink.NUD_PERMANENT,
+		IP:           gwIP,
+		HardwareAddr: mac,
+	}
+	if err := netlink.NeighSet(neigh); err != nil {
+		return fmt.Errorf("failed to add neighbor entry '%s lladdr %s': %v", gwIP, mac, err)
+	}
+	return nil
+}
+
+// gatewayMAC returns the MAC address of the gateway of the endpoint, or nil
+// if it is not known, e.g. because the datapath mode has no host side
+// interface
+func gatewayMAC(logger *logrus.Entry, ep *models.EndpointChangeRequest) (net.HardwareAddr, error) {
+	if ep.HostMac == "" {
+		logger.Warning("MAC address of the gateway is unknown, not programming a static neighbor entry")
+		return nil, nil
+	}
+	mac, err := net.ParseMAC(ep.HostMac)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gateway MAC address %q: %s", ep.HostMac, err)
+	}
+	return mac, nil
+}
+
+const (
+	// ipv6DADDisabled disables duplicate address detection of IPv6
+	// addresses of the endpoint
+	ipv6DADDisabled = "disabled"
+
+	// ipv6DADWait waits for duplicate address detection of IPv6 addresses
+	// of the endpoint to complete
+	ipv6DADWait = "wait"
+
+	// ipv6DADTimeout is the maximum time to wait for duplicate address
+	// detection to complete
+	ipv6DADTimeout = 10 * time.Second
+)
+
+// waitForDAD waits until duplicate address detection of the IPv6 address on
+// the link has completed
+func waitForDAD(link netlink.Link, ip net.IP, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V6)
+		if err != nil {
+			return fmt.Errorf("unable to list addresses: %s", err)
+		}
+		tentative := false
+		for _, a := range addrs {
+			if !a.IP.Equal(ip) {
+				continue
+			}
+			if a.Flags&unix.IFA_F_DADFAILED != 0 {
+				return fmt.Errorf("duplicate address detection failed for %s", ip)
+			}
+			tentative = a.Flags&unix.IFA_F_TENTATIVE != 0
+		}
+		if !tentative {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for duplicate address detection of %s", ip)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// enableIPv6WarnOnce limits the warning about failing to enable IPv6 to once
+// per process
+var enableIPv6WarnOnce sync.Once
+
+// writeSysConfig writes a sysctl of the current network namespace
+var writeSysConfig = connector.WriteSysConfig
+
+// enableIPv6 enables IPv6 on all interfaces of the current network namespace
+func enableIPv6(logger *logrus.Entry) {
+	allInterfacesPath := filepath.Join("/proc", "sys", "net", "ipv6", "conf", "all", "disable_ipv6")
+	if err := writeSysConfig(allInterfacesPath, "0\n"); err != nil {
+		enableIPv6WarnOnce.Do(func() {
+			logger.WithError(err).Warn("unable to enable ipv6 on all interfaces")
+		})
+	}
+}
+
+// sandboxPath returns the path of the container namespace to report in the
+// result. CNI_NETNS is a path for most runtimes, e.g. /proc/<pid>/ns/net for
+// Docker or /var/run/netns/cni-<id> for containerd and CRI-O, and is returned
+// as is. A bare PID is resolved to the namespace of the process.
+func sandboxPath(netns string) string {
+	if _, err := strconv.Atoi(netns); err == nil {
+		return filepath.Join("/proc", netns, "ns", "net")
+	}
+	return filepath.Clean(netns)
+}
+
+// setupLoopback brings up the loopback interface of the current namespace.
+// Some runtimes hand over a namespace with loopback down which breaks
+// localhost communication of the pod.
+func setupLoopback(logger *logrus.Entry) error {
+	l, err := netlink.LinkByName("lo")
+	if err != nil {
+		return fmt.Errorf("failed to lookup lo: %v", err)
+	}
+	if l.Attrs().Flags&net.FlagUp != 0 {
+		return nil
+	}
+	logger.Info("Loopback interface of container namespace is down, bringing it up")
+	if err := netlink.LinkSetUp(l); err != nil {
+		return fmt.Errorf("failed to set lo UP: %v", err)
+	}
+	return nil
+}
+
+// flushExisting returns true if existing addresses of the container
+// interface are flushed
+func (n *netConf) flushExisting() bool {
+	return n.FlushExisting == nil || *n.FlushExisting
+}
+
+// flushAddrs removes all addresses but IPv6 link-local addresses from the
+// link
+func flushAddrs(l netlink.Link, ifName string) error {
+	addrs, err := netlink.AddrList(l, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to list addresses of %q: %v", ifName, err)
+	}
+	for i := range addrs {
+		if addrs[i].IP.To4() == nil && addrs[i].IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if err := netlink.AddrDel(l, &addrs[i]); err != nil {
+			return fmt.Errorf("failed to flush addr %s from %q: %v", addrs[i].IPNet, ifName, err)
+		}
+		log.WithFields(logrus.Fields{
+			logfields.IPAddr:    addrs[i].IPNet,
+			logfields.Interface: ifName,
+		}).Info("Flushed existing address of interface")
+	}
+	return nil
+}
+
+// checkMACUnique returns an error if another link of the namespace uses the
+// MAC address requested for the given link
+func checkMACUnique(l netlink.Link, mac net.HardwareAddr) error {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to list links: %v", err)
+	}
+	for _, other := range links {
+		attrs := other.Attrs()
+		if attrs.Index == l.Attrs().Index {
+			continue
+		}
+		if bytes.Equal(attrs.HardwareAddr, mac) {
+			return fmt.Errorf("requested MAC %s of %q is already used by interface %q", mac, l.Attrs().Name, attrs.Name)
+		}
+	}
+	return nil
+}
+
+// verifyLink returns an error if the given link is not the interface which
+// has been created for the endpoint, e.g. because the name has been reused
+// in the meantime
+func verifyLink(l netlink.Link, state *CmdState) error {
+	if state.IfIndex == 0 {
+		return nil
+	}
+
+	attrs := l.Attrs()
+	if attrs.Index != state.IfIndex {
+		return fmt.Errorf("interface %q has index %d, expected %d", attrs.Name, attrs.Index, state.IfIndex)
+	}
+	if len(state.IfMAC) > 0 && attrs.HardwareAddr.String() != state.IfMAC.String() {
+		return fmt.Errorf("interface %q has MAC %s, expected %s", attrs.Name, attrs.HardwareAddr, state.IfMAC)
+	}
+	return nil
+}
+
+// removeHostVeth removes the host side interface of the veth pair created
+// for a container, if it still exists.
+func removeHostVeth(hostIfName string) {
+	l, err := netlink.LinkByName(hostIfName)
+	if err != nil {
+		return
+	}
+	if err := netlink.LinkDel(l); err != nil {
+		log.WithError(err).WithField(logfields.Veth, hostIfName).Warn("Unable to delete host side veth")
+	}
+}
+
+// containerIface is an interface created in the container namespace by ADD
+type containerIface struct {
+	name string
+	// ips are the addresses of the interface which are not released
+	// together with the endpoint. Only addresses allocated by Cilium
+	// are released, see ownedIPRelease.
+	ips []string
+}
+
+// containerInterfaces returns the interfaces Cilium created in the container
+// namespace in creation order, as listed in the result of the ADD passed to
+// DEL. Besides the interface of the request, an interface of the result is
+// only returned if created reports that Cilium set it up. The interfaces of
+// other plugins of the chain are left to their plugins. The addresses of the
+// interface of the request are owned by the endpoint and are not returned.
+// Without a result, only the interface of the request is returned.
+func containerInterfaces(n *netConf, ifName string, created func(ifName string) bool) []containerIface {
+	ifaces := []containerIface{{name: ifName}}
+	if len(n.NetConf.RawPrevResult) == 0 {
+		return ifaces
+	}
+	r, err := getPrevResult(n)
+	if err != nil {
+		log.WithError(err).Warning("Unable to parse previous result, only removing interface of request")
+		return ifaces
+	}
+
+	ifaces = ifaces[:0]
+	found := false
+	for i, iface := range r.Interfaces {
+		if iface.Sandbox == "" {
+			continue
+		}
+		ci := containerIface{name: iface.Name}
+		switch {
+		case iface.Name == ifName:
+			found = true
+		case !created(iface.Name):
+			continue
+		default:
+			for _, ipCfg := range r.IPs {
+				if ipCfg.Interface != nil && *ipCfg.Interface == i {
+					ci.ips = append(ci.ips, ipCfg.Address.IP.String())
+				}
+			}
+		}
+		ifaces = append(ifaces, ci)
+	}
+	if !found {
+		ifaces = append(ifaces, containerIface{name: ifName})
+	}
+	return ifaces
+}
+
+// teardownInterfaces removes the interfaces in reverse creation order and
+// releases their addresses. Like the rest of DEL, the teardown is best
+// effort: failures are logged and cleanup continues for the remaining
+// interfaces. Interfaces which do not exist are considered removed.
+func teardownInterfaces(ifaces []containerIface, remove func(ifName string) error, release func(ip string) error) {
+	for i := len(ifaces) - 1; i >= 0; i-- {
+		iface := ifaces[i]
+		if err := remove(iface.name); err != nil {
+			log.WithError(err).WithField(logfields.Interface, iface.name).Warning("Unable to delete interface")
+		}
+		for _, ip := range iface.ips {
+			if err := release(ip); err != nil {
+				log.WithError(err).WithFields(logrus.Fields{
+					logfields.Interface: iface.name,
+					logfields.IPAddr:    ip,
+				}).Warning("Unable to release IP of interface")
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// includeKey is the network configuration key referencing a file
+	// with default values for the configuration
+	includeKey = "include"
+
+	// defaultIncludeDir is the directory included files must reside in
+	defaultIncludeDir = "/etc/cni/cilium.d"
+
+	// includeDirEnv is the environment variable overriding the directory
+	// included files must reside in
+	includeDirEnv = "CILIUM_CNI_INCLUDE_DIR"
+
+	// overlayPathEnv is the environment variable overriding the path of
+	// the node-local overlay
+	overlayPathEnv = "CILIUM_CNI_OVERLAY"
+)
+
+// defaultOverlayPath is the path of the node-local overlay applied to all
+// network configurations. It can be changed at build time with
+// -ldflags "-X main.defaultOverlayPath=<path>".
+var defaultOverlayPath = "/etc/cilium/cni-overrides.json"
+
+var (
+	// netConfIncludeDir is the directory included files must reside in
+	netConfIncludeDir = getIncludeDir()
+
+	// netConfOverlayPath is the path of the node-local overlay
+	netConfOverlayPath = getOverlayPath()
+)
+
+func getIncludeDir() string {
+	if dir := os.Getenv(includeDirEnv); dir != "" {
+		return dir
+	}
+	return defaultIncludeDir
+}
+
+func getOverlayPath() string {
+	if path := os.Getenv(overlayPathEnv); path != "" {
+		return path
+	}
+	return defaultOverlayPath
+}
+
+// resolveInclude returns the path of the included file. The path is relative
+// to dir unless absolute and must not point outside of dir, also after
+// following symlinks.
+func resolveInclude(dir, include string) (string, error) {
+	path := include
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve include directory: %s", err)
+	}
+
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve include %q: %s", include, err)
+	}
+
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("include %q is outside of %s", include, dir)
+	}
+
+	return path, nil
+}
+
+// mergeConf merges override into base on a per field basis. Nested objects
+// are merged recursively, all other values in override replace the value in
+// base.
+func mergeConf(base, override map[string]interface{}) {
+	for key, value := range override {
+		if o, ok := value.(map[string]interface{}); ok {
+			if b, ok := base[key].(map[string]interface{}); ok {
+				mergeConf(b, o)
+				continue
+			}
+		}
+		base[key] = value
+	}
+}
+
+// applyInclude returns the network configuration with the included file
+// merged in if the configuration references one. Values of the
+// configuration take precedence over values of the included file.
+func applyInclude(bytes []byte, dir string) ([]byte, error) {
+	conf := map[string]interface{}{}
+	if err := json.Unmarshal(bytes, &conf); err != nil {
+		return nil, err
+	}
+
+	value, ok := conf[includeKey]
+	if !ok {
+		return bytes, nil
+	}
+	delete(conf, includeKey)
+
+	include, ok := value.(string)
+	if !ok || include == "" {
+		return nil, fmt.Errorf("invalid include %v", value)
+	}
+
+	path, err := resolveInclude(dir, include)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read include: %s", err)
+	}
+
+	base := map[string]interface{}{}
+	if err := json.Unmarshal(data, &base); err != nil {
+		return nil, fmt.Errorf("unable to parse include %s: %s", path, err)
+	}
+	if _, ok := base[includeKey]; ok {
+		return nil, fmt.Errorf("nested include in %s is not supported", path)
+	}
+
+	mergeConf(base, conf)
+	return json.Marshal(base)
+}
+
+// applyOverlay returns the network configuration merged into the node-local
+// overlay at path. The overlay has the lowest precedence, below the
+// configuration and its included file, and is skipped if it does not exist.
+func applyOverlay(bytes []byte, path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return bytes, nil
+		}
+		return nil, fmt.Errorf("unable to read overlay: %s", err)
+	}
+
+	base := map[string]interface{}{}
+	if err := json.Unmarshal(data, &base); err != nil {
+		return nil, fmt.Errorf("unable to parse overlay %s: %s", path, err)
+	}
+	if _, ok := base[includeKey]; ok {
+		return nil, fmt.Errorf("include in overlay %s is not supported", path)
+	}
+
+	conf := map[string]interface{}{}
+	if err := json.Unmarshal(bytes, &conf); err != nil {
+		return nil, err
+	}
+
+	mergeConf(base, conf)
+	return json.Marshal(base)
+}
@@ -0,0 +1,419 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/client"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/sirupsen/logrus"
+)
+
+// IPv6 address scopes
+const (
+	ipv6ScopeULA = "ula"
+	ipv6ScopeGUA = "gua"
+)
+
+var (
+	// ipv6ULARange is the range of unique local IPv6 addresses, RFC 4193
+	ipv6ULARange = net.IPNet{IP: net.ParseIP("fc00::"), Mask: net.CIDRMask(7, 128)}
+
+	// ipv6GUARange is the range of global unicast IPv6 addresses, RFC 4291
+	ipv6GUARange = net.IPNet{IP: net.ParseIP("2000::"), Mask: net.CIDRMask(3, 128)}
+)
+
+// ipamClient is the subset of the agent API used to allocate and release IPs
+type ipamClient interface {
+	IPAMAllocateContainer(family, owner, containerID, subnet string) (*models.IPAMResponse, error)
+	IPAMAllocateIP(ip, owner, containerID string) error
+	IPAMReleaseIP(ip string) error
+	IPAMReleaseOwner(owner, containerID string) error
+}
+
+// classifyIPAMError returns the error to report for a failed allocation. Pool
+// exhaustion is reported as a typed CNI error with a distinct code so that
+// the runtime does not treat it as a transient connectivity failure.
+func classifyIPAMError(err error, msg string) error {
+	if _, ok := err.(client.IPAMExhaustedError); ok {
+		details := err.Error()
+		if msg != "" {
+			details = msg + ": " + details
+		}
+		return &cniTypes.Error{
+			Code:    errCodeIPAMExhausted,
+			Msg:     "no IPs available",
+			Details: details,
+		}
+	}
+
+	if err != nil && msg != "" {
+		return fmt.Errorf("%s: %s", msg, err)
+	}
+	return err
+}
+
+// defaultOwnerTemplate is the template of the IP owner if none is configured
+const defaultOwnerTemplate = "{namespace}/{name}"
+
+// ownerPlaceholders are the placeholders supported in owner and interface
+// name templates
+var ownerPlaceholders = map[string]struct{}{
+	"{namespace}":   {},
+	"{name}":        {},
+	"{uid}":         {},
+	"{containerID}": {},
+	"{network}":     {},
+}
+
+// ownerPlaceholderRegex matches all placeholders of a template
+var ownerPlaceholderRegex = regexp.MustCompile(`\{[^{}]*\}`)
+
+// ownerPrefixRegex matches valid owner prefixes
+var ownerPrefixRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9._-]{0,61}[a-zA-Z0-9])?$`)
+
+// validateTemplate returns an error if the template of the given field
+// contains an unknown placeholder
+func validateTemplate(field, template string) error {
+	for _, p := range ownerPlaceholderRegex.FindAllString(template, -1) {
+		if _, ok := ownerPlaceholders[p]; !ok {
+			return fmt.Errorf("unknown placeholder %s in %s %q", p, field, template)
+		}
+	}
+	return nil
+}
+
+// renderTemplate replaces the placeholders of the template with the
+// identity of the container
+func renderTemplate(template string, n *netConf, args *skel.CmdArgs, cniArgs cniArgsSpec) string {
+	return strings.NewReplacer(
+		"{namespace}", string(cniArgs.K8S_POD_NAMESPACE),
+		"{name}", string(cniArgs.K8S_POD_NAME),
+		"{uid}", string(cniArgs.K8S_POD_UID),
+		"{containerID}", args.ContainerID,
+		"{network}", n.Name,
+	).Replace(template)
+}
+
+// ipOwner renders the owner of the IPs allocated for the container
+func ipOwner(n *netConf, args *skel.CmdArgs, cniArgs cniArgsSpec) string {
+	template := n.OwnerTemplate
+	if template == "" {
+		template = defaultOwnerTemplate
+	}
+	owner := renderTemplate(template, n, args, cniArgs)
+	if n.OwnerPrefix != "" {
+		owner = n.OwnerPrefix + "/" + owner
+	}
+	return owner
+}
+
+// parseRequestedIPs parses the addresses requested by the runtime. The
+// addresses may be given with or without a prefix length, at most one
+// address per family may be requested.
+func parseRequestedIPs(ips []string) ([]net.IP, error) {
+	var (
+		parsed       []net.IP
+		have4, have6 bool
+	)
+	for _, s := range ips {
+		ip, _, err := net.ParseCIDR(s)
+		if err != nil {
+			if ip = net.ParseIP(s); ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", s)
+			}
+		}
+		if ip.To4() != nil {
+			if have4 {
+				return nil, fmt.Errorf("more than one IPv4 address requested")
+			}
+			have4 = true
+		} else {
+			if have6 {
+				return nil, fmt.Errorf("more than one IPv6 address requested")
+			}
+			have6 = true
+		}
+		parsed = append(parsed, ip)
+	}
+	return parsed, nil
+}
+
+// requestedIPs returns the addresses requested for the endpoint, either
+// via the ips capability or the IP CNI argument. The runtimeConfig entry is
+// only honoured if the capability is enabled.
+func requestedIPs(n *netConf, cniArgs *cniArgsSpec) []net.IP {
+	if n.Capabilities[capabilityIPs] && len(n.RuntimeConfig.IPs) > 0 {
+		// Validated in parseOptions
+		ips, _ := parseRequestedIPs(n.RuntimeConfig.IPs)
+		return ips
+	}
+	if cniArgs.IP != nil {
+		return []net.IP{cniArgs.IP}
+	}
+	return nil
+}
+
+// allocateRequestedIPs allocates the addresses requested by the runtime.
+// Already allocated addresses are released again if an allocation fails.
+func allocateRequestedIPs(c ipamClient, ips []net.IP, owner, containerID string, hostAddressing *models.NodeAddressing) (*models.IPAMResponse, error) {
+	ipam := &models.IPAMResponse{
+		Address:        &models.AddressPair{},
+		HostAddressing: hostAddressing,
+	}
+	for _, ip := range ips {
+		if err := c.IPAMAllocateIP(ip.String(), owner, containerID); err != nil {
+			if ipam.Address.IPV4 != "" {
+				releaseIP(c, ipam.Address.IPV4)
+			}
+			if ipam.Address.IPV6 != "" {
+				releaseIP(c, ipam.Address.IPV6)
+			}
+			return nil, fmt.Errorf("unable to allocate requested IP %s: %s", ip, err)
+		}
+		if ip.To4() != nil {
+			ipam.Address.IPV4 = ip.String()
+		} else {
+			ipam.Address.IPV6 = ip.String()
+		}
+	}
+	return ipam, nil
+}
+
+// allocateIPs allocates the addresses for an endpoint. If a subnet hint is
+// configured, the addresses are allocated one family at a time and the first
+// allocation is released again if the second one fails.
+func allocateIPs(c ipamClient, conf *IPAM, owner, containerID string) (*models.IPAMResponse, error) {
+	if conf.SubnetHint == "" {
+		ipam, err := c.IPAMAllocateContainer("", owner, containerID, "")
+		if err != nil {
+			return nil, classifyIPAMError(err, "")
+		}
+		return ipam, nil
+	}
+
+	var subnet4, subnet6 string
+	if conf.SubnetHint != "" {
+		_, ipNet, err := net.ParseCIDR(conf.SubnetHint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet hint %q: %s", conf.SubnetHint, err)
+		}
+		if ipNet.IP.To4() != nil {
+			subnet4 = ipNet.String()
+		} else {
+			subnet6 = ipNet.String()
+		}
+	}
+
+	ipam6, err := c.IPAMAllocateContainer(client.AddressFamilyIPv6, owner, containerID, subnet6)
+	if err != nil {
+		return nil, classifyIPAMError(err, "unable to allocate IPv6 address")
+	}
+
+	ipam4, err := c.IPAMAllocateContainer(client.AddressFamilyIPv4, owner, containerID, subnet4)
+	if err != nil {
+		if ipam6.Address != nil {
+			releaseIP(c, ipam6.Address.IPV6)
+		}
+		return nil, classifyIPAMError(err, "unable to allocate IPv4 address")
+	}
+
+	if ipam4.Address == nil {
+		ipam4.Address = &models.AddressPair{}
+	}
+	if ipam6.Address != nil {
+		ipam4.Address.IPV6 = ipam6.Address.IPV6
+	}
+	ipam4.IPV6Pool = ipam6.IPV6Pool
+	if ipam4.HostAddressing == nil {
+		ipam4.HostAddressing = ipam6.HostAddressing
+	}
+	if exp6 := time.Time(ipam6.Expiration); !exp6.IsZero() {
+		if exp4 := time.Time(ipam4.Expiration); exp4.IsZero() || exp6.Before(exp4) {
+			ipam4.Expiration = ipam6.Expiration
+		}
+	}
+
+	return ipam4, nil
+}
+
+// recordAllocationDuration records the duration of the IP allocation which
+// started at start in the endpoint if metrics are reported to the agent
+func recordAllocationDuration(n *netConf, ep *models.EndpointChangeRequest, start time.Time) {
+	if n.ReportMetrics {
+		ep.IPAMAllocationDuration = int64(time.Since(start))
+	}
+}
+
+// disableFamilies releases the addresses of the address families disabled in
+// the network configuration and removes them from the IPAM response. An error
+// is returned if no address remains.
+func disableFamilies(c ipamClient, n *netConf, ipam *models.IPAMResponse) error {
+	if n.DisableIPv4 && ipam.Address.IPV4 != "" {
+		releaseIP(c, ipam.Address.IPV4)
+		ipam.Address.IPV4 = ""
+		ipam.IPV4Pool = ""
+	}
+	if n.DisableIPv6 && ipam.Address.IPV6 != "" {
+		releaseIP(c, ipam.Address.IPV6)
+		ipam.Address.IPV6 = ""
+		ipam.IPV6Pool = ""
+	}
+	if ipam.Address.IPV4 == "" && ipam.Address.IPV6 == "" {
+		return fmt.Errorf("IPAM did not provide an address of an address family enabled for the endpoint")
+	}
+	return nil
+}
+
+// checkAddressFamilies returns an error if IPAM returned an address of an
+// address family which is not enabled in the host addressing of the agent.
+// The address would either be ignored or lack a gateway. Missing host
+// addressing is reported by connector.SufficientAddressing.
+func checkAddressFamilies(ipam *models.IPAMResponse) error {
+	host := ipam.HostAddressing
+	if host == nil {
+		return nil
+	}
+	families := []struct {
+		name string
+		ip   string
+		host *models.NodeAddressingElement
+	}{
+		{"IPv4", ipam.Address.IPV4, host.IPV4},
+		{"IPv6", ipam.Address.IPV6, host.IPV6},
+	}
+	for _, f := range families {
+		switch {
+		case f.ip == "":
+		case f.host == nil:
+			return fmt.Errorf("IPAM returned %s address %s but the agent provided no %s host addressing", f.name, f.ip, f.name)
+		case !f.host.Enabled:
+			return fmt.Errorf("IPAM returned %s address %s but %s is disabled in the host addressing of the agent", f.name, f.ip, f.name)
+		}
+	}
+	return nil
+}
+
+// checkIPv6Scope verifies that the allocated IPv6 address has the scope
+// required by the configuration. All allocated addresses are released if it
+// does not.
+func checkIPv6Scope(c ipamClient, conf *IPAM, ipam *models.IPAMResponse) error {
+	if conf.IPv6Scope == "" || ipam.Address.IPV6 == "" {
+		return nil
+	}
+
+	ip := net.ParseIP(ipam.Address.IPV6)
+	scopeRange := ipv6ULARange
+	if conf.IPv6Scope == ipv6ScopeGUA {
+		scopeRange = ipv6GUARange
+	}
+	if ip != nil && scopeRange.Contains(ip) {
+		return nil
+	}
+
+	releaseIP(c, ipam.Address.IPV4)
+	releaseIP(c, ipam.Address.IPV6)
+	return fmt.Errorf("allocated IPv6 address %s is not a %s address", ipam.Address.IPV6, strings.ToUpper(conf.IPv6Scope))
+}
+
+// allocateSecondaryIPs allocates the secondary addresses of an endpoint for
+// all address families the primary addresses have been allocated for. All
+// secondary addresses are released again if an allocation fails.
+func allocateSecondaryIPs(c ipamClient, conf *IPAM, owner, containerID string, primary *models.AddressPair) ([]*models.AddressPair, error) {
+	var secondary []*models.AddressPair
+
+	for i := 1; i < conf.AddressesPerFamily; i++ {
+		pair := &models.AddressPair{}
+		secondary = append(secondary, pair)
+
+		if primary.IPV6 != "" {
+			ipam, err := c.IPAMAllocateContainer(client.AddressFamilyIPv6, owner, containerID, "")
+			if err != nil {
+				releaseSecondaryIPs(c, secondary)
+				return nil, classifyIPAMError(err, "unable to allocate secondary IPv6 address")
+			}
+			if ipam.Address != nil {
+				pair.IPV6 = ipam.Address.IPV6
+			}
+		}
+
+		if primary.IPV4 != "" {
+			ipam, err := c.IPAMAllocateContainer(client.AddressFamilyIPv4, owner, containerID, "")
+			if err != nil {
+				releaseSecondaryIPs(c, secondary)
+				return nil, classifyIPAMError(err, "unable to allocate secondary IPv4 address")
+			}
+			if ipam.Address != nil {
+				pair.IPV4 = ipam.Address.IPV4
+			}
+		}
+	}
+
+	return secondary, nil
+}
+
+func releaseSecondaryIPs(c ipamClient, secondary []*models.AddressPair) {
+	for _, pair := range secondary {
+		releaseIPs(c, pair)
+	}
+}
+
+func releaseIP(client ipamClient, ip string) {
+	if ip != "" {
+		if err := client.IPAMReleaseIP(ip); err != nil {
+			log.WithError(err).WithField(logfields.IPAddr, ip).Warn("Unable to release IP")
+		}
+	}
+}
+
+// releaseByIdentity releases all IPs allocated for the container. It is used
+// if the addresses of the container are not known, e.g. when an allocation
+// timed out after the agent allocated the addresses. The agent only releases
+// the addresses of the owner which have been allocated for the container ID,
+// releasing by the namespace and name of a pod alone could release the
+// addresses of another sandbox of the same pod.
+func releaseByIdentity(client ipamClient, n *netConf, args *skel.CmdArgs, cniArgs cniArgsSpec) {
+	if n.AttachToExisting {
+		// The owner is shared with the interfaces of the existing
+		// endpoint
+		log.WithField(logfields.ContainerID, args.ContainerID).
+			Debug("Interface is attached to an existing endpoint, not releasing IPs by owner")
+		return
+	}
+	if args.ContainerID == "" {
+		return
+	}
+	owner := ipOwner(n, args, cniArgs)
+	if err := client.IPAMReleaseOwner(owner, args.ContainerID); err != nil {
+		log.WithError(err).WithFields(logrus.Fields{
+			"owner":               owner,
+			logfields.ContainerID: args.ContainerID,
+		}).Warn("Unable to release IPs of container")
+	}
+}
+
+func releaseIPs(client ipamClient, addr *models.AddressPair) {
+	releaseIP(client, addr.IPV6)
+	releaseIP(client, addr.IPV4)
+}
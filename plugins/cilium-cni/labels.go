@@ -0,0 +1,169 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cilium/cilium/api/v1/models"
+	k8sConst "github.com/cilium/cilium/pkg/k8s/apis/cilium.io"
+	"github.com/cilium/cilium/pkg/labels"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// labelGroupMesos is the group of labels passed by Mesos in args
+	labelGroupMesos = "mesos"
+
+	// labelGroupArgs is the group of labels passed in CNI_ARGS
+	labelGroupArgs = "cni-args"
+
+	// labelGroupZone is the group of the availability zone label
+	labelGroupZone = "zone"
+
+	// zoneLabelKey is the key of the availability zone label, the
+	// well-known Kubernetes label of the zone
+	zoneLabelKey = "failure-domain.beta.kubernetes.io/zone"
+
+	// labelGroupServiceAccount is the group of the service account label
+	labelGroupServiceAccount = "service-account"
+)
+
+// serviceAccountLabels returns the service account label of the pod if
+// enabled and passed by the runtime
+func serviceAccountLabels(n *netConf, cniArgs cniArgsSpec) ([]argLabel, error) {
+	sa := string(cniArgs.K8S_POD_SERVICE_ACCOUNT)
+	if !n.ServiceAccountLabel || sa == "" {
+		return nil, nil
+	}
+	if errs := validation.IsDNS1123Subdomain(sa); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid K8S_POD_SERVICE_ACCOUNT %q: %s", sa, strings.Join(errs, ", "))
+	}
+	return []argLabel{{key: k8sConst.PolicyLabelServiceAccount, value: sa}}, nil
+}
+
+// defaultLabelSources maps the label groups to their default label source
+var defaultLabelSources = map[string]string{
+	labelGroupMesos:          labels.LabelSourceMesos,
+	labelGroupArgs:           labels.LabelSourceContainer,
+	labelGroupZone:           labels.LabelSourceContainer,
+	labelGroupServiceAccount: labels.LabelSourceK8s,
+}
+
+// validLabelSources are the label sources which may be configured for a
+// label group. Reserved sources are not allowed.
+var validLabelSources = map[string]struct{}{
+	labels.LabelSourceUnspec:          {},
+	labels.LabelSourceK8s:             {},
+	labels.LabelSourceMesos:           {},
+	labels.LabelSourceContainer:       {},
+	labels.LabelSourceCiliumGenerated: {},
+}
+
+// validateLabelSources validates the label source overrides
+func validateLabelSources(sources map[string]string) error {
+	for group, source := range sources {
+		if _, ok := defaultLabelSources[group]; !ok {
+			return fmt.Errorf("unknown label group %q", group)
+		}
+		if _, ok := validLabelSources[source]; !ok {
+			return fmt.Errorf("invalid label source %q for label group %q", source, group)
+		}
+	}
+	return nil
+}
+
+// labelSource returns the source of the labels of the given label group
+func (n *netConf) labelSource(group string) string {
+	if source, ok := n.LabelSources[group]; ok {
+		return source
+	}
+	return defaultLabelSources[group]
+}
+
+// argLabel is a label passed in CNI_ARGS
+type argLabel struct {
+	key, value string
+}
+
+// splitArgsLabels splits the labels passed in CNI_ARGS off the arguments.
+// The labels are the arguments with a key starting with prefix, returned in
+// order with the prefix stripped. The remaining arguments are returned in
+// CNI_ARGS format. Nothing is split off if prefix is empty.
+func splitArgsLabels(args, prefix string) ([]argLabel, string) {
+	if prefix == "" || args == "" {
+		return nil, args
+	}
+
+	var (
+		lbls []argLabel
+		rest []string
+	)
+	for _, pair := range strings.Split(args, ";") {
+		kv := strings.Split(pair, "=")
+		if len(kv) != 2 || !strings.HasPrefix(kv[0], prefix) || len(kv[0]) == len(prefix) {
+			rest = append(rest, pair)
+			continue
+		}
+		lbls = append(lbls, argLabel{key: strings.TrimPrefix(kv[0], prefix), value: kv[1]})
+	}
+	return lbls, strings.Join(rest, ";")
+}
+
+// addArgsLabels appends the labels passed in CNI_ARGS to lbls with the given
+// source. Labels with a key already present in lbls, regardless of the
+// source, are skipped, as are repeated keys in CNI_ARGS.
+func addArgsLabels(logger *logrus.Entry, lbls models.Labels, source string, argsLabels []argLabel) models.Labels {
+	keys := make(map[string]struct{}, len(lbls))
+	for _, l := range lbls {
+		keys[labels.ParseLabel(l).Key] = struct{}{}
+	}
+	for _, l := range argsLabels {
+		if _, ok := keys[l.key]; ok {
+			logger.WithField("key", l.key).Debug("Skipping label passed in CNI_ARGS, key already present")
+			continue
+		}
+		keys[l.key] = struct{}{}
+		lbls = append(lbls, fmt.Sprintf("%s:%s=%s", source, l.key, l.value))
+	}
+	return lbls
+}
+
+// defaultMaxLabels is the default maximum number of labels injected into an
+// endpoint
+const defaultMaxLabels = 256
+
+// maxLabels returns the maximum number of labels injected into an endpoint
+func (n *netConf) maxLabels() int {
+	if n.MaxLabels > 0 {
+		return n.MaxLabels
+	}
+	return defaultMaxLabels
+}
+
+// limitLabels truncates the labels to max labels
+func limitLabels(logger *logrus.Entry, lbls models.Labels, max int) models.Labels {
+	if len(lbls) <= max {
+		return lbls
+	}
+	logger.WithFields(logrus.Fields{
+		"labels":    len(lbls),
+		"maxLabels": max,
+	}).Warn("Too many labels injected into endpoint, dropping excess labels")
+	return lbls[:max]
+}
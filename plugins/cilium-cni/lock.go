@@ -0,0 +1,190 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cilium/cilium/pkg/defaults"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// defaultAddLockDir is the default directory of the lock files
+	// serializing ADDs of the same container interface
+	defaultAddLockDir = defaults.RuntimePath + "/cni-locks"
+
+	// defaultAddLockTimeout is the default time to wait for a concurrent
+	// ADD of the same container interface to complete
+	defaultAddLockTimeout = 30 * time.Second
+
+	// addLockRetryInterval is the interval in which a held lock is polled
+	addLockRetryInterval = 100 * time.Millisecond
+)
+
+// attachmentFileName returns the base name of the files of the container
+// interface
+func attachmentFileName(containerID, ifName string) (string, error) {
+	name := containerID + "-" + ifName
+	if containerID == "" || strings.ContainsRune(name, os.PathSeparator) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid container ID %q or interface name %q", containerID, ifName)
+	}
+	return name, nil
+}
+
+// addLockPath returns the path of the lock file of the container interface
+func addLockPath(dir, containerID, ifName string) (string, error) {
+	name, err := attachmentFileName(containerID, ifName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".lock"), nil
+}
+
+// acquireAddLock acquires the lock of the container interface, waiting up to
+// timeout for a concurrent holder to release it. The lock is an flock(2) on
+// a file in dir and is released by closing the returned file. As the kernel
+// releases the lock when its holder exits, locks of crashed invocations are
+// never stale.
+func acquireAddLock(dir, containerID, ifName string, timeout time.Duration) (*os.File, error) {
+	path, err := addLockPath(dir, containerID, ifName)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, defaults.RuntimePathRights); err != nil {
+		return nil, fmt.Errorf("unable to create lock directory %s: %s", dir, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open lock file: %s", err)
+		}
+		err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			// removeAddLock may have removed the file between opening
+			// and locking it. The lock of a removed file does not
+			// exclude an ADD locking a new file at the same path, the
+			// file is opened again in that case.
+			if isLockFile(f, path) {
+				return f, nil
+			}
+			f.Close()
+			continue
+		}
+		f.Close()
+		if err != unix.EWOULDBLOCK && err != unix.EINTR {
+			return nil, fmt.Errorf("unable to lock %s: %s", path, err)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timeout waiting for concurrent ADD of container %s interface %s", containerID, ifName)
+		}
+		time.Sleep(addLockRetryInterval)
+	}
+}
+
+// isLockFile returns true if the open file f is still the file at path
+func isLockFile(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fi, pi)
+}
+
+// removeAddLock removes the lock file of the container interface. The file is
+// left in place if the lock is currently held by an ADD.
+func removeAddLock(dir, containerID, ifName string) error {
+	path, err := addLockPath(dir, containerID, ifName)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		return fmt.Errorf("lock %s is held: %s", path, err)
+	}
+	// The file may have been replaced between opening and locking it, the
+	// new file is not covered by this lock and must be left in place
+	if !isLockFile(f, path) {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// addSlotsDir is the subdirectory of the lock directory containing the slot
+// files which bound the number of concurrent ADDs
+const addSlotsDir = "slots"
+
+// acquireAddSlot acquires one of max slots bounding the number of ADDs which
+// allocate addresses and create endpoints at once, waiting up to timeout for
+// a slot to become free. A slot is an flock(2) on one of max files in the
+// slots subdirectory of dir and is released by closing the returned file.
+// Like the ADD lock, slots of crashed invocations are released by the
+// kernel.
+func acquireAddSlot(dir string, max int, timeout time.Duration) (*os.File, error) {
+	slotsDir := filepath.Join(dir, addSlotsDir)
+	if err := os.MkdirAll(slotsDir, defaults.RuntimePathRights); err != nil {
+		return nil, fmt.Errorf("unable to create slot directory %s: %s", slotsDir, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		for i := 0; i < max; i++ {
+			path := filepath.Join(slotsDir, fmt.Sprintf("slot-%d.lock", i))
+			f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+			if err != nil {
+				return nil, fmt.Errorf("unable to open slot file: %s", err)
+			}
+			err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+			if err == nil {
+				return f, nil
+			}
+			f.Close()
+			if err != unix.EWOULDBLOCK && err != unix.EINTR {
+				return nil, fmt.Errorf("unable to lock %s: %s", path, err)
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timeout waiting for one of %d concurrent ADDs to complete", max)
+		}
+		time.Sleep(addLockRetryInterval)
+	}
+}
+
+// addLockDir returns the directory of the ADD lock files
+func (n *netConf) addLockDir() string {
+	if n.AddLockDir != "" {
+		return n.AddLockDir
+	}
+	return defaultAddLockDir
+}
@@ -0,0 +1,178 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/mtu"
+
+	"github.com/sirupsen/logrus"
+)
+
+// mtuModeAuto derives the endpoint MTU from the host uplink
+const mtuModeAuto = "auto"
+
+// clampMTU returns whether the route MTU is capped at the path MTU of the
+// host
+func (n *netConf) clampMTU() bool {
+	return n.ClampMTU != nil && *n.ClampMTU
+}
+
+// explicitRouteMTU returns whether the route MTU is set by the network
+// configuration
+func (n *netConf) explicitRouteMTU() bool {
+	return n.MTU > 0 || n.RouteMTU > 0 || n.IPv4MTU > 0 || n.IPv6MTU > 0
+}
+
+// pathMTU returns the path MTU of the host to cap the route MTU at or 0 if
+// the route MTU is not capped
+func (n *netConf) pathMTU(logger *logrus.Entry) int {
+	if !n.clampMTU() || n.explicitRouteMTU() {
+		return 0
+	}
+	pathMTU, err := uplinkMTU()
+	if err != nil {
+		logger.WithError(err).Debug("Unable to detect path MTU, route MTU is not clamped")
+		return 0
+	}
+	return pathMTU
+}
+
+// Sources of the MTU of an endpoint
+const (
+	mtuSourceNetConf = "netconf"
+	mtuSourceAuto    = "auto"
+	mtuSourceAgent   = "agent"
+)
+
+// detectUplinkMTU returns the MTU of the host uplink
+var detectUplinkMTU = mtu.AutoDetect
+
+var uplinkMTUCache struct {
+	sync.Mutex
+	done bool
+	mtu  int
+	err  error
+}
+
+// uplinkMTU returns the MTU of the host uplink. The uplink is only looked up
+// once per invocation, the result is shared by the MTU detection and the
+// path MTU clamp.
+func uplinkMTU() (int, error) {
+	uplinkMTUCache.Lock()
+	defer uplinkMTUCache.Unlock()
+	if !uplinkMTUCache.done {
+		uplinkMTUCache.mtu, uplinkMTUCache.err = detectUplinkMTU()
+		uplinkMTUCache.done = true
+	}
+	return uplinkMTUCache.mtu, uplinkMTUCache.err
+}
+
+// resetUplinkMTU discards the cached MTU of the host uplink
+func resetUplinkMTU() {
+	uplinkMTUCache.Lock()
+	uplinkMTUCache.done = false
+	uplinkMTUCache.Unlock()
+}
+
+// endpointMTU returns the device and route MTU of an endpoint and their
+// source. The MTU of the network configuration takes precedence over the MTU
+// detected in "auto" mode, which takes precedence over the MTU of the agent.
+// A failed detection falls back to the MTU of the agent. The deviceMTU and
+// routeMTU of the network configuration override the respective value
+// regardless of its source.
+func endpointMTU(n *netConf, conf *models.DaemonConfigurationStatus, logger *logrus.Entry) (deviceMTU, routeMTU int64, source string) {
+	switch {
+	case n.MTU > 0:
+		deviceMTU, routeMTU, source = int64(n.MTU), int64(n.MTU), mtuSourceNetConf
+	case n.MTUMode == mtuModeAuto:
+		var ok bool
+		if deviceMTU, routeMTU, ok = detectMTU(n, conf, logger); ok {
+			source = mtuSourceAuto
+			break
+		}
+		fallthrough
+	default:
+		deviceMTU, routeMTU, source = conf.DeviceMTU, conf.RouteMTU, mtuSourceAgent
+		if deviceMTU == 0 || routeMTU == 0 {
+			// The kernel default applies to an MTU of 0
+			logger.WithFields(logrus.Fields{
+				"deviceMTU": deviceMTU,
+				"routeMTU":  routeMTU,
+			}).Warn("cilium-agent did not provide the MTU, using the kernel default")
+		}
+	}
+	if n.DeviceMTU > 0 {
+		deviceMTU, source = int64(n.DeviceMTU), mtuSourceNetConf
+	}
+	if n.RouteMTU > 0 {
+		routeMTU, source = int64(n.RouteMTU), mtuSourceNetConf
+	}
+	logger.WithFields(logrus.Fields{
+		"deviceMTU": deviceMTU,
+		"routeMTU":  routeMTU,
+		"source":    source,
+	}).Debug("Selected endpoint MTU")
+	return
+}
+
+// familyRouteMTU returns the route MTU of the address family. It is the
+// route MTU of the endpoint unless overridden for the family.
+func familyRouteMTU(n *netConf, isIPv6 bool, routeMTU, deviceMTU int) (int, error) {
+	familyMTU, field := n.IPv4MTU, "ipv4MTU"
+	if isIPv6 {
+		familyMTU, field = n.IPv6MTU, "ipv6MTU"
+	}
+	if familyMTU == 0 {
+		return routeMTU, nil
+	}
+	if deviceMTU != 0 && familyMTU > deviceMTU {
+		return 0, fmt.Errorf("%s %d exceeds the device MTU %d", field, familyMTU, deviceMTU)
+	}
+	return familyMTU, nil
+}
+
+// detectMTU returns the device and route MTU of an endpoint based on the MTU
+// of the host uplink. It returns false if detection fails.
+func detectMTU(n *netConf, conf *models.DaemonConfigurationStatus, logger *logrus.Entry) (int64, int64, bool) {
+	uplinkMTU, err := uplinkMTU()
+	if err != nil {
+		logger.WithError(err).Warn("Unable to detect uplink MTU, using MTU of agent")
+		return 0, 0, false
+	}
+
+	// Without the MTU of the agent, the overhead is unknown
+	var overhead int64
+	if conf.DeviceMTU != 0 && conf.RouteMTU != 0 {
+		overhead = conf.DeviceMTU - conf.RouteMTU
+	}
+	if n.MTUOverhead != nil {
+		overhead = int64(*n.MTUOverhead)
+	}
+
+	epMTU := int64(uplinkMTU) - overhead
+	if epMTU <= 0 {
+		logger.WithFields(logrus.Fields{
+			"uplinkMTU": uplinkMTU,
+			"overhead":  overhead,
+		}).Warn("MTU overhead exceeds uplink MTU, using MTU of agent")
+		return 0, 0, false
+	}
+
+	return epMTU, epMTU, true
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// Legacy ethtool commands to set offload features, see
+// include/uapi/linux/ethtool.h
+const (
+	ethtoolSRXCSUM = 0x15
+	ethtoolSTXCSUM = 0x17
+	ethtoolSSG     = 0x19
+	ethtoolSTSO    = 0x1f
+	ethtoolSGSO    = 0x24
+	ethtoolSGRO    = 0x2c
+)
+
+// offloadFeatures maps the ethtool feature names, long and short, to the
+// ethtool command setting the feature
+var offloadFeatures = map[string]uint32{
+	"rx-checksumming":              ethtoolSRXCSUM,
+	"rx":                           ethtoolSRXCSUM,
+	"tx-checksumming":              ethtoolSTXCSUM,
+	"tx":                           ethtoolSTXCSUM,
+	"scatter-gather":               ethtoolSSG,
+	"sg":                           ethtoolSSG,
+	"tcp-segmentation-offload":     ethtoolSTSO,
+	"tso":                          ethtoolSTSO,
+	"generic-segmentation-offload": ethtoolSGSO,
+	"gso":                          ethtoolSGSO,
+	"generic-receive-offload":      ethtoolSGRO,
+	"gro":                          ethtoolSGRO,
+}
+
+// Devices of the veth pair to apply the offload settings to
+const (
+	offloadsDeviceHost      = "host"
+	offloadsDeviceContainer = "container"
+	offloadsDeviceBoth      = "both"
+)
+
+// ethtoolValue is struct ethtool_value
+type ethtoolValue struct {
+	cmd  uint32
+	data uint32
+}
+
+// ifreqData is struct ifreq with ifr_data set, padded to the size of the
+// struct in the kernel
+type ifreqData struct {
+	name [unix.IFNAMSIZ]byte
+	data uintptr
+	_    [16]byte
+}
+
+// validateOffloads validates the feature names and values of the offload
+// settings and the device to apply them to
+func validateOffloads(offloads map[string]string, device string) error {
+	for feature, value := range offloads {
+		if _, ok := offloadFeatures[feature]; !ok {
+			return fmt.Errorf("unknown offload feature %q", feature)
+		}
+		if value != "on" && value != "off" {
+			return fmt.Errorf("invalid value %q for offload feature %q, must be \"on\" or \"off\"", value, feature)
+		}
+	}
+	switch device {
+	case "", offloadsDeviceHost, offloadsDeviceContainer, offloadsDeviceBoth:
+	default:
+		return fmt.Errorf("invalid offloadsDevice %q", device)
+	}
+	return nil
+}
+
+// ethtoolSet runs the ethtool set command with the given value on the
+// interface of the current namespace
+func ethtoolSet(ifName string, cmd, value uint32) error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open socket: %s", err)
+	}
+	defer unix.Close(fd)
+
+	v := &ethtoolValue{cmd: cmd, data: value}
+	req := &ifreqData{data: uintptr(unsafe.Pointer(v))}
+	copy(req.name[:unix.IFNAMSIZ-1], ifName)
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCETHTOOL, uintptr(unsafe.Pointer(req)))
+	runtime.KeepAlive(v)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setOffloads applies the offload settings to the interface. Features not
+// supported by the interface are logged and skipped.
+func setOffloads(logger *logrus.Entry, ifName string, offloads map[string]string) error {
+	for feature, value := range offloads {
+		var data uint32
+		if value == "on" {
+			data = 1
+		}
+		err := ethtoolSet(ifName, offloadFeatures[feature], data)
+		switch err {
+		case nil:
+		case unix.EOPNOTSUPP, unix.EINVAL:
+			logger.WithFields(logrus.Fields{
+				"interface": ifName,
+				"feature":   feature,
+			}).Warn("Offload feature not supported by interface")
+		default:
+			return fmt.Errorf("unable to set %s %s on %s: %s", feature, value, ifName, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/cilium/cilium/api/v1/models"
+)
+
+// validPolicyExempt returns true if the direction is a valid value of
+// policyExempt. An empty direction leaves policy enforcement to the agent.
+func validPolicyExempt(direction string) bool {
+	switch direction {
+	case "",
+		models.EndpointChangeRequestPolicyExemptIngress,
+		models.EndpointChangeRequestPolicyExemptEgress,
+		models.EndpointChangeRequestPolicyExemptBoth:
+		return true
+	default:
+		return false
+	}
+}
+
+// policyExempt returns the direction in which policy enforcement of the
+// endpoint is disabled or an empty string if policy is enforced normally.
+// CNI_ARGS take precedence over the network configuration but are only
+// honoured if allowPolicyExemptArgs is set, as whoever controls the CNI_ARGS
+// of a pod could otherwise disable policy enforcement.
+func (n *netConf) policyExempt(cniArgs cniArgsSpec) (string, error) {
+	if cniArgs.CILIUM_POLICY_EXEMPT == "" {
+		return n.PolicyExempt, nil
+	}
+	if !n.AllowPolicyExemptArgs {
+		return "", fmt.Errorf("CILIUM_POLICY_EXEMPT requires allowPolicyExemptArgs in the network configuration")
+	}
+	direction := string(cniArgs.CILIUM_POLICY_EXEMPT)
+	if !validPolicyExempt(direction) {
+		return "", fmt.Errorf("invalid CILIUM_POLICY_EXEMPT %q", direction)
+	}
+	return direction, nil
+}
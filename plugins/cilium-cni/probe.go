@@ -0,0 +1,176 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/client"
+	"github.com/cilium/cilium/pkg/defaults"
+	endpointid "github.com/cilium/cilium/pkg/endpoint/id"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+)
+
+// cmdProbeName is the value of CNI_COMMAND for the PROBE command. It is not
+// part of the CNI specification and repeatedly creates and deletes a
+// throwaway endpoint to measure the latency of the endpoint API of the agent
+// under load. No network namespace, interface or IPAM is involved. As an
+// additional safeguard the command refuses to run unless probeEnableEnv is
+// set to "true".
+//
+// The probe endpoints are real endpoints of the agent. They are assigned an
+// identity, which may be allocated in the kvstore, are regenerated and show
+// up in the endpoint list and in monitor events until they are deleted. An
+// endpoint whose deletion fails is left behind. PROBE must therefore not be
+// run against an agent serving production workloads, only against agents of
+// test or benchmark clusters.
+const cmdProbeName = "PROBE"
+
+const (
+	// probeEnableEnv must be set to "true" for the PROBE command to run,
+	// see cmdProbeName for why it must not be set on production nodes
+	probeEnableEnv = "CILIUM_CNI_PROBE"
+
+	// probeIterationsEnv is the number of endpoints created and deleted
+	probeIterationsEnv = "CILIUM_CNI_PROBE_ITERATIONS"
+
+	// defaultProbeIterations is the number of iterations if
+	// probeIterationsEnv is not set
+	defaultProbeIterations = 10
+)
+
+// probeClient is the subset of the agent API exercised by the PROBE command
+type probeClient interface {
+	EndpointCreate(ep *models.EndpointChangeRequest) error
+	EndpointDelete(id string) error
+}
+
+// probeLatency summarizes the latencies of one API call. All values are in
+// nanoseconds.
+type probeLatency struct {
+	Count int   `json:"count"`
+	Min   int64 `json:"minNs"`
+	Max   int64 `json:"maxNs"`
+	Mean  int64 `json:"meanNs"`
+	P50   int64 `json:"p50Ns"`
+	P99   int64 `json:"p99Ns"`
+}
+
+// probeStats are the timing statistics printed by the PROBE command
+type probeStats struct {
+	Iterations int          `json:"iterations"`
+	Failures   int          `json:"failures"`
+	Create     probeLatency `json:"create"`
+	Delete     probeLatency `json:"delete"`
+}
+
+// newProbeLatency returns the summary of the successful calls in samples
+func newProbeLatency(samples []time.Duration) probeLatency {
+	l := probeLatency{Count: len(samples)}
+	if len(samples) == 0 {
+		return l
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	var total time.Duration
+	for _, d := range samples {
+		total += d
+	}
+	l.Min = int64(samples[0])
+	l.Max = int64(samples[len(samples)-1])
+	l.Mean = int64(total / time.Duration(len(samples)))
+	l.P50 = int64(samples[(len(samples)-1)*50/100])
+	l.P99 = int64(samples[(len(samples)-1)*99/100])
+	return l
+}
+
+// probeIterations returns the number of iterations from the environment
+func probeIterations() (int, error) {
+	v := os.Getenv(probeIterationsEnv)
+	if v == "" {
+		return defaultProbeIterations, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive integer", probeIterationsEnv, v)
+	}
+	return n, nil
+}
+
+// cmdProbe runs the PROBE command against the agent and writes the timing
+// statistics as JSON to w
+func cmdProbe(w io.Writer) error {
+	if os.Getenv(probeEnableEnv) != "true" {
+		return fmt.Errorf("%s must be set to true to run the %s command", probeEnableEnv, cmdProbeName)
+	}
+
+	iterations, err := probeIterations()
+	if err != nil {
+		return err
+	}
+
+	c, err := client.NewDefaultClientWithTimeout(defaults.ClientConnectTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to connect to Cilium daemon: %s", err)
+	}
+	defer c.Close()
+
+	stats := probeEndpointCreate(c, fmt.Sprintf("cilium-cni-probe-%d", os.Getpid()), iterations)
+	return json.NewEncoder(w).Encode(stats)
+}
+
+// probeEndpointCreate creates and deletes iterations endpoints with container
+// IDs derived from prefix and returns the latencies of both calls. An
+// iteration whose create or delete fails is counted as a failure.
+func probeEndpointCreate(c probeClient, prefix string, iterations int) *probeStats {
+	var creates, deletes []time.Duration
+	stats := &probeStats{Iterations: iterations}
+
+	for i := 0; i < iterations; i++ {
+		containerID := fmt.Sprintf("%s-%d", prefix, i)
+		scopedLog := log.WithField(logfields.ContainerID, containerID)
+		ep := &models.EndpointChangeRequest{
+			ContainerID: containerID,
+			State:       models.EndpointStateWaitingForIdentity,
+		}
+
+		start := time.Now()
+		if err := c.EndpointCreate(ep); err != nil {
+			scopedLog.WithError(err).Warning("Probe endpoint creation failed")
+			stats.Failures++
+			continue
+		}
+		creates = append(creates, time.Since(start))
+
+		start = time.Now()
+		if err := c.EndpointDelete(endpointid.NewID(endpointid.ContainerIdPrefix, containerID)); err != nil {
+			scopedLog.WithError(err).Warning("Probe endpoint deletion failed")
+			stats.Failures++
+			continue
+		}
+		deletes = append(deletes, time.Since(start))
+	}
+
+	stats.Create = newProbeLatency(creates)
+	stats.Delete = newProbeLatency(deletes)
+	return stats
+}
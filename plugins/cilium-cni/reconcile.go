@@ -0,0 +1,295 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/client"
+	"github.com/cilium/cilium/pkg/defaults"
+	endpointid "github.com/cilium/cilium/pkg/endpoint/id"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// cmdReconcileName is the value of CNI_COMMAND for the RECONCILE command. It
+// is not part of the CNI specification and is meant to be invoked by
+// operators to re-apply the addresses and routes of a single endpoint after
+// manual interference. The command is therefore dispatched before handing
+// over to skel.
+const cmdReconcileName = "RECONCILE"
+
+// reconcileClient is the subset of the agent API used to reconcile an
+// endpoint
+type reconcileClient interface {
+	ConfigGet() (*models.DaemonConfiguration, error)
+	EndpointGet(id string) (*models.Endpoint, error)
+}
+
+// reconcileArgs returns the arguments of the RECONCILE command from the
+// environment and the network configuration read from stdin
+func reconcileArgs(stdin io.Reader) (*skel.CmdArgs, error) {
+	args := &skel.CmdArgs{
+		ContainerID: os.Getenv("CNI_CONTAINERID"),
+		Netns:       os.Getenv("CNI_NETNS"),
+		IfName:      os.Getenv("CNI_IFNAME"),
+		Args:        os.Getenv("CNI_ARGS"),
+		Path:        os.Getenv("CNI_PATH"),
+	}
+	if args.ContainerID == "" || args.Netns == "" {
+		return nil, fmt.Errorf("CNI_CONTAINERID and CNI_NETNS are required")
+	}
+
+	data, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read network configuration: %s", err)
+	}
+	args.StdinData = data
+	return args, nil
+}
+
+// reconcileState builds the state of the endpoint of the container from the
+// addressing currently known to the agent. No addresses are allocated.
+func reconcileState(c reconcileClient, n *netConf, containerID, ifName string, logger *logrus.Entry) (*CmdState, *models.IPAMResponse, error) {
+	configResult, err := c.ConfigGet()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to retrieve configuration from cilium-agent: %s", err)
+	}
+	if configResult == nil || configResult.Status == nil {
+		return nil, nil, fmt.Errorf("did not receive configuration from cilium-agent")
+	}
+	conf := *configResult.Status
+	conf.DeviceMTU, conf.RouteMTU, _ = endpointMTU(n, &conf, logger)
+
+	ep, err := c.EndpointGet(endpointid.NewID(endpointid.ContainerIdPrefix, containerID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to retrieve endpoint of container: %s", err)
+	}
+	if ep.Status == nil || ep.Status.Networking == nil || len(ep.Status.Networking.Addressing) == 0 ||
+		ep.Status.Networking.Addressing[0] == nil {
+		return nil, nil, fmt.Errorf("endpoint of container has no addressing")
+	}
+
+	ipam := &models.IPAMResponse{
+		Address:        ep.Status.Networking.Addressing[0],
+		HostAddressing: conf.Addressing,
+	}
+	state := &CmdState{HostAddr: conf.Addressing}
+	if mac, err := net.ParseMAC(ep.Status.Networking.Mac); err == nil {
+		state.IfMAC = mac
+	}
+
+	pathMTU := n.pathMTU(logger)
+	if ipv6IsEnabled(ipam) {
+		routeMTU, err := familyRouteMTU(n, true, int(conf.RouteMTU), int(conf.DeviceMTU))
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, _, err := prepareIP(ipam.Address.IPV6, true, state, routeMTU, int(conf.DeviceMTU), pathMTU, n.StrictGatewayValidation, n.NoGateway, n.Routes); err != nil {
+			return nil, nil, err
+		}
+	}
+	if ipv4IsEnabled(ipam) {
+		routeMTU, err := familyRouteMTU(n, false, int(conf.RouteMTU), int(conf.DeviceMTU))
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, _, err := prepareIP(ipam.Address.IPV4, false, state, routeMTU, int(conf.DeviceMTU), pathMTU, n.StrictGatewayValidation, n.NoGateway, n.Routes); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := reconcileSecondary(n, containerID, ifName, ep.Status.Networking.Addressing[1:], state, ipam, logger); err != nil {
+		return nil, nil, err
+	}
+	if n.PreferredSource != "" {
+		if err := setPreferredSource(state, net.ParseIP(n.PreferredSource)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return state, ipam, nil
+}
+
+// reconcileSecondary adds the secondary addresses of the endpoint, reported
+// by the agent after its primary addresses, to the state. The endpoint also
+// holds the addresses of interfaces attached to it later. If the result of
+// the ADD of the interface is cached, only the addresses cached for the
+// interface are therefore restored.
+func reconcileSecondary(n *netConf, containerID, ifName string, pairs []*models.AddressPair, state *CmdState, ipam *models.IPAMResponse, logger *logrus.Entry) error {
+	var owned map[string]struct{}
+	if dir := n.resultCacheDir(); dir != "" {
+		cached, err := readResultCache(dir, containerID, ifName)
+		if err != nil {
+			logger.WithError(err).Warning("Unable to read result cache, restoring all secondary addresses")
+		} else if cached != nil {
+			owned = make(map[string]struct{}, len(cached.IPs))
+			for _, ip := range cached.IPs {
+				owned[ip] = struct{}{}
+			}
+		}
+	}
+
+	for _, pair := range pairs {
+		if pair == nil {
+			continue
+		}
+		addrs := []struct {
+			ip      string
+			isIPv6  bool
+			enabled bool
+		}{
+			{pair.IPV6, true, ipv6IsEnabled(ipam)},
+			{pair.IPV4, false, ipv4IsEnabled(ipam)},
+		}
+		for _, a := range addrs {
+			if a.ip == "" || !a.enabled {
+				continue
+			}
+			if _, ok := owned[a.ip]; owned != nil && !ok {
+				continue
+			}
+			if _, err := secondaryIPConfig(a.ip, a.isIPv6, state, n.NoGateway); err != nil {
+				return fmt.Errorf("invalid secondary address %s: %s", a.ip, err)
+			}
+		}
+	}
+	return nil
+}
+
+// linkConfig returns the addresses and routes of the link as strings
+func linkConfig(ifName string) ([]string, error) {
+	l, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup %q: %v", ifName, err)
+	}
+
+	addrs, err := netlink.AddrList(l, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list addresses of %q: %v", ifName, err)
+	}
+	routes, err := netlink.RouteList(l, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list routes of %q: %v", ifName, err)
+	}
+
+	config := make([]string, 0, len(addrs)+len(routes))
+	for _, a := range addrs {
+		config = append(config, "addr "+a.IPNet.String())
+	}
+	for _, r := range routes {
+		config = append(config, "route "+r.String())
+	}
+	sort.Strings(config)
+	return config, nil
+}
+
+// configChanges returns the entries of after which are not part of before
+func configChanges(before, after []string) []string {
+	existing := make(map[string]struct{}, len(before))
+	for _, s := range before {
+		existing[s] = struct{}{}
+	}
+
+	var changes []string
+	for _, s := range after {
+		if _, ok := existing[s]; !ok {
+			changes = append(changes, s)
+		}
+	}
+	return changes
+}
+
+// cmdReconcile re-applies the addresses and routes of the endpoint of the
+// container to its interface. It is idempotent, configuration which is
+// already present is left untouched.
+func cmdReconcile(args *skel.CmdArgs) error {
+	logger := log.WithFields(logrus.Fields{
+		logfields.ContainerID: args.ContainerID,
+		"interface":           args.IfName,
+		"netns":               args.Netns,
+	})
+	logger.Debug("Processing CNI RECONCILE request")
+
+	if err := validateIfName(args.IfName); err != nil {
+		return err
+	}
+
+	n, _, err := loadNetConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	if n.chained() {
+		return fmt.Errorf("the interface is owned by the previous plugin in the chain, nothing to reconcile")
+	}
+
+	c, err := client.NewDefaultClientWithTimeout(defaults.ClientConnectTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to connect to Cilium daemon: %s", err)
+	}
+	defer c.Close()
+
+	state, ipam, err := reconcileState(c, n, args.ContainerID, args.IfName, logger)
+	if err != nil {
+		return err
+	}
+
+	var netNs ns.NetNS
+	err = retryNetNSOp(n.netNSRetries(), func() (err error) {
+		netNs, err = ns.GetNS(args.Netns)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to open netns %q: %s", args.Netns, err)
+	}
+	defer netNs.Close()
+
+	var changes []string
+	if err = doInNetNS(n.netNSRetries(), netNs, func() error {
+		before, err := linkConfig(args.IfName)
+		if err != nil {
+			return err
+		}
+		if _, err := configureIface(ipam, args.IfName, state, n.IPv6DAD, false); err != nil {
+			return err
+		}
+		after, err := linkConfig(args.IfName)
+		if err != nil {
+			return err
+		}
+		changes = configChanges(before, after)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		logger.Info("Endpoint datapath is up to date")
+		return nil
+	}
+	for _, change := range changes {
+		logger.WithField("change", change).Info("Restored endpoint datapath configuration")
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/defaults"
+	"github.com/cilium/cilium/pkg/logging/logfields"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// defaultPendingReleaseFile is the default file recording the IPs
+	// which could not be released when rolling back a failed ADD
+	defaultPendingReleaseFile = defaults.RuntimePath + "/cni-pending-release"
+
+	// rollbackReleaseRetries is the number of times a failed release is
+	// retried when rolling back a failed ADD
+	rollbackReleaseRetries = 2
+
+	// pendingReleaseMaxAge is the age after which a pending release is
+	// no longer retried
+	pendingReleaseMaxAge = 24 * time.Hour
+)
+
+// rollbackReleaseInterval is the interval between retries of a failed
+// release when rolling back a failed ADD
+var rollbackReleaseInterval = 250 * time.Millisecond
+
+// pendingRelease is an IP which could not be released when rolling back a
+// failed ADD. It is recorded as a line of JSON in the pending release file.
+// The IP may have been allocated again by the time the record is processed,
+// the owner must therefore be verified before releasing it.
+type pendingRelease struct {
+	IP          string    `json:"ip"`
+	Owner       string    `json:"owner"`
+	ContainerID string    `json:"containerID"`
+	IfName      string    `json:"ifName"`
+	Time        time.Time `json:"time"`
+}
+
+// ipRollback releases the IPs allocated by a failed ADD
+type ipRollback struct {
+	client      ipamClient
+	file        string
+	owner       string
+	containerID string
+	ifName      string
+}
+
+// release releases the IP, retrying on failure. An IP which can't be
+// released is recorded in the pending release file instead of being leaked
+// silently.
+func (r *ipRollback) release(ip string) {
+	if ip == "" {
+		return
+	}
+
+	scopedLog := log.WithField(logfields.IPAddr, ip)
+	var err error
+	for i := 0; ; i++ {
+		if err = r.client.IPAMReleaseIP(ip); err == nil {
+			return
+		}
+		if i >= rollbackReleaseRetries {
+			break
+		}
+		scopedLog.WithError(err).Debug("Retrying release of IP")
+		time.Sleep(rollbackReleaseInterval)
+	}
+
+	scopedLog.WithError(err).Warn("Unable to release IP, recording it as pending release")
+	rec := pendingRelease{IP: ip, Owner: r.owner, ContainerID: r.containerID, IfName: r.ifName, Time: time.Now()}
+	if err := appendPendingRelease(r.file, rec); err != nil {
+		scopedLog.WithError(err).Error("Unable to record pending release, IP is leaked")
+	}
+}
+
+// appendPendingRelease appends the record to the pending release file.
+// Records are written with a single write to a file opened in append mode
+// so that concurrent invocations never interleave. The file is locked while
+// writing so that no record is lost to a concurrent takePendingReleases.
+func appendPendingRelease(file string, rec pendingRelease) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), defaults.RuntimePathRights); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// takePendingReleases returns all records of the pending release file and
+// truncates it. Records which can't be parsed are dropped.
+func takePendingReleases(file string) ([]pendingRelease, error) {
+	f, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if err := f.Truncate(0); err != nil {
+		return nil, err
+	}
+
+	var recs []pendingRelease
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		rec := pendingRelease{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			log.WithError(err).Warn("Dropping malformed pending release")
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	return recs, scanner.Err()
+}
+
+// allocationClient is the subset of the agent API used to release IPs
+// after verifying their owner
+type allocationClient interface {
+	IPAMAllocations() (models.AllocationMap, error)
+	IPAMReleaseIP(ip string) error
+}
+
+// pendingReleaseClient is the subset of the agent API used to replay
+// pending releases
+type pendingReleaseClient interface {
+	allocationClient
+	EndpointList() ([]*models.Endpoint, error)
+}
+
+// replayPendingReleases retries the releases recorded in the pending release
+// file. Records which are still pending afterwards are written back, records
+// older than pendingReleaseMaxAge are dropped.
+func replayPendingReleases(c pendingReleaseClient, file, lockDir string) {
+	recs, err := takePendingReleases(file)
+	if err != nil {
+		log.WithError(err).Warn("Unable to read pending releases")
+		return
+	}
+	if len(recs) == 0 {
+		return
+	}
+
+	// Without the allocations and the endpoints the owner can't be
+	// verified, all records are retried later
+	allocations, err := c.IPAMAllocations()
+	var inUse map[string]struct{}
+	if err == nil {
+		inUse, err = endpointIPs(c)
+	}
+	if err != nil {
+		log.WithError(err).Warn("Unable to verify pending releases, retrying later")
+	}
+
+	for _, rec := range recs {
+		scopedLog := log.WithFields(logrus.Fields{
+			logfields.IPAddr:      rec.IP,
+			logfields.ContainerID: rec.ContainerID,
+			"owner":               rec.Owner,
+		})
+		if time.Since(rec.Time) > pendingReleaseMaxAge {
+			scopedLog.Error("Giving up on pending release, IP is leaked")
+			continue
+		}
+		if err == nil && !replayPendingRelease(c, lockDir, rec, allocations, inUse) {
+			continue
+		}
+		if err := appendPendingRelease(file, rec); err != nil {
+			scopedLog.WithError(err).Error("Unable to record pending release, IP is leaked")
+		}
+	}
+}
+
+// replayPendingRelease releases the IP of the record unless it has been
+// released or allocated again since. The attachment of the record is locked
+// so that an ADD of the same attachment, which may allocate the IP for the
+// same owner again, can't run concurrently. It returns true if the release
+// must be retried later.
+func replayPendingRelease(c pendingReleaseClient, lockDir string, rec pendingRelease, allocations models.AllocationMap, inUse map[string]struct{}) bool {
+	scopedLog := log.WithField(logfields.IPAddr, rec.IP)
+
+	lock, err := acquireAddLock(lockDir, rec.ContainerID, rec.IfName, 0)
+	if err != nil {
+		scopedLog.WithError(err).Debug("Unable to lock attachment of pending release, retrying later")
+		return true
+	}
+	defer lock.Close()
+
+	if owner, ok := allocations[rec.IP]; !ok || owner != rec.Owner {
+		scopedLog.Info("IP of pending release is no longer allocated to its owner, dropping it")
+		return false
+	}
+	if _, ok := inUse[rec.IP]; ok {
+		scopedLog.Info("IP of pending release is used by an endpoint, dropping it")
+		return false
+	}
+	if err := c.IPAMReleaseIP(rec.IP); err != nil {
+		scopedLog.WithError(err).Warn("Unable to release IP of pending release, retrying later")
+		return true
+	}
+	scopedLog.Info("Released IP of pending release")
+	return false
+}
+
+// endpointIPs returns the IPs used by all endpoints
+func endpointIPs(c pendingReleaseClient) (map[string]struct{}, error) {
+	endpoints, err := c.EndpointList()
+	if err != nil {
+		return nil, err
+	}
+	ips := map[string]struct{}{}
+	for _, ep := range endpoints {
+		if ep.Status == nil || ep.Status.Networking == nil {
+			continue
+		}
+		for _, pair := range ep.Status.Networking.Addressing {
+			if pair.IPV4 != "" {
+				ips[pair.IPV4] = struct{}{}
+			}
+			if pair.IPV6 != "" {
+				ips[pair.IPV6] = struct{}{}
+			}
+		}
+	}
+	return ips, nil
+}
+
+// ownedIPRelease returns a function releasing an IP only if Cilium allocated
+// it to owner. The result passed to DEL lists the interfaces and addresses
+// of all plugins of the chain, the addresses of other plugins are managed
+// by their own IPAM. The allocations are retrieved on first use.
+func ownedIPRelease(c allocationClient, owner string) func(ip string) error {
+	var (
+		allocations models.AllocationMap
+		err         error
+		fetched     bool
+	)
+	return func(ip string) error {
+		if !fetched {
+			allocations, err = c.IPAMAllocations()
+			fetched = true
+		}
+		if err != nil {
+			return fmt.Errorf("unable to verify owner: %s", err)
+		}
+		if allocations[ip] != owner {
+			log.WithField(logfields.IPAddr, ip).Debug("IP is not allocated by Cilium for the container, not releasing it")
+			return nil
+		}
+		return c.IPAMReleaseIP(ip)
+	}
+}
+
+// pendingReleaseFile returns the file recording IPs pending release
+func (n *netConf) pendingReleaseFile() string {
+	if n.PendingReleaseFile != "" {
+		return n.PendingReleaseFile
+	}
+	return defaultPendingReleaseFile
+}
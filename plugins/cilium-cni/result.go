@@ -0,0 +1,437 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/datapath/linux/route"
+
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+	cniTypes020 "github.com/containernetworking/cni/pkg/types/020"
+	cniTypesVer "github.com/containernetworking/cni/pkg/types/current"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// ciliumResult is the result of the ADD command. It extends the result
+// defined by the CNI spec with Cilium specific details which runtimes
+// ignore.
+type ciliumResult struct {
+	cniTypesVer.Result
+	Cilium *resultDetails `json:"cilium,omitempty"`
+}
+
+// resultDetails contains the Cilium specific details of a result
+type resultDetails struct {
+	Routes []*routeDetails `json:"routes,omitempty"`
+	Chain  *chainDetails   `json:"chain,omitempty"`
+	MTU    *mtuDetails     `json:"mtu,omitempty"`
+	Lease  *leaseDetails   `json:"lease,omitempty"`
+	Pools  *poolDetails    `json:"pools,omitempty"`
+	// EndpointID is the ID assigned to the endpoint by the agent
+	EndpointID int64 `json:"endpointID,omitempty"`
+}
+
+// poolDetails is set if the IPAM backend reported the IP pools the
+// addresses of the endpoint were allocated from
+type poolDetails struct {
+	IPv4 string `json:"ipv4,omitempty"`
+	IPv6 string `json:"ipv6,omitempty"`
+}
+
+// leaseDetails is set if the addresses of the endpoint are leased by the
+// IPAM backend and expire
+type leaseDetails struct {
+	// Expiration is the time at which the lease expires in RFC 3339
+	// format
+	Expiration string `json:"expiration"`
+}
+
+// mtuDetails is set if the MTU of the endpoint differs from the MTU of the
+// agent
+type mtuDetails struct {
+	Device int64 `json:"device"`
+	Route  int64 `json:"route"`
+	// Source is the source of the MTU, see mtuSource*
+	Source string `json:"source"`
+}
+
+// chainDetails is set if the plugin was invoked as part of a chain after
+// another plugin
+type chainDetails struct {
+	// Name is the name of the network the chain belongs to
+	Name string `json:"name"`
+}
+
+// routeDetails contains the attributes of a route which can't be
+// represented by a CNI route
+type routeDetails struct {
+	Dst   cniTypes.IPNet `json:"dst"`
+	Table int            `json:"table,omitempty"`
+	MTU   int            `json:"mtu,omitempty"`
+}
+
+// newRouteDetails returns the details of the given route or nil if the route
+// is fully described by its CNI representation
+func newRouteDetails(r route.Route) *routeDetails {
+	d := &routeDetails{
+		Dst: cniTypes.IPNet(r.Prefix),
+		MTU: r.MTU,
+	}
+	if r.Table != unix.RT_TABLE_MAIN {
+		d.Table = r.Table
+	}
+	if d.Table == 0 && d.MTU == 0 {
+		return nil
+	}
+	return d
+}
+
+// addRouteDetails adds the details of all routes which can't be fully
+// represented by CNI routes to the result
+func (r *ciliumResult) addRouteDetails(routes []route.Route) {
+	for _, rt := range routes {
+		if d := newRouteDetails(rt); d != nil {
+			r.details().Routes = append(r.details().Routes, d)
+		}
+	}
+}
+
+// addLeaseDetails adds the expiration of the lease of the addresses to the
+// result. Nothing is added if the addresses do not expire.
+func (r *ciliumResult) addLeaseDetails(ipam *models.IPAMResponse) {
+	expiration := time.Time(ipam.Expiration)
+	if expiration.IsZero() {
+		return
+	}
+	r.details().Lease = &leaseDetails{Expiration: expiration.UTC().Format(time.RFC3339)}
+}
+
+// addPoolDetails adds the IP pools the addresses were allocated from to the
+// result. Nothing is added if the IPAM backend did not report any pool.
+func (r *ciliumResult) addPoolDetails(ipam *models.IPAMResponse) {
+	if ipam.IPV4Pool == "" && ipam.IPV6Pool == "" {
+		return
+	}
+	r.details().Pools = &poolDetails{IPv4: ipam.IPV4Pool, IPv6: ipam.IPV6Pool}
+}
+
+func (r *ciliumResult) details() *resultDetails {
+	if r.Cilium == nil {
+		r.Cilium = &resultDetails{}
+	}
+	return r.Cilium
+}
+
+const (
+	// specVersion100 is version 1.0.0 of the CNI specification. The
+	// vendored types predate it, its results are represented by
+	// result100.
+	specVersion100 = "1.0.0"
+
+	// specVersion110 is version 1.1.0 of the CNI specification. Its
+	// results have the same format as those of 1.0.0.
+	specVersion110 = "1.1.0"
+)
+
+// isResult100Version returns true if results of the CNI version are
+// represented by result100
+func isResult100Version(version string) bool {
+	return version == specVersion100 || version == specVersion110
+}
+
+// result100 is a result of version 1.0.0 or 1.1.0 of the CNI specification.
+// It only differs from the current result type by dropping the version of
+// the addresses, which is implied by the address itself.
+type result100 struct {
+	CNIVersion string                   `json:"cniVersion,omitempty"`
+	Interfaces []*cniTypesVer.Interface `json:"interfaces,omitempty"`
+	IPs        []*ipConfig100           `json:"ips,omitempty"`
+	Routes     []*cniTypes.Route        `json:"routes,omitempty"`
+	DNS        cniTypes.DNS             `json:"dns,omitempty"`
+	Cilium     *resultDetails           `json:"cilium,omitempty"`
+}
+
+// ipConfig100 is an address of a result100
+type ipConfig100 struct {
+	Interface *int           `json:"interface,omitempty"`
+	Address   cniTypes.IPNet `json:"address"`
+	Gateway   net.IP         `json:"gateway,omitempty"`
+}
+
+// newResult100 converts the result into a result of the given version, which
+// must be represented by result100
+func newResult100(r *ciliumResult, version string) *result100 {
+	res := &result100{
+		CNIVersion: version,
+		Interfaces: r.Interfaces,
+		Routes:     r.Routes,
+		DNS:        r.DNS,
+		Cilium:     r.Cilium,
+	}
+	for _, ip := range r.IPs {
+		res.IPs = append(res.IPs, &ipConfig100{
+			Interface: ip.Interface,
+			Address:   cniTypes.IPNet(ip.Address),
+			Gateway:   ip.Gateway,
+		})
+	}
+	return res
+}
+
+// Version returns the version of the result
+func (r *result100) Version() string {
+	return r.CNIVersion
+}
+
+// GetAsVersion returns the result in the given CNI version
+func (r *result100) GetAsVersion(version string) (cniTypes.Result, error) {
+	if isResult100Version(version) {
+		converted := *r
+		converted.CNIVersion = version
+		return &converted, nil
+	}
+	res := &ciliumResult{Cilium: r.Cilium}
+	res.CNIVersion = cniTypesVer.ImplementedSpecVersion
+	res.Interfaces = r.Interfaces
+	res.Routes = r.Routes
+	res.DNS = r.DNS
+	for _, ip := range r.IPs {
+		res.IPs = append(res.IPs, &cniTypesVer.IPConfig{
+			Interface: ip.Interface,
+			Address:   net.IPNet(ip.Address),
+			Gateway:   ip.Gateway,
+		})
+	}
+	fillIPVersions(&res.Result)
+	return res.GetAsVersion(version)
+}
+
+// Print writes the result to stdout
+func (r *result100) Print() error {
+	return r.PrintTo(os.Stdout)
+}
+
+// PrintTo writes the result to the given writer
+func (r *result100) PrintTo(writer io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	return err
+}
+
+// String returns a string representation of the result
+func (r *result100) String() string {
+	return fmt.Sprintf("Interfaces:%+v, IP:%+v, Routes:%+v, DNS:%+v", r.Interfaces, r.IPs, r.Routes, r.DNS)
+}
+
+// fillIPVersions sets the version of the addresses of a result which was
+// converted from a result100 omitting them
+func fillIPVersions(r *cniTypesVer.Result) {
+	for _, ip := range r.IPs {
+		if ip.Version != "" {
+			continue
+		}
+		if ip.Address.IP.To4() != nil {
+			ip.Version = "4"
+		} else {
+			ip.Version = "6"
+		}
+	}
+}
+
+// GetAsVersion returns the result in the given CNI version. The Cilium
+// specific details are only retained for versions which are represented by
+// the current result type or by result100. The result itself is left
+// unchanged.
+func (r *ciliumResult) GetAsVersion(version string) (cniTypes.Result, error) {
+	if isResult100Version(version) {
+		return newResult100(r, version), nil
+	}
+	// The conversion of the embedded result sets its version, convert a
+	// copy
+	converted := *r
+	res, err := converted.Result.GetAsVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	if res == cniTypes.Result(&converted.Result) {
+		return &converted, nil
+	}
+	// The conversion always yields a 0.2.0 result, report the version
+	// requested by the runtime
+	return res.GetAsVersion(version)
+}
+
+// validateResult checks that the addresses of the result match their
+// address family and that the result retains an address when converted to
+// the given CNI version. Versions before 0.3.0 define IPv4 as mandatory,
+// IPv6-only results are still emitted for them but runtimes may reject
+// them.
+func validateResult(logger *logrus.Entry, r *ciliumResult, version string) error {
+	if len(r.IPs) == 0 {
+		return fmt.Errorf("result does not contain any addresses")
+	}
+	var have4 bool
+	for _, ip := range r.IPs {
+		is4 := ip.Address.IP.To4() != nil
+		if is4 != (ip.Version == "4") {
+			return fmt.Errorf("address %s does not match IP version %q", ip.Address.IP, ip.Version)
+		}
+		have4 = have4 || is4
+	}
+	if have4 {
+		return nil
+	}
+
+	logger.Info("Attachment is IPv6-only")
+	res, err := r.GetAsVersion(version)
+	if err != nil {
+		return err
+	}
+	if r020, ok := res.(*cniTypes020.Result); ok {
+		if r020.IP6 == nil {
+			return fmt.Errorf("IPv6 address is missing in result of CNI version %s", version)
+		}
+		logger.WithField("cniVersion", version).
+			Warn("CNI version requires an IPv4 address, the runtime may reject the IPv6-only result")
+	}
+	return nil
+}
+
+// Print writes the result to stdout
+func (r *ciliumResult) Print() error {
+	return r.PrintTo(os.Stdout)
+}
+
+// PrintTo writes the result to the given writer
+func (r *ciliumResult) PrintTo(writer io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(data)
+	return err
+}
+
+// verboseResult contains the details of a successful ADD which are written
+// to a file in verbose mode, in addition to the result printed to stdout
+type verboseResult struct {
+	ContainerID string       `json:"containerID"`
+	IfName      string       `json:"ifName"`
+	EndpointID  int64        `json:"endpointID,omitempty"`
+	Pools       *poolDetails `json:"pools,omitempty"`
+	MTU         mtuDetails   `json:"mtu"`
+	IPv4Gateway string       `json:"ipv4Gateway,omitempty"`
+	IPv6Gateway string       `json:"ipv6Gateway,omitempty"`
+	// Timing are the durations of the phases of the ADD
+	Timing map[string]string `json:"timing,omitempty"`
+}
+
+// newVerboseResult returns the verbose details of the given result
+func newVerboseResult(containerID, ifName string, r *ciliumResult, mtu mtuDetails, timing map[string]string) *verboseResult {
+	v := &verboseResult{
+		ContainerID: containerID,
+		IfName:      ifName,
+		MTU:         mtu,
+		Timing:      timing,
+	}
+	if r.Cilium != nil {
+		v.EndpointID = r.Cilium.EndpointID
+		v.Pools = r.Cilium.Pools
+	}
+	for _, ip := range r.IPs {
+		if ip.Gateway == nil {
+			continue
+		}
+		if ip.Version == "4" && v.IPv4Gateway == "" {
+			v.IPv4Gateway = ip.Gateway.String()
+		} else if ip.Version == "6" && v.IPv6Gateway == "" {
+			v.IPv6Gateway = ip.Gateway.String()
+		}
+	}
+	return v
+}
+
+// verboseResultPath returns the path of the verbose result of the container
+// interface
+func verboseResultPath(dir, containerID, ifName string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s-%s.json", containerID, ifName))
+}
+
+// writeVerboseResult writes the verbose result into the directory
+func writeVerboseResult(dir string, v *verboseResult) error {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeResultFile(dir, verboseResultPath(dir, v.ContainerID, v.IfName), data)
+}
+
+// resultCopyPath returns the path of the copy of the result of the container
+// interface
+func resultCopyPath(dir, containerID, ifName string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s-%s.result.json", containerID, ifName))
+}
+
+// writeResultCopy writes the result, exactly as printed to the runtime in
+// the given CNI version, into the directory
+func writeResultCopy(dir, containerID, ifName string, r cniTypes.Result, version string) error {
+	res, err := r.GetAsVersion(version)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := res.PrintTo(&buf); err != nil {
+		return err
+	}
+	return writeResultFile(dir, resultCopyPath(dir, containerID, ifName), buf.Bytes())
+}
+
+// writeResultFile writes data to path in the directory. The file is replaced
+// atomically so that readers never observe a partial result.
+func writeResultFile(dir, path string, data []byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
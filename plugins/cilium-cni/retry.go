@@ -0,0 +1,205 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cilium/cilium/pkg/logging/logfields"
+	"github.com/cilium/cilium/pkg/netns"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// defaultNetNSRetries is the default number of times a namespace
+	// operation failing with a transient error is retried
+	defaultNetNSRetries = 2
+
+	// netNSRetryInterval is the time to wait between retries of a
+	// namespace operation
+	netNSRetryInterval = 50 * time.Millisecond
+
+	// ifRemovalChecks is the number of times the removal of an interface
+	// is checked before it is considered stuck
+	ifRemovalChecks = 10
+)
+
+// errnoIn returns true if err is or wraps one of the given errnos. Most
+// errors of namespace operations are wrapped with fmt.Errorf, the message is
+// therefore checked as well.
+func errnoIn(err error, errnos ...syscall.Errno) bool {
+	for _, errno := range errnos {
+		if err == errno || strings.Contains(err.Error(), errno.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransientNetNSError returns true if the namespace operation failed with
+// an error which is likely to go away when retried, e.g. during rapid pod
+// churn
+func isTransientNetNSError(err error) bool {
+	return errnoIn(err, unix.EAGAIN, unix.EBUSY, unix.EINTR)
+}
+
+// isMissingNetNSError returns true if the namespace operation failed because
+// the namespace or interface does not exist (anymore)
+func isMissingNetNSError(err error) bool {
+	return errnoIn(err, unix.ENOENT) || strings.Contains(err.Error(), "Link not found")
+}
+
+// retryNetNSOp runs op and retries it up to retries times as long as it fails
+// with a transient error
+func retryNetNSOp(retries int, op func() error) error {
+	var err error
+	for i := 0; ; i++ {
+		err = op()
+		if err == nil || !isTransientNetNSError(err) || i >= retries {
+			return err
+		}
+		log.WithError(err).Debug("Retrying namespace operation after transient error")
+		time.Sleep(netNSRetryInterval)
+	}
+}
+
+// netNSMissingError is returned by openNetNS if the path of the namespace
+// does not exist, e.g. because the sandbox was already torn down
+type netNSMissingError struct {
+	path string
+}
+
+func (e *netNSMissingError) Error() string {
+	return fmt.Sprintf("netns path %q does not exist", e.path)
+}
+
+// openNetNS opens the namespace at path with open, retrying on transient
+// errors. A missing path is reported as netNSMissingError so that it can be
+// told apart from a namespace which exists but can't be entered.
+func openNetNS(retries int, path string, open func(string) (ns.NetNS, error)) (ns.NetNS, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, &netNSMissingError{path: path}
+	}
+
+	var netNs ns.NetNS
+	err := retryNetNSOp(retries, func() (err error) {
+		netNs, err = open(path)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("netns %q exists but can't be entered: %s", path, err)
+	}
+	return netNs, nil
+}
+
+// removeIfFromNetNS removes the interface from the namespace, retrying on
+// transient errors. An interface which disappears while being removed is not
+// considered an error.
+func removeIfFromNetNS(retries int, netNs ns.NetNS, ifName string) error {
+	err := retryNetNSOp(retries, func() error {
+		return netns.RemoveIfFromNetNSIfExists(netNs, ifName)
+	})
+	if err != nil && isMissingNetNSError(err) {
+		return nil
+	}
+	return err
+}
+
+// downAndFlushIf removes all addresses of the interface in the namespace
+// and brings it down
+func downAndFlushIf(retries int, netNs ns.NetNS, ifName string) error {
+	return doInNetNS(retries, netNs, func() error {
+		link, err := netlink.LinkByName(ifName)
+		if err != nil {
+			return err
+		}
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return fmt.Errorf("unable to list addresses of %s: %s", ifName, err)
+		}
+		for i := range addrs {
+			if err := netlink.AddrDel(link, &addrs[i]); err != nil {
+				return fmt.Errorf("unable to remove address %s from %s: %s", addrs[i].IPNet, ifName, err)
+			}
+		}
+		if err := netlink.LinkSetDown(link); err != nil {
+			return fmt.Errorf("unable to bring down %s: %s", ifName, err)
+		}
+		return nil
+	})
+}
+
+// downBeforeRemove returns a removal function which brings the interface
+// down and flushes its addresses before removing it with remove. Failures
+// to bring the interface down are logged, the interface is removed anyway.
+func downBeforeRemove(remove func(retries int, netNs ns.NetNS, ifName string) error) func(retries int, netNs ns.NetNS, ifName string) error {
+	return func(retries int, netNs ns.NetNS, ifName string) error {
+		if err := downAndFlushIf(retries, netNs, ifName); err != nil && !isMissingNetNSError(err) {
+			log.WithError(err).WithField(logfields.Interface, ifName).Warning("Unable to bring down interface before deleting it")
+		}
+		return remove(retries, netNs, ifName)
+	}
+}
+
+// verifyIfRemoved checks that the interface is gone from the namespace. As
+// some kernels defer the deletion of links, the check is repeated a few
+// times before an error is returned.
+func verifyIfRemoved(netNs ns.NetNS, ifName string) error {
+	var err error
+	for i := 0; i < ifRemovalChecks; i++ {
+		if i > 0 {
+			time.Sleep(netNSRetryInterval)
+		}
+		err = netNs.Do(func(_ ns.NetNS) error {
+			_, err := netlink.LinkByName(ifName)
+			return err
+		})
+		if err != nil {
+			if _, ok := err.(netlink.LinkNotFoundError); ok || isMissingNetNSError(err) {
+				return nil
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("interface %s still present after %d checks", ifName, ifRemovalChecks)
+}
+
+// doInNetNS runs toRun in the namespace. Failures to enter the namespace are
+// retried if transient, failures of toRun itself are not as it may not be
+// idempotent.
+func doInNetNS(retries int, netNs ns.NetNS, toRun func() error) error {
+	var entered bool
+	var err error
+	for i := 0; ; i++ {
+		err = netNs.Do(func(_ ns.NetNS) error {
+			entered = true
+			return toRun()
+		})
+		if err == nil || entered || !isTransientNetNSError(err) || i >= retries {
+			return err
+		}
+		log.WithError(err).Debug("Retrying to enter namespace after transient error")
+		time.Sleep(netNSRetryInterval)
+	}
+}
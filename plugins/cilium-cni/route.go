@@ -0,0 +1,228 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/cilium/cilium/common/addressing"
+	"github.com/cilium/cilium/pkg/datapath/linux/route"
+	"github.com/cilium/cilium/pkg/defaults"
+	"github.com/cilium/cilium/pkg/endpoint/connector"
+
+	cniTypesVer "github.com/containernetworking/cni/pkg/types/current"
+	"github.com/vishvananda/netlink"
+)
+
+// routeScopes maps the names of route scopes to their netlink value
+var routeScopes = map[string]netlink.Scope{
+	"global":   netlink.SCOPE_UNIVERSE,
+	"universe": netlink.SCOPE_UNIVERSE,
+	"site":     netlink.SCOPE_SITE,
+	"link":     netlink.SCOPE_LINK,
+	"host":     netlink.SCOPE_HOST,
+}
+
+// parseRoute converts the route into its datapath representation. The
+// gateway is used as nexthop if the route does not specify one. Link-scope
+// routes use the device MTU, all others the route MTU.
+func parseRoute(r Route, gateway net.IP, routeMTU, deviceMTU int) (*route.Route, error) {
+	_, dst, err := net.ParseCIDR(r.Dst)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination %q: %s", r.Dst, err)
+	}
+
+	nexthop := gateway
+	if r.GW != "" {
+		if nexthop = net.ParseIP(r.GW); nexthop == nil {
+			return nil, fmt.Errorf("invalid gateway %q of route to %s", r.GW, r.Dst)
+		}
+		if (nexthop.To4() == nil) != (dst.IP.To4() == nil) {
+			return nil, fmt.Errorf("gateway %s and destination %s of route differ in address family", r.GW, r.Dst)
+		}
+	}
+
+	if r.Table < 0 {
+		return nil, fmt.Errorf("invalid table %d of route to %s", r.Table, r.Dst)
+	}
+	if r.Priority < 0 {
+		return nil, fmt.Errorf("invalid priority %d of route to %s", r.Priority, r.Dst)
+	}
+
+	var scope netlink.Scope
+	if r.Scope != "" {
+		var ok bool
+		if scope, ok = routeScopes[r.Scope]; !ok {
+			return nil, fmt.Errorf("invalid scope %q of route to %s", r.Scope, r.Dst)
+		}
+	}
+
+	mtu := routeMTU
+	if scope == netlink.SCOPE_LINK {
+		mtu = deviceMTU
+	}
+
+	rt := &route.Route{
+		Prefix:   *dst,
+		MTU:      mtu,
+		Scope:    scope,
+		Table:    r.Table,
+		Priority: r.Priority,
+	}
+	if nexthop != nil {
+		rt.Nexthop = &nexthop
+	} else if scope != netlink.SCOPE_LINK {
+		// Without a gateway, the destination must be directly attached
+		return nil, fmt.Errorf("route to %s requires a gateway or link scope", r.Dst)
+	}
+	return rt, nil
+}
+
+// extraRoutes returns the additional routes of the given address family
+func extraRoutes(routes []Route, isIPv6 bool, gateway net.IP, routeMTU, deviceMTU int) ([]route.Route, error) {
+	var result []route.Route
+	for _, r := range routes {
+		rt, err := parseRoute(r, gateway, routeMTU, deviceMTU)
+		if err != nil {
+			return nil, err
+		}
+		if (rt.Prefix.IP.To4() == nil) == isIPv6 {
+			result = append(result, *rt)
+		}
+	}
+	return result, nil
+}
+
+// validateGateway returns an error if the gateway is not within the
+// allocation range the endpoint address belongs to. IPv6 link-local gateways
+// are always considered valid.
+func validateGateway(ip addressing.CiliumIP, gw net.IP, allocRange string) error {
+	if ip.IsIPv6() && gw.IsLinkLocalUnicast() {
+		return nil
+	}
+
+	_, subnet, err := net.ParseCIDR(allocRange)
+	if err != nil {
+		return fmt.Errorf("unable to validate gateway %s of endpoint prefix %s: invalid allocation range %q",
+			gw, ip.EndpointPrefix(), allocRange)
+	}
+
+	if !subnet.Contains(ip.IP()) || !subnet.Contains(gw) {
+		return fmt.Errorf("gateway %s and endpoint prefix %s are not in the same subnet %s",
+			gw, ip.EndpointPrefix(), subnet)
+	}
+
+	return nil
+}
+
+// noGatewayRoutes returns the routes of an endpoint without gateway. The
+// default route has link scope, all destinations are resolved on the link.
+func noGatewayRoutes(isIPv6 bool, routeMTU int) []route.Route {
+	dst := defaults.IPv4DefaultRoute
+	if isIPv6 {
+		dst = defaults.IPv6DefaultRoute
+	}
+	return []route.Route{
+		{
+			Prefix: dst,
+			MTU:    routeMTU,
+			Scope:  netlink.SCOPE_LINK,
+		},
+	}
+}
+
+// setPreferredSource sets src as the source address of the default routes of
+// its address family. The source must be one of the addresses of the endpoint.
+func setPreferredSource(state *CmdState, src net.IP) error {
+	var (
+		routes []route.Route
+		ips    []addressing.CiliumIP
+	)
+
+	if src.To4() != nil {
+		routes = state.IP4routes
+		if state.IP4.IsSet() {
+			ips = append(ips, state.IP4)
+		}
+	} else {
+		routes = state.IP6routes
+		if state.IP6.IsSet() {
+			ips = append(ips, state.IP6)
+		}
+	}
+	for _, ip := range state.Secondary {
+		if ip.IsIPv6() == (src.To4() == nil) {
+			ips = append(ips, ip)
+		}
+	}
+
+	found := false
+	for _, ip := range ips {
+		if ip.IP().Equal(src) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("preferred source %s is not an address of the endpoint", src)
+	}
+
+	for i := range routes {
+		if ones, _ := routes[i].Prefix.Mask.Size(); ones == 0 {
+			routes[i].Local = src
+		}
+	}
+	return nil
+}
+
+// secondaryIPConfig parses the given secondary address, records it in the
+// state and returns its IP configuration. The gateway is the same as for the
+// primary address of the family.
+func secondaryIPConfig(ipAddr string, isIPv6 bool, state *CmdState, noGateway bool) (*cniTypesVer.IPConfig, error) {
+	var (
+		ip        addressing.CiliumIP
+		gw        string
+		ipVersion string
+		err       error
+	)
+
+	if isIPv6 {
+		if ip, err = addressing.NewCiliumIPv6(ipAddr); err != nil {
+			return nil, err
+		}
+		gw = connector.IPv6Gateway(state.HostAddr)
+		ipVersion = "6"
+	} else {
+		if ip, err = addressing.NewCiliumIPv4(ipAddr); err != nil {
+			return nil, err
+		}
+		gw = connector.IPv4Gateway(state.HostAddr)
+		ipVersion = "4"
+	}
+
+	gwIP := net.ParseIP(gw)
+	if gwIP == nil && !noGateway {
+		return nil, fmt.Errorf("Invalid gateway address: %s", gw)
+	}
+
+	state.Secondary = append(state.Secondary, ip)
+
+	return &cniTypesVer.IPConfig{
+		Address: *ip.EndpointPrefix(),
+		Gateway: gwIP,
+		Version: ipVersion,
+	}, nil
+}
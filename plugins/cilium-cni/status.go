@@ -0,0 +1,77 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/cilium/cilium/api/v1/models"
+	"github.com/cilium/cilium/pkg/client"
+	"github.com/cilium/cilium/pkg/defaults"
+
+	cniTypes "github.com/containernetworking/cni/pkg/types"
+)
+
+// cmdStatusName is the value of CNI_COMMAND for the STATUS command introduced
+// with version 1.1.0 of the CNI specification. Like GC, it is dispatched
+// before handing over to skel.
+const cmdStatusName = "STATUS"
+
+// statusClient is the subset of the agent API required by ADD which STATUS
+// verifies to be available
+type statusClient interface {
+	ConfigGet() (*models.DaemonConfiguration, error)
+}
+
+// cmdStatus reports whether the plugin is ready to service ADD requests of
+// the network configuration read from stdin
+func cmdStatus(stdin io.Reader) error {
+	data, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return fmt.Errorf("unable to read network configuration: %s", err)
+	}
+	if _, _, err := loadNetConf(data); err != nil {
+		return err
+	}
+
+	c, err := client.NewDefaultClientWithTimeout(defaults.ClientConnectTimeout)
+	if err != nil {
+		return pluginNotAvailable(err)
+	}
+	defer c.Close()
+
+	return agentStatus(c)
+}
+
+// agentStatus returns an error unless the agent serves its configuration,
+// which is the first request of every ADD
+func agentStatus(c statusClient) error {
+	if _, err := c.ConfigGet(); err != nil {
+		return pluginNotAvailable(err)
+	}
+	return nil
+}
+
+// pluginNotAvailable returns the CNI error of STATUS reporting that the agent
+// can't be reached
+func pluginNotAvailable(err error) error {
+	return &cniTypes.Error{
+		Code:    errCodePluginNotAvailable,
+		Msg:     "unable to connect to Cilium daemon",
+		Details: err.Error(),
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Phases of an ADD which are timed
+const (
+	timingNetConfLoad    = "netconf-load"
+	timingArgsParse      = "args-parse"
+	timingLockAcquire    = "lock-acquire"
+	timingClientConnect  = "client-connect"
+	timingNetNSPrepare   = "netns-prepare"
+	timingConfigGet      = "config-get"
+	timingDatapathSetup  = "datapath-setup"
+	timingSlotAcquire    = "slot-acquire"
+	timingIPAMAllocate   = "ipam-allocate"
+	timingIfaceConfigure = "iface-configure"
+	timingPreAddHook     = "pre-add-hook"
+	timingEndpointCreate = "endpoint-create"
+)
+
+// phaseDuration is the duration of a completed phase
+type phaseDuration struct {
+	phase    string
+	duration time.Duration
+}
+
+// phaseTimer measures the durations of the phases of a CNI command and logs
+// them at debug level. All methods are no-ops unless debug logging is
+// enabled.
+type phaseTimer struct {
+	logger  *logrus.Entry
+	enabled bool
+
+	start      time.Time
+	phase      string
+	phaseStart time.Time
+	durations  []phaseDuration
+}
+
+func newPhaseTimer(logger *logrus.Entry) *phaseTimer {
+	t := &phaseTimer{
+		logger:  logger,
+		enabled: logger.Logger.IsLevelEnabled(logrus.DebugLevel),
+	}
+	if t.enabled {
+		t.start = time.Now()
+	}
+	return t
+}
+
+// begin ends the current phase, if any, and begins the given phase
+func (t *phaseTimer) begin(phase string) {
+	if !t.enabled {
+		return
+	}
+	t.end()
+	t.phase = phase
+	t.phaseStart = time.Now()
+	t.logger.WithField("phase", phase).Debug("Phase started")
+}
+
+// end ends the current phase
+func (t *phaseTimer) end() {
+	if !t.enabled || t.phase == "" {
+		return
+	}
+	d := time.Since(t.phaseStart)
+	t.durations = append(t.durations, phaseDuration{phase: t.phase, duration: d})
+	t.logger.WithFields(logrus.Fields{
+		"phase":    t.phase,
+		"duration": d,
+	}).Debug("Phase completed")
+	t.phase = ""
+}
+
+// durationsByPhase returns the durations of the completed phases. Nil is
+// returned unless debug logging is enabled.
+func (t *phaseTimer) durationsByPhase() map[string]string {
+	if !t.enabled {
+		return nil
+	}
+	durations := make(map[string]string, len(t.durations))
+	for _, p := range t.durations {
+		durations[p.phase] = p.duration.String()
+	}
+	return durations
+}
+
+// summary ends the current phase and logs the durations of all phases
+func (t *phaseTimer) summary() {
+	if !t.enabled {
+		return
+	}
+	t.end()
+	fields := logrus.Fields{"total": time.Since(t.start)}
+	for _, p := range t.durations {
+		fields[p.phase] = p.duration
+	}
+	t.logger.WithFields(fields).Debug("Phase durations")
+}
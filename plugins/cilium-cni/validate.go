@@ -0,0 +1,298 @@
+// Copyright 2019 Authors of Cilium
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cilium/cilium/pkg/endpoint/connector"
+	"github.com/cilium/cilium/pkg/option"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// validate returns an error if options of the network configuration are
+// mutually exclusive or an option is set without an option it depends on.
+// The values of the individual options are validated by parseOptions. Options
+// which depend on the configuration of the agent, e.g. on its datapath mode
+// if datapathMode is unset, are validated on ADD.
+func (n *netConf) validate() error {
+	if n.NoGateway && n.StaticNeigh {
+		return fmt.Errorf("staticNeigh requires a gateway and can't be combined with noGateway")
+	}
+	if n.DisableIPv4 && n.DisableIPv6 {
+		return fmt.Errorf("disableIPv4 and disableIPv6 are mutually exclusive")
+	}
+	if n.HostInterfaceName != "" && n.HostInterfacePrefix != "" {
+		return fmt.Errorf("hostInterfaceName and hostInterfacePrefix are mutually exclusive")
+	}
+
+	// Disabled address families
+	if n.DisableIPv4 && n.IPv4MTU != 0 {
+		return fmt.Errorf("ipv4MTU can't be combined with disableIPv4")
+	}
+	if n.DisableIPv6 && n.IPv6MTU != 0 {
+		return fmt.Errorf("ipv6MTU can't be combined with disableIPv6")
+	}
+	if n.DisableIPv4 && n.HostProxyARP {
+		return fmt.Errorf("hostProxyARP can't be combined with disableIPv4")
+	}
+	if n.DisableIPv6 && n.HostProxyNDP {
+		return fmt.Errorf("hostProxyNDP can't be combined with disableIPv6")
+	}
+
+	// IPAM
+	if n.IPAM.Type == ipamTypeNone {
+		switch {
+		case len(n.RuntimeConfig.IPs) != 0:
+			return fmt.Errorf("ipam type %q can't be combined with requested ips", ipamTypeNone)
+		case n.AttachToExisting:
+			return fmt.Errorf("ipam type %q can't be combined with attachToExisting", ipamTypeNone)
+		case n.IPAM.AddressesPerFamily > 1:
+			return fmt.Errorf("ipam type %q can't be combined with addressesPerFamily", ipamTypeNone)
+		}
+	}
+
+	// Datapath mode
+	if n.DatapathMode != "" && n.DatapathMode != option.DatapathModeVeth {
+		vethOnly := []struct {
+			set    bool
+			option string
+		}{
+			{n.TxQueueLen != nil, "txQueueLen"},
+			{n.HostProxyARP, "hostProxyARP"},
+			{n.HostProxyNDP, "hostProxyNDP"},
+			{n.HostMaster != "", "hostMaster"},
+		}
+		for _, o := range vethOnly {
+			if o.set {
+				return fmt.Errorf("%s requires datapathMode %q and can't be combined with datapathMode %q",
+					o.option, option.DatapathModeVeth, n.DatapathMode)
+			}
+		}
+	}
+
+	// Dependent options
+	if n.HostTxQueueLen && n.TxQueueLen == nil {
+		return fmt.Errorf("hostTxQueueLen requires txQueueLen")
+	}
+	if n.MTUOverhead != nil && n.MTUMode != mtuModeAuto {
+		return fmt.Errorf("mtuOverhead requires mtuMode %q", mtuModeAuto)
+	}
+	if n.EndpointCreateJitterAlways && n.EndpointCreateJitter == "" {
+		return fmt.Errorf("endpointCreateJitterAlways requires endpointCreateJitter")
+	}
+	if n.PreAddHookTimeout != "" && n.PreAddHook == "" {
+		return fmt.Errorf("preAddHookTimeout requires preAddHook")
+	}
+	if n.PostDelHookTimeout != "" && n.PostDelHook == "" {
+		return fmt.Errorf("postDelHookTimeout requires postDelHook")
+	}
+	if n.PolicyExempt != "" && n.AttachToExisting {
+		return fmt.Errorf("policyExempt can't be combined with attachToExisting")
+	}
+	if n.AllowPolicyExemptArgs && n.AttachToExisting {
+		return fmt.Errorf("allowPolicyExemptArgs can't be combined with attachToExisting")
+	}
+	if n.DisableResultCache && n.ResultCacheDir != "" {
+		return fmt.Errorf("resultCacheDir can't be combined with disableResultCache")
+	}
+	return nil
+}
+
+// parseOptions validates the values of the individual options of the network
+// configuration and parses the options which are kept in parsed form, e.g.
+// durations. Options which are unset are set to their defaults.
+func (n *netConf) parseOptions() error {
+	var err error
+
+	// Interfaces
+	if err := connector.ValidateIfNamePrefix(n.HostInterfacePrefix); err != nil {
+		return fmt.Errorf("invalid hostInterfacePrefix: %s", err)
+	}
+	if n.TxQueueLen != nil && *n.TxQueueLen < 0 {
+		return fmt.Errorf("invalid txQueueLen %d", *n.TxQueueLen)
+	}
+	if err := connector.ValidateVethQueues(n.VethQueues); err != nil {
+		return fmt.Errorf("invalid vethQueues: %s", err)
+	}
+	if err := validateTemplate("hostInterfaceName", n.HostInterfaceName); err != nil {
+		return err
+	}
+	switch n.DatapathMode {
+	case "", option.DatapathModeVeth, option.DatapathModeIpvlan:
+	default:
+		return fmt.Errorf("invalid datapathMode %q", n.DatapathMode)
+	}
+	if err := validateOffloads(n.Offloads, n.OffloadsDevice); err != nil {
+		return err
+	}
+	switch n.IPv6DAD {
+	case "", ipv6DADDisabled, ipv6DADWait:
+	default:
+		return fmt.Errorf("invalid ipv6DAD %q", n.IPv6DAD)
+	}
+	if n.NetNSRetries != nil && *n.NetNSRetries < 0 {
+		return fmt.Errorf("invalid netnsRetries %d", *n.NetNSRetries)
+	}
+
+	// Runtime configuration
+	for capability, enabled := range n.Capabilities {
+		if enabled && !supportedCapabilities[capability] {
+			return fmt.Errorf("unsupported capability %q", capability)
+		}
+	}
+	if _, err := parseRequestedIPs(n.RuntimeConfig.IPs); err != nil {
+		return fmt.Errorf("invalid runtimeConfig ips: %s", err)
+	}
+	if n.RuntimeConfig.Mac != "" {
+		if _, err := net.ParseMAC(n.RuntimeConfig.Mac); err != nil {
+			return fmt.Errorf("invalid runtimeConfig mac %q: %s", n.RuntimeConfig.Mac, err)
+		}
+	}
+
+	// MTU
+	if n.MTU < 0 {
+		return fmt.Errorf("invalid mtu %d", n.MTU)
+	}
+	if n.MTUMode != "" && n.MTUMode != mtuModeAuto {
+		return fmt.Errorf("invalid mtuMode %q", n.MTUMode)
+	}
+	if n.MTUOverhead != nil && *n.MTUOverhead < 0 {
+		return fmt.Errorf("invalid mtuOverhead %d", *n.MTUOverhead)
+	}
+	if n.IPv4MTU < 0 {
+		return fmt.Errorf("invalid ipv4MTU %d", n.IPv4MTU)
+	}
+	if n.IPv6MTU < 0 {
+		return fmt.Errorf("invalid ipv6MTU %d", n.IPv6MTU)
+	}
+	if n.DeviceMTU < 0 {
+		return fmt.Errorf("invalid deviceMTU %d", n.DeviceMTU)
+	}
+	if n.RouteMTU < 0 {
+		return fmt.Errorf("invalid routeMTU %d", n.RouteMTU)
+	}
+
+	// Routing
+	for _, r := range n.Routes {
+		if _, err := parseRoute(r, nil, 0, 0); err != nil {
+			return fmt.Errorf("invalid route: %s", err)
+		}
+	}
+	if n.PreferredSource != "" && net.ParseIP(n.PreferredSource) == nil {
+		return fmt.Errorf("invalid preferredSource %q", n.PreferredSource)
+	}
+
+	// IPAM
+	if n.IPAM.AddressesPerFamily < 0 {
+		return fmt.Errorf("invalid addressesPerFamily %d", n.IPAM.AddressesPerFamily)
+	}
+	switch n.IPAM.IPv6Scope {
+	case "", ipv6ScopeULA, ipv6ScopeGUA:
+	default:
+		return fmt.Errorf("invalid ipv6Scope %q", n.IPAM.IPv6Scope)
+	}
+	if n.IPAM.SubnetHint != "" {
+		if _, _, err := net.ParseCIDR(n.IPAM.SubnetHint); err != nil {
+			return fmt.Errorf("invalid subnetHint %q: %s", n.IPAM.SubnetHint, err)
+		}
+	}
+	if err := validateTemplate("ownerTemplate", n.OwnerTemplate); err != nil {
+		return err
+	}
+	if n.OwnerPrefix != "" && !ownerPrefixRegex.MatchString(n.OwnerPrefix) {
+		return fmt.Errorf("invalid ownerPrefix %q: must consist of at most 63 alphanumeric characters, '-', '_' or '.' and start and end with an alphanumeric character", n.OwnerPrefix)
+	}
+
+	// Endpoint creation and deletion
+	if n.EndpointCreateTimeout != "" {
+		n.endpointCreateTimeout, err = time.ParseDuration(n.EndpointCreateTimeout)
+		if err != nil || n.endpointCreateTimeout <= 0 {
+			return fmt.Errorf("invalid endpointCreateTimeout %q", n.EndpointCreateTimeout)
+		}
+	}
+	if n.EndpointCreateJitter != "" {
+		n.endpointCreateJitter, err = time.ParseDuration(n.EndpointCreateJitter)
+		if err != nil || n.endpointCreateJitter < 0 {
+			return fmt.Errorf("invalid endpointCreateJitter %q", n.EndpointCreateJitter)
+		}
+		if n.endpointCreateJitter >= n.createTimeout() {
+			return fmt.Errorf("endpointCreateJitter %s must be lower than the endpoint creation timeout %s",
+				n.endpointCreateJitter, n.createTimeout())
+		}
+	}
+	switch n.EndpointBuildMode {
+	case "", endpointBuildModeSync, endpointBuildModeAuto:
+	default:
+		return fmt.Errorf("invalid endpointBuildMode %q", n.EndpointBuildMode)
+	}
+	switch n.DelRetryPolicy {
+	case "", delRetryPolicyDefault, delRetryPolicyNever, delRetryPolicyTimeouts:
+	default:
+		return fmt.Errorf("invalid delRetryPolicy %q", n.DelRetryPolicy)
+	}
+	if !validPolicyExempt(n.PolicyExempt) {
+		return fmt.Errorf("invalid policyExempt %q", n.PolicyExempt)
+	}
+
+	// Locking and hooks
+	n.addLockTimeout = defaultAddLockTimeout
+	if n.AddLockTimeout != "" {
+		n.addLockTimeout, err = time.ParseDuration(n.AddLockTimeout)
+		if err != nil || n.addLockTimeout < 0 {
+			return fmt.Errorf("invalid addLockTimeout %q", n.AddLockTimeout)
+		}
+	}
+	if n.PreAddHook != "" && !filepath.IsAbs(n.PreAddHook) {
+		return fmt.Errorf("invalid preAddHook %q: must be an absolute path", n.PreAddHook)
+	}
+	n.preAddHookTimeout = defaultHookTimeout
+	if n.PreAddHookTimeout != "" {
+		n.preAddHookTimeout, err = time.ParseDuration(n.PreAddHookTimeout)
+		if err != nil || n.preAddHookTimeout <= 0 {
+			return fmt.Errorf("invalid preAddHookTimeout %q", n.PreAddHookTimeout)
+		}
+	}
+	if n.PostDelHook != "" && !filepath.IsAbs(n.PostDelHook) {
+		return fmt.Errorf("invalid postDelHook %q: must be an absolute path", n.PostDelHook)
+	}
+	n.postDelHookTimeout = defaultHookTimeout
+	if n.PostDelHookTimeout != "" {
+		n.postDelHookTimeout, err = time.ParseDuration(n.PostDelHookTimeout)
+		if err != nil || n.postDelHookTimeout <= 0 {
+			return fmt.Errorf("invalid postDelHookTimeout %q", n.PostDelHookTimeout)
+		}
+	}
+	if n.MaxConcurrentAdds < 0 {
+		return fmt.Errorf("invalid maxConcurrentAdds %d", n.MaxConcurrentAdds)
+	}
+
+	// Labels
+	if errs := validation.IsValidLabelValue(n.AvailabilityZone); len(errs) > 0 {
+		return fmt.Errorf("invalid availabilityZone %q: %s", n.AvailabilityZone, strings.Join(errs, ", "))
+	}
+	if n.MaxLabels < 0 {
+		return fmt.Errorf("invalid maxLabels %d", n.MaxLabels)
+	}
+	if err := validateLabelSources(n.LabelSources); err != nil {
+		return err
+	}
+	return nil
+}
@@ -136,7 +136,7 @@ func (driver *driver) updateRoutes(addressing *models.NodeAddressing) {
 	driver.routes = []api.StaticRoute{}
 
 	if driver.conf.Addressing.IPV6 != nil && driver.conf.Addressing.IPV6.Enabled {
-		if routes, err := connector.IPv6Routes(driver.conf.Addressing, int(driver.conf.RouteMTU)); err != nil {
+		if routes, err := connector.IPv6Routes(driver.conf.Addressing, int(driver.conf.RouteMTU), int(driver.conf.DeviceMTU)); err != nil {
 			log.Fatalf("Unable to generate IPv6 routes: %s", err)
 		} else {
 			for _, r := range routes {
@@ -148,7 +148,7 @@ func (driver *driver) updateRoutes(addressing *models.NodeAddressing) {
 	}
 
 	if driver.conf.Addressing.IPV4 != nil && driver.conf.Addressing.IPV4.Enabled {
-		if routes, err := connector.IPv4Routes(driver.conf.Addressing, int(driver.conf.RouteMTU)); err != nil {
+		if routes, err := connector.IPv4Routes(driver.conf.Addressing, int(driver.conf.RouteMTU), int(driver.conf.DeviceMTU)); err != nil {
 			log.Fatalf("Unable to generate IPv4 routes: %s", err)
 		} else {
 			for _, r := range routes {